@@ -22,6 +22,7 @@ type Context struct {
 	mode    ContextType
 	outdata *bytes.Buffer
 	flusher func()
+	user    string // Who is giving the commands, if known
 }
 
 func NewContext(mode ContextType) *Context {
@@ -39,6 +40,16 @@ func (c *Context) SetFlusher(f func()) {
 	c.flusher = f
 }
 
+// SetUser records who is giving the commands in this context.
+func (c *Context) SetUser(user string) {
+	c.user = user
+}
+
+// User returns who is giving the commands, or blank if not known.
+func (c *Context) User() string {
+	return c.user
+}
+
 func (c *Context) Printf(f string, args ...interface{}) {
 	fmt.Fprintf(c.outdata, f, args...)
 }
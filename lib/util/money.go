@@ -0,0 +1,257 @@
+// --------------------------------------------------------------------
+// money.go -- Money type, with exact parsing and formatting.
+//
+// Created 2020-04-19 DLB
+// --------------------------------------------------------------------
+
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Money is an amount of money in cents.  Money is never held in a
+// float, so that amounts such as 19.99 are always exact.
+type Money int
+
+// MoneyFormat gives the options for formatting money.  The zero value
+// gives the same format as CentsToStr: "-1,234.56".
+type MoneyFormat struct {
+	Symbol   string // Put in front of the digits, such as "$"
+	NoCommas bool   // Leave out the thousands separators
+	Parens   bool   // Show negative amounts in parentheses, instead of with a "-"
+	Plus     bool   // Show a "+" in front of positive amounts
+}
+
+// ParseMoney converts a string, such as "$1,234.56", to money.  It
+// accepts currency symbols, thousands separators, a leading or
+// trailing sign, and parentheses for negative amounts.  Digits past
+// the cents are rounded, half away from zero.  A blank string is an
+// error.
+func ParseMoney(s string) (Money, error) {
+	return ParseMoneyDecimal(s, '.')
+}
+
+// ParseMoneyDecimal is ParseMoney for a given decimal point.  If the
+// decimal point is a comma, dots are taken as thousands separators
+// (such as "1.234,56").
+func ParseMoneyDecimal(s string, decimal rune) (Money, error) {
+	sep := ','
+	if decimal == ',' {
+		sep = '.'
+	}
+	t := strings.TrimSpace(s)
+	neg := false
+	if strings.HasPrefix(t, "(") && strings.HasSuffix(t, ")") {
+		neg = true
+		t = strings.TrimSpace(t[1 : len(t)-1])
+	}
+	// Currency symbols and signs can come before or after the number,
+	// but not in the middle of it.
+	var digits strings.Builder
+	nsign := 0
+	phase := 0 // 0 before the number, 1 in it, 2 after it
+	for _, r := range t {
+		isnum := (r >= '0' && r <= '9') || r == decimal || r == sep
+		if isnum && phase == 2 {
+			return 0, fmt.Errorf("Bad money amount (%q).", s)
+		}
+		if isnum {
+			phase = 1
+		} else if phase == 1 && !unicode.IsSpace(r) {
+			phase = 2
+		}
+		switch {
+		case r == '-' || r == '+':
+			nsign++
+			if r == '-' {
+				neg = !neg
+			}
+		case r == sep || unicode.IsSpace(r):
+			// Thousands separator
+		case r == decimal:
+			digits.WriteRune('.')
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case unicode.Is(unicode.Sc, r) || unicode.IsLetter(r):
+			// Currency symbol, or a code such as USD
+		default:
+			return 0, fmt.Errorf("Bad money amount (%q).", s)
+		}
+	}
+	if nsign > 1 {
+		return 0, fmt.Errorf("Bad money amount (%q).", s)
+	}
+	d := digits.String()
+	whole, frac := d, ""
+	if i := strings.Index(d, "."); i >= 0 {
+		whole, frac = d[:i], d[i+1:]
+		if strings.Contains(frac, ".") {
+			return 0, fmt.Errorf("Bad money amount (%q).", s)
+		}
+	}
+	if whole == "" && frac == "" {
+		return 0, fmt.Errorf("Bad money amount (%q).", s)
+	}
+	up := false
+	if len(frac) > 2 {
+		up = frac[2] >= '5'
+		frac = frac[:2]
+	}
+	for len(frac) < 2 {
+		frac += "0"
+	}
+	if whole == "" {
+		whole = "0"
+	}
+	if len(whole) > 16 {
+		return 0, fmt.Errorf("Money amount is too large (%q).", s)
+	}
+	w, err := strconv.Atoi(whole)
+	if err != nil {
+		return 0, fmt.Errorf("Bad money amount (%q).", s)
+	}
+	f, _ := strconv.Atoi(frac)
+	m := Money(w*100 + f)
+	if up {
+		m++
+	}
+	if neg {
+		m = -m
+	}
+	return m, nil
+}
+
+// Cents returns the money as an int number of cents.
+func (m Money) Cents() int {
+	return int(m)
+}
+
+// String formats the money as "-1,234.56".
+func (m Money) String() string {
+	return m.Format(MoneyFormat{})
+}
+
+// Format formats the money with the given options.
+func (m Money) Format(f MoneyFormat) string {
+	neg := m < 0
+	if neg {
+		m = -m
+	}
+	dollars := strconv.Itoa(int(m / 100))
+	if !f.NoCommas {
+		var b strings.Builder
+		for i, c := range dollars {
+			if i > 0 && (len(dollars)-i)%3 == 0 {
+				b.WriteByte(',')
+			}
+			b.WriteRune(c)
+		}
+		dollars = b.String()
+	}
+	s := fmt.Sprintf("%s%s.%02d", f.Symbol, dollars, int(m%100))
+	switch {
+	case neg && f.Parens:
+		return "(" + s + ")"
+	case neg:
+		return "-" + s
+	case f.Plus && m > 0:
+		return "+" + s
+	}
+	return s
+}
+
+// Abs returns the money without its sign.
+func (m Money) Abs() Money {
+	if m < 0 {
+		return -m
+	}
+	return m
+}
+
+// Mul returns the money times a whole number.
+func (m Money) Mul(n int) Money {
+	return m * Money(n)
+}
+
+// MulFrac returns the money times num/den, rounded to the nearest cent
+// (half away from zero).
+func (m Money) MulFrac(num, den int) Money {
+	if den == 0 {
+		return 0
+	}
+	if den < 0 {
+		num, den = -num, -den
+	}
+	p := int64(m) * int64(num)
+	q := p / int64(den)
+	r := p % int64(den)
+	if r < 0 {
+		r = -r
+	}
+	if 2*r >= int64(den) {
+		if p < 0 {
+			q--
+		} else {
+			q++
+		}
+	}
+	return Money(q)
+}
+
+// Split divides the money into n parts that add up to it exactly.  The
+// extra cents go to the first parts.
+func (m Money) Split(n int) []Money {
+	if n <= 0 {
+		return []Money{}
+	}
+	w := make([]int, n)
+	for i := range w {
+		w[i] = 1
+	}
+	return m.Allocate(w)
+}
+
+// Allocate divides the money into parts in proportion to the weights,
+// so that the parts add up to it exactly.  The cents left over from
+// rounding go to the first parts.  If the weights add to zero,
+// the money is split evenly.
+func (m Money) Allocate(weights []int) []Money {
+	parts := make([]Money, len(weights))
+	if len(weights) == 0 {
+		return parts
+	}
+	total := 0
+	for _, w := range weights {
+		total += w
+	}
+	if total == 0 {
+		return m.Split(len(weights))
+	}
+	var used Money
+	for i, w := range weights {
+		parts[i] = Money(int64(m) * int64(w) / int64(total))
+		used += parts[i]
+	}
+	step := Money(1)
+	if used > m {
+		step = -1
+	}
+	for i := 0; used != m; i = (i + 1) % len(parts) {
+		parts[i] += step
+		used += step
+	}
+	return parts
+}
+
+// SumMoney adds up a list of amounts.
+func SumMoney(lst ...Money) Money {
+	var s Money
+	for _, m := range lst {
+		s += m
+	}
+	return s
+}
@@ -0,0 +1,165 @@
+// --------------------------------------------------------------------
+// money_test.go -- Test the Money type
+//
+// Created 2020-04-19 DLB
+// --------------------------------------------------------------------
+
+package util
+
+import (
+	"testing"
+)
+
+type Moneyparsetest struct {
+	Input  string
+	Result Money
+}
+
+var money_parse_tests []Moneyparsetest = []Moneyparsetest{
+	{"19.99", 1999},
+	{"0.29", 29},
+	{"1.005", 101},
+	{"-1.005", -101},
+	{"1.004", 100},
+	{"12", 1200},
+	{".5", 50},
+	{"5.", 500},
+	{"-12.34", -1234},
+	{"+12.34", 1234},
+	{"12.34-", -1234},
+	{"(12.34)", -1234},
+	{"($1,234.56)", -123456},
+	{"$1,234,567.89", 123456789},
+	{"$-5.00", -500},
+	{"-$5.00", -500},
+	{"USD 7.10", 710},
+	{"7.10 USD", 710},
+	{"€ 3.50", 350},
+	{" 42.00 ", 4200},
+	{"0", 0},
+	{"-0.01", -1},
+}
+
+var money_parse_errors []string = []string{
+	"", "-", "abc", "1.2.3", "--5", "12-34", "1O0", "12 USD 3", "$", "()",
+}
+
+func Test_ParseMoney(t *testing.T) {
+	for _, x := range money_parse_tests {
+		m, err := ParseMoney(x.Input)
+		if err != nil {
+			t.Fatalf("ParseMoney(%q) fails with Err=%v", x.Input, err)
+		}
+		if m != x.Result {
+			t.Fatalf("ParseMoney(%q) = %d, Expected = %d", x.Input, m, x.Result)
+		}
+	}
+	for _, s := range money_parse_errors {
+		m, err := ParseMoney(s)
+		if err == nil {
+			t.Fatalf("ParseMoney(%q) = %d, Expected an error", s, m)
+		}
+	}
+}
+
+func Test_ParseMoneyDecimal(t *testing.T) {
+	m, err := ParseMoneyDecimal("1.234,56", ',')
+	if err != nil || m != 123456 {
+		t.Fatalf("ParseMoneyDecimal(\"1.234,56\") = %d, %v. Expected 123456", m, err)
+	}
+	m, err = ParseMoneyDecimal("-0,5", ',')
+	if err != nil || m != -50 {
+		t.Fatalf("ParseMoneyDecimal(\"-0,5\") = %d, %v. Expected -50", m, err)
+	}
+}
+
+func Test_MoneyFormat(t *testing.T) {
+	for _, x := range cent_tests {
+		sout := Money(x.Value).String()
+		if sout != x.Result {
+			t.Fatalf("Money.String fail. Input = %d, Output = %q, Expected = %q",
+				x.Value, sout, x.Result)
+		}
+	}
+	tests := []struct {
+		m Money
+		f MoneyFormat
+		s string
+	}{
+		{-123456, MoneyFormat{Symbol: "$", Parens: true}, "($1,234.56)"},
+		{123456, MoneyFormat{Symbol: "$", NoCommas: true}, "$1234.56"},
+		{5, MoneyFormat{Plus: true}, "+0.05"},
+		{0, MoneyFormat{Plus: true}, "0.00"},
+		{-5, MoneyFormat{Plus: true}, "-0.05"},
+	}
+	for _, x := range tests {
+		if s := x.m.Format(x.f); s != x.s {
+			t.Fatalf("Money.Format(%d, %+v) = %q, Expected = %q", x.m, x.f, s, x.s)
+		}
+	}
+}
+
+func Test_MoneyRoundTrip(t *testing.T) {
+	for _, x := range cent_tests {
+		m, err := ParseMoney(Money(x.Value).String())
+		if err != nil || m != Money(x.Value) {
+			t.Fatalf("Round trip of %d gives %d, %v", x.Value, m, err)
+		}
+	}
+}
+
+func Test_MoneyArithmetic(t *testing.T) {
+	if m := Money(-250).Abs(); m != 250 {
+		t.Fatalf("Abs(-250) = %d", m)
+	}
+	if m := Money(1999).Mul(3); m != 5997 {
+		t.Fatalf("Mul(1999, 3) = %d", m)
+	}
+	if m := Money(1000).MulFrac(1, 3); m != 333 {
+		t.Fatalf("MulFrac(1000, 1/3) = %d", m)
+	}
+	if m := Money(1000).MulFrac(2, 3); m != 667 {
+		t.Fatalf("MulFrac(1000, 2/3) = %d", m)
+	}
+	if m := Money(-1000).MulFrac(2, 3); m != -667 {
+		t.Fatalf("MulFrac(-1000, 2/3) = %d", m)
+	}
+	if m := Money(5).MulFrac(1, 2); m != 3 {
+		t.Fatalf("MulFrac(5, 1/2) = %d", m)
+	}
+	if m := SumMoney(100, -25, 1); m != 76 {
+		t.Fatalf("SumMoney = %d", m)
+	}
+}
+
+func Test_MoneySplit(t *testing.T) {
+	tests := []struct {
+		m       Money
+		weights []int
+		parts   []Money
+	}{
+		{100, []int{1, 1, 1}, []Money{34, 33, 33}},
+		{-100, []int{1, 1, 1}, []Money{-34, -33, -33}},
+		{1000, []int{1, 2, 2}, []Money{200, 400, 400}},
+		{1001, []int{1, 2, 2}, []Money{201, 400, 400}},
+		{10, []int{3, -1}, []Money{15, -5}},
+		{7, []int{0, 0}, []Money{4, 3}},
+	}
+	for _, x := range tests {
+		parts := x.m.Allocate(x.weights)
+		if len(parts) != len(x.parts) {
+			t.Fatalf("Allocate(%d, %v) = %v, Expected = %v", x.m, x.weights, parts, x.parts)
+		}
+		for i := range parts {
+			if parts[i] != x.parts[i] {
+				t.Fatalf("Allocate(%d, %v) = %v, Expected = %v", x.m, x.weights, parts, x.parts)
+			}
+		}
+		if SumMoney(parts...) != x.m {
+			t.Fatalf("Allocate(%d, %v) parts do not add up.", x.m, x.weights)
+		}
+	}
+	if parts := Money(5).Split(0); len(parts) != 0 {
+		t.Fatalf("Split(5, 0) = %v", parts)
+	}
+}
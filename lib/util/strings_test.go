@@ -406,3 +406,25 @@ func Test_StrLeft(t *testing.T) {
 		t.Fatalf("StrLeft failed on test 6.")
 	}
 }
+
+func Test_EditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		n    int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"", "abc", 3},
+		{"abc", "abc", 0},
+		{"kitten", "sitting", 3},
+		{"STARBUCKS", "STARBUKS", 1},
+		{"flaw", "lawn", 2},
+		{"café", "cafe", 1},
+	}
+	for i, tst := range tests {
+		n := EditDistance(tst.a, tst.b)
+		if n != tst.n {
+			t.Fatalf("EditDistance failed on test %d. (%q, %q) gave %d, not %d.", i+1, tst.a, tst.b, n, tst.n)
+		}
+	}
+}
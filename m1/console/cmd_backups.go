@@ -9,7 +9,9 @@ package console
 import (
 	"dbe/lib/util"
 	m1 "dbe/m1/m1data"
+	"fmt"
 	"sort"
+	"strings"
 )
 
 var gTopic_list_backups string = `
@@ -43,6 +45,7 @@ WARNING: this command will overwrite the current database
 with the backuped data, and can lead to a loss of data.
 It is usually best to do a make-backup right before issuing
 this command so that the current database can be restored.
+The loaded backup is saved as the new primary data file.
 
 `
 var gTopic_delete_backup string = `
@@ -58,8 +61,24 @@ is deleted.
 
 `
 
+var gTopic_check_backups string = `
+The check-backups command reads every backup file, verifies its
+checksum, and reports the format and schema version it was
+written with.  Old backups are upgraded to the current data model
+in memory while checking.  The format of the command is:
+
+  check-backups rewrite
+
+where "rewrite" is optional, and if given, any backup written in
+an old format or with an old schema version is written back in
+the current format and version.  The current database is not
+changed by this command.
+
+`
+
 func init() {
 	RegistorCmd("list-backups", "", "Lists the backup files.", handle_list_backups)
+	RegistorCmd("check-backups", "", "Verifies and upgrades backup files.", handle_check_backups)
 	RegistorCmd("make-backup", "", "Makes a backup.", handle_make_backup)
 	RegistorCmd("load-backup", "", "Loads a backup.", handle_load_backup)
 	RegistorCmd("delete-backup", "", "Deletes a backup.", handle_delete_backup)
@@ -67,6 +86,7 @@ func init() {
 	RegistorTopic("make-backup", gTopic_make_backup)
 	RegistorTopic("load-backup", gTopic_load_backup)
 	RegistorTopic("deete-backup", gTopic_delete_backup)
+	RegistorTopic("check-backups", gTopic_check_backups)
 }
 
 func handle_list_backups(c *util.Context, cmdline string) {
@@ -133,11 +153,12 @@ func handle_load_backup(c *util.Context, cmdline string) {
 		return
 	}
 	fname := args[1]
-	err = m1.LoadBackup(fname)
+	info, err := m1.LoadBackup(fname)
 	if err != nil {
 		c.Printf("Error: %v\n", err)
 		return
 	}
+	c.Printf("Loaded from %s\n", info)
 	c.Printf("Success.\n")
 }
 
@@ -161,6 +182,51 @@ func handle_delete_backup(c *util.Context, cmdline string) {
 	c.Printf("Success.\n")
 }
 
+func handle_check_backups(c *util.Context, cmdline string) {
+	params := make(map[string]string, 10)
+	args, err := ParseCmdLine(cmdline, params)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	rewrite := false
+	if len(args) > 1 {
+		if strings.ToLower(args[1]) == "rewrite" {
+			rewrite = true
+		} else {
+			c.Printf("Unknown argument (%s)\n", args[1])
+			return
+		}
+	}
+	lst := m1.GetBackupFileList()
+	tbl := util.NewTable("File Name", "Format", "Schema", "Saved", "Transactions", "Status")
+	nbad := 0
+	for _, f := range lst {
+		info, err := m1.CheckBackup(f, rewrite)
+		if err != nil {
+			nbad += 1
+			tbl.AddRow(f, "", "", "", "", fmt.Sprintf("%v", err))
+			continue
+		}
+		status := "ok"
+		if info.UpgradedFrom != 0 {
+			status = fmt.Sprintf("upgraded from schema %d", info.UpgradedFrom)
+		}
+		if !info.Verified {
+			status += ", no checksum"
+		}
+		saved := ""
+		if !info.Saved.IsZero() {
+			saved = info.Saved.Format("2006-01-02 15:04")
+		}
+		tbl.AddRow(f, fmt.Sprintf("%d", info.FormatVersion), fmt.Sprintf("%d", info.SchemaVersion),
+			saved, fmt.Sprintf("%d", info.NTransactions), status)
+		c.Flush()
+	}
+	c.Printf("%s\n", tbl.Text())
+	c.Printf("Backups checked: %d, with errors: %d\n", len(lst), nbad)
+}
+
 func legalFileRootName(fname string) bool {
 	for i, x := range fname {
 		if x >= 'a' && x <= 'z' {
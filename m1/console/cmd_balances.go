@@ -0,0 +1,211 @@
+// --------------------------------------------------------------------
+// cmd_balances.go -- Shows the balances of accounts, and sets their
+// opening balances.
+//
+// Created 2020-04-20 DLB
+// --------------------------------------------------------------------
+
+package console
+
+import (
+	"dbe/lib/util"
+	m1 "dbe/m1/m1data"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var gTopic_balances string = `
+The balances command lists every account with its balance.  The
+format of the command is:
+
+  balances
+
+The posted balance counts every transaction in the account.  The
+settled balance counts only the ones that have settled, and should
+match what the bank shows.  The transactions that have not settled
+yet are counted as pending.  Both start from the account's opening
+balance (see set-opening-balance).
+
+The balance-history command shows how the balance of one account
+changed over time.  The format of the command is:
+
+  balance-history account=name basis=posted by=month from=date to=date
+
+where basis is posted (the default) or settled, and by is either
+transaction (the default), for a running balance with a line for
+each transaction, or month, for the change and ending balance of
+each month.  The from and to dates are optional, and limit the lines
+shown, but not the balance, which always starts from the opening
+balance.
+
+The set-opening-balance command sets what was in an account before
+its first transaction.  The format of the command is:
+
+  set-opening-balance account=name amount=dollars date=date
+
+The opening balance is the balance at the start of the date given.
+Transactions dated before that day are taken to be part of it, and
+are left out of the balances.  If no date is given, all the
+transactions are counted.
+`
+
+func init() {
+	RegistorCmd("balances", "", "Lists the balances of the accounts.", handle_balances)
+	RegistorCmd("balance-history", "", "Shows the running balance of an account.", handle_balance_history)
+	RegistorCmd("set-opening-balance", "", "Sets the opening balance of an account.", handle_set_opening_balance)
+	RegistorTopic("balances", gTopic_balances)
+}
+
+func handle_balances(c *util.Context, cmdline string) {
+	params := make(map[string]string, 10)
+	_, err := ParseCmdLine(cmdline, params)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	tbl := util.NewTable("ShortName", "Account", "Opening", "Opened", "Posted", "Settled", "Pending", "Last")
+	for _, ab := range m1.GetView().AccountBalances() {
+		a := ab.A
+		tbl.AddRow(a.ShortName, a.FName, fmt.Sprintf("%12s", a.OpeningBalance), format_day(a.OpeningDate),
+			fmt.Sprintf("%12s", ab.Posted), fmt.Sprintf("%12s", ab.Settled), fmt.Sprintf("%5d", ab.NPending),
+			format_day(ab.Last))
+	}
+	c.Printf("%s\n", tbl.Text())
+}
+
+func handle_balance_history(c *util.Context, cmdline string) {
+	params := make(map[string]string, 10)
+	_, err := ParseCmdLine(cmdline, params)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	v := m1.GetView()
+	name, ok := util.MapAlias(params, "account", "acc")
+	if !ok {
+		c.Printf("No account given.\n")
+		return
+	}
+	a := v.AccountByName(name)
+	if a == nil {
+		c.Printf("No account named %q.\n", name)
+		return
+	}
+	sbasis, _ := util.MapAlias(params, "basis")
+	basis, err := m1.ParseBasis(sbasis)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	bymonth := false
+	if s, ok := util.MapAlias(params, "by"); ok {
+		switch strings.ToLower(s) {
+		case "month", "m":
+			bymonth = true
+		case "transaction", "t":
+		default:
+			c.Printf("Invalid value for by (%s). Use transaction or month.\n", s)
+			return
+		}
+	}
+	var from, to time.Time
+	if s, ok := util.MapAlias(params, "from"); ok {
+		from, err = util.ParseGenericTime(s)
+		if err != nil {
+			c.Printf("Invalid parameter for from (%s). Err=%v\n", s, err)
+			return
+		}
+	}
+	if s, ok := util.MapAlias(params, "to"); ok {
+		to, err = util.ParseGenericTime(s)
+		if err != nil {
+			c.Printf("Invalid parameter for to (%s). Err=%v\n", s, err)
+			return
+		}
+		to = to.AddDate(0, 0, 1)
+	}
+	lst, err := v.RunningBalance(a.Aid, basis)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	if bymonth {
+		lst = m1.MonthlyBalances(lst)
+	}
+	var tbl *util.Table
+	if bymonth {
+		tbl = util.NewTable("Month", "Change", "Balance")
+	} else {
+		tbl = util.NewTable("Date", "Description", "Amount", "Balance")
+	}
+	for _, x := range lst {
+		if x.T != nil && ((!from.IsZero() && x.Date.Before(from)) || (!to.IsZero() && !x.Date.Before(to))) {
+			continue
+		}
+		switch {
+		case x.T == nil && bymonth:
+			tbl.AddRow("Opening", fmt.Sprintf("%12s", x.Amount), fmt.Sprintf("%12s", x.Balance))
+		case x.T == nil:
+			tbl.AddRow(format_day(x.Date), "Opening Balance", fmt.Sprintf("%12s", x.Amount), fmt.Sprintf("%12s", x.Balance))
+		case bymonth:
+			tbl.AddRow(x.Date.Format("2006-01"), fmt.Sprintf("%12s", x.Amount), fmt.Sprintf("%12s", x.Balance))
+		default:
+			tbl.AddRow(format_day(x.Date), util.FixStrLen(x.T.Description, 40, "..."),
+				fmt.Sprintf("%12s", x.Amount), fmt.Sprintf("%12s", x.Balance))
+		}
+	}
+	c.Printf("Account: %s (%s basis)\n", a.FName, basis)
+	c.Printf("%s\n", tbl.Text())
+}
+
+func handle_set_opening_balance(c *util.Context, cmdline string) {
+	params := make(map[string]string, 10)
+	_, err := ParseCmdLine(cmdline, params)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	name, ok := util.MapAlias(params, "account", "acc")
+	if !ok {
+		c.Printf("No account given.\n")
+		return
+	}
+	a := m1.GetAccountByName(name)
+	if a == nil {
+		c.Printf("No account named %q.\n", name)
+		return
+	}
+	samt, ok := util.MapAlias(params, "amount", "amt")
+	if !ok {
+		c.Printf("No amount given.\n")
+		return
+	}
+	amt, err := util.ParseMoney(samt)
+	if err != nil {
+		c.Printf("Invalid amount (%s). Err=%v\n", samt, err)
+		return
+	}
+	var date time.Time
+	if s, ok := util.MapAlias(params, "date"); ok {
+		date, err = util.ParseGenericTime(s)
+		if err != nil {
+			c.Printf("Invalid date (%s). Err=%v\n", s, err)
+			return
+		}
+	}
+	err = m1.SetOpeningBalance(a.Aid, amt, date)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	c.Printf("Success.\n")
+}
+
+// format_day returns a date for tables, or blank for the zero time.
+func format_day(d time.Time) string {
+	if d.IsZero() {
+		return ""
+	}
+	return d.Format("2006-01-02")
+}
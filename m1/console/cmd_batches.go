@@ -0,0 +1,450 @@
+// --------------------------------------------------------------------
+// cmd_batches.go -- Commands to look over, fix, commit and discard
+// staged imports.
+//
+// Created 2020-04-19 DLB
+// --------------------------------------------------------------------
+
+package console
+
+import (
+	"dbe/lib/util"
+	"dbe/m1/importer"
+	m1 "dbe/m1/m1data"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var gTopic_batches string = `
+Imports do not go straight into the database.  Each file is staged in
+a batch, with one row for each transaction and the status of the row:
+
+  new          -- Will be added.
+  no-vendor    -- Will be added, but no vendor was found.
+  no-category  -- Will be added, but a category in the file was not found.
+  duplicate    -- Already in the database, so it is skipped.
+  review       -- Might be a duplicate.  It goes to the review queue
+                  (see 'help dups').
+  no-account   -- No account was found.  Give one, or skip the row.
+  problem      -- Cannot be added as it is (such as splits that do not
+                  add up, or a date in a reconciled period).  Fix it,
+                  or skip the row.
+
+The batch is then committed, which adds all its rows at once, or
+discarded.  Each transaction added keeps the id of its batch, and the
+batch is kept as the record of the import: the file, its hash, the
+profile, who did it, when, and how many rows were added.  The
+commands are:
+
+  list-batches all=true
+  show-batch id status=name max=nnn
+  edit-batch id rows=list status=name account=name vendor=name cat=name skip=true|false
+  commit-batch id
+  discard-batch id
+  rollback-batch id
+
+The id is the first few characters of the Id column in list-batches.
+list-batches shows the staged batches, or all of them with all=true.
+show-batch shows the rows of a batch, or only those with a status.
+
+edit-batch changes the rows given by rows (such as 3,5,10-12), or the
+rows with a status, or both.  It can set the account, vendor or
+category (for the whole amount), or skip rows (skip=false puts a
+skipped row back).  The batch is checked again after each edit.
+
+commit-batch first checks the batch against the database again, in
+case it changed since the batch was staged.  If any row changes, the
+batch is not committed, so that it can be looked over again.
+
+rollback-batch undoes a committed import.  Every transaction that it
+added is removed, even ones changed since, as are any of its rows
+still waiting for review.  The batch is kept, marked rolled-back.
+
+A file that was imported before (the same hash), and not rolled back,
+is refused by the import command unless force=true is given.
+`
+
+func init() {
+	RegistorCmd("list-batches", "", "Lists the staged imports.", handle_list_batches)
+	RegistorCmd("show-batch", "", "Shows the rows of a staged import.", handle_show_batch)
+	RegistorCmd("edit-batch", "", "Fixes or skips rows of a staged import.", handle_edit_batch)
+	RegistorCmd("commit-batch", "", "Adds a staged import to the database.", handle_commit_batch)
+	RegistorCmd("discard-batch", "", "Drops a staged import.", handle_discard_batch)
+	RegistorCmd("rollback-batch", "", "Removes the transactions of a committed import.", handle_rollback_batch)
+	RegistorTopic("batches", gTopic_batches)
+}
+
+func handle_list_batches(c *util.Context, cmdline string) {
+	params := make(map[string]string, 10)
+	_, err := ParseCmdLine(cmdline, params)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	all := false
+	if s, ok := util.MapAlias(params, "all"); ok {
+		all, err = util.StrToBool(s, false)
+		if err != nil {
+			c.Printf("Invalid parameter for all (%s). Err=%v\n", s, err)
+			return
+		}
+	}
+	tbl := util.NewTable("Id", "Created", "Source", "Kind", "Profile", "User", "Status", "Rows", "Added", "Review",
+		"Skipped", "Need Work", "Hash")
+	n := 0
+	for _, b := range m1.GetBatches() {
+		if !all && b.Status != m1.Batch_Staged {
+			continue
+		}
+		nrows, nadd, nreview, nskip, nwork := b.NRows, b.NAdded, b.NReview, b.NSkipped, 0
+		if b.Status == m1.Batch_Staged {
+			nrows = len(b.Rows)
+			nadd, nskip, nwork = batch_counts(b)
+			nreview = 0
+			for _, r := range b.Rows {
+				if !r.Skip && r.Status == m1.Row_Review {
+					nreview++
+				}
+			}
+		}
+		hash := b.Hash
+		if len(hash) > 12 {
+			hash = hash[:12]
+		}
+		status := b.Status
+		if b.Status == m1.Batch_RolledBack {
+			status += fmt.Sprintf(" (%d removed)", b.NRemoved)
+		}
+		tbl.AddRow(short_id(b.Bid), b.Created.Format("06-01-02 15:04"), b.Source, b.Kind, b.Profile, b.User, status,
+			fmt.Sprintf("%5d", nrows), fmt.Sprintf("%5d", nadd), fmt.Sprintf("%5d", nreview), fmt.Sprintf("%5d", nskip),
+			fmt.Sprintf("%5d", nwork), hash)
+		n++
+	}
+	c.Printf("%s\n", tbl.Text())
+	c.Printf("Number of batches: %d\n", n)
+}
+
+// batch_counts returns the number of rows in a batch that will be
+// added (or put in the review queue), that are skipped, and that must
+// be fixed or skipped before the batch can be committed.
+func batch_counts(b *m1.ImportBatch) (int, int, int) {
+	nadd, nskip, nwork := 0, 0, 0
+	for _, r := range b.Rows {
+		switch {
+		case r.Skip:
+			nskip++
+		case r.Status == m1.Row_NoAccount || r.Status == m1.Row_Problem:
+			nwork++
+		default:
+			nadd++
+		}
+	}
+	return nadd, nskip, nwork
+}
+
+func handle_show_batch(c *util.Context, cmdline string) {
+	params := make(map[string]string, 10)
+	args, err := ParseCmdLine(cmdline, params)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	b, err := batch_arg(args)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	maxlst := 100
+	smax, ok := util.MapAlias(params, "max")
+	if ok {
+		maxlst, err = strconv.Atoi(smax)
+		if err != nil {
+			c.Printf("Invalid paramger for max. (%s), Err=%v\n", smax, err)
+			return
+		}
+	}
+	status, _ := util.MapAlias(params, "status")
+	v := m1.GetView()
+	c.Printf("Batch %s: %s (%s), %s on %s.\n", short_id(b.Bid), b.Source, b.Kind, b.Status,
+		b.Created.Format("2006-01-02 15:04"))
+	if !util.Blank(b.User) || !util.Blank(b.Profile) {
+		c.Printf("User: %s. Profile: %s.\n", b.User, b.Profile)
+	}
+	if b.Hash != "" {
+		c.Printf("Hash: %s\n", b.Hash)
+	}
+	for _, al := range b.NewAliases {
+		switch b.Status {
+		case m1.Batch_Staged:
+			c.Printf("Adds alias %s to account %s, on commit.\n", al.Alias, account_name(v, al.Aid))
+		case m1.Batch_Committed:
+			c.Printf("Added alias %s to account %s.\n", al.Alias, account_name(v, al.Aid))
+		default:
+			c.Printf("Took alias %s away from account %s.\n", al.Alias, account_name(v, al.Aid))
+		}
+	}
+	if b.Status != m1.Batch_Staged {
+		c.Printf("Committed on %s. Rows: %d. Added: %d. To review: %d. Skipped: %d.\n",
+			b.Closed.Format("2006-01-02 15:04"), b.NRows, b.NAdded, b.NReview, b.NSkipped)
+		if b.Status == m1.Batch_RolledBack {
+			c.Printf("Rolled back on %s. Transactions removed: %d.\n",
+				b.RolledBack.Format("2006-01-02 15:04"), b.NRemoved)
+		}
+		return
+	}
+	tbl := util.NewTable("Row", "Status", "Skip", "Date", "Account", "Vendor", "Description", "Amount", "Cat", "Why")
+	n := 0
+	for _, r := range b.Rows {
+		if status != "" && r.Status != status {
+			continue
+		}
+		if n >= maxlst {
+			break
+		}
+		t := r.T
+		acc := account_name(v, t.Aid)
+		if acc == "" {
+			acc = "(" + r.Account + ")"
+		}
+		ven := vendor_name(v, t.Vid)
+		if ven == "" && !util.Blank(r.Vendor) {
+			ven = "(" + util.FixStrLen(r.Vendor, 20, "...") + ")"
+		}
+		cat := ""
+		if len(t.Cats) > 0 {
+			cat = category_name(v, t.Cats[0].Cid)
+			if len(t.Cats) > 1 {
+				cat += fmt.Sprintf(" +%d", len(t.Cats)-1)
+			}
+		}
+		tbl.AddRow(fmt.Sprintf("%4d", r.Row), r.Status, util.SelStr("skip", "", r.Skip), t.Date().Format("06-01-02"),
+			acc, ven, util.FixStrLen(t.Description, 30, "..."), util.StrLeft(t.Amount.String(), 14), cat,
+			strings.Join(r.Reasons, " "))
+		n++
+	}
+	c.Printf("%s\n", tbl.Text())
+	nadd, nskip, nwork := batch_counts(b)
+	c.Printf("Rows: %d. To add: %d. Skipped: %d. Need work: %d.\n", len(b.Rows), nadd, nskip, nwork)
+}
+
+func handle_edit_batch(c *util.Context, cmdline string) {
+	params := make(map[string]string, 10)
+	args, err := ParseCmdLine(cmdline, params)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	b, err := batch_arg(args)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	if b.Status != m1.Batch_Staged {
+		c.Printf("Batch %s is already %s.\n", short_id(b.Bid), b.Status)
+		return
+	}
+	v := m1.GetView()
+	e := &importer.RowEdit{}
+	if s, ok := util.MapAlias(params, "account", "acc"); ok {
+		a := v.AccountByName(s)
+		if a == nil {
+			c.Printf("No account named %q.\n", s)
+			return
+		}
+		e.Aid = a.Aid
+	}
+	if s, ok := util.MapAlias(params, "vendor", "ven"); ok {
+		vv := v.VendorByName(s)
+		if vv == nil {
+			c.Printf("No vendor named %q.\n", s)
+			return
+		}
+		e.Vid = vv.Vid
+	}
+	if s, ok := util.MapAlias(params, "cat", "category"); ok {
+		cat := v.CategoryByName(s)
+		if cat == nil {
+			c.Printf("No category named %q.\n", s)
+			return
+		}
+		e.Cid = cat.Cid
+	}
+	if s, ok := util.MapAlias(params, "skip"); ok {
+		e.SetSkip = true
+		e.Skip, err = util.StrToBool(s, true)
+		if err != nil {
+			c.Printf("Invalid parameter for skip (%s). Err=%v\n", s, err)
+			return
+		}
+	}
+	if e.Aid.IsZero() && e.Vid.IsZero() && e.Cid.IsZero() && !e.SetSkip {
+		c.Printf("Nothing to change. Give account, vendor, cat or skip.\n")
+		return
+	}
+	srows, okrows := util.MapAlias(params, "rows", "row")
+	status, okstatus := util.MapAlias(params, "status")
+	if !okrows && !okstatus {
+		c.Printf("No rows given. Use rows=list or status=name.\n")
+		return
+	}
+	var want map[int]bool
+	if okrows {
+		want, err = parse_row_list(srows)
+		if err != nil {
+			c.Printf("%v\n", err)
+			return
+		}
+	}
+	rows := make([]int, 0, len(b.Rows))
+	for _, r := range b.Rows {
+		if okrows && !want[r.Row] {
+			continue
+		}
+		if okstatus && r.Status != status {
+			continue
+		}
+		rows = append(rows, r.Row)
+	}
+	if okrows && !okstatus && len(rows) != len(want) {
+		c.Printf("Some of the rows (%s) are not in the batch.\n", srows)
+		return
+	}
+	if len(rows) == 0 {
+		c.Printf("No rows to change.\n")
+		return
+	}
+	err = importer.EditBatch(b.Bid, rows, e)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	c.Printf("Number of rows changed: %d\n", len(rows))
+	_, nskip, nwork := batch_counts(m1.GetBatch(b.Bid))
+	c.Printf("Skipped: %d. Need work: %d.\n", nskip, nwork)
+}
+
+func handle_commit_batch(c *util.Context, cmdline string) {
+	params := make(map[string]string, 10)
+	args, err := ParseCmdLine(cmdline, params)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	b, err := batch_arg(args)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	commit_batch(c, b)
+}
+
+// commit_batch checks a batch again and commits it.
+func commit_batch(c *util.Context, b *m1.ImportBatch) {
+	n, err := importer.RecheckBatch(b.Bid)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	if n > 0 {
+		c.Printf("The database changed since the batch was staged, and %d rows changed status.\n", n)
+		c.Printf("Look it over with show-batch, and commit it again.\n")
+		return
+	}
+	b = m1.GetBatch(b.Bid)
+	nadd, _, _ := batch_counts(b)
+	err = m1.CommitBatch(b.Bid)
+	if err != nil {
+		c.Printf("Unable to commit batch %s. Err=%v\n", short_id(b.Bid), err)
+		return
+	}
+	c.Printf("Batch %s committed. Number of rows added: %d\n", short_id(b.Bid), nadd)
+}
+
+func handle_discard_batch(c *util.Context, cmdline string) {
+	params := make(map[string]string, 10)
+	args, err := ParseCmdLine(cmdline, params)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	b, err := batch_arg(args)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	err = m1.DiscardBatch(b.Bid)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	c.Printf("Batch %s discarded.\n", short_id(b.Bid))
+}
+
+func handle_rollback_batch(c *util.Context, cmdline string) {
+	params := make(map[string]string, 10)
+	args, err := ParseCmdLine(cmdline, params)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	b, err := batch_arg(args)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	n, err := m1.RollbackBatch(b.Bid)
+	if err != nil {
+		c.Printf("Unable to roll back batch %s. Err=%v\n", short_id(b.Bid), err)
+		return
+	}
+	c.Printf("Batch %s (%s) rolled back. Number of transactions removed: %d\n", short_id(b.Bid), b.Source, n)
+}
+
+// batch_arg finds the batch whose id starts with the first argument.
+func batch_arg(args []string) (*m1.ImportBatch, error) {
+	if len(args) < 2 {
+		return nil, fmt.Errorf("No batch given. Use list-batches to find its id.")
+	}
+	prefix := strings.ToUpper(args[1])
+	if len(prefix) < 4 {
+		return nil, fmt.Errorf("Give at least 4 characters of the id.")
+	}
+	var found *m1.ImportBatch
+	for _, b := range m1.GetBatches() {
+		if strings.HasPrefix(strings.ToUpper(b.Bid.String()), prefix) {
+			if found != nil {
+				return nil, fmt.Errorf("More than one batch has an id that starts with %q.", args[1])
+			}
+			found = b
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("No batch has the id %q.", args[1])
+	}
+	return found, nil
+}
+
+// parse_row_list reads a list of row numbers, such as "3,5,10-12".
+func parse_row_list(s string) (map[int]bool, error) {
+	rows := make(map[int]bool, 10)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		first, last := part, part
+		if i := strings.Index(part, "-"); i > 0 {
+			first, last = part[:i], part[i+1:]
+		}
+		n1, err1 := strconv.Atoi(strings.TrimSpace(first))
+		n2, err2 := strconv.Atoi(strings.TrimSpace(last))
+		if err1 != nil || err2 != nil || n1 < 1 || n2 < n1 {
+			return nil, fmt.Errorf("Bad row list (%s). Use rows such as 3,5,10-12.", s)
+		}
+		for n := n1; n <= n2; n++ {
+			rows[n] = true
+		}
+	}
+	return rows, nil
+}
@@ -0,0 +1,267 @@
+// --------------------------------------------------------------------
+// cmd_budgets.go -- Commands to set budgets for categories, and to
+// compare them with what was spent.
+//
+// Created 2020-04-20 DLB
+// --------------------------------------------------------------------
+
+package console
+
+import (
+	"dbe/lib/util"
+	m1 "dbe/m1/m1data"
+	"fmt"
+	"time"
+)
+
+var gTopic_budgets string = `
+A budget is the planned spending for a category, each month or each
+year.  The commands are:
+
+  set-budget cat=name amount=dollars period=monthly start=yyyy-mm rollover=none notes=text
+  delete-budget cat=name start=yyyy-mm
+  list-budgets
+  budget-report month=yyyy-mm asof=date
+
+set-budget adds a budget, or changes the one for the category that
+starts in the same month.  The period is monthly (the default) or
+annual.  An annual budget covers the twelve months from its start.
+The start defaults to this month.  A category can have several
+budgets, each in effect until the next one starts, so a budget can be
+raised or lowered from a month on without changing the months before.
+
+The rollover is what carries from one period to the next:
+
+  none     -- Each period starts with the amount (the default).
+  unspent  -- Money left over is added to the next period.
+  all      -- Overspending is also taken out of the next period.
+
+budget-report shows, for each budget in effect in the month (default
+this month), what was spent through asof (default today, or the end
+of the month), against the allowance and against the pace: the part
+of the allowance that would be used by now if it were spent evenly.
+A category marked 'ahead' is spending faster than its pace, and one
+marked 'over' has spent more than its allowance.  Spending is taken
+from the splits, without transfers between accounts.  The categories
+with spending but no budget are listed after.
+
+A budget covers the categories under its category as well (see help
+categories).  So a budget for Auto counts what is spent in Auto:Fuel
+and Auto:Insurance, which can have budgets of their own inside it.
+Only the top budgets are added into the totals.
+`
+
+func init() {
+	RegistorCmd("set-budget", "", "Sets the budget of a category.", handle_set_budget)
+	RegistorCmd("delete-budget", "", "Deletes the budget of a category.", handle_delete_budget)
+	RegistorCmd("list-budgets", "", "Lists the budgets.", handle_list_budgets)
+	RegistorCmd("budget-report", "", "Compares the budgets with what was spent.", handle_budget_report)
+	RegistorTopic("budgets", gTopic_budgets)
+}
+
+func handle_set_budget(c *util.Context, cmdline string) {
+	params := make(map[string]string, 10)
+	_, err := ParseCmdLine(cmdline, params)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	v := m1.GetView()
+	cat, err := budget_category(v, params)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	start, err := budget_month(params, "start")
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	b := &m1.Budget{Cid: cat.Cid, Start: start}
+	if o := v.CategoryBudgetAt(cat.Cid, start); o != nil {
+		bc := *o
+		b = &bc
+	}
+	s, ok := util.MapAlias(params, "amount", "amt")
+	if !ok && b.BudId.IsZero() {
+		c.Printf("No amount given.\n")
+		return
+	}
+	if ok {
+		b.Amount, err = util.ParseMoney(s)
+		if err != nil {
+			c.Printf("Invalid parameter for amount (%s). Err=%v\n", s, err)
+			return
+		}
+	}
+	if s, ok := util.MapAlias(params, "period"); ok {
+		b.Period = s
+	}
+	if s, ok := util.MapAlias(params, "rollover"); ok {
+		b.Rollover = s
+	}
+	if s, ok := util.MapAlias(params, "notes"); ok {
+		b.Notes = s
+	}
+	isnew := b.BudId.IsZero()
+	err = m1.SetBudget(b)
+	if err != nil {
+		c.Printf("Unable to set the budget. Err=%v\n", err)
+		return
+	}
+	what := "changed"
+	if isnew {
+		what = "added"
+	}
+	c.Printf("Budget %s: %s %s %s from %s, rollover %s.\n", what, cat.Name, b.Amount, b.Period,
+		b.Start.Format("2006-01"), m1.RolloverName(b.Rollover))
+}
+
+func handle_delete_budget(c *util.Context, cmdline string) {
+	params := make(map[string]string, 10)
+	_, err := ParseCmdLine(cmdline, params)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	v := m1.GetView()
+	cat, err := budget_category(v, params)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	lst := v.CategoryBudgets(cat.Cid)
+	if len(lst) == 0 {
+		c.Printf("Category %s has no budget.\n", cat.Name)
+		return
+	}
+	b := lst[len(lst)-1]
+	if _, ok := util.MapAlias(params, "start"); ok {
+		start, err := budget_month(params, "start")
+		if err != nil {
+			c.Printf("%v\n", err)
+			return
+		}
+		b = v.CategoryBudgetAt(cat.Cid, start)
+		if b == nil {
+			c.Printf("Category %s has no budget starting %s.\n", cat.Name, start.Format("2006-01"))
+			return
+		}
+	} else if len(lst) > 1 {
+		c.Printf("Category %s has %d budgets. Give the start of the one to delete.\n", cat.Name, len(lst))
+		return
+	}
+	err = m1.DeleteBudget(b.BudId)
+	if err != nil {
+		c.Printf("Unable to delete the budget. Err=%v\n", err)
+		return
+	}
+	c.Printf("Budget of %s starting %s deleted.\n", cat.Name, b.Start.Format("2006-01"))
+}
+
+func handle_list_budgets(c *util.Context, cmdline string) {
+	params := make(map[string]string, 10)
+	_, err := ParseCmdLine(cmdline, params)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	v := m1.GetView()
+	lst := v.Budgets()
+	tbl := util.NewTable("Category", "Amount", "Period", "Start", "Rollover", "Notes")
+	for _, b := range lst {
+		tbl.AddRow(category_name(v, b.Cid), fmt.Sprintf("%12s", b.Amount), b.Period, b.Start.Format("2006-01"),
+			m1.RolloverName(b.Rollover), b.Notes)
+	}
+	c.Printf("%s\n", tbl.Text())
+	c.Printf("Number of budgets: %d\n", len(lst))
+}
+
+func handle_budget_report(c *util.Context, cmdline string) {
+	params := make(map[string]string, 10)
+	_, err := ParseCmdLine(cmdline, params)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	month, err := budget_month(params, "month")
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	now := time.Now()
+	asof := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if s, ok := util.MapAlias(params, "asof"); ok {
+		asof, err = util.ParseGenericTime(s)
+		if err != nil {
+			c.Printf("Invalid parameter for asof (%s). Err=%v\n", s, err)
+			return
+		}
+	}
+	r, err := m1.GetView().BudgetReport(month, asof)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	c.Printf("Budget report for %s, through %s.\n\n", r.Month.Format("2006-01"), r.AsOf.Format("2006-01-02"))
+	tbl := util.NewTable("Category", "Period", "Carry", "Allowance", "Pace", "Spent", "Left", "Status")
+	for _, ln := range r.Lines {
+		period := ln.From.Format("2006-01")
+		if ln.B.Period == m1.Budget_Annual {
+			period += " to " + ln.To.AddDate(0, 0, -1).Format("2006-01")
+		}
+		status := ln.Status
+		if status == m1.BudgetStatus_OK {
+			status = ""
+		}
+		tbl.AddRow(tree_name(ln.Name, ln.Depth), period, fmt.Sprintf("%10s", ln.Carry), fmt.Sprintf("%10s", ln.Allowance),
+			fmt.Sprintf("%10s", ln.Pace), fmt.Sprintf("%10s", ln.Spent), fmt.Sprintf("%10s", ln.Left), status)
+	}
+	c.Printf("%s\n", tbl.Text())
+	c.Printf("Monthly budgets: %s allowed, %s spent.\n", r.Budgeted, r.Spent)
+	if len(r.Unbudgeted) == 0 {
+		return
+	}
+	c.Printf("\nSpending without a budget this month:\n\n")
+	tbl = util.NewTable("Category", "Spent")
+	for _, ct := range r.Unbudgeted {
+		name := ct.Name
+		if ct.Cid.IsZero() {
+			name = "(uncategorized)"
+		}
+		tbl.AddRow(name, fmt.Sprintf("%10s", -ct.Net()))
+	}
+	c.Printf("%s\n", tbl.Text())
+	c.Printf("Total without a budget: %s\n", r.Unspent)
+}
+
+// budget_category returns the category named by the cat parameter.
+func budget_category(v *m1.View, params map[string]string) (*m1.Category, error) {
+	name, ok := util.MapAlias(params, "cat", "category")
+	if !ok {
+		return nil, fmt.Errorf("No category given.")
+	}
+	cat := v.CategoryByName(name)
+	if cat == nil {
+		return nil, fmt.Errorf("No category named %q.", name)
+	}
+	return cat, nil
+}
+
+// budget_month returns the month given by a parameter, as yyyy-mm or a
+// date, or this month if it is not given.
+func budget_month(params map[string]string, key string) (time.Time, error) {
+	s, ok := util.MapAlias(params, key)
+	if !ok {
+		now := time.Now()
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC), nil
+	}
+	m, err := time.Parse("2006-01", s)
+	if err != nil {
+		m, err = util.ParseGenericTime(s)
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid parameter for %s (%s). Use yyyy-mm.", key, s)
+	}
+	return time.Date(m.Year(), m.Month(), 1, 0, 0, 0, 0, time.UTC), nil
+}
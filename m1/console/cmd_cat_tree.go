@@ -0,0 +1,82 @@
+// --------------------------------------------------------------------
+// cmd_cat_tree.go -- Commands to arrange the categories in a tree.
+//
+// Created 2020-04-20 DLB
+// --------------------------------------------------------------------
+
+package console
+
+import (
+	"dbe/lib/util"
+	m1 "dbe/m1/m1data"
+)
+
+var gTopic_categories string = `
+Categories can be put under other categories, so that the totals of
+Auto:Fuel and Auto:Insurance roll up to Auto.  Every report by
+category (category-report, budget-report, olddata cat) shows the
+subtotals of each level.  The commands are:
+
+  set-cat-parent cat=name parent=name
+  build-cat-tree
+
+set-cat-parent puts a category under another.  Leave out the parent
+to make it a top category again.  A category cannot be put under
+itself, or under a category that is already under it.
+
+build-cat-tree uses the colon-separated names from the old
+spreadsheet: each category named like Auto:Fuel is put under the
+category Auto, which is made if it does not exist.  Categories that
+already have a parent are left alone.  It is run after olddata load,
+and can be run again at any time.
+
+When a category is merged or deleted with a replacement, the
+categories under it move to the replacement.
+`
+
+func init() {
+	RegistorCmd("set-cat-parent", "", "Puts a category under another.", handle_set_cat_parent)
+	RegistorCmd("build-cat-tree", "", "Builds the category tree from colon-separated names.", handle_build_cat_tree)
+	RegistorTopic("categories", gTopic_categories)
+}
+
+func handle_set_cat_parent(c *util.Context, cmdline string) {
+	params := make(map[string]string, 10)
+	_, err := ParseCmdLine(cmdline, params)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	name, ok := util.MapAlias(params, "cat", "category")
+	if !ok {
+		c.Printf("No category given.\n")
+		return
+	}
+	parent, _ := util.MapAlias(params, "parent")
+	err = m1.SetCategoryParent(name, parent)
+	if err != nil {
+		c.Printf("Unable to set the parent. Err=%v\n", err)
+		return
+	}
+	if util.Blank(parent) {
+		c.Printf("Category %s is now a top category.\n", name)
+		return
+	}
+	c.Printf("Category %s is now under %s.\n", name, parent)
+}
+
+func handle_build_cat_tree(c *util.Context, cmdline string) {
+	params := make(map[string]string, 10)
+	_, err := ParseCmdLine(cmdline, params)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	nmade, nlinked, err := m1.BuildCategoryTree()
+	if err != nil {
+		c.Printf("Unable to build the category tree. Err=%v\n", err)
+		return
+	}
+	c.Printf("Number of categories made: %d\n", nmade)
+	c.Printf("Number of categories put under a parent: %d\n", nlinked)
+}
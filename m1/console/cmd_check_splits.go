@@ -0,0 +1,80 @@
+// --------------------------------------------------------------------
+// cmd_check_splits.go -- Reports transactions with unbalanced splits.
+//
+// Created 2020-04-08 DLB
+// --------------------------------------------------------------------
+
+package console
+
+import (
+	"dbe/lib/util"
+	"dbe/lib/uuid"
+	m1 "dbe/m1/m1data"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var gTopic_check_splits string = `
+The check-splits command lists all the transactions whose category
+splits do not balance.  A transaction balances if its splits add up
+to its amount, every split names a known category, and no split
+is for zero.  The format of the command is:
+
+  check-splits max=nnn
+
+where nnn is the max number of transactions listed. The default for
+max is 100.  The total number of bad transactions is always given.
+
+Whether AddTransaction refuses unbalanced transactions or only warns
+about them is set with the 'split_check' config parameter, which can
+be 'warn' (the default) or 'strict'.
+
+`
+
+func init() {
+	RegistorCmd("check-splits", "", "Lists transactions whose splits do not balance.", handle_check_splits)
+	RegistorTopic("check-splits", gTopic_check_splits)
+}
+
+func handle_check_splits(c *util.Context, cmdline string) {
+	params := make(map[string]string, 10)
+	_, err := ParseCmdLine(cmdline, params)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	maxlst := 100
+	smax, ok := util.MapAlias(params, "max")
+	if ok {
+		maxlst, err = strconv.Atoi(smax)
+		if err != nil {
+			c.Printf("Invalid paramger for max. (%s), Err=%v\n", smax, err)
+			return
+		}
+	}
+
+	v := m1.GetView()
+	probs := v.CheckSplits()
+	tmap := make(map[uuid.UUID]*m1.Transaction, len(probs))
+	for _, p := range probs {
+		tmap[p.Tid] = v.Transaction(p.Tid)
+	}
+	sort.Slice(probs, func(i, j int) bool {
+		return tmap[probs[i].Tid].Date().Before(tmap[probs[j].Tid].Date())
+	})
+
+	tbl := util.NewTable("Date", "Account", "Description", "Amount", "Problem")
+	for i, p := range probs {
+		if i >= maxlst {
+			break
+		}
+		t := tmap[p.Tid]
+		samt := util.StrLeft(t.Amount.String(), 14)
+		tbl.AddRow(t.Date().Format("06-01-02"), m1.AccountName(t.Aid), util.FixStrLen(t.Description, 40, "..."),
+			samt, strings.Join(p.Problems, " "))
+	}
+	c.Printf("%s\n", tbl.Text())
+	c.Printf("Split check mode: %s\n", m1.GetSplitCheckMode())
+	c.Printf("Number of transactions with bad splits: %d\n", len(probs))
+}
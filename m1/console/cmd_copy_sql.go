@@ -0,0 +1,290 @@
+// --------------------------------------------------------------------
+// cmd_copy_sql.go -- Copies the database to and from mysql.
+//
+// Created 2020-04-06 DLB
+// --------------------------------------------------------------------
+
+package console
+
+import (
+	"dbe/lib/util"
+	"dbe/lib/uuid"
+	m1 "dbe/m1/m1data"
+	"dbe/m1/m1sql"
+	"sort"
+	"strings"
+)
+
+var gTopic_copy_sql string = `
+The copy-sql command copies the entire database to or from the mysql
+database.  The format of the command is:
+
+  copy-sql to
+  copy-sql from
+
+where "to" replaces everything in mysql with the current database,
+and "from" replaces the current database with everything in mysql.
+Each copy is done in a single sql transaction, so a failure leaves
+mysql unchanged.  Vendors, categories, transactions, budgets and
+reconciliations keep their ids, so the reconciled periods stay
+locked, and the categories keep their parents.  Mysql numbers the
+accounts, so when they are copied back they are matched to the
+current accounts by name.  The import batches and the duplicate
+review queue are not kept in mysql, so "copy-sql from" keeps the
+ones in the current database.
+
+A mysql database made with the first CreateDatabase.sql is missing
+columns and tables, and both copies fail with an error until it is
+upgraded with m1sql/UpgradeDatabase_v2.sql.
+
+WARNING: "copy-sql from" overwrites the current database and can
+lead to a loss of data.  It is usually best to do a make-backup
+first.  The copied data is saved as the new primary data.
+
+This command is only available if the sql_pw config parameter
+is provided.
+
+`
+
+func init() {
+	RegistorCmd("copy-sql", "", "Copies the database to or from mysql.", handle_copy_sql)
+	RegistorTopic("copy-sql", gTopic_copy_sql)
+}
+
+func handle_copy_sql(c *util.Context, cmdline string) {
+	params := make(map[string]string, 10)
+	args, err := ParseCmdLine(cmdline, params)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	if !m1sql.IsOpen() {
+		c.Printf("Mysql is not open.  Provide sql_pw in the config file.\n")
+		return
+	}
+	if len(args) < 2 {
+		c.Printf("Direction (to or from) not provided.\n")
+		return
+	}
+	switch strings.ToLower(args[1]) {
+	case "to":
+		copy_to_sql(c)
+	case "from":
+		copy_from_sql(c)
+	default:
+		c.Printf("Unknown argument (%s)\n", args[1])
+	}
+}
+
+func copy_to_sql(c *util.Context) {
+	sd := &m1sql.AllData{}
+	aids := make(map[uuid.UUID]int, 10)
+	accounts := m1.GetAccounts()
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].FName < accounts[j].FName })
+	for i, a := range accounts {
+		sa := &m1sql.Account{Aid: i + 1, ShortName: a.ShortName, DName: a.DName, FName: a.FName,
+			Notes: a.Notes, Active: a.Active, Aliases: make([]m1sql.Alias, 0, len(a.Aliases)),
+			OpeningBalance: a.OpeningBalance.Cents(), OpeningDate: a.OpeningDate}
+		for _, alias := range a.Aliases {
+			sa.Aliases = append(sa.Aliases, m1sql.Alias{Name: alias})
+		}
+		aids[a.Aid] = sa.Aid
+		sd.Accounts = append(sd.Accounts, sa)
+	}
+	for _, v := range m1.GetVendors() {
+		sv := &m1sql.Vendor{Vid: v.Vid, DName: v.DName, FName: v.FName, DefaultCat: m1.CategoryName(v.DefaultCid),
+			BusinessType: v.BusinessType, PrimaryProduct: v.PrimaryProduct, Notes: v.Notes, Aliases: v.Aliases}
+		sd.Vendors = append(sd.Vendors, sv)
+	}
+	for _, cat := range m1.GetCategories() {
+		sc := &m1sql.Category{Cid: cat.Cid, Name: cat.Name, Notes: cat.Notes, Parent: cat.Parent,
+			Aliases: cat.Aliases}
+		sd.Categories = append(sd.Categories, sc)
+	}
+	nbad := 0
+	for _, t := range m1.GetTransactions() {
+		st := &m1sql.Transaction{Tid: t.Tid, Amount: t.Amount.Cents(), Description: t.Description,
+			DatePosted: t.DatePosted, DateSettled: t.DateSettled, Month: t.Month,
+			Aid: aids[t.Aid], Vid: t.Vid, BankInfo: t.BankInfo, Location: t.Location,
+			CheckNum: t.CheckNum, FitId: t.FitId, Flag: t.Flag, Notes: t.Notes, Receipts: t.Receipts,
+			ImportId: t.ImportId, Cleared: t.Cleared, RecId: t.RecId, Xfer: t.Xfer,
+			Cats: make([]m1sql.CatListItem, 0, len(t.Cats))}
+		if st.Aid == 0 {
+			c.Printf("Transaction %s has an unknown account (%s).\n", t.Tid, t.Aid)
+			nbad++
+		}
+		for _, ci := range t.Cats {
+			st.Cats = append(st.Cats, m1sql.CatListItem{Cid: ci.Cid, Amount: ci.Amount.Cents(), Notes: ci.Notes})
+		}
+		sd.Transactions = append(sd.Transactions, st)
+	}
+	v := m1.GetView()
+	for _, a := range accounts {
+		for _, r := range v.Reconciliations(a.Aid) {
+			sd.Recons = append(sd.Recons, &m1sql.Recon{RecId: r.RecId, Aid: aids[r.Aid], Month: r.Month, End: r.End,
+				StartBal: r.StartBal.Cents(), EndBal: r.EndBal.Cents(), Status: r.Status, User: r.User,
+				Created: r.Created, Finished: r.Finished, NCleared: r.NCleared})
+		}
+	}
+	for _, b := range v.Budgets() {
+		sd.Budgets = append(sd.Budgets, &m1sql.Budget{BudId: b.BudId, Cid: b.Cid, Period: b.Period,
+			Amount: b.Amount.Cents(), Rollover: b.Rollover, Start: b.Start, Notes: b.Notes})
+	}
+	if nbad > 0 {
+		c.Printf("%d bad references found.  Nothing copied.\n", nbad)
+		return
+	}
+	err := m1sql.ReplaceAllData(sd)
+	if err != nil {
+		c.Printf("Error: %v\n", err)
+		return
+	}
+	c.Printf("Copied %d accounts, %d vendors, %d categories, %d transactions, %d reconciliations "+
+		"and %d budgets to mysql.\n", len(sd.Accounts), len(sd.Vendors), len(sd.Categories),
+		len(sd.Transactions), len(sd.Recons), len(sd.Budgets))
+	c.Printf("Success.\n")
+}
+
+func copy_from_sql(c *util.Context) {
+	sd, err := m1sql.GetAllData()
+	if err != nil {
+		c.Printf("Error: %v\n", err)
+		return
+	}
+	d := &m1.Database{}
+	d.Accounts = make(map[uuid.UUID]*m1.Account, len(sd.Accounts))
+	d.Vendors = make(map[uuid.UUID]*m1.Vendor, len(sd.Vendors))
+	d.Categories = make(map[uuid.UUID]*m1.Category, len(sd.Categories))
+	d.Transactions = make(map[uuid.UUID]*m1.Transaction, len(sd.Transactions))
+	d.Recons = make(map[uuid.UUID]*m1.Reconciliation, len(sd.Recons))
+	d.Budgets = make(map[uuid.UUID]*m1.Budget, len(sd.Budgets))
+	// Mysql numbers the accounts, so keep the id of an account that
+	// already exists with the same name.
+	accids := make(map[int]uuid.UUID, len(sd.Accounts))
+	for _, sa := range sd.Accounts {
+		a := &m1.Account{Aid: uuid.New(), ShortName: sa.ShortName, DName: sa.DName, FName: sa.FName,
+			Notes: sa.Notes, Active: sa.Active, Aliases: make([]string, 0, len(sa.Aliases)),
+			OpeningBalance: util.Money(sa.OpeningBalance), OpeningDate: sa.OpeningDate}
+		if old := m1.GetAccountByName(sa.FName); old != nil {
+			a.Aid = old.Aid
+		}
+		for _, alias := range sa.Aliases {
+			a.Aliases = append(a.Aliases, alias.Name)
+		}
+		accids[sa.Aid] = a.Aid
+		d.Accounts[a.Aid] = a
+	}
+	catids := make(map[string]uuid.UUID, len(sd.Categories))
+	for _, sc := range sd.Categories {
+		cat := &m1.Category{Cid: sc.Cid, Name: sc.Name, Aliases: sc.Aliases, Notes: sc.Notes, Parent: sc.Parent}
+		catids[cat.Name] = cat.Cid
+		d.Categories[cat.Cid] = cat
+	}
+	nbad := 0
+	for _, cat := range d.Categories {
+		if _, ok := d.Categories[cat.Parent]; !ok && !cat.Parent.IsZero() {
+			c.Printf("Category %q has an unknown parent (%s).\n", cat.Name, cat.Parent)
+			nbad++
+		}
+	}
+	for _, sr := range sd.Recons {
+		r := &m1.Reconciliation{RecId: sr.RecId, Aid: accids[sr.Aid], Month: sr.Month, End: sr.End,
+			StartBal: util.Money(sr.StartBal), EndBal: util.Money(sr.EndBal), Status: sr.Status, User: sr.User,
+			Created: sr.Created, Finished: sr.Finished, NCleared: sr.NCleared}
+		if r.Aid.IsZero() {
+			c.Printf("Reconciliation %s has an unknown account (%d).\n", sr.RecId, sr.Aid)
+			nbad++
+		}
+		d.Recons[r.RecId] = r
+	}
+	for _, sb := range sd.Budgets {
+		b := &m1.Budget{BudId: sb.BudId, Cid: sb.Cid, Period: sb.Period, Amount: util.Money(sb.Amount),
+			Rollover: sb.Rollover, Start: sb.Start, Notes: sb.Notes}
+		if _, ok := d.Categories[b.Cid]; !ok {
+			c.Printf("Budget %s has an unknown category (%s).\n", sb.BudId, sb.Cid)
+			nbad++
+		}
+		d.Budgets[b.BudId] = b
+	}
+	for _, sv := range sd.Vendors {
+		v := &m1.Vendor{Vid: sv.Vid, FName: sv.FName, DName: sv.DName, Aliases: sv.Aliases,
+			PrimaryProduct: sv.PrimaryProduct, BusinessType: sv.BusinessType,
+			DefaultCid: catids[sv.DefaultCat], Notes: sv.Notes}
+		if v.DefaultCid.IsZero() && !util.Blank(sv.DefaultCat) {
+			c.Printf("Vendor %q has an unknown default category (%q).\n", sv.FName, sv.DefaultCat)
+			nbad++
+		}
+		d.Vendors[v.Vid] = v
+	}
+	for _, st := range sd.Transactions {
+		t := &m1.Transaction{Tid: st.Tid, Amount: util.Money(st.Amount), Aid: accids[st.Aid], Vid: st.Vid,
+			Description: st.Description, DatePosted: st.DatePosted, DateSettled: st.DateSettled, Month: st.Month,
+			BankInfo: st.BankInfo, Location: st.Location, CheckNum: st.CheckNum, FitId: st.FitId, Flag: st.Flag,
+			Receipts: st.Receipts, Notes: st.Notes, ImportId: st.ImportId, Cleared: st.Cleared, RecId: st.RecId,
+			Xfer: st.Xfer, Cats: make([]m1.CatItem, 0, len(st.Cats))}
+		if t.Aid.IsZero() {
+			c.Printf("Transaction %s has an unknown account (%d).\n", st.Tid, st.Aid)
+			nbad++
+		}
+		if _, ok := d.Recons[st.RecId]; !ok && !st.RecId.IsZero() {
+			c.Printf("Transaction %s has an unknown reconciliation (%s).\n", st.Tid, st.RecId)
+			nbad++
+		}
+		if _, ok := d.Vendors[st.Vid]; !ok && !st.Vid.IsZero() {
+			c.Printf("Transaction %s has an unknown vendor (%s).\n", st.Tid, st.Vid)
+			nbad++
+		}
+		for _, ci := range st.Cats {
+			if _, ok := d.Categories[ci.Cid]; !ok && !ci.Cid.IsZero() {
+				c.Printf("Transaction %s has an unknown category (%s).\n", st.Tid, ci.Cid)
+				nbad++
+			}
+			t.Cats = append(t.Cats, m1.CatItem{Amount: util.Money(ci.Amount), Cid: ci.Cid, Notes: ci.Notes})
+		}
+		d.Transactions[t.Tid] = t
+	}
+	nbad += keep_local_data(c, d)
+	if nbad > 0 {
+		c.Printf("%d bad references found.  Nothing copied.\n", nbad)
+		return
+	}
+	err = m1.ReplaceDatabase(d)
+	if err != nil {
+		c.Printf("Error: %v\n", err)
+		return
+	}
+	c.Printf("Copied %d accounts, %d vendors, %d categories, %d transactions, %d reconciliations "+
+		"and %d budgets from mysql.\n", len(d.Accounts), len(d.Vendors), len(d.Categories),
+		len(d.Transactions), len(d.Recons), len(d.Budgets))
+	c.Printf("Kept %d import batches and %d reviews.\n", len(d.Batches), len(d.Reviews))
+	c.Printf("Success.\n")
+}
+
+// keep_local_data carries over what is not kept in mysql from the
+// current database: the import batches, so that they can still be
+// rolled back and the same file is not imported twice, and the review
+// queue.  The number of items that refer to an account that is not in
+// the new database is returned.
+func keep_local_data(c *util.Context, d *m1.Database) int {
+	v := m1.GetView()
+	nbad := 0
+	d.Batches = make(map[uuid.UUID]*m1.ImportBatch, 10)
+	for _, b := range v.Batches() {
+		for _, r := range b.Rows {
+			if _, ok := d.Accounts[r.T.Aid]; !ok && !r.T.Aid.IsZero() {
+				c.Printf("Row %d of import batch %s has an unknown account (%s).\n", r.Row, b.Bid, r.T.Aid)
+				nbad++
+			}
+		}
+		d.Batches[b.Bid] = b
+	}
+	d.Reviews = make(map[uuid.UUID]*m1.DupReview, 10)
+	for _, r := range v.Reviews() {
+		if _, ok := d.Accounts[r.T.Aid]; !ok {
+			c.Printf("Review %s has an unknown account (%s).\n", r.Rid, r.T.Aid)
+			nbad++
+		}
+		d.Reviews[r.Rid] = r
+	}
+	return nbad
+}
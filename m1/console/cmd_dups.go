@@ -0,0 +1,154 @@
+// --------------------------------------------------------------------
+// cmd_dups.go -- Commands to work the duplicate review queue.
+//
+// Created 2020-04-19 DLB
+// --------------------------------------------------------------------
+
+package console
+
+import (
+	"dbe/lib/util"
+	"dbe/lib/uuid"
+	m1 "dbe/m1/m1data"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var gTopic_dups string = `
+When transactions are imported, each one is compared to the
+transactions already in the account with the same amount.  The
+closer the dates and descriptions are, the higher the score (0 to
+100).  A transaction that scores 90 or more, or has the same bank
+id, is a duplicate and is skipped.  One that scores from 50 to 89
+might be a duplicate, and is put in the review queue instead of the
+database.  The commands for the queue are:
+
+  list-dups max=nnn
+  accept-dup id
+  reject-dup id
+
+list-dups shows the transactions in the queue, with the transaction
+in the database that each one looks like, and why.  The id is the
+first few characters of the Id column (or 'all' for every item).
+
+accept-dup agrees that the transaction is a duplicate, so it is
+dropped.  reject-dup says that it is not, so it is added to the
+database.
+`
+
+func init() {
+	RegistorCmd("list-dups", "", "Lists transactions waiting for duplicate review.", handle_list_dups)
+	RegistorCmd("accept-dup", "", "Drops a transaction in the review queue as a duplicate.", handle_accept_dup)
+	RegistorCmd("reject-dup", "", "Adds a transaction in the review queue, as not a duplicate.", handle_reject_dup)
+	RegistorTopic("dups", gTopic_dups)
+}
+
+func handle_list_dups(c *util.Context, cmdline string) {
+	params := make(map[string]string, 10)
+	_, err := ParseCmdLine(cmdline, params)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	maxlst := 100
+	smax, ok := util.MapAlias(params, "max")
+	if ok {
+		maxlst, err = strconv.Atoi(smax)
+		if err != nil {
+			c.Printf("Invalid paramger for max. (%s), Err=%v\n", smax, err)
+			return
+		}
+	}
+	v := m1.GetView()
+	lst := v.Reviews()
+	tbl := util.NewTable("Id", "Date", "Account", "Description", "Amount", "Score", "Looks Like", "Why")
+	for i, r := range lst {
+		if i >= maxlst {
+			break
+		}
+		t := r.T
+		like := "(gone)"
+		if o := v.Transaction(r.DupOf); o != nil {
+			like = o.Date().Format("06-01-02") + " " + util.FixStrLen(o.Description, 30, "...")
+		}
+		tbl.AddRow(short_id(r.Rid), t.Date().Format("06-01-02"), account_name(v, t.Aid),
+			util.FixStrLen(t.Description, 30, "..."), util.StrLeft(t.Amount.String(), 14),
+			fmt.Sprintf("%3d", r.Score), like, strings.Join(r.Reasons, " "))
+	}
+	c.Printf("%s\n", tbl.Text())
+	c.Printf("Number of transactions waiting for review: %d\n", len(lst))
+}
+
+func handle_accept_dup(c *util.Context, cmdline string) {
+	decide_dups(c, cmdline, "dropped", m1.AcceptDuplicate)
+}
+
+func handle_reject_dup(c *util.Context, cmdline string) {
+	decide_dups(c, cmdline, "added", m1.RejectDuplicate)
+}
+
+// decide_dups runs accept-dup or reject-dup on the reviews named on the
+// command line.
+func decide_dups(c *util.Context, cmdline, what string, fdecide func(rid uuid.UUID) error) {
+	params := make(map[string]string, 10)
+	args, err := ParseCmdLine(cmdline, params)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	if len(args) < 2 {
+		c.Printf("No id given. Use list-dups to find it.\n")
+		return
+	}
+	lst, err := find_reviews(args[1])
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	n := 0
+	for _, r := range lst {
+		err = fdecide(r.Rid)
+		if err != nil {
+			c.Printf("Unable to decide on %s. Err=%v\n", short_id(r.Rid), err)
+			continue
+		}
+		n++
+	}
+	c.Printf("Number of transactions %s: %d\n", what, n)
+}
+
+// find_reviews returns the reviews whose id starts with the given
+// characters, or all of them for 'all'.  A prefix that matches more than
+// one review is an error.
+func find_reviews(prefix string) ([]*m1.DupReview, error) {
+	all := m1.GetReviews()
+	if strings.ToLower(prefix) == "all" {
+		return all, nil
+	}
+	if len(prefix) < 4 {
+		return nil, fmt.Errorf("Give at least 4 characters of the id.")
+	}
+	lst := make([]*m1.DupReview, 0, 1)
+	for _, r := range all {
+		if strings.HasPrefix(strings.ToUpper(r.Rid.String()), strings.ToUpper(prefix)) {
+			lst = append(lst, r)
+		}
+	}
+	if len(lst) == 0 {
+		return nil, fmt.Errorf("No transaction in the review queue has the id %q.", prefix)
+	}
+	if len(lst) > 1 {
+		return nil, fmt.Errorf("More than one transaction in the review queue has an id that starts with %q.", prefix)
+	}
+	return lst, nil
+}
+
+// short_id returns the first part of an id, for tables.
+func short_id(id uuid.UUID) string {
+	s := id.String()
+	if len(s) > 8 {
+		return s[:8]
+	}
+	return s
+}
@@ -0,0 +1,70 @@
+// --------------------------------------------------------------------
+// cmd_export_qif.go -- Exports transactions to QIF files.
+//
+// Created 2020-04-17 DLB
+// --------------------------------------------------------------------
+
+package console
+
+import (
+	"dbe/lib/util"
+	"dbe/m1/importer"
+	m1 "dbe/m1/m1data"
+	"os"
+)
+
+var gTopic_export_qif string = `
+The export-qif command writes the transactions of one account to
+a QIF file.  The format of the command is:
+
+  export-qif filename account=name type=bank from=date to=date
+
+where type is the kind of account for Quicken: bank (the default),
+ccard or cash.  The from and to dates are optional, and include the
+days given.
+`
+
+func init() {
+	RegistorCmd("export-qif", "", "Exports an account's transactions to a QIF file.", handle_export_qif)
+	RegistorTopic("export-qif", gTopic_export_qif)
+}
+
+func handle_export_qif(c *util.Context, cmdline string) {
+	params := make(map[string]string, 10)
+	args, err := ParseCmdLine(cmdline, params)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	if len(args) < 2 {
+		c.Printf("No file given.\n")
+		return
+	}
+	if _, ok := util.MapAlias(params, "account", "acc"); !ok {
+		c.Printf("No account given.\n")
+		return
+	}
+	qtype, _ := util.MapAlias(params, "type")
+	delete(params, "type")
+	q := &m1.Query{}
+	err = parse_query_params(m1.GetView(), params, q)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	f, err := os.Create(args[1])
+	if err != nil {
+		c.Printf("Unable to create %s. Err=%v\n", args[1], err)
+		return
+	}
+	n, err := importer.ExportQIF(f, q, qtype)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		c.Printf("Error = %v.\n", err)
+		return
+	}
+	c.Printf("%d transactions written to %s.\n", n, args[1])
+	c.Printf("Success.\n")
+}
@@ -0,0 +1,176 @@
+// --------------------------------------------------------------------
+// cmd_import.go -- Imports transactions from files.
+//
+// Created 2020-04-16 DLB
+// --------------------------------------------------------------------
+
+package console
+
+import (
+	"dbe/lib/util"
+	"dbe/m1/importer"
+	m1 "dbe/m1/m1data"
+	"fmt"
+	"strings"
+)
+
+var gTopic_import string = `
+The import command imports the transactions in a file downloaded
+from a bank, or kept by another program.  The format of the command
+is:
+
+  import filename format=name profile=name account=name dryrun=true
+         commit=true force=true
+
+The kind of file is found from its name and what is in it, so format
+is only needed when that is not enough.  The formats are listed by
+the import-formats command, and include OFX/QFX statements, Quicken
+QIF files, bank CSV files and the old data files.  For a CSV file, the
+profile tells which columns hold the date, description, amount and
+so on.  If none is given, the profile whose header fits the file is
+used.  The profiles are kept in the file import_profiles.txt,
+next to config.txt (or in the file given by the 'import_profiles'
+config parameter).  See import_profiles_example.txt for how to write
+one.
+
+The account for each statement (or group of rows) in the file is
+found by matching the account number or name in it against the names
+and aliases of the accounts.  If that fails, the account given with
+the account parameter is used, and the number or name from the file
+is added to its aliases when the batch is committed, so it is not
+needed again.  Rolling the batch back takes the alias away.
+
+The transactions are staged in a batch, to be looked over and then
+committed (see 'help batches').  Transactions that were already
+imported (same bank id, or close enough in date and description) are
+skipped, and ones that might have been will go to the duplicate
+review queue (see 'help dups').  The vendor is found from the payee
+name, and the rules fill in the rest (see 'help rules').  Categories
+in the file (as in QIF) are found through the names and aliases of
+the categories.
+
+If commit is true, the batch is committed right away, unless some of
+its rows need work.  If dryrun is true, nothing is changed and the
+command only reports what would be imported.  A file that was
+imported before is refused, unless force is true (see 'help batches').
+`
+
+var gTopic_import_formats string = `
+The import-formats command lists the kinds of files that the import
+command knows, and the CSV profiles.  It takes no arguments.
+`
+
+func init() {
+	RegistorCmd("import", "", "Imports transactions from a file.", handle_import)
+	RegistorTopic("import", gTopic_import)
+	RegistorCmd("import-formats", "", "Lists the kinds of files that can be imported.", handle_import_formats)
+	RegistorTopic("import-formats", gTopic_import_formats)
+}
+
+func handle_import(c *util.Context, cmdline string) {
+	run_import(c, cmdline)
+}
+
+func handle_import_formats(c *util.Context, cmdline string) {
+	tbl := util.NewTable("Format", "Description")
+	for _, imp := range importer.GetImporters() {
+		tbl.AddRow(imp.Name(), imp.Description())
+	}
+	c.Printf("%s\n", tbl.Text())
+	profiles, err := importer.LoadProfiles()
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	c.Printf("CSV profiles in %s: %s\n", importer.ProfilesFile(),
+		strings.Join(importer.ProfileNames(profiles), ", "))
+}
+
+// run_import parses the arguments of the import command, runs the
+// import and prints its report.
+func run_import(c *util.Context, cmdline string) {
+	params := make(map[string]string, 10)
+	args, err := ParseCmdLine(cmdline, params)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	if len(args) < 2 {
+		c.Printf("No file given.\n")
+		return
+	}
+	opt := &importer.Options{User: c.User()}
+	opt.Format, _ = util.MapAlias(params, "format", "fmt")
+	opt.Profile, _ = util.MapAlias(params, "profile", "prof")
+	opt.Account, _ = util.MapAlias(params, "account", "acc")
+	sdry, ok := util.MapAlias(params, "dryrun", "dry")
+	if ok {
+		opt.DryRun, err = util.StrToBool(sdry, false)
+		if err != nil {
+			c.Printf("Invalid value for dryrun (%s). Err=%v\n", sdry, err)
+			return
+		}
+	}
+	if s, ok := util.MapAlias(params, "force"); ok {
+		opt.Force, err = util.StrToBool(s, false)
+		if err != nil {
+			c.Printf("Invalid value for force (%s). Err=%v\n", s, err)
+			return
+		}
+	}
+
+	docommit := false
+	if s, ok := util.MapAlias(params, "commit"); ok {
+		docommit, err = util.StrToBool(s, false)
+		if err != nil {
+			c.Printf("Invalid value for commit (%s). Err=%v\n", s, err)
+			return
+		}
+	}
+
+	rpt, err := importer.ImportFile(args[1], opt)
+	if rpt != nil {
+		print_import_report(c, rpt)
+	}
+	if err != nil {
+		c.Printf("Error = %v.\n", err)
+		return
+	}
+	if opt.DryRun {
+		c.Printf("Dry run. Nothing was changed.\n")
+		return
+	}
+	if rpt.Bid.IsZero() {
+		c.Printf("Nothing new to import.\n")
+		return
+	}
+	b := m1.GetBatch(rpt.Bid)
+	if _, _, nwork := batch_counts(b); docommit && nwork == 0 {
+		commit_batch(c, b)
+		return
+	}
+	c.Printf("Staged as batch %s. Use show-batch to look it over, and commit-batch to add it.\n",
+		short_id(rpt.Bid))
+}
+
+func print_import_report(c *util.Context, rpt *importer.Report) {
+	c.Printf("File: %s (%s)\n", rpt.FileName, rpt.Format)
+	tbl := util.NewTable("AcctId", "Account", "Found", "To Add", "Duplicates", "Review", "Ruled", "No Vendor", "No Cat",
+		"No Account", "Problems")
+	for _, s := range rpt.Statements {
+		acc := s.Account
+		if s.NewAlias {
+			acc += " (new alias)"
+		}
+		tbl.AddRow(s.AcctId, acc, fmt.Sprintf("%5d", s.NFound), fmt.Sprintf("%5d", s.NAdded),
+			fmt.Sprintf("%5d", s.NDuplicates), fmt.Sprintf("%5d", s.NReview), fmt.Sprintf("%5d", s.NRuled),
+			fmt.Sprintf("%5d", s.NNoVendor),
+			fmt.Sprintf("%5d", s.NNoCategory), fmt.Sprintf("%5d", s.NNoAccount), fmt.Sprintf("%5d", len(s.Problems)))
+	}
+	c.Printf("%s\n", tbl.Text())
+	for _, s := range rpt.Statements {
+		for _, p := range s.Problems {
+			c.Printf("Problem (%s): %s\n", s.AcctId, p)
+		}
+	}
+}
@@ -9,7 +9,6 @@ package console
 import (
 	"dbe/lib/util"
 	m1 "dbe/m1/m1data"
-	"sort"
 )
 
 var gTopic_list_cats string = `
@@ -18,6 +17,8 @@ The format of the command is:
 
   list-cats
 
+The categories are listed as a tree, with each one indented under its
+parent (see help categories).
 `
 
 func init() {
@@ -32,13 +33,13 @@ func handle_list_cats(c *util.Context, cmdline string) {
 		c.Printf("%v\n", err)
 		return
 	}
-	cats := m1.GetCategories()
-	sort.Slice(cats, func(i, j int) bool { return cats[j].Name > cats[i].Name })
+	v := m1.GetView()
+	cats := v.CategoryTree()
 
 	tbl := util.NewTable("Name", "Aliases")
 	for _, cx := range cats {
 		saliases := util.FormatStrSlice(cx.Aliases)
-		tbl.AddRow(cx.Name, saliases)
+		tbl.AddRow(tree_name(cx.Name, v.CategoryDepth(cx.Cid)), saliases)
 	}
 	c.Printf("%s\n", tbl.Text())
 }
@@ -8,8 +8,10 @@ package console
 
 import (
 	"dbe/lib/util"
+	"dbe/lib/uuid"
 	m1 "dbe/m1/m1data"
-	"sort"
+	"fmt"
+	"math"
 	"strconv"
 )
 
@@ -17,10 +19,29 @@ var gTopic_list_transactions string = `
 The list-transactions command is used to list the transactions in the database.
 The format of the command is:
 
-  list-transactions max=nnn skip=nnn
+  list-transactions max=nnn skip=nnn [filters] sort=order desc=true
 
 where nnn is the max number of transactions listed. The default for max is 100.
 The skip parameter is optional, and if given, the first nnn records will be skipped.
+The filters are all optional, and are:
+
+  from=date       -- Only transactions on or after this date.
+  to=date         -- Only transactions on or before this date.
+  account=name    -- Only transactions for this account.
+  vendor=name     -- Only transactions for this vendor.
+  cat=name        -- Only transactions with a split for this category.
+  minamt=dollars  -- Only transactions of at least this amount.
+  maxamt=dollars  -- Only transactions of at most this amount.
+  flag=text       -- Only transactions with this flag.
+  text=text       -- Only transactions with this text in the description,
+                     notes, bank info, location or check number.
+
+The sort order can be date (the default), amount or description.  If
+desc=true is given, the order is reversed.
+
+The Id column is the start of the transaction's id, which other
+commands (such as link-transfer) take.  A transfer shows the account
+on its other side, in brackets, in place of its category.
 
 `
 
@@ -36,50 +57,138 @@ func handle_list_transactions(c *util.Context, cmdline string) {
 		c.Printf("%v\n", err)
 		return
 	}
-	maxlst := 100
+	q := &m1.Query{Limit: 100}
 	smax, ok := util.MapAlias(params, "max")
 	if ok {
-		maxlst, err = strconv.Atoi(smax)
+		q.Limit, err = strconv.Atoi(smax)
 		if err != nil {
 			c.Printf("Invalid paramger for max. (%s), Err=%v\n", smax, err)
 			return
 		}
 	}
-	iSkip := 0
 	sskip, ok := util.MapAlias(params, "skip", "start")
 	if ok {
-		iSkip, err = strconv.Atoi(sskip)
+		q.Offset, err = strconv.Atoi(sskip)
 		if err != nil {
 			c.Printf("Invalid parameter for skip (%s), Err=%v\n", sskip, err)
 			return
 		}
 	}
+	v := m1.GetView()
+	err = parse_query_params(v, params, q)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	tlst, total, err := v.Query(q)
+	if err != nil {
+		c.Printf("Error: %v\n", err)
+		return
+	}
 
-	tlst := m1.GetTransactions()
-	sort.Slice(tlst, func(i, j int) bool {
-		return tlst[j].Date().Format("06-01-02") >
-			tlst[i].Date().Format("06-01-02")
-	})
-
-	tbl := util.NewTable("Date", "Account", "Vendor", "Description", "Cat", "Amount")
-
-	icnt := 0
-	nrows := 0
+	tbl := util.NewTable("Id", "Date", "Account", "Vendor", "Description", "Cat", "Amount")
 	for _, t := range tlst {
-		if icnt >= iSkip {
-			sscat := ""
-			if len(t.Cats) > 0 {
-				sscat = t.Cats[0].Category
+		sscat := ""
+		if o := v.TransferOf(t); o != nil {
+			sscat = "[" + account_name(v, o.Aid) + "]"
+		} else if len(t.Cats) > 0 {
+			sscat = category_name(v, t.Cats[0].Cid)
+		}
+		samt := util.StrLeft(t.Amount.String(), 14)
+		tbl.AddRow(short_id(t.Tid), t.Date().Format("06-01-02"), account_name(v, t.Aid), vendor_name(v, t.Vid),
+			t.Description, sscat, samt)
+	}
+	c.Printf("%s\n", tbl.Text())
+	c.Printf("Listed %d of %d transactions found.\n", len(tlst), total)
+}
+
+// parse_query_params fills in the filters and sort order of a query
+// from the parameters of a command.
+func parse_query_params(v *m1.View, params map[string]string, q *m1.Query) error {
+	var err error
+	if s, ok := util.MapAlias(params, "from"); ok {
+		q.DateFrom, err = util.ParseGenericTime(s)
+		if err != nil {
+			return fmt.Errorf("Invalid parameter for from (%s). Err=%v", s, err)
+		}
+	}
+	if s, ok := util.MapAlias(params, "to"); ok {
+		q.DateTo, err = util.ParseGenericTime(s)
+		if err != nil {
+			return fmt.Errorf("Invalid parameter for to (%s). Err=%v", s, err)
+		}
+		// The query excludes DateTo, so the day given is included by
+		// going to the next day.
+		q.DateTo = q.DateTo.AddDate(0, 0, 1)
+	}
+	if s, ok := util.MapAlias(params, "account", "acc"); ok {
+		a := v.AccountByName(s)
+		if a == nil {
+			return fmt.Errorf("No account named %q.", s)
+		}
+		q.Aid = a.Aid
+	}
+	if s, ok := util.MapAlias(params, "vendor", "ven"); ok {
+		vv := v.VendorByName(s)
+		if vv == nil {
+			return fmt.Errorf("No vendor named %q.", s)
+		}
+		q.Vid = vv.Vid
+	}
+	if s, ok := util.MapAlias(params, "cat", "category"); ok {
+		cat := v.CategoryByName(s)
+		if cat == nil {
+			return fmt.Errorf("No category named %q.", s)
+		}
+		q.Cid = cat.Cid
+	}
+	smin, okmin := util.MapAlias(params, "minamt")
+	smax, okmax := util.MapAlias(params, "maxamt")
+	if okmin || okmax {
+		q.UseAmount = true
+		q.MinAmount, q.MaxAmount = math.MinInt32, math.MaxInt32
+		if okmin {
+			q.MinAmount, err = util.ParseMoney(smin)
+			if err != nil {
+				return fmt.Errorf("Invalid parameter for minamt (%s). Err=%v", smin, err)
 			}
-			samt := util.StrLeft(util.CentsToStr(t.Amount), 14)
-			tbl.AddRow(t.Date().Format("06-01-02"), t.Account, t.Vendor,
-				t.Description, sscat, samt)
-			nrows += 1
 		}
-		if nrows > maxlst {
-			break
+		if okmax {
+			q.MaxAmount, err = util.ParseMoney(smax)
+			if err != nil {
+				return fmt.Errorf("Invalid parameter for maxamt (%s). Err=%v", smax, err)
+			}
 		}
-		icnt += 1
 	}
-	c.Printf("%s\n", tbl.Text())
+	q.Flag, _ = util.MapAlias(params, "flag")
+	q.Text, _ = util.MapAlias(params, "text")
+	q.SortBy, _ = util.MapAlias(params, "sort")
+	if s, ok := util.MapAlias(params, "desc"); ok {
+		q.Descending, err = util.StrToBool(s, false)
+		if err != nil {
+			return fmt.Errorf("Invalid parameter for desc (%s). Err=%v", s, err)
+		}
+	}
+	return nil
+}
+
+func account_name(v *m1.View, aid uuid.UUID) string {
+	if a := v.Account(aid); a != nil {
+		return a.FName
+	}
+	return ""
+}
+
+func vendor_name(v *m1.View, vid uuid.UUID) string {
+	if vv := v.Vendor(vid); vv != nil {
+		return vv.FName
+	}
+	return ""
+}
+
+func category_name(v *m1.View, cid uuid.UUID) string {
+	if cat := v.Category(cid); cat != nil {
+		return cat.Name
+	}
+	return ""
 }
@@ -40,7 +40,7 @@ func handle_list_vendors(c *util.Context, cmdline string) {
 	for _, v := range vens {
 		saliases := util.FixStrLen(util.FormatStrSlice(v.Aliases), 50, "...")
 		snaliases := util.StrLeft(fmt.Sprintf("%d", len(v.Aliases)), 10)
-		tbl.AddRow(v.FName, v.DName, v.DefaultCat, snaliases, saliases)
+		tbl.AddRow(v.FName, v.DName, m1.CategoryName(v.DefaultCid), snaliases, saliases)
 	}
 	c.Printf("%s\n", tbl.Text())
 }
@@ -18,8 +18,10 @@ The format of the command is:
   load-data
 
 Without any arguments, this command loads the latest saved
-data.  Note: this WILL overwrite changes that have been
-made since the last save.
+data and then replays the journal of changes made since that
+save.  Any changes that have not reached the journal are lost.
+The file that was actually used (primary or backup) is reported,
+along with whether its checksum was verified.
 `
 
 func init() {
@@ -35,10 +37,11 @@ func handle_load_data(c *util.Context, cmdline string) {
 		return
 	}
 
-	err = m1.LoadData()
+	info, err := m1.LoadData()
 	if err != nil {
 		c.Printf("Error = %v.\n", err)
 		return
 	}
+	c.Printf("Loaded from %s\n", info)
 	c.Printf("Success.\n")
 }
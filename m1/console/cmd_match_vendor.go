@@ -0,0 +1,203 @@
+// --------------------------------------------------------------------
+// cmd_match_vendor.go -- Commands to find vendors for bank descriptors
+// and to learn new vendor aliases.
+//
+// Created 2020-04-19 DLB
+// --------------------------------------------------------------------
+
+package console
+
+import (
+	"dbe/lib/util"
+	"dbe/m1/importer"
+	m1 "dbe/m1/m1data"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var gTopic_match_vendor string = `
+Banks describe a purchase with the merchant's name surrounded by other
+things, such as "SQ *BLUE BOTTLE 1234 OAKLAND CA".  To find the vendor,
+the card processor's prefix, store numbers, and the city and state are
+taken off ("blue bottle"), and what is left is scored against the names
+and aliases of the vendors, from 0 to 100.  On import, a vendor that
+scores 85 or more is used, if no other vendor is close.  The commands
+are:
+
+  match-vendor text max=nnn
+  learn-vendor vendor=name text
+  unmatched-vendors max=nnn
+
+match-vendor shows the vendors that could go with a descriptor, best
+first, and what the descriptor was reduced to.
+
+learn-vendor confirms that a descriptor goes with a vendor.  What the
+descriptor was reduced to is added to the vendor's aliases, so that
+the vendor's other stores match too.  Transactions without a vendor
+whose descriptions reduce to the same thing are given the vendor.
+
+unmatched-vendors lists the descriptions of the transactions that have
+no vendor, grouped by what they reduce to, with the best suggestion for
+each.  The most common are listed first.
+`
+
+func init() {
+	RegistorCmd("match-vendor", "", "Shows the vendors that could go with a bank descriptor.", handle_match_vendor)
+	RegistorCmd("learn-vendor", "", "Adds a bank descriptor to a vendor's aliases.", handle_learn_vendor)
+	RegistorCmd("unmatched-vendors", "", "Lists transaction descriptions that have no vendor.", handle_unmatched_vendors)
+	RegistorTopic("match-vendor", gTopic_match_vendor)
+}
+
+func handle_match_vendor(c *util.Context, cmdline string) {
+	params := make(map[string]string, 10)
+	args, err := ParseCmdLine(cmdline, params)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	if len(args) < 2 {
+		c.Printf("No descriptor given.\n")
+		return
+	}
+	maxlst := 5
+	smax, ok := util.MapAlias(params, "max")
+	if ok {
+		maxlst, err = strconv.Atoi(smax)
+		if err != nil {
+			c.Printf("Invalid paramger for max. (%s), Err=%v\n", smax, err)
+			return
+		}
+	}
+	desc := strings.Join(args[1:], " ")
+	vm := importer.NewVendorMatcher(m1.GetVendors())
+	c.Printf("Reduced to: %q\n", importer.NormalizeDescriptor(desc))
+	lst := vm.Suggest(desc, maxlst)
+	if len(lst) == 0 {
+		c.Printf("No vendor is close.\n")
+		return
+	}
+	tbl := util.NewTable("Vendor", "Score", "Matched")
+	for _, m := range lst {
+		tbl.AddRow(m.Vendor, fmt.Sprintf("%3d", m.Score), m.Matched)
+	}
+	c.Printf("%s\n", tbl.Text())
+	vid, err := vm.Best(desc)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	c.Printf("An import would use: %s\n", m1.VendorName(vid))
+}
+
+func handle_learn_vendor(c *util.Context, cmdline string) {
+	params := make(map[string]string, 10)
+	args, err := ParseCmdLine(cmdline, params)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	vname, ok := util.MapAlias(params, "vendor", "ven")
+	if !ok {
+		c.Printf("No vendor given. Use vendor=name.\n")
+		return
+	}
+	if len(args) < 2 {
+		c.Printf("No descriptor given.\n")
+		return
+	}
+	v := m1.GetVendorByName(vname)
+	if v == nil {
+		c.Printf("No vendor named %q.\n", vname)
+		return
+	}
+	desc := strings.Join(args[1:], " ")
+	alias, err := importer.LearnVendorAlias(v.Vid, desc)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	if alias == "" {
+		alias = importer.NormalizeDescriptor(desc)
+		c.Printf("Vendor %s already has the alias %q.\n", v.FName, alias)
+	} else {
+		c.Printf("Alias %q added to vendor %s.\n", alias, v.FName)
+	}
+	lst := make([]*m1.Transaction, 0)
+	m1.GetView().EachTransaction(func(t *m1.Transaction) {
+		if t.Vid.IsZero() && importer.NormalizeDescriptor(t.Description) == alias {
+			tc := *t
+			tc.Vid = v.Vid
+			lst = append(lst, &tc)
+		}
+	})
+	err = m1.AddTransactions(lst)
+	if err != nil {
+		c.Printf("Unable to set the vendor on transactions. Err=%v\n", err)
+		return
+	}
+	c.Printf("Number of transactions given the vendor: %d\n", len(lst))
+}
+
+func handle_unmatched_vendors(c *util.Context, cmdline string) {
+	params := make(map[string]string, 10)
+	_, err := ParseCmdLine(cmdline, params)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	maxlst := 100
+	smax, ok := util.MapAlias(params, "max")
+	if ok {
+		maxlst, err = strconv.Atoi(smax)
+		if err != nil {
+			c.Printf("Invalid paramger for max. (%s), Err=%v\n", smax, err)
+			return
+		}
+	}
+	type group struct {
+		reduced string
+		example string
+		count   int
+	}
+	groups := make(map[string]*group, 100)
+	ntotal := 0
+	m1.GetView().EachTransaction(func(t *m1.Transaction) {
+		if !t.Vid.IsZero() {
+			return
+		}
+		ntotal++
+		r := importer.NormalizeDescriptor(t.Description)
+		g, ok := groups[r]
+		if !ok {
+			g = &group{reduced: r, example: t.Description}
+			groups[r] = g
+		}
+		g.count++
+	})
+	lst := make([]*group, 0, len(groups))
+	for _, g := range groups {
+		lst = append(lst, g)
+	}
+	sort.Slice(lst, func(i, j int) bool {
+		if lst[i].count != lst[j].count {
+			return lst[i].count > lst[j].count
+		}
+		return lst[i].reduced < lst[j].reduced
+	})
+	vm := importer.NewVendorMatcher(m1.GetVendors())
+	tbl := util.NewTable("Count", "Reduced To", "Example", "Suggestion", "Score")
+	for i, g := range lst {
+		if i >= maxlst {
+			break
+		}
+		sugg, score := "", ""
+		if ms := vm.Suggest(g.example, 1); len(ms) > 0 {
+			sugg, score = ms[0].Vendor, fmt.Sprintf("%3d", ms[0].Score)
+		}
+		tbl.AddRow(fmt.Sprintf("%5d", g.count), g.reduced, util.FixStrLen(g.example, 40, "..."), sugg, score)
+	}
+	c.Printf("%s\n", tbl.Text())
+	c.Printf("Transactions without a vendor: %d, in %d groups.\n", ntotal, len(lst))
+}
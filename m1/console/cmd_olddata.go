@@ -8,6 +8,7 @@ package console
 
 import (
 	"dbe/lib/util"
+	"dbe/m1/importer"
 	//"dbe/m1/m1sql"
 	m1 "dbe/m1/m1data"
 	"dbe/m1/olddata"
@@ -22,7 +23,7 @@ var gTopic_olddata string = `
 The olddata command is used to analyze old data.  The format of
 the command is:
 
-  olddata report yyyy  
+  olddata report yyyy depth=n
 
 where report is the type of report desired and yyyy is the year
 that is covered by the report.  If yyyy is omited, all the old 
@@ -33,6 +34,11 @@ data is included in the report.  Possible reports are:
   accounts -- lists the accounts
   ckeck    -- Checks for errors between old and new
   load     -- Loads the old data into the database
+
+The old category names are colon-separated (Auto:Fuel), so the cat
+report shows them as a tree, with a subtotal for each level.  With
+depth=n, only the top n levels are shown.  The load puts the
+categories in a tree the same way (see help categories).
 `
 
 func init() {
@@ -65,7 +71,15 @@ func handle_olddata(c *util.Context, cmdline string) {
 		}
 	}
 	if rpt == "cat" || rpt == "category" {
-		oldata_cat_report(c, year)
+		depth := 0
+		if s, ok := util.MapAlias(params, "depth"); ok {
+			depth, err = strconv.Atoi(s)
+			if err != nil || depth < 0 {
+				c.Printf("Bad depth input (%s).\n", s)
+				return
+			}
+		}
+		oldata_cat_report(c, year, depth)
 		return
 	}
 	if rpt == "accounts" {
@@ -87,10 +101,10 @@ func handle_olddata(c *util.Context, cmdline string) {
 	c.Printf("Error -- unknown operation (%s).\n", rpt)
 }
 
-func oldata_cat_report(c *util.Context, year int) {
+func oldata_cat_report(c *util.Context, year, depth int) {
 	type catinfo struct {
 		Name   string
-		Amount int
+		Amount util.Money
 		Count  int
 	}
 	newcontext := util.NewContext(util.Context_Internal)
@@ -126,17 +140,45 @@ func oldata_cat_report(c *util.Context, year int) {
 	} else {
 		c.Printf("Number of transactions (year=%d) = %d\n", year, ncnt)
 	}
-	// Sort
-	keys := make([]string, 0, len(m))
-	for k, _ := range m {
+	// Roll the totals up the levels of the names, so each level has
+	// its subtotal.  Levels below depth are folded into the one above.
+	tree := make(map[string]*catinfo, 2*len(m))
+	for name, mcat := range m {
+		parts := strings.Split(name, m1.CategorySep)
+		for i := range parts {
+			if depth > 0 && i >= depth {
+				break
+			}
+			key := strings.Join(parts[:i+1], m1.CategorySep)
+			tcat, ok := tree[key]
+			if !ok {
+				tcat = &catinfo{Name: strings.TrimSpace(parts[i])}
+				tree[key] = tcat
+			}
+			tcat.Count += mcat.Count
+			tcat.Amount += mcat.Amount
+		}
+	}
+	// Sort by level, so each name comes right before the ones under it.
+	keys := make([]string, 0, len(tree))
+	for k, _ := range tree {
 		keys = append(keys, k)
 	}
-	sort.Strings(keys)
+	sort.Slice(keys, func(i, j int) bool {
+		pi, pj := strings.Split(keys[i], m1.CategorySep), strings.Split(keys[j], m1.CategorySep)
+		for k := 0; k < len(pi) && k < len(pj); k++ {
+			if pi[k] != pj[k] {
+				return pi[k] < pj[k]
+			}
+		}
+		return len(pi) < len(pj)
+	})
 	tbl := util.NewTable("Category", "Item Count", "Total")
 	for _, cat := range keys {
-		scnt := fmt.Sprintf("%5d", m[cat].Count)
-		samt := util.StrLeft(util.CentsToStr(m[cat].Amount), 14)
-		tbl.AddRow(cat, scnt, samt)
+		scnt := fmt.Sprintf("%5d", tree[cat].Count)
+		samt := util.StrLeft(tree[cat].Amount.String(), 14)
+		level := strings.Count(cat, m1.CategorySep) + 1
+		tbl.AddRow(tree_name(tree[cat].Name, level), scnt, samt)
 	}
 	c.Printf("%s", tbl.Text())
 }
@@ -144,7 +186,7 @@ func oldata_cat_report(c *util.Context, year int) {
 func olddata_accounts_report(c *util.Context, year int) {
 	type accinfo struct {
 		Name   string
-		Amount int
+		Amount util.Money
 		Count  int
 	}
 	newcontext := util.NewContext(util.Context_Internal)
@@ -189,7 +231,7 @@ func olddata_accounts_report(c *util.Context, year int) {
 	tbl := util.NewTable("Account", "Item Count", "Total")
 	for _, acc := range keys {
 		scnt := fmt.Sprintf("%5d", m[acc].Count)
-		samt := util.StrLeft(util.CentsToStr(m[acc].Amount), 14)
+		samt := util.StrLeft(m[acc].Amount.String(), 14)
 		tbl.AddRow(acc, scnt, samt)
 	}
 	c.Printf("%s", tbl.Text())
@@ -198,7 +240,7 @@ func olddata_accounts_report(c *util.Context, year int) {
 func olddata_vendors_report(c *util.Context, year int) {
 	type vendorinfo struct {
 		Name   string
-		Amount int
+		Amount util.Money
 		Count  int
 	}
 	newcontext := util.NewContext(util.Context_Internal)
@@ -243,7 +285,7 @@ func olddata_vendors_report(c *util.Context, year int) {
 	tbl := util.NewTable("Account", "Item Count", "Total")
 	for _, vendor := range keys {
 		scnt := fmt.Sprintf("%5d", m[vendor].Count)
-		samt := util.StrLeft(util.CentsToStr(m[vendor].Amount), 14)
+		samt := util.StrLeft(m[vendor].Amount.String(), 14)
 		tbl.AddRow(vendor, scnt, samt)
 	}
 	c.Printf("%s", tbl.Text())
@@ -252,7 +294,7 @@ func olddata_vendors_report(c *util.Context, year int) {
 // func olddata_check_report(c *util.Context, year int) {
 // 	type accinfo struct {
 // 		Name   string
-// 		Amount int
+// 		Amount util.Money
 // 		Count  int
 // 	}
 // 	newcontext := util.NewContext(util.Context_Internal)
@@ -377,7 +419,7 @@ func olddata_load(c *util.Context) {
 	ncatcnt := 0
 	for k, v := range mcats {
 		// If the cat exists, don't add it.
-		ce := m1.GetCategory(k)
+		ce := m1.GetCategoryByName(k)
 		if ce == nil {
 			err := m1.AddCategory(v)
 			if err != nil {
@@ -388,6 +430,13 @@ func olddata_load(c *util.Context) {
 		}
 	}
 	c.Printf("Number of new categories added: %d\n", ncatcnt)
+	nmade, nlinked, err := m1.BuildCategoryTree()
+	if err != nil {
+		c.Printf("Unable to build the category tree. Err=%v. Aborting.\n", err)
+		return
+	}
+	c.Printf("Number of parent categories made: %d\n", nmade)
+	c.Printf("Number of categories put under a parent: %d\n", nlinked)
 	c.Flush()
 
 	// ---------------   Vendors
@@ -411,7 +460,7 @@ func olddata_load(c *util.Context) {
 	nvencnt := 0
 	for k, v := range mvens {
 		// If the vendor exists, don't add it.
-		ve := m1.GetVendor(k)
+		ve := m1.GetVendorByName(k)
 		if ve == nil {
 			err := m1.AddVendor(v)
 			if err != nil {
@@ -434,177 +483,32 @@ func olddata_load(c *util.Context) {
 	temp_catetories := m1.GetCategories()
 	c.Printf("Number of Categories in Database: %d\n", len(temp_catetories))
 	c.Flush()
-	temp_transactions := m1.GetTransactions()
-	c.Printf("Number of Transactions in Database: %d\n", len(temp_transactions))
+	c.Printf("Number of Transactions in Database: %d\n", m1.GetView().TransactionCount())
 	c.Flush()
 
-	tlst, err := olddata.ReadAll(newcontext)
+	// Each old file is imported like any other file, and staged in its
+	// own batch.  The old files overlap, so the batches should be
+	// committed in order: each is checked again when it is committed,
+	// and its copies of what is already in are skipped.
+	files, err := olddata.TransactionFiles()
 	if err != nil {
-		c.Printf("Error reading old tranactions. Err=%v\n", err)
+		c.Printf("%v\n", err)
 		return
 	}
-	c.Printf("Number of Old Transactions to Process: %d\n", len(tlst))
-	c.Flush()
-
-	nSkips := 0
-	nErrs := 0
-	nCnt := 0
-	t0 := time.Now()
-	for _, t := range tlst {
-		svendor, err1 := getbestvendor(temp_vendors, t.Vendor)
-		saccount, err2 := getbestaccount(temp_accounts, t.Account)
-		scat, err3 := getbestcategory(temp_catetories, t.Category)
-		if err1 != nil || err2 != nil || err3 != nil {
-			nSkips += 1
-			if nSkips < 20 {
-				c.Printf("Skipping Tranactions. %v, %v, %v\n", err1, err2, err3)
-				c.Printf("Tranaction: Amount: %d, Date: %s, Account: %s, Description: %s\n",
-					t.Amount, t.Date().Format("06-01-02"), t.Account, t.Description)
-			}
-			continue
-		}
-		bSkip := false
-		for _, tt := range temp_transactions {
-			if tt.Date() == t.Date() && t.Description == tt.Description &&
-				tt.Account == saccount && tt.Amount == t.Amount {
-				nSkips += 1
-				if nSkips < 20 {
-					c.Printf("Duplicate Transaction? Skipping...\n")
-					c.Printf("Date: %s, Account: %s, Description: %s\n",
-						t.Date().Format("06-01-02"), t.Account, t.Description)
-				}
-				bSkip = true
-			}
-		}
-		if bSkip {
-			continue
-		}
-		tnew := &m1.Transaction{}
-		tnew.Amount = t.Amount
-		tnew.DatePosted = t.DatePosted
-		tnew.DateSettled = t.DateSettled
-		tnew.Month = t.Month
-		tnew.Description = t.Description
-		tnew.Month = t.Month
-		tnew.BankInfo = t.BankInfo
-		tnew.Location = t.Location
-		tnew.CheckNum = t.CheckNum
-		tnew.Flag = t.Flag
-		tnew.Account = saccount
-		tnew.Vendor = svendor
-		if util.Blank(scat) {
-			tnew.Cats = []m1.CatItem{}
-		} else {
-			tnew.Cats = []m1.CatItem{m1.CatItem{Category: scat, Amount: t.Amount}}
-		}
-		tnew.Notes = fmt.Sprintf("From Old Data... Converted on %s\n")
-		tnew.Notes += fmt.Sprintf("Old Account: %s\nOld Vendor: %s\nOld Cat: %s.\n",
-			time.Now().Format("06-01-02"), t.Account, t.Vendor, t.Category)
-		if !util.Blank(t.Flag) {
-			tnew.Notes += fmt.Sprintf("Flags: %s\n", t.Flag)
-		}
-		if !util.Blank(t.CheckNum) {
-			tnew.Notes += fmt.Sprintf("CheckNum: %s\n", t.CheckNum)
-		}
-		if !util.Blank(t.Receipt) {
-			tnew.Notes += fmt.Sprintf("Receipt: %s\n", t.Receipt)
+	imp := importer.GetImporter("olddata")
+	for _, fn := range files {
+		rpt, err := importer.Import(imp, fn, &importer.Options{User: c.User()})
+		if rpt != nil {
+			print_import_report(c, rpt)
 		}
-		err := m1.AddTransaction(tnew)
 		if err != nil {
-			nErrs += 1
-			if nErrs < 20 {
-				c.Printf("Unable to add transaction. Err=%v\nTrans = %v\n", err, tnew)
-			}
-		} else {
-			nCnt += 1
-		}
-		if time.Now().Sub(t0).Seconds() > 10.0 {
-			c.Printf("Number of Transactions Processed so far = %d\n", nCnt)
-			c.Flush()
-			t0 = time.Now()
-		}
-	}
-	if nSkips > 0 {
-		c.Printf("Number of transactions skipped: %d (Only frist 20 shown).\n", nSkips)
-	}
-	if nErrs > 0 {
-		c.Printf("Number of Errors: %d (Only first 20 shown).\n", nErrs)
-	}
-	c.Printf("Number of transcations added: %d\n", nCnt)
-	c.Printf("Success.\n")
-}
-
-func getbestvendor(vendors []*m1.Vendor, v string) (string, error) {
-	if util.Blank(v) {
-		return "", nil
-	}
-	vv := strings.TrimSpace(v)
-	for _, v := range vendors {
-		if vv == strings.TrimSpace(v.FName) {
-			return v.FName, nil
-		}
-		if vv == strings.TrimSpace(v.DName) {
-			return v.FName, nil
-		}
-		for _, a := range v.Aliases {
-			if vv == strings.TrimSpace(a) {
-				return v.FName, nil
-			}
-		}
-	}
-	// Try lowercase.
-	vv = strings.ToLower(v)
-	for _, v := range vendors {
-		if vv == strings.ToLower(strings.TrimSpace(v.FName)) {
-			return v.FName, nil
-		}
-		if vv == strings.ToLower(strings.TrimSpace(v.DName)) {
-			return v.FName, nil
-		}
-		for _, a := range v.Aliases {
-			if vv == strings.ToLower(strings.TrimSpace(a)) {
-				return v.FName, nil
-			}
-		}
-	}
-	return "", fmt.Errorf("No vendor for %s.", v)
-}
-
-func getbestaccount(accounts []*m1.Account, a string) (string, error) {
-	aa := strings.ToLower(strings.TrimSpace(a))
-	for _, account := range accounts {
-		if aa == strings.ToLower(strings.TrimSpace(account.ShortName)) {
-			return account.FName, nil
-		}
-		if aa == strings.ToLower(strings.TrimSpace(account.DName)) {
-			return account.FName, nil
-		}
-		if aa == strings.ToLower(strings.TrimSpace(account.FName)) {
-			return account.FName, nil
-		}
-		for _, alias := range account.Aliases {
-			if aa == strings.ToLower(strings.TrimSpace(alias)) {
-				return account.FName, nil
-			}
-		}
-	}
-	return "", fmt.Errorf("No account for %s.", a)
-}
-
-func getbestcategory(categories []*m1.Category, cat string) (string, error) {
-	if util.Blank(cat) {
-		return "", nil
-	}
-	catc := strings.ToLower(strings.TrimSpace(cat))
-	for _, c := range categories {
-		if catc == strings.ToLower(strings.TrimSpace(c.Name)) {
-			return c.Name, nil
+			c.Printf("Error = %v.\n", err)
+			return
 		}
-		for _, a := range c.Aliases {
-			if catc == strings.ToLower(strings.TrimSpace(a)) {
-				return c.Name, nil
-			}
+		if !rpt.Bid.IsZero() {
+			c.Printf("Staged as batch %s.\n", short_id(rpt.Bid))
 		}
+		c.Flush()
 	}
-	return "", fmt.Errorf("No category for %s.", cat)
+	c.Printf("Use show-batch to look the batches over, and commit-batch to add them, in order.\n")
 }
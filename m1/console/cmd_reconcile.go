@@ -0,0 +1,353 @@
+// --------------------------------------------------------------------
+// cmd_reconcile.go -- Reconciles accounts against bank statements.
+//
+// Created 2020-04-20 DLB
+// --------------------------------------------------------------------
+
+package console
+
+import (
+	"dbe/lib/util"
+	"dbe/lib/uuid"
+	m1 "dbe/m1/m1data"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var gTopic_reconcile string = `
+An account is reconciled against each statement from the bank.  A
+reconciliation is started with the closing date and ending balance of
+the statement.  The transactions on the statement are then marked as
+cleared, until the cleared balance (what was reconciled before, plus
+the cleared transactions) matches the ending balance.  Then it is
+finished, the cleared transactions become reconciled, and the account
+is locked through the closing date.  The commands are:
+
+  reconcile account=name end=date balance=dollars month=yyyy-mm
+  reconcile account=name all=true
+  clear account=name items=list
+  unclear account=name items=list
+  finish-reconcile account=name
+  discard-reconcile account=name
+  reopen-reconcile account=name
+  list-reconciles account=name
+
+reconcile with end and balance starts a reconciliation.  The month of
+the statement is optional, and is the month of the closing date if
+not given.  Without end and balance, it shows the open
+reconciliation: the cleared balance, the difference from the
+statement, and the transactions that are not cleared, numbered.  With
+all=true, the cleared ones are shown too.
+
+clear and unclear mark the transactions given by their numbers (such
+as 3,5,10-12, or all) as cleared or not.  finish-reconcile only works
+when the difference is zero.  discard-reconcile drops an open
+reconciliation, but leaves the transactions cleared.
+
+A reconciled transaction cannot be deleted, or have its account,
+amount, dates or cleared state changed, and no transaction can be
+added to the account on or before the closing date.  Vendors,
+categories and notes can still be changed.  reopen-reconcile opens
+the last reconciliation of an account again, so that its period can
+be changed.  list-reconciles shows the reconciliations of an account.
+`
+
+func init() {
+	RegistorCmd("reconcile", "", "Starts or shows the reconciliation of an account.", handle_reconcile)
+	RegistorCmd("clear", "", "Marks transactions in a reconciliation as cleared.", handle_clear)
+	RegistorCmd("unclear", "", "Marks transactions in a reconciliation as not cleared.", handle_unclear)
+	RegistorCmd("finish-reconcile", "", "Finishes a reconciliation that balances.", handle_finish_reconcile)
+	RegistorCmd("discard-reconcile", "", "Drops an open reconciliation.", handle_discard_reconcile)
+	RegistorCmd("reopen-reconcile", "", "Opens the last reconciliation of an account again.", handle_reopen_reconcile)
+	RegistorCmd("list-reconciles", "", "Lists the reconciliations of an account.", handle_list_reconciles)
+	RegistorTopic("reconcile", gTopic_reconcile)
+}
+
+func handle_reconcile(c *util.Context, cmdline string) {
+	params := make(map[string]string, 10)
+	_, err := ParseCmdLine(cmdline, params)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	a, err := reconcile_account(params)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	send, okend := util.MapAlias(params, "end")
+	sbal, okbal := util.MapAlias(params, "balance", "bal")
+	if okend || okbal {
+		if !okend || !okbal {
+			c.Printf("Both end and balance must be given to start a reconciliation.\n")
+			return
+		}
+		end, err := util.ParseGenericTime(send)
+		if err != nil {
+			c.Printf("Invalid date for end (%s). Err=%v\n", send, err)
+			return
+		}
+		bal, err := util.ParseMoney(sbal)
+		if err != nil {
+			c.Printf("Invalid balance (%s). Err=%v\n", sbal, err)
+			return
+		}
+		var month time.Time
+		if s, ok := util.MapAlias(params, "month"); ok {
+			month, err = util.ParseGenericTime(s)
+			if err != nil {
+				c.Printf("Invalid month (%s). Err=%v\n", s, err)
+				return
+			}
+		}
+		_, err = m1.StartReconciliation(a.Aid, month, end, bal, c.User())
+		if err != nil {
+			c.Printf("%v\n", err)
+			return
+		}
+	}
+	showall := false
+	if s, ok := util.MapAlias(params, "all"); ok {
+		showall, err = util.StrToBool(s, false)
+		if err != nil {
+			c.Printf("Invalid value for all (%s). Err=%v\n", s, err)
+			return
+		}
+	}
+	st, err := open_reconcile_state(a)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	tbl := util.NewTable("No", "Date", "Description", "Amount", "Cleared")
+	for i, t := range st.Items {
+		if t.Cleared != m1.Cleared_No && !showall {
+			continue
+		}
+		scleared := ""
+		if t.Cleared != m1.Cleared_No {
+			scleared = "Yes"
+		}
+		tbl.AddRow(fmt.Sprintf("%4d", i+1), t.Date().Format("2006-01-02"), util.FixStrLen(t.Description, 40, "..."),
+			fmt.Sprintf("%12s", t.Amount), scleared)
+	}
+	c.Printf("%s\n", tbl.Text())
+	print_reconcile_summary(c, a, st)
+}
+
+func print_reconcile_summary(c *util.Context, a *m1.Account, st *m1.ReconcileState) {
+	r := st.R
+	c.Printf("Account: %s.  Statement of %s, closing %s.\n", a.FName, r.Month.Format("2006-01"),
+		r.End.Format("2006-01-02"))
+	c.Printf("Starting balance: %12s\n", r.StartBal)
+	c.Printf("Cleared (%4d):    %12s\n", st.NCleared, st.Cleared)
+	c.Printf("Cleared balance:  %12s\n", st.ClearedBal)
+	c.Printf("Ending balance:   %12s\n", r.EndBal)
+	c.Printf("Difference:       %12s\n", st.Difference)
+	if st.Difference == 0 && r.Status == m1.Recon_Open {
+		c.Printf("It balances. Use finish-reconcile to lock it.\n")
+	}
+}
+
+func handle_clear(c *util.Context, cmdline string) {
+	set_cleared(c, cmdline, true)
+}
+
+func handle_unclear(c *util.Context, cmdline string) {
+	set_cleared(c, cmdline, false)
+}
+
+func set_cleared(c *util.Context, cmdline string, cleared bool) {
+	params := make(map[string]string, 10)
+	_, err := ParseCmdLine(cmdline, params)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	a, err := reconcile_account(params)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	st, err := open_reconcile_state(a)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	sitems, ok := util.MapAlias(params, "items", "item")
+	if !ok {
+		c.Printf("No items given. Use items=list, with the numbers shown by reconcile.\n")
+		return
+	}
+	var nums map[int]bool
+	if strings.ToLower(strings.TrimSpace(sitems)) != "all" {
+		nums, err = parse_row_list(sitems)
+		if err != nil {
+			c.Printf("%v\n", err)
+			return
+		}
+	}
+	tids := make([]uuid.UUID, 0, len(st.Items))
+	for i, t := range st.Items {
+		if nums == nil || nums[i+1] {
+			tids = append(tids, t.Tid)
+			delete(nums, i+1)
+		}
+	}
+	for n := range nums {
+		c.Printf("No item %d in the reconciliation.\n", n)
+		return
+	}
+	var n int
+	if cleared {
+		n, err = m1.SetCleared(tids, nil)
+	} else {
+		n, err = m1.SetCleared(nil, tids)
+	}
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	c.Printf("Number of transactions changed: %d\n", n)
+	st, err = open_reconcile_state(a)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	print_reconcile_summary(c, a, st)
+}
+
+func handle_finish_reconcile(c *util.Context, cmdline string) {
+	params := make(map[string]string, 10)
+	_, err := ParseCmdLine(cmdline, params)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	a, err := reconcile_account(params)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	st, err := open_reconcile_state(a)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	err = m1.FinishReconciliation(st.R.RecId)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	c.Printf("Reconciled %d transactions. %s is locked through %s.\n", st.NCleared, a.FName,
+		st.R.End.Format("2006-01-02"))
+}
+
+func handle_discard_reconcile(c *util.Context, cmdline string) {
+	params := make(map[string]string, 10)
+	_, err := ParseCmdLine(cmdline, params)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	a, err := reconcile_account(params)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	st, err := open_reconcile_state(a)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	err = m1.DiscardReconciliation(st.R.RecId)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	c.Printf("Reconciliation discarded.\n")
+}
+
+func handle_reopen_reconcile(c *util.Context, cmdline string) {
+	params := make(map[string]string, 10)
+	_, err := ParseCmdLine(cmdline, params)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	a, err := reconcile_account(params)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	var last *m1.Reconciliation
+	for _, r := range m1.GetView().Reconciliations(a.Aid) {
+		if r.Status == m1.Recon_Finished {
+			last = r
+		}
+	}
+	if last == nil {
+		c.Printf("Account %s has no finished reconciliations.\n", a.FName)
+		return
+	}
+	n, err := m1.ReopenReconciliation(last.RecId)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	c.Printf("Reconciliation of %s reopened. Number of transactions unlocked: %d\n",
+		last.End.Format("2006-01-02"), n)
+}
+
+func handle_list_reconciles(c *util.Context, cmdline string) {
+	params := make(map[string]string, 10)
+	_, err := ParseCmdLine(cmdline, params)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	a, err := reconcile_account(params)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	tbl := util.NewTable("Month", "Closing", "Start", "Ending", "Status", "Cleared", "User", "Finished")
+	for _, r := range m1.GetView().Reconciliations(a.Aid) {
+		sfinished := ""
+		if !r.Finished.IsZero() {
+			sfinished = r.Finished.Format("2006-01-02 15:04")
+		}
+		tbl.AddRow(r.Month.Format("2006-01"), r.End.Format("2006-01-02"), fmt.Sprintf("%12s", r.StartBal),
+			fmt.Sprintf("%12s", r.EndBal), r.Status, fmt.Sprintf("%5d", r.NCleared), r.User, sfinished)
+	}
+	c.Printf("%s\n", tbl.Text())
+	if through := m1.GetView().LockedThrough(a.Aid); !through.IsZero() {
+		c.Printf("Locked through %s.\n", through.Format("2006-01-02"))
+	}
+}
+
+// reconcile_account returns the account given in the parameters.
+func reconcile_account(params map[string]string) (*m1.Account, error) {
+	name, ok := util.MapAlias(params, "account", "acc")
+	if !ok {
+		return nil, fmt.Errorf("No account given.")
+	}
+	a := m1.GetAccountByName(name)
+	if a == nil {
+		return nil, fmt.Errorf("No account named %q.", name)
+	}
+	return a, nil
+}
+
+// open_reconcile_state returns where the open reconciliation of an
+// account stands.
+func open_reconcile_state(a *m1.Account) (*m1.ReconcileState, error) {
+	v := m1.GetView()
+	r := v.OpenReconciliation(a.Aid)
+	if r == nil {
+		return nil, fmt.Errorf("Account %s has no open reconciliation. Start one with end=date and balance=dollars.",
+			a.FName)
+	}
+	return v.ReconcileState(r.RecId)
+}
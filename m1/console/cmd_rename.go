@@ -0,0 +1,156 @@
+// --------------------------------------------------------------------
+// cmd_rename.go -- Rename, merge and delete accounts, vendors and
+// categories.
+//
+// Created 2020-04-10 DLB
+// --------------------------------------------------------------------
+
+package console
+
+import (
+	"dbe/lib/util"
+	m1 "dbe/m1/m1data"
+	"strings"
+)
+
+var gTopic_rename string = `
+The rename command gives an account, vendor or category a new name.
+Transactions refer to it by id, so they follow the new name.  The
+format of the command is:
+
+  rename kind "old name" "new name"
+
+where kind is account, vendor or category.  The new name must not
+already be in use.  Use merge to combine two existing items.
+
+`
+var gTopic_merge string = `
+The merge command combines two accounts, vendors or categories.  Every
+reference to the first is changed to the second, the aliases of the
+first are added to the second, and then the first is deleted.  The
+format of the command is:
+
+  merge kind "from name" "into name"
+
+where kind is account, vendor or category.  An account that has
+reconciliations or an opening balance cannot be merged away, since
+they only fit its own statements.
+
+`
+var gTopic_delete string = `
+The delete command deletes an account, vendor or category.  The format
+of the command is:
+
+  delete kind "name" replace="other name"
+
+where kind is account, vendor or category.  If the item is still
+referred to by any transaction (or, for a category, as the default
+category of a vendor), the replace argument must be given, and all
+those references are changed to the replacement first.  A
+reconciliation counts as a reference to its account, and an account
+that has reconciliations or an opening balance cannot be replaced.
+
+`
+
+func init() {
+	RegistorCmd("rename", "", "Renames an account, vendor or category.", handle_rename)
+	RegistorCmd("merge", "", "Merges one account, vendor or category into another.", handle_merge)
+	RegistorCmd("delete", "", "Deletes an account, vendor or category.", handle_delete)
+	RegistorTopic("rename", gTopic_rename)
+	RegistorTopic("merge", gTopic_merge)
+	RegistorTopic("delete", gTopic_delete)
+}
+
+func handle_rename(c *util.Context, cmdline string) {
+	params := make(map[string]string, 10)
+	args, err := ParseCmdLine(cmdline, params)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	if len(args) < 4 {
+		c.Printf("Not enough args.\n")
+		return
+	}
+	switch strings.ToLower(args[1]) {
+	case "account":
+		err = m1.RenameAccount(args[2], args[3])
+	case "vendor":
+		err = m1.RenameVendor(args[2], args[3])
+	case "category", "cat":
+		err = m1.RenameCategory(args[2], args[3])
+	default:
+		c.Printf("Unknown kind (%s). Use account, vendor or category.\n", args[1])
+		return
+	}
+	if err != nil {
+		c.Printf("Error: %v\n", err)
+		return
+	}
+	c.Printf("Success.\n")
+}
+
+func handle_merge(c *util.Context, cmdline string) {
+	params := make(map[string]string, 10)
+	args, err := ParseCmdLine(cmdline, params)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	if len(args) < 4 {
+		c.Printf("Not enough args.\n")
+		return
+	}
+	var n int
+	switch strings.ToLower(args[1]) {
+	case "account":
+		n, err = m1.MergeAccounts(args[2], args[3])
+	case "vendor":
+		n, err = m1.MergeVendors(args[2], args[3])
+	case "category", "cat":
+		n, err = m1.MergeCategories(args[2], args[3])
+	default:
+		c.Printf("Unknown kind (%s). Use account, vendor or category.\n", args[1])
+		return
+	}
+	if err != nil {
+		c.Printf("Error: %v\n", err)
+		return
+	}
+	c.Printf("%d references changed.\n", n)
+	c.Printf("Success.\n")
+}
+
+func handle_delete(c *util.Context, cmdline string) {
+	params := make(map[string]string, 10)
+	args, err := ParseCmdLine(cmdline, params)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	if len(args) < 3 {
+		c.Printf("Not enough args.\n")
+		return
+	}
+	replacement, _ := util.MapAlias(params, "replace", "replacement", "with")
+	var n int
+	switch strings.ToLower(args[1]) {
+	case "account":
+		n, err = m1.DeleteAccount(args[2], replacement)
+	case "vendor":
+		n, err = m1.DeleteVendor(args[2], replacement)
+	case "category", "cat":
+		n, err = m1.DeleteCategory(args[2], replacement)
+	default:
+		c.Printf("Unknown kind (%s). Use account, vendor or category.\n", args[1])
+		return
+	}
+	if err != nil {
+		c.Printf("Error: %v\n", err)
+		return
+	}
+	if n > 0 {
+		c.Printf("%d references changed to %q.\n", n, replacement)
+	}
+	c.Printf("Success.\n")
+}
@@ -0,0 +1,123 @@
+// --------------------------------------------------------------------
+// cmd_report.go -- Reports income and spending by category.
+//
+// Created 2020-04-20 DLB
+// --------------------------------------------------------------------
+
+package console
+
+import (
+	"dbe/lib/util"
+	"dbe/lib/uuid"
+	m1 "dbe/m1/m1data"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var gTopic_report string = `
+The category-report command totals income and spending by category.
+The format of the command is:
+
+  category-report from=date to=date account=name depth=n transfers=true
+
+The dates are optional, and include the days given.  If an account is
+given, only its transactions are counted.  Each split is counted in
+its category, as income if money came in and as spending if it went
+out.  Money not in a category is shown as (uncategorized).
+
+The categories are shown as a tree (see help categories), each with
+the subtotal of itself and the categories under it.  With depth=n,
+only the top n levels are shown, and the levels below are folded
+into them, so depth=1 gives one line for each top category.
+
+Transfers between our own accounts (see help transfers) are left out,
+so that a card payment is not counted as spending a second time.  The
+number and amount left out are shown at the end.  Give
+transfers=true to count them anyway.
+`
+
+func init() {
+	RegistorCmd("category-report", "", "Totals income and spending by category.", handle_category_report)
+	RegistorTopic("category-report", gTopic_report)
+}
+
+func handle_category_report(c *util.Context, cmdline string) {
+	params := make(map[string]string, 10)
+	_, err := ParseCmdLine(cmdline, params)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	v := m1.GetView()
+	var from, to time.Time
+	if s, ok := util.MapAlias(params, "from"); ok {
+		from, err = util.ParseGenericTime(s)
+		if err != nil {
+			c.Printf("Invalid parameter for from (%s). Err=%v\n", s, err)
+			return
+		}
+	}
+	if s, ok := util.MapAlias(params, "to"); ok {
+		to, err = util.ParseGenericTime(s)
+		if err != nil {
+			c.Printf("Invalid parameter for to (%s). Err=%v\n", s, err)
+			return
+		}
+		to = to.AddDate(0, 0, 1)
+	}
+	aid := uuid.Zero()
+	if s, ok := util.MapAlias(params, "account", "acc"); ok {
+		a := v.AccountByName(s)
+		if a == nil {
+			c.Printf("No account named %q.\n", s)
+			return
+		}
+		aid = a.Aid
+	}
+	transfers := false
+	if s, ok := util.MapAlias(params, "transfers", "xfers"); ok {
+		transfers, err = util.StrToBool(s, false)
+		if err != nil {
+			c.Printf("Invalid parameter for transfers (%s). Err=%v\n", s, err)
+			return
+		}
+	}
+	depth := 0
+	if s, ok := util.MapAlias(params, "depth"); ok {
+		depth, err = strconv.Atoi(s)
+		if err != nil || depth < 0 {
+			c.Printf("Invalid parameter for depth (%s).\n", s)
+			return
+		}
+	}
+	r, err := v.CategoryReport(from, to, aid, transfers)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	tbl := util.NewTable("Category", "Income", "Spending", "Net", "Count")
+	for _, ct := range v.RollUp(r.Lines, depth) {
+		name := tree_name(ct.Name, ct.Depth)
+		if ct.Cid.IsZero() {
+			name = "(uncategorized)"
+		}
+		tbl.AddRow(name, fmt.Sprintf("%12s", ct.Income), fmt.Sprintf("%12s", ct.Expense),
+			fmt.Sprintf("%12s", ct.Net()), fmt.Sprintf("%5d", ct.N))
+	}
+	tbl.AddRow("Total", fmt.Sprintf("%12s", r.Income), fmt.Sprintf("%12s", r.Expense),
+		fmt.Sprintf("%12s", r.Net()), fmt.Sprintf("%5d", r.NTrans))
+	c.Printf("%s\n", tbl.Text())
+	if r.NTransfers > 0 {
+		c.Printf("Left out %d transfer transactions, moving %s between accounts.\n", r.NTransfers, r.Transfers)
+	}
+}
+
+// tree_name indents a name by its depth in the category tree.
+func tree_name(name string, depth int) string {
+	if depth <= 1 {
+		return name
+	}
+	return strings.Repeat("  ", depth-1) + name
+}
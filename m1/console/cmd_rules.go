@@ -0,0 +1,143 @@
+// --------------------------------------------------------------------
+// cmd_rules.go -- Commands to list the rules and apply them to the
+// transactions already in the database.
+//
+// Created 2020-04-19 DLB
+// --------------------------------------------------------------------
+
+package console
+
+import (
+	"dbe/lib/util"
+	m1 "dbe/m1/m1data"
+	"dbe/m1/rules"
+	"strconv"
+	"strings"
+)
+
+var gTopic_rules string = `
+Rules fill in the vendor, categories, flag and notes of transactions.
+They are kept in a text file, given by the rules_file config parameter
+(rules.txt by default).  Each rule has a name in brackets, then what
+it matches and what it does, one key=value per line:
+
+  [costco]
+  vendor=Costco
+  cats=Groceries 70%, Household rest
+
+  [rent]
+  desc=^ONLINE PMT .*PROPERTY MGMT
+  amount=..-1000
+  day=1..5
+  set_vendor=Oak Street Apartments
+  cats=Rent
+
+A rule matches on vendor, account, desc (a regular expression on the
+description, ignoring case), amount (a range, min..max, either end can
+be left off) and day (of the month, first..last).  What is not given
+matches everything.  It can set set_vendor, cats, flag and note.  The
+cats are split by percent (NN%), by amount (45.00) or get the rest.
+
+The rules are tried in order, and the first one that matches is used.
+Only what is blank is filled in, unless the rule has replace=true.  If
+there are still no categories, the vendor's default category is used.
+Rules are run on every import.  The commands are:
+
+  list-rules
+  apply-rules [filters] max=nnn dryrun=false
+
+list-rules shows the rules, in order.  apply-rules runs the rules on the
+transactions in the database, and shows what would change (up to max
+rows, 100 by default).  Nothing is changed unless dryrun=false is
+given.  The filters are the same as for list-transactions.  See
+rules_example.txt for more.
+`
+
+func init() {
+	RegistorCmd("list-rules", "", "Lists the rules that fill in transactions.", handle_list_rules)
+	RegistorCmd("apply-rules", "", "Runs the rules on transactions in the database.", handle_apply_rules)
+	RegistorTopic("rules", gTopic_rules)
+}
+
+func handle_list_rules(c *util.Context, cmdline string) {
+	rs, err := rules.LoadRules(m1.GetView())
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	tbl := util.NewTable("Name", "Matches", "Does")
+	for _, r := range rs.Rules {
+		tbl.AddRow(r.Name, r.MatchText(), r.ActionText())
+	}
+	c.Printf("%s\n", tbl.Text())
+	c.Printf("Number of rules in %s: %d\n", rs.FileName, len(rs.Rules))
+}
+
+func handle_apply_rules(c *util.Context, cmdline string) {
+	params := make(map[string]string, 10)
+	_, err := ParseCmdLine(cmdline, params)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	maxlst := 100
+	smax, ok := util.MapAlias(params, "max")
+	if ok {
+		maxlst, err = strconv.Atoi(smax)
+		if err != nil {
+			c.Printf("Invalid paramger for max. (%s), Err=%v\n", smax, err)
+			return
+		}
+	}
+	dryrun := true
+	if s, ok := util.MapAlias(params, "dryrun", "dry"); ok {
+		dryrun, err = util.StrToBool(s, true)
+		if err != nil {
+			c.Printf("Invalid parameter for dryrun (%s). Err=%v\n", s, err)
+			return
+		}
+	}
+	v := m1.GetView()
+	rs, err := rules.LoadRules(v)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	q := &m1.Query{}
+	err = parse_query_params(v, params, q)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	tlst, _, err := v.Query(q)
+	if err != nil {
+		c.Printf("Error: %v\n", err)
+		return
+	}
+	changed := make([]*m1.Transaction, 0)
+	tbl := util.NewTable("Date", "Account", "Description", "Amount", "Rule", "Changes")
+	for _, t := range tlst {
+		res := rs.Apply(v, t)
+		if res == nil {
+			continue
+		}
+		if len(changed) < maxlst {
+			tbl.AddRow(t.Date().Format("06-01-02"), account_name(v, t.Aid),
+				util.FixStrLen(t.Description, 30, "..."), util.StrLeft(t.Amount.String(), 14),
+				res.Rule, strings.Join(res.Changes, "; "))
+		}
+		changed = append(changed, res.T)
+	}
+	c.Printf("%s\n", tbl.Text())
+	if dryrun {
+		c.Printf("Dry run. Transactions that would change: %d of %d. Use dryrun=false to change them.\n",
+			len(changed), len(tlst))
+		return
+	}
+	err = m1.AddTransactions(changed)
+	if err != nil {
+		c.Printf("Unable to change transactions. Err=%v\n", err)
+		return
+	}
+	c.Printf("Transactions changed: %d of %d.\n", len(changed), len(tlst))
+}
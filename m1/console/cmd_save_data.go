@@ -19,6 +19,10 @@ The format of the command is:
 
 Without any arguments, the data saved will become the 
 default data to load the next time the server starts.
+
+Every change to the database is also written to a journal
+as it happens, so that changes survive a crash.  Saving
+the data folds the journal into the new snapshot.
 `
 
 func init() {
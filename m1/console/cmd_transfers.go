@@ -0,0 +1,179 @@
+// --------------------------------------------------------------------
+// cmd_transfers.go -- Commands to find and link transfers between
+// accounts.
+//
+// Created 2020-04-20 DLB
+// --------------------------------------------------------------------
+
+package console
+
+import (
+	"dbe/lib/util"
+	m1 "dbe/m1/m1data"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var gTopic_transfers string = `
+Money moved between two of our own accounts, such as paying a credit
+card from checking, shows up in both.  The two sides are linked as a
+transfer, so that they are left out of the category report (otherwise
+card payments count as spending twice).  The commands are:
+
+  find-transfers days=n max=nnn link=true
+  link-transfer id1 id2
+  unlink-transfer id
+
+find-transfers lists the likely transfers among the transactions that
+are not yet linked: a pair in two different accounts with opposite
+amounts, no more than days apart (default 5).  Pairs whose
+descriptions name the other account, or read like a payment or
+transfer, score higher.  Each transaction is put in at most one pair,
+best score first.  With link=true, the pairs listed are linked.
+
+link-transfer links two transactions by hand, and unlink-transfer
+undoes a link, given either side.  The ids are the first few
+characters of the Id column of list-transactions.
+`
+
+func init() {
+	RegistorCmd("find-transfers", "", "Finds likely transfers between accounts (and links them).", handle_find_transfers)
+	RegistorCmd("link-transfer", "", "Links two transactions as a transfer.", handle_link_transfer)
+	RegistorCmd("unlink-transfer", "", "Unlinks the two sides of a transfer.", handle_unlink_transfer)
+	RegistorTopic("transfers", gTopic_transfers)
+}
+
+func handle_find_transfers(c *util.Context, cmdline string) {
+	params := make(map[string]string, 10)
+	_, err := ParseCmdLine(cmdline, params)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	days := m1.TransferDateWindow
+	if s, ok := util.MapAlias(params, "days"); ok {
+		days, err = strconv.Atoi(s)
+		if err != nil || days < 0 {
+			c.Printf("Invalid parameter for days (%s).\n", s)
+			return
+		}
+	}
+	maxlst := 100
+	if s, ok := util.MapAlias(params, "max"); ok {
+		maxlst, err = strconv.Atoi(s)
+		if err != nil {
+			c.Printf("Invalid parameter for max (%s). Err=%v\n", s, err)
+			return
+		}
+	}
+	link := false
+	if s, ok := util.MapAlias(params, "link"); ok {
+		link, err = util.StrToBool(s, false)
+		if err != nil {
+			c.Printf("Invalid parameter for link (%s). Err=%v\n", s, err)
+			return
+		}
+	}
+	v := m1.GetView()
+	lst := v.FindTransfers(days)
+	if len(lst) > maxlst {
+		lst = lst[:maxlst]
+	}
+	tbl := util.NewTable("Date", "From", "To", "Description", "Amount", "Score", "Why")
+	for _, m := range lst {
+		tbl.AddRow(m.From.Date().Format("06-01-02"), account_name(v, m.From.Aid), account_name(v, m.To.Aid),
+			util.FixStrLen(m.From.Description, 30, "..."), util.StrLeft((-m.From.Amount).String(), 14),
+			fmt.Sprintf("%3d", m.Score), strings.Join(m.Reasons, " "))
+	}
+	c.Printf("%s\n", tbl.Text())
+	c.Printf("Number of likely transfers: %d\n", len(lst))
+	if !link || len(lst) == 0 {
+		return
+	}
+	n, err := m1.LinkTransfers(lst)
+	if err != nil {
+		c.Printf("Unable to link the transfers. Err=%v\n", err)
+		return
+	}
+	c.Printf("Number of transfers linked: %d\n", n)
+}
+
+func handle_link_transfer(c *util.Context, cmdline string) {
+	params := make(map[string]string, 10)
+	args, err := ParseCmdLine(cmdline, params)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	if len(args) < 3 {
+		c.Printf("Give the ids of both sides. Use list-transactions to find them.\n")
+		return
+	}
+	v := m1.GetView()
+	t1, err := find_transaction(v, args[1])
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	t2, err := find_transaction(v, args[2])
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	err = m1.LinkTransfer(t1.Tid, t2.Tid)
+	if err != nil {
+		c.Printf("Unable to link the transfer. Err=%v\n", err)
+		return
+	}
+	c.Printf("Linked %s in %s with %s in %s.\n", short_id(t1.Tid), account_name(v, t1.Aid),
+		short_id(t2.Tid), account_name(v, t2.Aid))
+}
+
+func handle_unlink_transfer(c *util.Context, cmdline string) {
+	params := make(map[string]string, 10)
+	args, err := ParseCmdLine(cmdline, params)
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	if len(args) < 2 {
+		c.Printf("No id given. Use list-transactions to find it.\n")
+		return
+	}
+	t, err := find_transaction(m1.GetView(), args[1])
+	if err != nil {
+		c.Printf("%v\n", err)
+		return
+	}
+	err = m1.UnlinkTransfer(t.Tid)
+	if err != nil {
+		c.Printf("Unable to unlink the transfer. Err=%v\n", err)
+		return
+	}
+	c.Printf("Transfer unlinked.\n")
+}
+
+// find_transaction returns the transaction whose id starts with the
+// given characters.  A prefix that matches more than one is an error.
+func find_transaction(v *m1.View, prefix string) (*m1.Transaction, error) {
+	if len(prefix) < 4 {
+		return nil, fmt.Errorf("Give at least 4 characters of the id.")
+	}
+	prefix = strings.ToUpper(prefix)
+	var found *m1.Transaction
+	n := 0
+	v.EachTransaction(func(t *m1.Transaction) {
+		if strings.HasPrefix(strings.ToUpper(t.Tid.String()), prefix) {
+			found = t
+			n++
+		}
+	})
+	if n == 0 {
+		return nil, fmt.Errorf("No transaction has the id %q.", prefix)
+	}
+	if n > 1 {
+		return nil, fmt.Errorf("More than one transaction has an id that starts with %q.", prefix)
+	}
+	return found, nil
+}
@@ -12,6 +12,7 @@ import (
 	"fmt"
 	"github.com/peterh/liner"
 	"os"
+	osuser "os/user"
 	"sort"
 	"strings"
 )
@@ -61,7 +62,15 @@ func SetHistoryFile(filename string) {
 // ExecuteCommand will execute a command outside a command loop.  Suitable for
 // a web interface.  No history is maintained.
 func ExecuteCommand(cmdline string) string {
+	return ExecuteCommandAs("", cmdline)
+}
+
+// ExecuteCommandAs is ExecuteCommand for a known user, who is then
+// recorded by the commands that keep track of who did things (such as
+// the imports).
+func ExecuteCommandAs(user, cmdline string) string {
 	c := util.NewContext(util.Context_External)
+	c.SetUser(user)
 	execute_cmd(c, cmdline)
 	return c.Output()
 }
@@ -94,6 +103,12 @@ func ConsoleLoop() {
 		fmt.Printf("Terminal not supported. Editting commands won't work.\n")
 	}
 	fmt.Printf("Use 'help' for a list of commands.\n")
+
+	// Whoever is at the console is logged into the machine.
+	user := "console"
+	if u, err := osuser.Current(); err == nil {
+		user = u.Username
+	}
 	for {
 		cmdline, err := gConsole.Prompt(gPrompt)
 		if err == liner.ErrPromptAborted {
@@ -106,6 +121,7 @@ func ConsoleLoop() {
 		if !util.Blank(cmdline) {
 			gConsole.AppendHistory(cmdline)
 			c := util.NewContext(util.Context_Internal)
+			c.SetUser(user)
 			c.SetFlusher(func() {
 				fmt.Printf("%s", c.Output())
 				c.Reset()
@@ -0,0 +1,112 @@
+// --------------------------------------------------------------------
+// batch.go -- Fixes and rechecks the rows of staged import batches.
+//
+// Created 2020-04-19 DLB
+// --------------------------------------------------------------------
+
+package importer
+
+import (
+	"dbe/lib/uuid"
+	m1 "dbe/m1/m1data"
+	"dbe/m1/rules"
+	"fmt"
+)
+
+// RowEdit is a change to rows of a staged batch.  The ids that are zero
+// are left alone.
+type RowEdit struct {
+	Aid     uuid.UUID // The account for the rows
+	Vid     uuid.UUID // The vendor for the rows
+	Cid     uuid.UUID // A category for the whole amount of each row
+	SetSkip bool      // If true, Skip is set on the rows
+	Skip    bool
+}
+
+// EditBatch changes rows of a staged batch, given by their row numbers,
+// and then checks the whole batch again.  When the vendor of a row is
+// changed, and the row has no categories, the rules are run on it to
+// fill them in.  A row that becomes a duplicate is skipped.
+func EditBatch(bid uuid.UUID, rows []int, e *RowEdit) error {
+	v := m1.GetView()
+	b := v.Batch(bid)
+	if b == nil {
+		return fmt.Errorf("No import batch (%s).", bid)
+	}
+	if b.Status != m1.Batch_Staged {
+		return fmt.Errorf("Import batch %s is already %s.", bid, b.Status)
+	}
+	rs, err := rules.LoadRules(v)
+	if err != nil {
+		return err
+	}
+	bc := m1.CopyBatch(b)
+	byrow := make(map[int]*m1.ImportRow, len(bc.Rows))
+	for _, r := range bc.Rows {
+		byrow[r.Row] = r
+	}
+	for _, n := range rows {
+		r, ok := byrow[n]
+		if !ok {
+			return fmt.Errorf("No row %d in the batch.", n)
+		}
+		t := r.T
+		if !e.Aid.IsZero() {
+			t.Aid = e.Aid
+		}
+		if !e.Cid.IsZero() && t.Amount != 0 {
+			t.Cats = []m1.CatItem{m1.CatItem{Cid: e.Cid, Amount: t.Amount}}
+		}
+		if !e.Vid.IsZero() && t.Vid != e.Vid {
+			t.Vid = e.Vid
+			if res := rs.Apply(v, t); res != nil {
+				r.T = res.T
+			}
+		}
+		if e.SetSkip {
+			r.Skip = e.Skip
+		}
+	}
+	check_batch(v, bc)
+	return m1.AddBatch(bc)
+}
+
+// RecheckBatch checks the rows of a staged batch against the database
+// as it is now, since it may have changed since the batch was staged.
+// A row that becomes a duplicate is skipped.  The number of rows whose
+// status changed is returned.
+func RecheckBatch(bid uuid.UUID) (int, error) {
+	v := m1.GetView()
+	b := v.Batch(bid)
+	if b == nil {
+		return 0, fmt.Errorf("No import batch (%s).", bid)
+	}
+	if b.Status != m1.Batch_Staged {
+		return 0, fmt.Errorf("Import batch %s is already %s.", bid, b.Status)
+	}
+	bc := m1.CopyBatch(b)
+	n := check_batch(v, bc)
+	if n == 0 {
+		return 0, nil
+	}
+	return n, m1.AddBatch(bc)
+}
+
+// check_batch checks every row of a batch, in order, and returns the
+// number of rows whose status changed.
+func check_batch(v *m1.View, b *m1.ImportBatch) int {
+	ck := new_batch_checker(v)
+	n := 0
+	for _, r := range b.Rows {
+		old := r.Status
+		ck.check(r)
+		if r.Status == old {
+			continue
+		}
+		if r.Status == m1.Row_Duplicate {
+			r.Skip = true
+		}
+		n++
+	}
+	return n
+}
@@ -0,0 +1,281 @@
+// --------------------------------------------------------------------
+// csvprofile.go -- Named profiles that tell how to read the CSV files
+// that each bank exports.
+//
+// Created 2020-04-18 DLB
+// --------------------------------------------------------------------
+
+package importer
+
+import (
+	"dbe/lib/util"
+	"dbe/m1/config"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The profiles are kept in a text file next to config.txt, named by the
+// 'import_profiles' config parameter (import_profiles.txt by default).
+// Each profile starts with its name in brackets, and is followed by
+// key=value lines, in the same style as config.txt:
+//
+//   // BofA checking download
+//   [bofa]
+//   date=Posted Date
+//   date_format=01/02/2006
+//   description=Payee
+//   amount=Amount
+//
+// A column is given by its name in the header (case is ignored, and
+// several names can be separated by "|"), or by its number, counting
+// from 1.  See import_profiles_example.txt for all the keys.
+
+const default_profiles_file = "import_profiles.txt"
+
+// Sign conventions for the amount column.
+const (
+	Sign_Normal  = "normal"  // Positive is money into the account
+	Sign_Reverse = "reverse" // Positive is money out (as in most credit card files)
+)
+
+// CsvProfile tells how to read one bank's CSV files.  The column fields
+// hold the column names (or numbers) from the profile, and are blank if
+// the column is not used.
+type CsvProfile struct {
+	Name string
+
+	Account     string // Account that all rows go to, unless AccountCol is given
+	AccountCol  string
+	Date        string // Required
+	Settle      string
+	Month       string
+	Description string
+	Payee       string // Used to find the vendor.  Defaults to Description.
+	Category    string
+	Memo        string
+	CheckNum    string
+	FitId       string // A column with the bank's id for the transaction
+	Location    string
+	Flag        string
+
+	// The amount is either in one column, or in a debit and a credit
+	// column.  Debits are always taken as money out.
+	Amount string
+	Debit  string
+	Credit string
+	Sign   string // For the amount column: Sign_Normal or Sign_Reverse
+
+	DateFormat string // Go layout, such as 01/02/2006.  Blank for a guess.
+	Decimal    string // "." (the default) or ","
+	Delimiter  rune   // ',' (the default), ';' or tab
+	Encoding   string // utf-8 (the default), latin1 or windows-1252
+	SkipRows   int    // Lines to skip before the header
+	Header     bool   // True (the default) if the file has a header line
+}
+
+// ProfilesFile returns the name of the file that holds the profiles.
+func ProfilesFile() string {
+	fn, _ := config.GetStringParam("import_profiles", default_profiles_file)
+	return fn
+}
+
+// LoadProfiles reads all the profiles in the profiles file.
+func LoadProfiles() (map[string]*CsvProfile, error) {
+	fn := ProfilesFile()
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read import profiles (%s). Err=%v", fn, err)
+	}
+	return ParseProfiles(string(data))
+}
+
+// GetProfile returns a profile by name.
+func GetProfile(name string) (*CsvProfile, error) {
+	profiles, err := LoadProfiles()
+	if err != nil {
+		return nil, err
+	}
+	p, ok := profiles[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return nil, fmt.Errorf("No import profile named %q in %s.", name, ProfilesFile())
+	}
+	return p, nil
+}
+
+// ProfileNames returns the names of all the profiles, sorted.
+func ProfileNames(profiles map[string]*CsvProfile) []string {
+	lst := make([]string, 0, len(profiles))
+	for k := range profiles {
+		lst = append(lst, k)
+	}
+	sort.Strings(lst)
+	return lst
+}
+
+// ParseProfiles reads profiles from the text of a profiles file.
+func ParseProfiles(text string) (map[string]*CsvProfile, error) {
+	profiles := make(map[string]*CsvProfile, 10)
+	var p *CsvProfile
+	lines := strings.Split(text, "\n")
+	for i, ln := range lines {
+		ilinenum := i + 1
+		ln = strings.TrimSpace(ln)
+		if util.Blank(ln) || strings.HasPrefix(ln, "//") {
+			continue
+		}
+		if strings.HasPrefix(ln, "[") && strings.HasSuffix(ln, "]") {
+			if p != nil {
+				if err := check_profile(p); err != nil {
+					return nil, err
+				}
+			}
+			name := strings.ToLower(strings.TrimSpace(ln[1 : len(ln)-1]))
+			if name == "" {
+				return nil, fmt.Errorf("Blank profile name on line %d.", ilinenum)
+			}
+			if _, ok := profiles[name]; ok {
+				return nil, fmt.Errorf("Profile %q is given twice (line %d).", name, ilinenum)
+			}
+			p = &CsvProfile{Name: name, Sign: Sign_Normal, Decimal: ".", Delimiter: ',',
+				Encoding: "utf-8", Header: true}
+			profiles[name] = p
+			continue
+		}
+		if p == nil {
+			return nil, fmt.Errorf("Line %d is not in a profile. Start a profile with [name].", ilinenum)
+		}
+		i := strings.Index(ln, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("Bad syntax on line %d. No equal char found.", ilinenum)
+		}
+		key := strings.ToLower(strings.TrimSpace(ln[:i]))
+		val := strings.TrimSpace(ln[i+1:])
+		if err := set_profile_value(p, key, val); err != nil {
+			return nil, fmt.Errorf("Line %d: %v", ilinenum, err)
+		}
+	}
+	if p != nil {
+		if err := check_profile(p); err != nil {
+			return nil, err
+		}
+	}
+	return profiles, nil
+}
+
+// set_profile_value sets one key of a profile.
+func set_profile_value(p *CsvProfile, key, val string) error {
+	var err error
+	switch key {
+	case "account":
+		p.Account = val
+	case "account_col":
+		p.AccountCol = val
+	case "date":
+		p.Date = val
+	case "settle":
+		p.Settle = val
+	case "month":
+		p.Month = val
+	case "description", "desc":
+		p.Description = val
+	case "payee", "vendor":
+		p.Payee = val
+	case "category", "cat":
+		p.Category = val
+	case "memo":
+		p.Memo = val
+	case "checknum", "check":
+		p.CheckNum = val
+	case "fitid", "id":
+		p.FitId = val
+	case "location":
+		p.Location = val
+	case "flag":
+		p.Flag = val
+	case "amount":
+		p.Amount = val
+	case "debit":
+		p.Debit = val
+	case "credit":
+		p.Credit = val
+	case "sign":
+		p.Sign = strings.ToLower(val)
+		if p.Sign != Sign_Normal && p.Sign != Sign_Reverse {
+			return fmt.Errorf("Bad sign (%q). Use normal or reverse.", val)
+		}
+	case "date_format":
+		p.DateFormat = val
+	case "decimal":
+		if val != "." && val != "," {
+			return fmt.Errorf("Bad decimal (%q). Use . or ,", val)
+		}
+		p.Decimal = val
+	case "delimiter":
+		switch strings.ToLower(val) {
+		case ",", "comma":
+			p.Delimiter = ','
+		case ";", "semicolon":
+			p.Delimiter = ';'
+		case "tab", "\\t":
+			p.Delimiter = '\t'
+		case "|", "pipe":
+			p.Delimiter = '|'
+		default:
+			return fmt.Errorf("Bad delimiter (%q). Use comma, semicolon, tab or pipe.", val)
+		}
+	case "encoding":
+		p.Encoding = strings.ToLower(val)
+		switch p.Encoding {
+		case "utf-8", "utf8", "latin1", "iso-8859-1", "windows-1252", "cp1252":
+		default:
+			return fmt.Errorf("Unknown encoding (%q). Use utf-8, latin1 or windows-1252.", val)
+		}
+	case "skip", "skip_rows":
+		p.SkipRows, err = strconv.Atoi(val)
+		if err != nil || p.SkipRows < 0 {
+			return fmt.Errorf("Bad number of rows to skip (%q).", val)
+		}
+	case "header":
+		p.Header, err = util.StrToBool(val, true)
+		if err != nil {
+			return fmt.Errorf("Bad value for header (%q).", val)
+		}
+	default:
+		return fmt.Errorf("Unknown key (%q).", key)
+	}
+	return nil
+}
+
+// check_profile makes sure that a profile has the columns it needs.
+func check_profile(p *CsvProfile) error {
+	if p.Date == "" {
+		return fmt.Errorf("Profile %q has no date column.", p.Name)
+	}
+	if p.Amount == "" && p.Debit == "" && p.Credit == "" {
+		return fmt.Errorf("Profile %q has no amount column, or debit and credit columns.", p.Name)
+	}
+	if p.Amount != "" && (p.Debit != "" || p.Credit != "") {
+		return fmt.Errorf("Profile %q has both an amount column and debit/credit columns.", p.Name)
+	}
+	if p.Description == "" && p.Payee == "" {
+		return fmt.Errorf("Profile %q has no description or payee column.", p.Name)
+	}
+	if !p.Header {
+		for _, col := range p.columns() {
+			if _, err := strconv.Atoi(col); col != "" && err != nil {
+				return fmt.Errorf("Profile %q has no header, so its columns must be numbers (not %q).",
+					p.Name, col)
+			}
+		}
+	}
+	return nil
+}
+
+// columns returns all the column fields of a profile.
+func (p *CsvProfile) columns() []string {
+	return []string{p.AccountCol, p.Date, p.Settle, p.Month, p.Description, p.Payee, p.Category,
+		p.Memo, p.CheckNum, p.FitId, p.Location, p.Flag, p.Amount, p.Debit, p.Credit}
+}
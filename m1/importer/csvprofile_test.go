@@ -0,0 +1,185 @@
+// --------------------------------------------------------------------
+// csvprofile_test.go -- Test the CSV profiles, and reading CSV files
+// with them
+//
+// Created 2020-04-18 DLB
+// --------------------------------------------------------------------
+
+package importer
+
+import (
+	"dbe/lib/util"
+	"testing"
+)
+
+var csv_profiles string = `
+// Checking, with separate debit and credit columns.
+[checking]
+account=BofA Checking
+date=Posted Date|Date
+date_format=01/02/2006
+description=Payee
+memo=Memo
+checknum=Check
+debit=Debit
+credit=Credit
+
+// Credit card, where a charge is positive.
+[visa]
+date=Trans. Date
+description=Description
+amount=Amount
+sign=reverse
+
+// European bank: semicolons, decimal commas, two lines before the
+// header.
+[euro]
+date=Datum
+date_format=02.01.2006
+description=Omschrijving
+amount=Bedrag
+decimal=,
+delimiter=semicolon
+skip=2
+
+// No header, so the columns are numbers.
+[plain]
+header=false
+date=1
+description=3
+amount=2
+`
+
+type csvrowtest struct {
+	Date        string
+	Description string
+	Memo        string
+	CheckNum    string
+	Amount      util.Money
+}
+
+type csvtest struct {
+	Profile string
+	Input   string
+	Rows    []csvrowtest
+}
+
+var csv_tests []csvtest = []csvtest{
+	{"checking", "Posted Date,Payee,Memo,Check,Debit,Credit\n" +
+		"03/02/2020,Safeway,food,,12.50,\n" +
+		"03/05/2020,Acme Roofing,,1042,\"$1,234.00\",\n" +
+		"\n" +
+		"03/15/2020,Payroll,,,,\"2,500.00\"\n" +
+		"03/16/2020,Refund,,,-5.00,\n",
+		[]csvrowtest{
+			{"2020-03-02", "Safeway", "food", "", -1250},
+			{"2020-03-05", "Acme Roofing", "", "1042", -123400},
+			{"2020-03-15", "Payroll", "", "", 250000},
+			{"2020-03-16", "Refund", "", "", -500},
+		}},
+	{"visa", "Trans. Date,Description,Amount\n" +
+		"2020-04-10,SHELL OIL,45.00\n" +
+		"2020-04-12,PAYMENT THANK YOU,(300.00)\n",
+		[]csvrowtest{
+			{"2020-04-10", "SHELL OIL", "", "", -4500},
+			{"2020-04-12", "PAYMENT THANK YOU", "", "", 30000},
+		}},
+	{"euro", "Rekening 123\nPeriode maart\nDatum;Omschrijving;Bedrag\n" +
+		"02.03.2020;Albert Heijn;-1.234,56\n",
+		[]csvrowtest{
+			{"2020-03-02", "Albert Heijn", "", "", -123456},
+		}},
+	{"plain", "03/02/2020,-7.25,Parking\n",
+		[]csvrowtest{
+			{"2020-03-02", "Parking", "", "", -725},
+		}},
+}
+
+func Test_ReadCsvRows(t *testing.T) {
+	profiles, err := ParseProfiles(csv_profiles)
+	if err != nil {
+		t.Fatalf("ParseProfiles fails with Err=%v", err)
+	}
+	if p := profiles["checking"]; p.Account != "BofA Checking" || p.Sign != Sign_Normal || p.Delimiter != ',' {
+		t.Fatalf("Profile checking = %+v, not what was given", p)
+	}
+	for _, x := range csv_tests {
+		p := profiles[x.Profile]
+		if p == nil {
+			t.Fatalf("Profile %q not found", x.Profile)
+		}
+		rows, err := read_csv_rows([]byte(x.Input), p)
+		if err != nil {
+			t.Fatalf("read_csv_rows(%s) fails with Err=%v", x.Profile, err)
+		}
+		if len(rows) != len(x.Rows) {
+			t.Fatalf("read_csv_rows(%s) gives %d rows, Expected %d", x.Profile, len(rows), len(x.Rows))
+		}
+		for i, xr := range x.Rows {
+			r := rows[i].t
+			if r.DatePosted.Format("2006-01-02") != xr.Date || r.Description != xr.Description ||
+				r.Memo != xr.Memo || r.CheckNum != xr.CheckNum || r.Amount != xr.Amount {
+				t.Fatalf("read_csv_rows(%s) row %d = %+v, Expected %+v", x.Profile, i, r, xr)
+			}
+		}
+	}
+}
+
+func Test_ProfileFits(t *testing.T) {
+	profiles, err := ParseProfiles(csv_profiles)
+	if err != nil {
+		t.Fatalf("ParseProfiles fails with Err=%v", err)
+	}
+	for _, x := range csv_tests {
+		for name, p := range profiles {
+			fits := profile_fits(p, []byte(x.Input))
+			want := name == x.Profile && p.Header
+			if fits != want {
+				t.Fatalf("profile_fits(%s) on the %s file = %v, Expected %v", name, x.Profile, fits, want)
+			}
+		}
+	}
+}
+
+var csv_bad_rows []string = []string{
+	"Posted Date,Payee,Memo,Check,Debit,Credit\n03/02/2020,Safeway,,,abc,\n",
+	"Posted Date,Payee,Memo,Check,Debit,Credit\n2020-03-02,Safeway,,,1.00,\n",
+	"Posted Date,Payee,Memo,Check,Debit,Credit\n,Safeway,,,1.00,\n",
+	"Posted Date,Payee,Memo,Check,Debit,Credit\n03/02/2020,Safeway\n",
+	"Date,Description,Amount\n03/02/2020,Safeway,1.00\n",
+}
+
+func Test_ReadCsvRowsErrors(t *testing.T) {
+	profiles, err := ParseProfiles(csv_profiles)
+	if err != nil {
+		t.Fatalf("ParseProfiles fails with Err=%v", err)
+	}
+	for _, s := range csv_bad_rows {
+		if _, err := read_csv_rows([]byte(s), profiles["checking"]); err == nil {
+			t.Fatalf("read_csv_rows(%q) Expected an error", s)
+		}
+	}
+}
+
+var csv_bad_profiles []string = []string{
+	"date=Date\n",
+	"[]\n",
+	"[a]\ndate=Date\ndescription=Payee\namount=Amount\n[a]\ndate=Date\ndescription=Payee\namount=Amount\n",
+	"[a]\ndescription=Payee\namount=Amount\n",
+	"[a]\ndate=Date\ndescription=Payee\n",
+	"[a]\ndate=Date\ndescription=Payee\namount=Amount\ndebit=Debit\n",
+	"[a]\ndate=Date\namount=Amount\n",
+	"[a]\nheader=false\ndate=Date\ndescription=2\namount=3\n",
+	"[a]\ndate=Date\ndescription=Payee\namount=Amount\nsign=backwards\n",
+	"[a]\ndate=Date\ndescription=Payee\namount=Amount\ndelimiter=x\n",
+	"[a]\ndate=Date\ndescription=Payee\namount=Amount\ncolour=red\n",
+	"[a]\ndate Date\n",
+}
+
+func Test_ParseProfilesErrors(t *testing.T) {
+	for _, s := range csv_bad_profiles {
+		if _, err := ParseProfiles(s); err == nil {
+			t.Fatalf("ParseProfiles(%q) Expected an error", s)
+		}
+	}
+}
@@ -0,0 +1,482 @@
+// --------------------------------------------------------------------
+// import.go -- The part of an import that is the same for every kind
+// of file: finding duplicates, vendors and categories, and staging the
+// transactions in a batch.
+//
+// Created 2020-04-17 DLB
+// --------------------------------------------------------------------
+
+package importer
+
+import (
+	"crypto/sha256"
+	"dbe/lib/util"
+	"dbe/lib/uuid"
+	m1 "dbe/m1/m1data"
+	"dbe/m1/rules"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// Options are what the person doing an import can choose.
+type Options struct {
+	Format  string // Name of the importer to use, or blank to find it from the file
+	Profile string // The CSV profile, or blank to find one that fits the file
+	Account string // Account for statements (or rows) whose account is not found
+	DryRun  bool   // If true, the database is not changed
+	Force   bool   // If true, a file is imported even if it was imported before
+	User    string // Who is doing the import, kept in the batch
+}
+
+// Report tells what an import did, or would do on a dry run.
+type Report struct {
+	FileName   string
+	Format     string // Name of the importer used
+	DryRun     bool
+	Bid        uuid.UUID // The batch the rows were staged in, or zero if none was
+	Statements []*StatementReport
+}
+
+// StatementReport tells what was done with one statement (or one
+// account) in a file.
+type StatementReport struct {
+	AcctId      string
+	Account     string   // FName of the account that the statement went to
+	NewAlias    bool     // True if AcctId is to be added to the account's aliases
+	NFound      int      // Transactions in the statement
+	NAdded      int      // To be added when the batch is committed
+	NDuplicates int      // Skipped, because they are already in the database
+	NReview     int      // To go in the review queue, because they might be duplicates
+	NRuled      int      // Filled in by a rule, or the vendor's default category
+	NNoVendor   int      // To be added without a vendor
+	NNoCategory int      // To be added with a category that could not be found
+	NNoAccount  int      // Waiting for an account to be given
+	Problems    []string // Transactions that cannot be added, and why
+}
+
+// Imports do not go straight into the database.  Each file is staged in
+// an ImportBatch, one row per transaction, with the status of the row:
+// new, a duplicate, or missing its account, vendor or a category.  The
+// batch can then be looked over and fixed (see EditBatch), and
+// committed or discarded as a unit (see m1data/batch.go).
+
+// ImportFile imports a file, with the importer that fits it (see
+// FindImporter).
+func ImportFile(fn string, opt *Options) (*Report, error) {
+	imp, err := FindImporter(fn, opt)
+	if err != nil {
+		return nil, err
+	}
+	return Import(imp, fn, opt)
+}
+
+// Import reads a file with an importer, and stages its transactions in
+// a batch.  The account for each list of transactions in the file is
+// found by matching the name (or account number) in it against the
+// names and aliases of the accounts.  If nothing matches and
+// opt.Account is not blank, that account is used, and the name from
+// the file will be added to its aliases when the batch is committed, so
+// that the next file matches on its own.  If no account is found, the rows wait for one to be given.
+// Transactions already in the account are marked as duplicates, and
+// ones that might be will go to the review queue.  The vendor is found
+// from the payee, and the rules fill in the rest.  If opt.DryRun is
+// true, the database is not changed.
+func Import(imp Importer, fn string, opt *Options) (*Report, error) {
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read %s. Err=%v", fn, err)
+	}
+	o := *opt
+	opt = &o
+	plst, err := imp.Parse(fn, data, opt)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filepath.Base(fn), err)
+	}
+	if len(plst) == 0 {
+		return nil, fmt.Errorf("No transactions found in %s.", filepath.Base(fn))
+	}
+	rpt := &Report{FileName: filepath.Base(fn), Format: imp.Name(), DryRun: opt.DryRun}
+	stg, err := new_stager(fn, data, imp.Name(), opt)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range plst {
+		srpt := &StatementReport{AcctId: p.Account, NFound: len(p.Records) + len(p.Problems),
+			Problems: append([]string{}, p.Problems...)}
+		rpt.Statements = append(rpt.Statements, srpt)
+		acc, err := match_account(p.Account, opt.Account, srpt)
+		if err != nil {
+			return rpt, err
+		}
+		if srpt.NewAlias {
+			stg.new_alias(acc, p.Account)
+		}
+		stg.add(acc, p.Account, p.Records, srpt)
+	}
+	return rpt, stg.save(rpt, opt.DryRun)
+}
+
+// stager turns the transactions of a file into the rows of a batch.
+// One is used for the whole file, so that the checks for duplicates
+// cover every statement in it.
+type stager struct {
+	v    *m1.View
+	b    *m1.ImportBatch
+	vm   *VendorMatcher
+	rs   *rules.RuleSet
+	ck   *batch_checker
+	cats []*m1.Category
+}
+
+// new_stager makes a stager for a file.  The file's hash is kept in
+// the batch, and unless opt.Force is true, a file that was imported
+// before (and not rolled back) is refused, so that it is not loaded
+// twice.
+func new_stager(fn string, data []byte, kind string, opt *Options) (*stager, error) {
+	v := m1.GetView()
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	if old := v.BatchByHash(hash); old != nil && !opt.Force {
+		return nil, fmt.Errorf("%s was already imported on %s (batch %s, %s). "+
+			"Roll that batch back first, or use force=true.", filepath.Base(fn),
+			old.Created.Format("2006-01-02 15:04"), old.Bid, old.Status)
+	}
+	rs, err := rules.LoadRules(v)
+	if err != nil {
+		return nil, err
+	}
+	b := &m1.ImportBatch{Source: filepath.Base(fn), Kind: kind, Hash: hash, Profile: opt.Profile, User: opt.User,
+		Status: m1.Batch_Staged, Rows: make([]*m1.ImportRow, 0, 100)}
+	return &stager{v: v, b: b, vm: NewVendorMatcher(v.Vendors()), rs: rs, ck: new_batch_checker(v),
+		cats: v.Categories()}, nil
+}
+
+// add stages the transactions of one statement (or account) in a file.
+// The rules (see m1/rules) are run on each one to fill in what the file
+// left out, and then it is checked.  If acc is nil, the rows wait for
+// an account to be given; name is the account named in the file.  The
+// report is filled in as it goes.
+func (s *stager) add(acc *m1.Account, name string, lst []*Record, rpt *StatementReport) {
+	for _, x := range lst {
+		t := &m1.Transaction{Amount: x.Amount, FitId: strings.TrimSpace(x.FitId),
+			CheckNum: x.CheckNum, BankInfo: x.Memo, Month: x.Month, Location: x.Location, Flag: x.Flag,
+			DatePosted: x.DatePosted, DateSettled: x.DateSettled}
+		if acc != nil {
+			t.Aid = acc.Aid
+		}
+		t.Description = x.Description
+		if util.Blank(t.Description) {
+			t.Description = x.Payee
+		}
+		if util.Blank(t.Description) {
+			t.Description = x.Memo
+		}
+		t.Vid, _ = s.vm.Best(x.Payee)
+		t.Cats = s.categories(x)
+		t.Notes = x.Notes
+		if !util.Blank(x.TrnType) {
+			t.Notes += fmt.Sprintf("Type: %s\n", x.TrnType)
+		}
+		ruled := false
+		if res := s.rs.Apply(s.v, t); res != nil {
+			t = res.T
+			ruled = true
+		}
+		r := &m1.ImportRow{Row: x.Line, T: t, Account: name, Vendor: x.Payee}
+		if r.Row == 0 {
+			r.Row = len(s.b.Rows) + 1
+		}
+		s.ck.check(r)
+		if r.Status == m1.Row_Duplicate {
+			r.Skip = true
+		}
+		s.b.Rows = append(s.b.Rows, r)
+		count_row(rpt, r)
+		if ruled && r.Status != m1.Row_Duplicate {
+			rpt.NRuled++
+		}
+	}
+}
+
+// new_alias notes that an account id from the file is to be added to
+// the aliases of an account, when the batch is committed.
+func (s *stager) new_alias(acc *m1.Account, acctid string) {
+	for _, al := range s.b.NewAliases {
+		if al.Aid == acc.Aid && strings.EqualFold(al.Alias, acctid) {
+			return
+		}
+	}
+	s.b.NewAliases = append(s.b.NewAliases, &m1.BatchAlias{Aid: acc.Aid, Alias: acctid})
+}
+
+// categories makes the splits for the categories of a record.  If the
+// record has none, the list is empty, and the rules (or the vendor's
+// default category) fill them in.
+func (s *stager) categories(x *Record) []m1.CatItem {
+	lst := make([]m1.CatItem, 0, len(x.Splits))
+	if len(x.Splits) == 0 {
+		if !util.Blank(x.Category) && x.Amount != 0 {
+			lst = append(lst, category_item(s.cats, x.Category, x.Amount))
+		}
+		return lst
+	}
+	for _, sp := range x.Splits {
+		if sp.Amount == 0 {
+			continue
+		}
+		ci := category_item(s.cats, sp.Category, sp.Amount)
+		if sp.Memo != "" && ci.Notes != "" {
+			ci.Notes += "\n" + sp.Memo
+		} else if sp.Memo != "" {
+			ci.Notes = sp.Memo
+		}
+		lst = append(lst, ci)
+	}
+	return lst
+}
+
+// save stages the batch, unless this is a dry run, and puts its id in
+// the report.  A batch with nothing but duplicates is not kept.
+func (s *stager) save(rpt *Report, dryrun bool) error {
+	if dryrun {
+		return nil
+	}
+	keep := false
+	for _, r := range s.b.Rows {
+		if !r.Skip {
+			keep = true
+		}
+	}
+	if !keep {
+		return nil
+	}
+	if err := m1.AddBatch(s.b); err != nil {
+		return err
+	}
+	rpt.Bid = s.b.Bid
+	return nil
+}
+
+// count_row adds a staged row to the counts in a report.
+func count_row(rpt *StatementReport, r *m1.ImportRow) {
+	switch r.Status {
+	case m1.Row_Duplicate:
+		rpt.NDuplicates++
+	case m1.Row_Review:
+		rpt.NReview++
+	case m1.Row_NoAccount:
+		rpt.NNoAccount++
+	case m1.Row_Problem:
+		t := r.T
+		rpt.Problems = append(rpt.Problems, fmt.Sprintf("Row %d, %s %s %s: %s", r.Row,
+			t.Date().Format("2006-01-02"), t.Amount, t.Description, strings.Join(r.Reasons, " ")))
+	default:
+		rpt.NAdded++
+		if r.T.Vid.IsZero() {
+			rpt.NNoVendor++
+		}
+		if missing_category(r.T) {
+			rpt.NNoCategory++
+		}
+	}
+}
+
+// batch_checker works out the status of the rows in a batch.  The rows
+// must be checked in order, since a row can be a copy of one before it.
+type batch_checker struct {
+	v       *m1.View
+	seen    map[string]int // By account and dedupe_key, the row it was seen in, or 0 for the review queue
+	matched map[uuid.UUID]bool
+}
+
+func new_batch_checker(v *m1.View) *batch_checker {
+	ck := &batch_checker{v: v, seen: make(map[string]int, 100), matched: make(map[uuid.UUID]bool, 100)}
+	for _, r := range v.Reviews() {
+		ck.seen[r.T.Aid.String()+":"+dedupe_key(r.T)] = 0
+	}
+	return ck
+}
+
+// check sets the status of a row.  Within the batch, and against the
+// transactions already waiting for review, only exact copies are
+// duplicates.  Against the database, a transaction is a duplicate if it
+// scores high enough, and might be one if it scores lower (see
+// m1data/dedupe.go).  A transaction in the database can only be the
+// duplicate of one row.  A new transaction dated in a reconciled
+// period is a problem, since it cannot be added (see
+// m1data/reconcile.go).
+func (ck *batch_checker) check(r *m1.ImportRow) {
+	t := r.T
+	r.Reasons = make([]string, 0, 2)
+	r.DupOf = uuid.Zero()
+	r.Score = 0
+	if t.Aid.IsZero() {
+		r.Status = m1.Row_NoAccount
+		if util.Blank(r.Account) {
+			r.Reasons = append(r.Reasons, "No account given.")
+		} else {
+			r.Reasons = append(r.Reasons, fmt.Sprintf("No account for %s.", r.Account))
+		}
+		return
+	}
+	key := t.Aid.String() + ":" + dedupe_key(t)
+	if n, ok := ck.seen[key]; ok {
+		r.Status = m1.Row_Duplicate
+		if n == 0 {
+			r.Reasons = append(r.Reasons, "Same as a transaction waiting for review.")
+		} else {
+			r.Reasons = append(r.Reasons, fmt.Sprintf("Same as row %d.", n))
+		}
+		return
+	}
+	ck.seen[key] = r.Row
+	dup := best_duplicate(ck.v, t, ck.matched)
+	if dup != nil {
+		ck.matched[dup.T.Tid] = true
+		r.DupOf = dup.T.Tid
+		r.Score = dup.Score
+		if dup.Score >= m1.DupScore_Certain {
+			r.Status = m1.Row_Duplicate
+			r.Reasons = append(r.Reasons, dup.Reasons...)
+			return
+		}
+	}
+	if through := ck.v.LockedThrough(t.Aid); !through.IsZero() && !t.Date().After(through) {
+		r.Status = m1.Row_Problem
+		r.Reasons = append(r.Reasons, fmt.Sprintf("Dated in a reconciled period (through %s).",
+			through.Format("2006-01-02")))
+		return
+	}
+	if m1.GetSplitCheckMode() == m1.SplitCheck_Strict {
+		if probs := m1.CheckTransactionSplits(t); len(probs) > 0 {
+			r.Status = m1.Row_Problem
+			r.Reasons = append(r.Reasons, probs...)
+			return
+		}
+	}
+	switch {
+	case dup != nil:
+		r.Status = m1.Row_Review
+		r.Reasons = append(r.Reasons, dup.Reasons...)
+	case t.Vid.IsZero():
+		r.Status = m1.Row_NoVendor
+		if !util.Blank(r.Vendor) {
+			r.Reasons = append(r.Reasons, fmt.Sprintf("No vendor for %s.", r.Vendor))
+		}
+	case missing_category(t):
+		r.Status = m1.Row_NoCategory
+		for _, ci := range t.Cats {
+			if ci.Cid.IsZero() && strings.HasPrefix(ci.Notes, category_note) {
+				r.Reasons = append(r.Reasons, fmt.Sprintf("No category for %s.",
+					strings.TrimPrefix(strings.SplitN(ci.Notes, "\n", 2)[0], category_note)))
+			}
+		}
+	default:
+		r.Status = m1.Row_New
+	}
+}
+
+// best_duplicate returns the best match in the database for a
+// transaction, skipping the ones already matched, or nil.
+func best_duplicate(v *m1.View, t *m1.Transaction, matched map[uuid.UUID]bool) *m1.DupMatch {
+	for _, m := range v.FindDuplicates(t) {
+		if !matched[m.T.Tid] {
+			return m
+		}
+	}
+	return nil
+}
+
+// A split whose category could not be found is left without one, with
+// this note followed by the name from the file, so that it shows up in
+// check-splits and can be fixed by hand.
+const category_note = "Imported category: "
+
+// A transfer to another account ("[Savings]", as QIF writes it) is left
+// without a category, with this note, so that it can be written back
+// out by ExportQIF.  The note is the first line of the split's notes,
+// and the memo from the file, if any, follows it.
+const transfer_note = "Transfer: "
+
+// category_item makes a split for a category name from a file.  The
+// name is matched against the names and aliases of the categories.  A
+// name with subcategories ("Auto:Fuel") is tried whole, and then by its
+// last part.
+func category_item(cats []*m1.Category, name string, amount util.Money) m1.CatItem {
+	name = strings.TrimSpace(name)
+	if strings.HasPrefix(name, "[") && strings.HasSuffix(name, "]") {
+		return m1.CatItem{Amount: amount, Notes: transfer_note + strings.TrimSpace(name[1:len(name)-1])}
+	}
+	ci := m1.CatItem{Amount: amount}
+	cid, err := BestCategory(cats, name)
+	if err != nil {
+		if i := strings.LastIndex(name, ":"); i >= 0 {
+			cid, err = BestCategory(cats, name[i+1:])
+		}
+	}
+	if err != nil || cid.IsZero() {
+		ci.Notes = category_note + name
+		return ci
+	}
+	ci.Cid = cid
+	return ci
+}
+
+// missing_category returns true if a split of a transaction still has a
+// category from the file that could not be found.
+func missing_category(t *m1.Transaction) bool {
+	for _, ci := range t.Cats {
+		if ci.Cid.IsZero() && strings.HasPrefix(ci.Notes, category_note) {
+			return true
+		}
+	}
+	return false
+}
+
+// dedupe_key returns the key used to find exact copies of a
+// transaction, within a file or in the review queue.  The FITID is
+// used if there is one, otherwise the date, amount and description.
+func dedupe_key(t *m1.Transaction) string {
+	if !util.Blank(t.FitId) {
+		return "F:" + t.FitId
+	}
+	return fmt.Sprintf("D:%s:%d:%s", t.Date().Format("20060102"), t.Amount.Cents(),
+		strings.ToLower(strings.TrimSpace(t.Description)))
+}
+
+// match_account finds the account that a statement goes to.  The
+// statement's own account id (blank if the file has none) is matched
+// against the names and aliases of the accounts first.  If that fails,
+// the account given by the user is used, and rpt.NewAlias is set, so
+// that the statement's account id can be added to its aliases when the
+// batch is committed, and the next file matches on its own.  If no
+// account is found and none is given, nil is returned, and the
+// statement's rows wait in the batch for an account.
+func match_account(acctid, account string, rpt *StatementReport) (*m1.Account, error) {
+	accounts := m1.GetAccounts()
+	if !util.Blank(acctid) {
+		aid, err := BestAccount(accounts, acctid)
+		if err == nil {
+			acc := m1.GetAccount(aid)
+			rpt.Account = acc.FName
+			return acc, nil
+		}
+	}
+	if util.Blank(account) {
+		return nil, nil
+	}
+	aid, err := BestAccount(accounts, account)
+	if err != nil {
+		return nil, err
+	}
+	acc := m1.GetAccount(aid)
+	rpt.Account = acc.FName
+	if util.Blank(acctid) {
+		return acc, nil
+	}
+	rpt.NewAlias = true
+	return acc, nil
+}
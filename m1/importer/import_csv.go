@@ -0,0 +1,411 @@
+// --------------------------------------------------------------------
+// import_csv.go -- Imports CSV files from banks, using a profile to
+// find the columns.
+//
+// Created 2020-04-18 DLB
+// --------------------------------------------------------------------
+
+package importer
+
+import (
+	"bytes"
+	"dbe/lib/util"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// csvcols holds the index of each column used by a profile, or -1 if
+// the column is not used.
+type csvcols struct {
+	account, date, settle, month, description, payee, category, memo int
+	checknum, fitid, location, flag, amount, debit, credit           int
+	nMaxField                                                        int
+}
+
+// csv_format is the name of the CSV importer.
+const csv_format = "csv"
+
+// csv_importer reads CSV files from banks, using a profile (see
+// csvprofile.go) to find the columns.  The profile is given by name, or
+// found by trying each one on the header of the file.  The rows go to
+// the account given, or if none is, to the profile's account.  If the
+// profile has an account column, each row goes to the account named
+// in it.
+type csv_importer struct{}
+
+func init() {
+	RegisterImporter(csv_importer{})
+}
+
+func (csv_importer) Name() string {
+	return csv_format
+}
+
+func (csv_importer) Description() string {
+	return "CSV files from banks, read with a profile from " + filepath.Base(ProfilesFile()) + "."
+}
+
+func (csv_importer) Detect(fn string, head []byte) int {
+	profiles, err := LoadProfiles()
+	if err == nil {
+		for _, p := range profiles {
+			if profile_fits(p, head) {
+				return Detect_Content
+			}
+		}
+	}
+	if has_extension(fn, ".csv") {
+		return Detect_Extension
+	}
+	return Detect_No
+}
+
+func (csv_importer) Parse(fn string, data []byte, opt *Options) ([]*Parsed, error) {
+	p, err := csv_profile(data, opt)
+	if err != nil {
+		return nil, err
+	}
+	opt.Profile = p.Name
+	rows, err := read_csv_rows(data, p)
+	if err != nil {
+		return nil, err
+	}
+
+	// Put the rows in lists by the account named in the file.
+	account := opt.Account
+	if util.Blank(account) {
+		account = p.Account
+	}
+	lst := make([]*Parsed, 0, 2)
+	byname := make(map[string]*Parsed, 2)
+	for _, r := range rows {
+		name := r.account
+		if util.Blank(name) || p.AccountCol == "" {
+			name = account
+		}
+		key := strings.ToLower(strings.TrimSpace(name))
+		pa, ok := byname[key]
+		if !ok {
+			pa = &Parsed{Account: name, Records: make([]*Record, 0, len(rows))}
+			byname[key] = pa
+			lst = append(lst, pa)
+		}
+		r.t.Line = r.line
+		pa.Records = append(pa.Records, r.t)
+	}
+	return lst, nil
+}
+
+// csv_profile returns the profile named in opt, or else the one profile
+// that fits the header of the file.
+func csv_profile(data []byte, opt *Options) (*CsvProfile, error) {
+	if !util.Blank(opt.Profile) {
+		return GetProfile(opt.Profile)
+	}
+	profiles, err := LoadProfiles()
+	if err != nil {
+		return nil, err
+	}
+	fits := make([]string, 0, 2)
+	for _, name := range ProfileNames(profiles) {
+		if profile_fits(profiles[name], data) {
+			fits = append(fits, name)
+		}
+	}
+	switch len(fits) {
+	case 0:
+		return nil, fmt.Errorf("No CSV profile fits the header. Give one with profile=name.")
+	case 1:
+		return profiles[fits[0]], nil
+	}
+	return nil, fmt.Errorf("More than one CSV profile fits the header (%s). Give one with profile=name.",
+		strings.Join(fits, ", "))
+}
+
+// profile_fits returns true if the header at the start of some data has
+// every column that a profile uses.  A profile without a header never
+// fits, since there is nothing to go by.
+func profile_fits(p *CsvProfile, data []byte) bool {
+	if !p.Header {
+		return false
+	}
+	text := csv_decode(data, p.Encoding)
+	for i := 0; i < p.SkipRows; i++ {
+		j := strings.Index(text, "\n")
+		if j < 0 {
+			return false
+		}
+		text = text[j+1:]
+	}
+	if j := strings.Index(text, "\n"); j >= 0 {
+		text = text[:j]
+	}
+	rdr := csv.NewReader(strings.NewReader(text))
+	rdr.Comma = p.Delimiter
+	rdr.LazyQuotes = true
+	rdr.TrimLeadingSpace = true
+	header, err := rdr.Read()
+	if err != nil {
+		return false
+	}
+	_, err = map_columns(header, p)
+	return err == nil
+}
+
+// csvrow is one row of a CSV file, ready to import.
+type csvrow struct {
+	line    int
+	account string
+	t       *Record
+}
+
+// read_csv_rows reads all the rows in a CSV file.
+func read_csv_rows(data []byte, p *CsvProfile) ([]*csvrow, error) {
+	text := csv_decode(data, p.Encoding)
+	ilinenum := 0
+	for i := 0; i < p.SkipRows; i++ {
+		j := strings.Index(text, "\n")
+		if j < 0 {
+			text = ""
+			break
+		}
+		text = text[j+1:]
+		ilinenum++
+	}
+	rdr := csv.NewReader(strings.NewReader(text))
+	rdr.Comma = p.Delimiter
+	rdr.LazyQuotes = true
+	rdr.TrimLeadingSpace = true
+	rdr.FieldsPerRecord = -1
+	var header []string
+	if p.Header {
+		r, err := rdr.Read()
+		if err != nil {
+			return nil, fmt.Errorf("Header record unreadable. Err=%v", err)
+		}
+		ilinenum++
+		header = r
+	}
+	m, err := map_columns(header, p)
+	if err != nil {
+		return nil, err
+	}
+	rows := make([]*csvrow, 0, 100)
+	for {
+		r, err := rdr.Read()
+		if err == io.EOF {
+			break
+		}
+		ilinenum++
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: %v", ilinenum, err)
+		}
+		if all_blank(r) {
+			continue
+		}
+		row, err := convert_row(p, m, r)
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: %v", ilinenum, err)
+		}
+		row.line = ilinenum
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// convert_row makes a record from one row.
+func convert_row(p *CsvProfile, m *csvcols, r []string) (*csvrow, error) {
+	if len(r) <= m.nMaxField {
+		return nil, fmt.Errorf("Only %d columns found. Need %d columns.", len(r), m.nMaxField+1)
+	}
+	get := func(i int) string {
+		if i < 0 {
+			return ""
+		}
+		return strings.TrimSpace(r[i])
+	}
+	row := &csvrow{account: get(m.account)}
+	t := &Record{Payee: get(m.payee), Description: get(m.description), Memo: get(m.memo),
+		CheckNum: get(m.checknum), FitId: get(m.fitid), Location: get(m.location), Flag: get(m.flag),
+		Category: get(m.category)}
+	row.t = t
+	var err error
+	if t.DatePosted, err = csv_date(get(m.date), p.DateFormat); err != nil {
+		return nil, err
+	}
+	if t.DatePosted.IsZero() {
+		return nil, fmt.Errorf("No date.")
+	}
+	if t.DateSettled, err = csv_date(get(m.settle), p.DateFormat); err != nil {
+		return nil, err
+	}
+	if s := get(m.month); s != "" {
+		if t.Month, err = util.ParseGenericTime(s); err != nil {
+			return nil, fmt.Errorf("Unable to convert statement month (%q).", s)
+		}
+	}
+	if m.amount >= 0 {
+		t.Amount, err = csv_amount(get(m.amount), p.Decimal)
+		if err != nil {
+			return nil, err
+		}
+		if p.Sign == Sign_Reverse {
+			t.Amount = -t.Amount
+		}
+	} else {
+		debit, err := csv_amount(get(m.debit), p.Decimal)
+		if err != nil {
+			return nil, err
+		}
+		credit, err := csv_amount(get(m.credit), p.Decimal)
+		if err != nil {
+			return nil, err
+		}
+		t.Amount = credit.Abs() - debit.Abs()
+	}
+	return row, nil
+}
+
+// map_columns finds the index of each column that a profile uses.
+func map_columns(header []string, p *CsvProfile) (*csvcols, error) {
+	m := &csvcols{}
+	var err error
+	find := func(spec string) int {
+		if spec == "" || err != nil {
+			return -1
+		}
+		var i int
+		i, err = find_column(header, spec)
+		return i
+	}
+	m.account = find(p.AccountCol)
+	m.date = find(p.Date)
+	m.settle = find(p.Settle)
+	m.month = find(p.Month)
+	m.description = find(p.Description)
+	m.payee = find(p.Payee)
+	m.category = find(p.Category)
+	m.memo = find(p.Memo)
+	m.checknum = find(p.CheckNum)
+	m.fitid = find(p.FitId)
+	m.location = find(p.Location)
+	m.flag = find(p.Flag)
+	m.amount = find(p.Amount)
+	m.debit = find(p.Debit)
+	m.credit = find(p.Credit)
+	if err != nil {
+		return nil, err
+	}
+	if m.payee < 0 {
+		m.payee = m.description
+	}
+	m.nMaxField = findmax(m.account, m.date, m.settle, m.month, m.description, m.payee, m.category,
+		m.memo, m.checknum, m.fitid, m.location, m.flag, m.amount, m.debit, m.credit)
+	return m, nil
+}
+
+// find_column returns the index of a column, given its number
+// (counting from 1) or the names it can have in the header.
+func find_column(header []string, spec string) (int, error) {
+	if n, err := strconv.Atoi(spec); err == nil {
+		if n < 1 {
+			return -1, fmt.Errorf("Bad column number (%d).", n)
+		}
+		return n - 1, nil
+	}
+	for _, name := range strings.Split(spec, "|") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		for i, h := range header {
+			if strings.ToLower(strings.TrimSpace(h)) == name {
+				return i, nil
+			}
+		}
+	}
+	return -1, fmt.Errorf("No column for %q found in the header.", spec)
+}
+
+// csv_date converts a date with the profile's layout, or guesses at the
+// layout if none is given.  A blank date is the zero time.
+func csv_date(s, layout string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if layout == "" {
+		d, err := util.ParseGenericTime(s)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("Unable to convert date (%q).", s)
+		}
+		return d, nil
+	}
+	d, err := time.Parse(layout, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Unable to convert date (%q) with format %q.", s, layout)
+	}
+	return d, nil
+}
+
+// csv_amount converts an amount in dollars to money.  Dollar signs and
+// thousands separators are ignored, and an amount in parentheses is
+// negative.  A blank amount is zero.
+func csv_amount(s, decimal string) (util.Money, error) {
+	if util.Blank(s) {
+		return 0, nil
+	}
+	dec := '.'
+	if decimal == "," {
+		dec = ','
+	}
+	n, err := util.ParseMoneyDecimal(s, dec)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to parse the amount (%q).", s)
+	}
+	return n, nil
+}
+
+// csv_decode converts the bytes of a file to a string.
+func csv_decode(data []byte, encoding string) string {
+	switch encoding {
+	case "latin1", "iso-8859-1", "windows-1252", "cp1252":
+		var b strings.Builder
+		for _, c := range data {
+			if c >= 0x80 && c < 0xa0 && encoding != "latin1" && encoding != "iso-8859-1" {
+				b.WriteRune(cp1252[c-0x80])
+			} else {
+				b.WriteRune(rune(c))
+			}
+		}
+		return b.String()
+	}
+	return string(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")))
+}
+
+// cp1252 holds the characters for bytes 0x80 to 0x9f in windows-1252,
+// which are the only ones that are not the same as in latin1.
+var cp1252 = [32]rune{
+	'€', '\u0081', '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', '\u008d', 'Ž', '\u008f',
+	'\u0090', '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', '\u009d', 'ž', 'Ÿ'}
+
+func all_blank(ss []string) bool {
+	for _, s := range ss {
+		if !util.Blank(s) {
+			return false
+		}
+	}
+	return true
+}
+
+// findmax returns the largest number in the argument list.
+func findmax(ifields ...int) int {
+	n := -1
+	for _, i := range ifields {
+		if i > n {
+			n = i
+		}
+	}
+	return n
+}
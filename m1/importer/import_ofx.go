@@ -0,0 +1,67 @@
+// --------------------------------------------------------------------
+// import_ofx.go -- Imports OFX and QFX statements into the database.
+//
+// Created 2020-04-16 DLB
+// --------------------------------------------------------------------
+
+package importer
+
+import (
+	"bytes"
+	"time"
+)
+
+// ofx_importer reads the statements in OFX and QFX files.  Each
+// statement is given to Import with its ACCTID, which is matched
+// against the names and aliases of the accounts.
+type ofx_importer struct{}
+
+func init() {
+	RegisterImporter(ofx_importer{})
+}
+
+func (ofx_importer) Name() string {
+	return "ofx"
+}
+
+func (ofx_importer) Description() string {
+	return "Bank and credit card statements in OFX or QFX (Quicken) files."
+}
+
+func (ofx_importer) Detect(fn string, head []byte) int {
+	h := bytes.ToUpper(head)
+	if bytes.Contains(h, []byte("OFXHEADER")) || bytes.Contains(h, []byte("<OFX>")) {
+		return Detect_Certain
+	}
+	if has_extension(fn, ".ofx", ".qfx") {
+		return Detect_Extension
+	}
+	return Detect_No
+}
+
+func (ofx_importer) Parse(fn string, data []byte, opt *Options) ([]*Parsed, error) {
+	stmts, err := ParseOFX(string(data))
+	if err != nil {
+		return nil, err
+	}
+	lst := make([]*Parsed, 0, len(stmts))
+	for _, st := range stmts {
+		month := time.Time{}
+		if !st.End.IsZero() {
+			month = time.Date(st.End.Year(), st.End.Month(), 1, 0, 0, 0, 0, time.UTC)
+		}
+		p := &Parsed{Account: st.AcctId, Records: make([]*Record, 0, len(st.Trans))}
+		for _, x := range st.Trans {
+			r := &Record{FitId: x.FitId, Payee: x.Name, Memo: x.Memo, CheckNum: x.CheckNum,
+				TrnType: x.TrnType, Amount: x.Amount, Month: month}
+			r.DatePosted = x.DateUser
+			if r.DatePosted.IsZero() {
+				r.DatePosted = x.DatePosted
+			}
+			r.DateSettled = x.DatePosted
+			p.Records = append(p.Records, r)
+		}
+		lst = append(lst, p)
+	}
+	return lst, nil
+}
@@ -0,0 +1,159 @@
+// --------------------------------------------------------------------
+// import_qif.go -- Imports QIF files into the database, and exports
+// an account's transactions to QIF.
+//
+// Created 2020-04-17 DLB
+// --------------------------------------------------------------------
+
+package importer
+
+import (
+	"bytes"
+	m1 "dbe/m1/m1data"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// qif_importer reads the bank, credit card and cash transactions in
+// QIF files.  If the file has an !Account header, the name in it is
+// matched against the names and aliases of the accounts.  Categories,
+// including those on split lines, are found through the names and
+// aliases of the categories.  QIF has no transaction ids, so duplicates
+// are found by amount, date and payee.
+type qif_importer struct{}
+
+func init() {
+	RegisterImporter(qif_importer{})
+}
+
+func (qif_importer) Name() string {
+	return "qif"
+}
+
+func (qif_importer) Description() string {
+	return "Bank, credit card and cash transactions in Quicken QIF files."
+}
+
+func (qif_importer) Detect(fn string, head []byte) int {
+	line := strings.ToLower(strings.TrimSpace(string(head)))
+	if i := strings.Index(line, "\n"); i >= 0 {
+		line = strings.TrimSpace(line[:i])
+	}
+	if strings.HasPrefix(line, "!type:") || strings.HasPrefix(line, "!account") ||
+		strings.HasPrefix(line, "!option") {
+		return Detect_Certain
+	}
+	if has_extension(fn, ".qif") {
+		return Detect_Extension
+	}
+	return Detect_No
+}
+
+func (qif_importer) Parse(fn string, data []byte, opt *Options) ([]*Parsed, error) {
+	accts, err := ReadQIF(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if len(accts) == 0 {
+		return nil, fmt.Errorf("No bank, credit card or cash transactions found.")
+	}
+	lst := make([]*Parsed, 0, len(accts))
+	for _, qa := range accts {
+		p := &Parsed{Account: qa.Name, Records: make([]*Record, 0, len(qa.Trans)), Problems: make([]string, 0)}
+		for _, x := range qa.Trans {
+			if x.Date.IsZero() {
+				p.Problems = append(p.Problems, fmt.Sprintf("%s %s: No date.", x.Amount, x.Payee))
+				continue
+			}
+			r := &Record{Payee: x.Payee, Memo: x.Memo, CheckNum: x.CheckNum, DatePosted: x.Date,
+				Amount: x.Amount, Category: qif_category(x.Category)}
+			for _, sp := range x.Splits {
+				r.Splits = append(r.Splits, &RecordSplit{Category: qif_category(sp.Category), Amount: sp.Amount,
+					Memo: sp.Memo})
+			}
+			p.Records = append(p.Records, r)
+		}
+		lst = append(lst, p)
+	}
+	return lst, nil
+}
+
+// qif_category returns a QIF category without its class (after a "/").
+func qif_category(name string) string {
+	if i := strings.Index(name, "/"); i >= 0 {
+		name = name[:i]
+	}
+	return strings.TrimSpace(name)
+}
+
+// ExportQIF writes the transactions found by a query as QIF data.  The
+// query must be for one account.  The qtype is one of the QifType
+// values, and tells Quicken what kind of account it is.  The number of
+// transactions written is returned.
+func ExportQIF(w io.Writer, q *m1.Query, qtype string) (int, error) {
+	if q.Aid.IsZero() {
+		return 0, fmt.Errorf("No account given for the QIF export.")
+	}
+	switch strings.ToLower(qtype) {
+	case "", "bank":
+		qtype = QifType_Bank
+	case "ccard":
+		qtype = QifType_CCard
+	case "cash":
+		qtype = QifType_Cash
+	default:
+		return 0, fmt.Errorf("Unknown QIF type (%q). Use Bank, CCard or Cash.", qtype)
+	}
+	v := m1.GetView()
+	acc := v.Account(q.Aid)
+	if acc == nil {
+		return 0, fmt.Errorf("No account (%s) for the QIF export.", q.Aid)
+	}
+	lst, _, err := v.Query(q)
+	if err != nil {
+		return 0, err
+	}
+	qa := &QifAccount{Name: acc.FName, Type: qtype, Trans: make([]*QifTrn, 0, len(lst))}
+	for _, t := range lst {
+		x := &QifTrn{Date: t.Date(), Amount: t.Amount, Payee: t.Description, Memo: t.BankInfo,
+			CheckNum: t.CheckNum}
+		if len(t.Cats) == 1 && t.Cats[0].Amount == t.Amount {
+			x.Category = qif_catname(v, t.Cats[0])
+		} else {
+			for _, ci := range t.Cats {
+				s := &QifSplit{Category: qif_catname(v, ci), Amount: ci.Amount, Memo: ci.Notes}
+				if ci.Cid.IsZero() && s.Category != "" {
+					// The first line of the notes was the category.
+					s.Memo = ""
+					if i := strings.Index(ci.Notes, "\n"); i >= 0 {
+						s.Memo = ci.Notes[i+1:]
+					}
+				}
+				x.Splits = append(x.Splits, s)
+			}
+		}
+		qa.Trans = append(qa.Trans, x)
+	}
+	return len(qa.Trans), WriteQIF(w, qa)
+}
+
+// qif_catname returns the name to write for the category of a split.
+// A split that was imported without a category gets back the name it
+// had in the QIF file.
+func qif_catname(v *m1.View, ci m1.CatItem) string {
+	if c := v.Category(ci.Cid); c != nil {
+		return c.Name
+	}
+	note := ci.Notes
+	if i := strings.Index(note, "\n"); i >= 0 {
+		note = note[:i]
+	}
+	if strings.HasPrefix(note, transfer_note) {
+		return "[" + strings.TrimPrefix(note, transfer_note) + "]"
+	}
+	if strings.HasPrefix(note, category_note) {
+		return strings.TrimPrefix(note, category_note)
+	}
+	return ""
+}
@@ -0,0 +1,307 @@
+// --------------------------------------------------------------------
+// ofx.go -- Reads bank statements in OFX 1.x (SGML), OFX 2.x (XML)
+// and QFX files.
+//
+// Created 2020-04-16 DLB
+// --------------------------------------------------------------------
+
+package importer
+
+import (
+	"dbe/lib/util"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// An OFX 1.x file is SGML: a header of "NAME:VALUE" lines, followed by
+// tags where the leaf elements are not closed (<TRNAMT>-12.50).  An OFX
+// 2.x file is XML, where every element is closed.  A QFX file is OFX
+// with a few extra Quicken tags.  All of them are read by the same
+// tokenizer: an open tag that is followed by text is taken to be a leaf,
+// whether or not it is closed, and a close tag pops back to the element
+// with the same name.  Tags we don't know about are kept but not used.
+
+// Statement is one bank or credit card statement from an OFX file.
+type Statement struct {
+	BankId     string // Blank for credit cards
+	AcctId     string
+	AcctType   string // CHECKING, SAVINGS, CREDITCARD, ...
+	Currency   string
+	Start      time.Time
+	End        time.Time
+	HasLedger  bool
+	LedgerBal  util.Money
+	LedgerDate time.Time
+	Trans      []*StmtTrn
+}
+
+// StmtTrn is one transaction from a statement.
+type StmtTrn struct {
+	FitId      string
+	TrnType    string // DEBIT, CREDIT, CHECK, ...
+	Name       string
+	Memo       string
+	CheckNum   string
+	RefNum     string
+	DatePosted time.Time
+	DateUser   time.Time // Zero if not given
+	Amount     util.Money
+}
+
+// ofxnode is one element of an OFX file.
+type ofxnode struct {
+	name  string
+	value string
+	kids  []*ofxnode
+}
+
+// ReadOFXFile reads all the statements in an OFX or QFX file.
+func ReadOFXFile(fn string) ([]*Statement, error) {
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read %s. Err=%v", fn, err)
+	}
+	return ParseOFX(string(b))
+}
+
+// ParseOFX parses the text of an OFX or QFX file, and returns the
+// statements in it.
+func ParseOFX(s string) ([]*Statement, error) {
+	root, err := ofx_parse(s)
+	if err != nil {
+		return nil, err
+	}
+	lst := make([]*Statement, 0, 2)
+	for _, n := range root.find_all("STMTRS") {
+		st, err := ofx_statement(n, "BANKACCTFROM")
+		if err != nil {
+			return nil, err
+		}
+		lst = append(lst, st)
+	}
+	for _, n := range root.find_all("CCSTMTRS") {
+		st, err := ofx_statement(n, "CCACCTFROM")
+		if err != nil {
+			return nil, err
+		}
+		if st.AcctType == "" {
+			st.AcctType = "CREDITCARD"
+		}
+		lst = append(lst, st)
+	}
+	if len(lst) == 0 {
+		return nil, fmt.Errorf("No bank or credit card statements found.")
+	}
+	return lst, nil
+}
+
+// ofx_parse builds the tree of elements, starting at the <OFX> tag.
+// The header (SGML or XML) is skipped.
+func ofx_parse(s string) (*ofxnode, error) {
+	i := strings.Index(strings.ToUpper(s), "<OFX>")
+	if i < 0 {
+		return nil, fmt.Errorf("Not an OFX file. No <OFX> tag found.")
+	}
+	s = s[i:]
+	root := &ofxnode{name: ""}
+	stack := []*ofxnode{root}
+	for len(s) > 0 {
+		lt := strings.Index(s, "<")
+		if lt < 0 {
+			break
+		}
+		text := strings.TrimSpace(s[:lt])
+		s = s[lt:]
+		if strings.HasPrefix(s, "<!--") {
+			end := strings.Index(s, "-->")
+			if end < 0 {
+				break
+			}
+			s = s[end+3:]
+			continue
+		}
+		gt := strings.Index(s, ">")
+		if gt < 0 {
+			return nil, fmt.Errorf("Unterminated tag in OFX data.")
+		}
+		tag := strings.TrimSpace(s[1:gt])
+		s = s[gt+1:]
+		top := stack[len(stack)-1]
+		if text != "" && len(top.kids) == 0 && len(stack) > 1 {
+			// The text belongs to the last open tag, which is a leaf.
+			top.value = html.UnescapeString(text)
+			stack = stack[:len(stack)-1]
+			top = stack[len(stack)-1]
+			if strings.HasPrefix(tag, "/") && strings.EqualFold(tag[1:], top.kids[len(top.kids)-1].name) {
+				// The leaf was closed, as in XML.
+				continue
+			}
+		}
+		if strings.HasPrefix(tag, "?") || strings.HasPrefix(tag, "!") {
+			continue
+		}
+		if strings.HasPrefix(tag, "/") {
+			name := strings.ToUpper(strings.TrimSpace(tag[1:]))
+			for j := len(stack) - 1; j > 0; j-- {
+				if stack[j].name == name {
+					stack = stack[:j]
+					break
+				}
+			}
+			continue
+		}
+		selfclosed := strings.HasSuffix(tag, "/")
+		if selfclosed {
+			tag = strings.TrimSuffix(tag, "/")
+		}
+		if sp := strings.IndexAny(tag, " \t\r\n"); sp >= 0 {
+			tag = tag[:sp]
+		}
+		n := &ofxnode{name: strings.ToUpper(tag)}
+		top.kids = append(top.kids, n)
+		if !selfclosed {
+			stack = append(stack, n)
+		}
+	}
+	if len(root.kids) == 0 {
+		return nil, fmt.Errorf("Not an OFX file. No elements found.")
+	}
+	return root.kids[0], nil
+}
+
+// find returns the first child with the given name, or nil.
+func (n *ofxnode) find(name string) *ofxnode {
+	if n == nil {
+		return nil
+	}
+	for _, k := range n.kids {
+		if k.name == name {
+			return k
+		}
+	}
+	return nil
+}
+
+// find_all returns all the elements below a node, at any depth, that
+// have the given name.
+func (n *ofxnode) find_all(name string) []*ofxnode {
+	lst := make([]*ofxnode, 0, 2)
+	for _, k := range n.kids {
+		if k.name == name {
+			lst = append(lst, k)
+		} else {
+			lst = append(lst, k.find_all(name)...)
+		}
+	}
+	return lst
+}
+
+// get returns the value of the child with the given name, or blank.
+func (n *ofxnode) get(name string) string {
+	k := n.find(name)
+	if k == nil {
+		return ""
+	}
+	return k.value
+}
+
+// ofx_statement reads one STMTRS or CCSTMTRS element.
+func ofx_statement(n *ofxnode, acctag string) (*Statement, error) {
+	st := &Statement{Currency: n.get("CURDEF")}
+	acc := n.find(acctag)
+	if acc == nil {
+		return nil, fmt.Errorf("Statement has no %s.", acctag)
+	}
+	st.BankId = acc.get("BANKID")
+	st.AcctId = acc.get("ACCTID")
+	st.AcctType = acc.get("ACCTTYPE")
+	if st.AcctId == "" {
+		return nil, fmt.Errorf("Statement has no ACCTID.")
+	}
+	var err error
+	lst := n.find("BANKTRANLIST")
+	if lst != nil {
+		if st.Start, err = ofx_date(lst.get("DTSTART")); err != nil {
+			return nil, err
+		}
+		if st.End, err = ofx_date(lst.get("DTEND")); err != nil {
+			return nil, err
+		}
+		for _, k := range lst.kids {
+			if k.name != "STMTTRN" {
+				continue
+			}
+			t, err := ofx_transaction(k)
+			if err != nil {
+				return nil, fmt.Errorf("Account %s: %v", st.AcctId, err)
+			}
+			st.Trans = append(st.Trans, t)
+		}
+	}
+	bal := n.find("LEDGERBAL")
+	if bal != nil && bal.get("BALAMT") != "" {
+		if st.LedgerBal, err = ofx_amount(bal.get("BALAMT")); err != nil {
+			return nil, err
+		}
+		if st.LedgerDate, err = ofx_date(bal.get("DTASOF")); err != nil {
+			return nil, err
+		}
+		st.HasLedger = true
+	}
+	return st, nil
+}
+
+// ofx_transaction reads one STMTTRN element.
+func ofx_transaction(n *ofxnode) (*StmtTrn, error) {
+	t := &StmtTrn{FitId: n.get("FITID"), TrnType: n.get("TRNTYPE"), Name: n.get("NAME"),
+		Memo: n.get("MEMO"), CheckNum: n.get("CHECKNUM"), RefNum: n.get("REFNUM")}
+	if t.Name == "" {
+		// Some banks send the payee as a PAYEE aggregate instead.
+		t.Name = n.find("PAYEE").get("NAME")
+	}
+	var err error
+	if t.DatePosted, err = ofx_date(n.get("DTPOSTED")); err != nil {
+		return nil, err
+	}
+	if t.DatePosted.IsZero() {
+		return nil, fmt.Errorf("Transaction %q has no DTPOSTED.", t.FitId)
+	}
+	if t.DateUser, err = ofx_date(n.get("DTUSER")); err != nil {
+		return nil, err
+	}
+	if t.Amount, err = ofx_amount(n.get("TRNAMT")); err != nil {
+		return nil, fmt.Errorf("Transaction %q: %v", t.FitId, err)
+	}
+	return t, nil
+}
+
+// ofx_date converts an OFX date (YYYYMMDD, followed by an optional
+// time and time zone) to a date at midnight UTC, which is how dates are
+// kept in the database.  A blank date is the zero time.
+func ofx_date(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if len(s) < 8 {
+		return time.Time{}, fmt.Errorf("Bad OFX date (%q).", s)
+	}
+	d, err := time.Parse("20060102", s[:8])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Bad OFX date (%q). Err=%v", s, err)
+	}
+	return d, nil
+}
+
+// ofx_amount converts an OFX amount (such as "-1234.5") to money.  Some
+// banks use a comma for the decimal point, and some put commas between
+// the thousands.
+func ofx_amount(s string) (util.Money, error) {
+	if strings.Contains(s, ".") {
+		return util.ParseMoney(s)
+	}
+	return util.ParseMoneyDecimal(s, ',')
+}
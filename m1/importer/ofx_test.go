@@ -0,0 +1,246 @@
+// --------------------------------------------------------------------
+// ofx_test.go -- Test the OFX reader
+//
+// Created 2020-04-16 DLB
+// --------------------------------------------------------------------
+
+package importer
+
+import (
+	"dbe/lib/util"
+	"testing"
+	"time"
+)
+
+// An OFX 1.x (SGML) checking statement, where the leaf tags are not
+// closed.
+var ofx_sgml string = `OFXHEADER:100
+DATA:OFXSGML
+VERSION:102
+SECURITY:NONE
+ENCODING:USASCII
+CHARSET:1252
+COMPRESSION:NONE
+OLDFILEUID:NONE
+NEWFILEUID:NONE
+
+<OFX>
+<SIGNONMSGSRSV1>
+<SONRS>
+<STATUS>
+<CODE>0
+<SEVERITY>INFO
+</STATUS>
+<DTSERVER>20200415120000[-7:PDT]
+<LANGUAGE>ENG
+</SONRS>
+</SIGNONMSGSRSV1>
+<BANKMSGSRSV1>
+<STMTTRNRS>
+<TRNUID>1
+<STMTRS>
+<CURDEF>USD
+<BANKACCTFROM>
+<BANKID>121000358
+<ACCTID>000123456789
+<ACCTTYPE>CHECKING
+</BANKACCTFROM>
+<BANKTRANLIST>
+<DTSTART>20200301
+<DTEND>20200331235959.000[-8:PST]
+<STMTTRN>
+<TRNTYPE>DEBIT
+<DTPOSTED>20200302120000
+<TRNAMT>-12.50
+<FITID>202003021
+<NAME>SAFEWAY #1234
+<MEMO>Groceries &amp; more
+</STMTTRN>
+<STMTTRN>
+<TRNTYPE>CHECK
+<DTPOSTED>20200305
+<DTUSER>20200303
+<TRNAMT>-1,234.00
+<FITID>202003052
+<CHECKNUM>1042
+<PAYEE>
+<NAME>ACME ROOFING
+</PAYEE>
+</STMTTRN>
+<STMTTRN>
+<TRNTYPE>CREDIT
+<DTPOSTED>20200315
+<TRNAMT>2500,00
+<FITID>202003153
+<NAME>PAYROLL
+</STMTTRN>
+</BANKTRANLIST>
+<LEDGERBAL>
+<BALAMT>4321.09
+<DTASOF>20200331
+</LEDGERBAL>
+</STMTRS>
+</STMTTRNRS>
+</BANKMSGSRSV1>
+</OFX>
+`
+
+// An OFX 2.x (XML) credit card statement, where every element is
+// closed.
+var ofx_xml string = `<?xml version="1.0" encoding="UTF-8" standalone="no"?>
+<?OFX OFXHEADER="200" VERSION="211" SECURITY="NONE" OLDFILEUID="NONE" NEWFILEUID="NONE"?>
+<OFX>
+  <CREDITCARDMSGSRSV1>
+    <CCSTMTTRNRS>
+      <CCSTMTRS>
+        <CURDEF>USD</CURDEF>
+        <CCACCTFROM><ACCTID>4111XXXXXXXX1111</ACCTID></CCACCTFROM>
+        <BANKTRANLIST>
+          <DTSTART>20200401</DTSTART>
+          <DTEND>20200430</DTEND>
+          <!-- A comment the bank left in -->
+          <STMTTRN>
+            <TRNTYPE>DEBIT</TRNTYPE>
+            <DTPOSTED>20200410000000.000</DTPOSTED>
+            <TRNAMT>-45.00</TRNAMT>
+            <FITID>CC-1</FITID>
+            <NAME>SHELL OIL</NAME>
+          </STMTTRN>
+        </BANKTRANLIST>
+      </CCSTMTRS>
+    </CCSTMTTRNRS>
+  </CREDITCARDMSGSRSV1>
+</OFX>
+`
+
+type ofxtrantest struct {
+	FitId    string
+	TrnType  string
+	Name     string
+	Memo     string
+	CheckNum string
+	Posted   string
+	User     string
+	Amount   util.Money
+}
+
+type ofxtest struct {
+	Name      string
+	Input     string
+	AcctId    string
+	AcctType  string
+	BankId    string
+	Start     string
+	End       string
+	HasLedger bool
+	LedgerBal util.Money
+	Trans     []ofxtrantest
+}
+
+var ofx_tests []ofxtest = []ofxtest{
+	{"sgml", ofx_sgml, "000123456789", "CHECKING", "121000358", "2020-03-01", "2020-03-31", true, 432109,
+		[]ofxtrantest{
+			{"202003021", "DEBIT", "SAFEWAY #1234", "Groceries & more", "", "2020-03-02", "", -1250},
+			{"202003052", "CHECK", "ACME ROOFING", "", "1042", "2020-03-05", "2020-03-03", -123400},
+			{"202003153", "CREDIT", "PAYROLL", "", "", "2020-03-15", "", 250000},
+		}},
+	{"xml", ofx_xml, "4111XXXXXXXX1111", "CREDITCARD", "", "2020-04-01", "2020-04-30", false, 0,
+		[]ofxtrantest{
+			{"CC-1", "DEBIT", "SHELL OIL", "", "", "2020-04-10", "", -4500},
+		}},
+}
+
+var ofx_errors []string = []string{
+	"",
+	"OFXHEADER:100\nDATA:OFXSGML\n",
+	"<OFX><SIGNONMSGSRSV1></SIGNONMSGSRSV1></OFX>",
+	"<OFX><STMTRS><BANKACCTFROM><BANKID>1</BANKACCTFROM></STMTRS></OFX>",
+	"<OFX><STMTRS><BANKACCTFROM><ACCTID>1</BANKACCTFROM><BANKTRANLIST><STMTTRN><TRNAMT>1.00</STMTTRN>" +
+		"</BANKTRANLIST></STMTRS></OFX>",
+	"<OFX><STMTRS><BANKACCTFROM><ACCTID>1</BANKACCTFROM><BANKTRANLIST><STMTTRN><DTPOSTED>2020" +
+		"<TRNAMT>1.00</STMTTRN></BANKTRANLIST></STMTRS></OFX>",
+	"<OFX><STMTRS><BANKACCTFROM><ACCTID>1</BANKACCTFROM><BANKTRANLIST><STMTTRN><DTPOSTED>20200101" +
+		"<TRNAMT>abc</STMTTRN></BANKTRANLIST></STMTRS></OFX>",
+}
+
+func ofx_test_date(s string) time.Time {
+	if s == "" {
+		return time.Time{}
+	}
+	d, _ := time.Parse("2006-01-02", s)
+	return d
+}
+
+func Test_ParseOFX(t *testing.T) {
+	for _, x := range ofx_tests {
+		lst, err := ParseOFX(x.Input)
+		if err != nil {
+			t.Fatalf("ParseOFX(%s) fails with Err=%v", x.Name, err)
+		}
+		if len(lst) != 1 {
+			t.Fatalf("ParseOFX(%s) gives %d statements, Expected 1", x.Name, len(lst))
+		}
+		st := lst[0]
+		if st.AcctId != x.AcctId || st.AcctType != x.AcctType || st.BankId != x.BankId {
+			t.Fatalf("ParseOFX(%s) account = %q %q %q, Expected %q %q %q", x.Name, st.AcctId, st.AcctType,
+				st.BankId, x.AcctId, x.AcctType, x.BankId)
+		}
+		if !st.Start.Equal(ofx_test_date(x.Start)) || !st.End.Equal(ofx_test_date(x.End)) {
+			t.Fatalf("ParseOFX(%s) dates = %s to %s, Expected %s to %s", x.Name, st.Start, st.End, x.Start, x.End)
+		}
+		if st.HasLedger != x.HasLedger || st.LedgerBal != x.LedgerBal {
+			t.Fatalf("ParseOFX(%s) ledger = %v %d, Expected %v %d", x.Name, st.HasLedger, st.LedgerBal,
+				x.HasLedger, x.LedgerBal)
+		}
+		if len(st.Trans) != len(x.Trans) {
+			t.Fatalf("ParseOFX(%s) gives %d transactions, Expected %d", x.Name, len(st.Trans), len(x.Trans))
+		}
+		for i, xt := range x.Trans {
+			tr := st.Trans[i]
+			if tr.FitId != xt.FitId || tr.TrnType != xt.TrnType || tr.Name != xt.Name || tr.Memo != xt.Memo ||
+				tr.CheckNum != xt.CheckNum {
+				t.Fatalf("ParseOFX(%s) transaction %d = %+v, Expected %+v", x.Name, i, tr, xt)
+			}
+			if !tr.DatePosted.Equal(ofx_test_date(xt.Posted)) || !tr.DateUser.Equal(ofx_test_date(xt.User)) {
+				t.Fatalf("ParseOFX(%s) transaction %d dates = %s %s, Expected %s %s", x.Name, i,
+					tr.DatePosted, tr.DateUser, xt.Posted, xt.User)
+			}
+			if tr.Amount != xt.Amount {
+				t.Fatalf("ParseOFX(%s) transaction %d amount = %d, Expected %d", x.Name, i, tr.Amount, xt.Amount)
+			}
+		}
+	}
+	for _, s := range ofx_errors {
+		_, err := ParseOFX(s)
+		if err == nil {
+			t.Fatalf("ParseOFX(%q) Expected an error", s)
+		}
+	}
+}
+
+func Test_OfxDate(t *testing.T) {
+	tests := []struct {
+		Input  string
+		Result string
+	}{
+		{"20200302", "2020-03-02"},
+		{"20200302120000", "2020-03-02"},
+		{"20200331235959.000[-8:PST]", "2020-03-31"},
+		{" 20191231 ", "2019-12-31"},
+		{"", ""},
+	}
+	for _, x := range tests {
+		d, err := ofx_date(x.Input)
+		if err != nil {
+			t.Fatalf("ofx_date(%q) fails with Err=%v", x.Input, err)
+		}
+		if !d.Equal(ofx_test_date(x.Result)) {
+			t.Fatalf("ofx_date(%q) = %s, Expected %s", x.Input, d, x.Result)
+		}
+	}
+	for _, s := range []string{"2020", "2020-03-02", "20201340"} {
+		if _, err := ofx_date(s); err == nil {
+			t.Fatalf("ofx_date(%q) Expected an error", s)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+// --------------------------------------------------------------------
+// qif.go -- Reads and writes Quicken Interchange Format (QIF) files.
+//
+// Created 2020-04-17 DLB
+// --------------------------------------------------------------------
+
+package importer
+
+import (
+	"bufio"
+	"dbe/lib/util"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A QIF file is a list of lines, where the first character of each line
+// tells what the rest of the line is (D for date, T for amount, and so
+// on), and a line with "^" ends each record.  A line starting with "!"
+// sets the type of the records that follow, such as "!Type:Bank".  An
+// "!Account" header, if there is one, names the account that the next
+// list of transactions is for.  Only the bank, credit card and cash
+// types are read here.  Other lists (categories, investments, memorized
+// transactions, ...) are skipped.
+
+// QIF types that can be read and written.
+const (
+	QifType_Bank  = "Bank"
+	QifType_CCard = "CCard"
+	QifType_Cash  = "Cash"
+)
+
+// QifAccount is the list of transactions for one account in a QIF file.
+type QifAccount struct {
+	Name  string // From the !Account header, or blank if there was none
+	Type  string // One of the QifType values
+	Trans []*QifTrn
+}
+
+// QifTrn is one transaction from a QIF file.
+type QifTrn struct {
+	Date     time.Time
+	Amount   util.Money
+	Payee    string
+	Memo     string
+	CheckNum string
+	Cleared  string
+	Category string // Blank, a category name, or "[account]" for a transfer
+	Splits   []*QifSplit
+}
+
+// QifSplit is one split line (S, E and $) of a QIF transaction.
+type QifSplit struct {
+	Category string
+	Memo     string
+	Amount   util.Money
+}
+
+// ReadQIFFile reads the bank, credit card and cash transactions in a
+// QIF file.
+func ReadQIFFile(fn string) ([]*QifAccount, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open %s. Err=%v", fn, err)
+	}
+	defer f.Close()
+	return ReadQIF(f)
+}
+
+// ReadQIF reads the bank, credit card and cash transactions from QIF
+// data.
+func ReadQIF(r io.Reader) ([]*QifAccount, error) {
+	lst := make([]*QifAccount, 0, 2)
+	var cur *QifAccount // Nil when the records are not transactions
+	var t *QifTrn
+	var split *QifSplit
+	inaccount := false // True while reading an !Account record
+	acctname := ""
+	scanner := bufio.NewScanner(r)
+	nline := 0
+	for scanner.Scan() {
+		nline++
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if nline == 1 {
+			line = strings.TrimPrefix(line, "\ufeff") // Byte order mark
+		}
+		if line == "" {
+			continue
+		}
+		if line[0] == '!' {
+			hdr := strings.ToLower(strings.TrimSpace(line[1:]))
+			cur, t, split = nil, nil, nil
+			inaccount = false
+			switch {
+			case hdr == "account":
+				inaccount = true
+				acctname = ""
+			case strings.HasPrefix(hdr, "type:"):
+				qtype := qif_type(strings.TrimSpace(hdr[5:]))
+				if qtype != "" {
+					cur = &QifAccount{Name: acctname, Type: qtype}
+					lst = append(lst, cur)
+				}
+			}
+			continue
+		}
+		code, val := line[0], strings.TrimSpace(line[1:])
+		if inaccount {
+			// Only the name is used.  The transactions for the account
+			// follow, after a !Type header.
+			if code == 'N' {
+				acctname = val
+			}
+			continue
+		}
+		if cur == nil {
+			continue
+		}
+		if code == '^' {
+			if t != nil {
+				cur.Trans = append(cur.Trans, t)
+			}
+			t, split = nil, nil
+			continue
+		}
+		if t == nil {
+			t = &QifTrn{}
+		}
+		var err error
+		switch code {
+		case 'D':
+			t.Date, err = qif_date(val)
+		case 'T', 'U':
+			if code == 'T' || t.Amount == 0 {
+				t.Amount, err = qif_amount(val)
+			}
+		case 'P':
+			t.Payee = val
+		case 'M':
+			t.Memo = val
+		case 'N':
+			t.CheckNum = val
+		case 'C':
+			t.Cleared = val
+		case 'L':
+			t.Category = val
+		case 'S':
+			split = &QifSplit{Category: val}
+			t.Splits = append(t.Splits, split)
+		case 'E':
+			if split != nil {
+				split.Memo = val
+			}
+		case '$':
+			if split != nil {
+				split.Amount, err = qif_amount(val)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: %v", nline, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Unable to read QIF data. Err=%v", err)
+	}
+	if t != nil && cur != nil {
+		// The last record was not ended with a "^".
+		cur.Trans = append(cur.Trans, t)
+	}
+	return lst, nil
+}
+
+// qif_type returns the QIF type for a !Type header, or blank if the
+// type is not one that is read.
+func qif_type(s string) string {
+	switch s {
+	case "bank":
+		return QifType_Bank
+	case "ccard":
+		return QifType_CCard
+	case "cash":
+		return QifType_Cash
+	}
+	return ""
+}
+
+// qif_date converts a QIF date to a date at midnight UTC.  Quicken
+// writes dates as month/day/year, with an apostrophe before a two
+// digit year after 1999 (such as 3/ 4'05).  Some programs write the
+// year in full, or use dashes or dots, or write yyyy-mm-dd.
+func qif_date(s string) (time.Time, error) {
+	s = strings.Replace(s, " ", "", -1)
+	y2k := strings.Contains(s, "'")
+	f := strings.FieldsFunc(s, func(r rune) bool {
+		return r == '/' || r == '-' || r == '.' || r == '\''
+	})
+	if len(f) != 3 {
+		return time.Time{}, fmt.Errorf("Bad QIF date (%q).", s)
+	}
+	n := make([]int, 3)
+	for i, x := range f {
+		v, err := strconv.Atoi(x)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("Bad QIF date (%q).", s)
+		}
+		n[i] = v
+	}
+	month, day, year := n[0], n[1], n[2]
+	if len(f[0]) == 4 {
+		year, month, day = n[0], n[1], n[2]
+	} else if len(f[2]) <= 2 {
+		if y2k || year < 70 {
+			year += 2000
+		} else {
+			year += 1900
+		}
+	}
+	if month < 1 || month > 12 || day < 1 || day > 31 {
+		return time.Time{}, fmt.Errorf("Bad QIF date (%q).", s)
+	}
+	return time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC), nil
+}
+
+// qif_amount converts a QIF amount (such as "-1,234.56") to money.
+func qif_amount(s string) (util.Money, error) {
+	return util.ParseMoney(s)
+}
+
+// WriteQIF writes the transactions for one account as QIF data.  An
+// !Account header is written first, so that reading the data back in
+// finds the same account.  Each transaction gives a payee (P), memo
+// (M), category (L) and, if it is split, the split lines (S, E, $).
+func WriteQIF(w io.Writer, acct *QifAccount) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "!Account\nN%s\nT%s\n^\n", qif_line(acct.Name), acct.Type)
+	fmt.Fprintf(bw, "!Type:%s\n", acct.Type)
+	for _, t := range acct.Trans {
+		fmt.Fprintf(bw, "D%s\n", t.Date.Format("01/02/2006"))
+		fmt.Fprintf(bw, "T%s\n", qif_cents(t.Amount))
+		if t.CheckNum != "" {
+			fmt.Fprintf(bw, "N%s\n", qif_line(t.CheckNum))
+		}
+		if t.Cleared != "" {
+			fmt.Fprintf(bw, "C%s\n", qif_line(t.Cleared))
+		}
+		if t.Payee != "" {
+			fmt.Fprintf(bw, "P%s\n", qif_line(t.Payee))
+		}
+		if t.Memo != "" {
+			fmt.Fprintf(bw, "M%s\n", qif_line(t.Memo))
+		}
+		if t.Category != "" {
+			fmt.Fprintf(bw, "L%s\n", qif_line(t.Category))
+		}
+		for _, s := range t.Splits {
+			fmt.Fprintf(bw, "S%s\n", qif_line(s.Category))
+			if s.Memo != "" {
+				fmt.Fprintf(bw, "E%s\n", qif_line(s.Memo))
+			}
+			fmt.Fprintf(bw, "$%s\n", qif_cents(s.Amount))
+		}
+		fmt.Fprintf(bw, "^\n")
+	}
+	return bw.Flush()
+}
+
+// qif_cents formats money as a QIF amount, without commas.
+func qif_cents(m util.Money) string {
+	return m.Format(util.MoneyFormat{NoCommas: true})
+}
+
+// qif_line keeps a value on one line.
+func qif_line(s string) string {
+	s = strings.Replace(s, "\r", " ", -1)
+	return strings.TrimSpace(strings.Replace(s, "\n", " ", -1))
+}
@@ -0,0 +1,185 @@
+// --------------------------------------------------------------------
+// qif_test.go -- Test the QIF reader and writer
+//
+// Created 2020-04-17 DLB
+// --------------------------------------------------------------------
+
+package importer
+
+import (
+	"bytes"
+	"dbe/lib/util"
+	"strings"
+	"testing"
+)
+
+// A Quicken export with a byte order mark, account headers, a split
+// transaction, a transfer, a category list that is skipped, and a last
+// record that is not ended with a "^".
+var qif_sample string = "\ufeff!Account\r\n" +
+	`NBofA Checking
+TBank
+^
+!Type:Bank
+D3/ 4'05
+T-1,234.56
+N1042
+CX
+PCostco
+MWeekly shopping
+LGroceries
+SGroceries
+EFood
+$-1,000.00
+SHousehold
+$-234.56
+^
+D12/31/1999
+U50.00
+PATM Deposit
+L[Savings]
+^
+!Type:Cat
+NGroceries
+E
+^
+!Account
+NVisa
+TCCard
+^
+!Type:CCard
+D2020-04-01
+T-20.00
+PShell
+`
+
+type qifsplittest struct {
+	Category string
+	Memo     string
+	Amount   util.Money
+}
+
+type qiftrantest struct {
+	Date     string
+	Amount   util.Money
+	Payee    string
+	Memo     string
+	CheckNum string
+	Cleared  string
+	Category string
+	Splits   []qifsplittest
+}
+
+type qifaccttest struct {
+	Name  string
+	Type  string
+	Trans []qiftrantest
+}
+
+var qif_results []qifaccttest = []qifaccttest{
+	{"BofA Checking", QifType_Bank, []qiftrantest{
+		{"2005-03-04", -123456, "Costco", "Weekly shopping", "1042", "X", "Groceries",
+			[]qifsplittest{{"Groceries", "Food", -100000}, {"Household", "", -23456}}},
+		{"1999-12-31", 5000, "ATM Deposit", "", "", "", "[Savings]", nil},
+	}},
+	{"Visa", QifType_CCard, []qiftrantest{
+		{"2020-04-01", -2000, "Shell", "", "", "", "", nil},
+	}},
+}
+
+func Test_ReadQIF(t *testing.T) {
+	lst, err := ReadQIF(strings.NewReader(qif_sample))
+	if err != nil {
+		t.Fatalf("ReadQIF fails with Err=%v", err)
+	}
+	check_qif(t, "ReadQIF", lst)
+}
+
+// Test_WriteQIF writes what was read and reads it back.
+func Test_WriteQIF(t *testing.T) {
+	lst, err := ReadQIF(strings.NewReader(qif_sample))
+	if err != nil {
+		t.Fatalf("ReadQIF fails with Err=%v", err)
+	}
+	var b bytes.Buffer
+	for _, acct := range lst {
+		if err := WriteQIF(&b, acct); err != nil {
+			t.Fatalf("WriteQIF fails with Err=%v", err)
+		}
+	}
+	lst, err = ReadQIF(&b)
+	if err != nil {
+		t.Fatalf("ReadQIF of WriteQIF output fails with Err=%v", err)
+	}
+	check_qif(t, "WriteQIF", lst)
+}
+
+func check_qif(t *testing.T, name string, lst []*QifAccount) {
+	if len(lst) != len(qif_results) {
+		t.Fatalf("%s gives %d accounts, Expected %d", name, len(lst), len(qif_results))
+	}
+	for i, xa := range qif_results {
+		a := lst[i]
+		if a.Name != xa.Name || a.Type != xa.Type || len(a.Trans) != len(xa.Trans) {
+			t.Fatalf("%s account %d = %q %q with %d transactions, Expected %q %q with %d", name, i,
+				a.Name, a.Type, len(a.Trans), xa.Name, xa.Type, len(xa.Trans))
+		}
+		for j, xt := range xa.Trans {
+			tr := a.Trans[j]
+			if tr.Date.Format("2006-01-02") != xt.Date || tr.Amount != xt.Amount || tr.Payee != xt.Payee ||
+				tr.Memo != xt.Memo || tr.CheckNum != xt.CheckNum || tr.Cleared != xt.Cleared ||
+				tr.Category != xt.Category {
+				t.Fatalf("%s account %d transaction %d = %+v, Expected %+v", name, i, j, tr, xt)
+			}
+			if len(tr.Splits) != len(xt.Splits) {
+				t.Fatalf("%s account %d transaction %d has %d splits, Expected %d", name, i, j,
+					len(tr.Splits), len(xt.Splits))
+			}
+			for k, xs := range xt.Splits {
+				s := tr.Splits[k]
+				if s.Category != xs.Category || s.Memo != xs.Memo || s.Amount != xs.Amount {
+					t.Fatalf("%s account %d transaction %d split %d = %+v, Expected %+v", name, i, j, k, s, xs)
+				}
+			}
+		}
+	}
+}
+
+func Test_QifDate(t *testing.T) {
+	tests := []struct {
+		Input  string
+		Result string
+	}{
+		{"3/ 4'05", "2005-03-04"},
+		{"3/4/05", "2005-03-04"},
+		{"3/4/95", "1995-03-04"},
+		{"12/31'99", "2099-12-31"},
+		{"03/04/2005", "2005-03-04"},
+		{"3-4-2005", "2005-03-04"},
+		{"3.4.2005", "2005-03-04"},
+		{"2005-03-04", "2005-03-04"},
+	}
+	for _, x := range tests {
+		d, err := qif_date(x.Input)
+		if err != nil {
+			t.Fatalf("qif_date(%q) fails with Err=%v", x.Input, err)
+		}
+		if d.Format("2006-01-02") != x.Result {
+			t.Fatalf("qif_date(%q) = %s, Expected %s", x.Input, d.Format("2006-01-02"), x.Result)
+		}
+	}
+	for _, s := range []string{"", "3/4", "13/4/2005", "3/32/2005", "a/b/c", "3/4/5/6"} {
+		if _, err := qif_date(s); err == nil {
+			t.Fatalf("qif_date(%q) Expected an error", s)
+		}
+	}
+}
+
+func Test_ReadQIFErrors(t *testing.T) {
+	for _, s := range []string{"!Type:Bank\nD13/45/2005\n^\n", "!Type:Bank\nD1/1/2005\nTabc\n^\n",
+		"!Type:Bank\nD1/1/2005\nT-5.00\nSFood\n$x\n^\n"} {
+		if _, err := ReadQIF(strings.NewReader(s)); err == nil {
+			t.Fatalf("ReadQIF(%q) Expected an error", s)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+// --------------------------------------------------------------------
+// registry.go -- The kinds of files that can be imported, and how the
+// right one is picked for a file.
+//
+// Created 2020-04-19 DLB
+// --------------------------------------------------------------------
+
+package importer
+
+import (
+	"dbe/lib/util"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Every kind of file that can be imported (OFX, QIF, a bank's CSV, the
+// old data, ...) is an Importer, which registers itself in an init func
+// with RegisterImporter, the same way the console commands do.  An
+// importer only has to know its own files: how to tell one from the
+// first bytes (Detect), and how to read it into Records (Parse).
+// Mapping the records onto the accounts, vendors and categories, finding
+// duplicates, running the rules and staging the batch is the same for
+// every kind, and is done by Import.
+type Importer interface {
+	// Name is the short name of the format, such as "ofx", used to pick
+	// it by hand and kept in the batch.
+	Name() string
+
+	// Description is one line about the format, for lists.
+	Description() string
+
+	// Detect tells how sure the importer is that a file is its kind, as
+	// one of the Detect_ values, given the file's name and its first
+	// bytes (up to detect_len).
+	Detect(fn string, head []byte) int
+
+	// Parse reads the whole file, and returns its transactions, in one
+	// Parsed list for each account named in it.  An importer that has
+	// choices (such as the CSV profile) may fill them in, in opt.
+	Parse(fn string, data []byte, opt *Options) ([]*Parsed, error)
+}
+
+// How sure an importer is that a file is its kind.  The importer that
+// is most sure is used.
+const (
+	Detect_No        = 0
+	Detect_Extension = 30 // Only the file's extension fits
+	Detect_Content   = 60 // What is in the file fits
+	Detect_Certain   = 90 // What is in the file could only be this kind
+)
+
+// detect_len is how much of a file is given to Detect.
+const detect_len = 4096
+
+// Parsed is the transactions from a file for one account.
+type Parsed struct {
+	Account  string    // The account id or name from the file, or blank if none
+	Records  []*Record // The transactions
+	Problems []string  // Transactions that could not be read, and why
+}
+
+// Record is one transaction as read from a file, before it is matched
+// up with the database.
+type Record struct {
+	Line        int // Line or item number in the file, if known
+	FitId       string
+	Payee       string // Used to find the vendor
+	Description string // If blank, the payee is used
+	Memo        string
+	CheckNum    string
+	Location    string
+	Flag        string
+	TrnType     string
+	DatePosted  time.Time
+	DateSettled time.Time
+	Month       time.Time
+	Amount      util.Money
+	Category    string         // Category name from the file, if any
+	Splits      []*RecordSplit // Split categories, if the file has them, in place of Category
+	Notes       string         // Put at the start of the transaction's notes
+}
+
+// RecordSplit is one split category of a Record.
+type RecordSplit struct {
+	Category string
+	Amount   util.Money
+	Memo     string
+}
+
+var gImporters []Importer
+
+// RegisterImporter adds an importer to the registry.  It is called from
+// init funcs.  The names must be unique.
+func RegisterImporter(imp Importer) {
+	for _, x := range gImporters {
+		if x.Name() == imp.Name() {
+			panic(fmt.Sprintf("Importer %q registered twice.", imp.Name()))
+		}
+	}
+	gImporters = append(gImporters, imp)
+	sort.Slice(gImporters, func(i, j int) bool { return gImporters[i].Name() < gImporters[j].Name() })
+}
+
+// GetImporters returns the registered importers, by name.
+func GetImporters() []Importer {
+	return append([]Importer{}, gImporters...)
+}
+
+// GetImporter returns the importer with a name, ignoring case, or nil.
+func GetImporter(name string) Importer {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for _, imp := range gImporters {
+		if imp.Name() == name {
+			return imp
+		}
+	}
+	return nil
+}
+
+// importer_names returns the names of the importers, for messages.
+func importer_names() string {
+	lst := make([]string, 0, len(gImporters))
+	for _, imp := range gImporters {
+		lst = append(lst, imp.Name())
+	}
+	return strings.Join(lst, ", ")
+}
+
+// FindImporter picks the importer for a file.  The one named in
+// opt.Format is used if given, and the CSV importer if only a profile
+// is given.  Otherwise each importer looks at the start of the file,
+// and the one that is most sure is used.  If two are as sure, neither
+// is used, and the format must be given.
+func FindImporter(fn string, opt *Options) (Importer, error) {
+	if !util.Blank(opt.Format) {
+		imp := GetImporter(opt.Format)
+		if imp == nil {
+			return nil, fmt.Errorf("No importer for the format %q. Formats are: %s.", opt.Format, importer_names())
+		}
+		return imp, nil
+	}
+	if !util.Blank(opt.Profile) {
+		if imp := GetImporter(csv_format); imp != nil {
+			return imp, nil
+		}
+	}
+	head, err := read_head(fn)
+	if err != nil {
+		return nil, err
+	}
+	var best Importer
+	bestscore, tie := Detect_No, ""
+	for _, imp := range gImporters {
+		score := imp.Detect(fn, head)
+		if score > bestscore {
+			best, bestscore, tie = imp, score, ""
+		} else if score == bestscore && score > Detect_No {
+			tie = imp.Name()
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("Unable to tell what kind of file %s is. Give the format (%s).",
+			filepath.Base(fn), importer_names())
+	}
+	if tie != "" {
+		return nil, fmt.Errorf("%s could be %s or %s. Give the format.", filepath.Base(fn), best.Name(), tie)
+	}
+	return best, nil
+}
+
+// read_head returns the first bytes of a file, for Detect.
+func read_head(fn string) ([]byte, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open %s. Err=%v", fn, err)
+	}
+	defer f.Close()
+	head := make([]byte, detect_len)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("Unable to read %s. Err=%v", fn, err)
+	}
+	return head[:n], nil
+}
+
+// has_extension returns true if a file name ends with one of the
+// extensions, ignoring case.
+func has_extension(fn string, exts ...string) bool {
+	ext := strings.ToLower(filepath.Ext(fn))
+	for _, x := range exts {
+		if ext == x {
+			return true
+		}
+	}
+	return false
+}
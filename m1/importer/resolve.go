@@ -0,0 +1,61 @@
+// --------------------------------------------------------------------
+// resolve.go -- Finds the account and category that best match a name
+// from imported data.  Vendors are found with a VendorMatcher (see
+// vendormatch.go).
+//
+// Created 2020-04-16 DLB
+// --------------------------------------------------------------------
+
+package importer
+
+import (
+	"dbe/lib/util"
+	"dbe/lib/uuid"
+	m1 "dbe/m1/m1data"
+	"fmt"
+	"strings"
+)
+
+// BestAccount returns the id of the account whose names or aliases
+// match the given name, ignoring case.
+func BestAccount(accounts []*m1.Account, a string) (uuid.UUID, error) {
+	aa := strings.ToLower(strings.TrimSpace(a))
+	for _, account := range accounts {
+		if aa == strings.ToLower(strings.TrimSpace(account.ShortName)) {
+			return account.Aid, nil
+		}
+		if aa == strings.ToLower(strings.TrimSpace(account.DName)) {
+			return account.Aid, nil
+		}
+		if aa == strings.ToLower(strings.TrimSpace(account.FName)) {
+			return account.Aid, nil
+		}
+		for _, alias := range account.Aliases {
+			if aa == strings.ToLower(strings.TrimSpace(alias)) {
+				return account.Aid, nil
+			}
+		}
+	}
+	return uuid.Zero(), fmt.Errorf("No account for %s.", a)
+}
+
+// BestCategory returns the id of the category whose name or aliases
+// match the given name, ignoring case.  A blank name gives a zero id
+// and no error.
+func BestCategory(categories []*m1.Category, cat string) (uuid.UUID, error) {
+	if util.Blank(cat) {
+		return uuid.Zero(), nil
+	}
+	catc := strings.ToLower(strings.TrimSpace(cat))
+	for _, c := range categories {
+		if catc == strings.ToLower(strings.TrimSpace(c.Name)) {
+			return c.Cid, nil
+		}
+		for _, a := range c.Aliases {
+			if catc == strings.ToLower(strings.TrimSpace(a)) {
+				return c.Cid, nil
+			}
+		}
+	}
+	return uuid.Zero(), fmt.Errorf("No category for %s.", cat)
+}
@@ -0,0 +1,301 @@
+// --------------------------------------------------------------------
+// vendormatch.go -- Finds the vendor for a raw bank descriptor, such as
+// "SQ *BLUE BOTTLE 1234 OAKLAND CA", and learns aliases for vendors.
+//
+// Created 2020-04-19 DLB
+// --------------------------------------------------------------------
+
+package importer
+
+import (
+	"dbe/lib/util"
+	"dbe/lib/uuid"
+	m1 "dbe/m1/m1data"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// A bank descriptor is the merchant's name with other things around it:
+// a card processor's prefix ("SQ *", "TST* "), words from the bank ("POS
+// PURCHASE"), store and reference numbers, and the city and state.  These
+// change from one transaction to the next, which is why matching on the
+// whole string needed an alias for every store.  So both the descriptor
+// and the vendor names are normalized (see NormalizeDescriptor), and
+// then scored from 0 to 100 on the words they share and how close the
+// spelling is.  A normalized name that is the same scores 100.  An
+// import takes a vendor at VendorScore_Certain or above, if no other
+// vendor is close (VendorScore_Margin).  Matches down to
+// VendorScore_Suggest are shown as suggestions.
+const (
+	VendorScore_Certain = 85
+	VendorScore_Suggest = 50
+	VendorScore_Margin  = 10
+)
+
+// Text before a '*' in the first few characters of a descriptor is the
+// card processor's code, such as "SQ *" or "TST* ", unless a reference
+// number follows the '*'.
+const processor_prefix_len = 12
+
+// noise_words are dropped from descriptors and names.  They are put in
+// by the banks and processors, and tell nothing about the merchant.
+var noise_words = map[string]bool{
+	"pos": true, "purchase": true, "debit": true, "credit": true, "card": true,
+	"checkcard": true, "authorized": true, "recurring": true, "ach": true,
+	"ppd": true, "web": true, "www": true, "com": true, "inc": true, "llc": true,
+	"co": true, "the": true, "and": true,
+}
+
+var state_codes = map[string]bool{
+	"al": true, "ak": true, "az": true, "ar": true, "ca": true, "co": true, "ct": true,
+	"de": true, "dc": true, "fl": true, "ga": true, "hi": true, "id": true, "il": true,
+	"in": true, "ia": true, "ks": true, "ky": true, "la": true, "me": true, "md": true,
+	"ma": true, "mi": true, "mn": true, "ms": true, "mo": true, "mt": true, "ne": true,
+	"nv": true, "nh": true, "nj": true, "nm": true, "ny": true, "nc": true, "nd": true,
+	"oh": true, "ok": true, "or": true, "pa": true, "ri": true, "sc": true, "sd": true,
+	"tn": true, "tx": true, "ut": true, "vt": true, "va": true, "wa": true, "wv": true,
+	"wi": true, "wy": true,
+}
+
+// NormalizeDescriptor reduces a bank descriptor, or a vendor name, to
+// the words that name the merchant, in lower case.  The processor's
+// prefix, noise words and words with digits in them (store numbers,
+// dates) are dropped.  A state code at the end is dropped, and so is the
+// city before it if that still leaves two words.  So "SQ *BLUE BOTTLE
+// 1234 OAKLAND CA" becomes "blue bottle".
+func NormalizeDescriptor(s string) string {
+	return strings.Join(descriptor_words(s), " ")
+}
+
+func descriptor_words(s string) []string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if i := strings.Index(s, "*"); i >= 0 && i <= processor_prefix_len {
+		// If a reference number follows the '*', as in "AMZN MKTP
+		// US*2K4L91", the merchant is before it.
+		rest := strings.TrimSpace(s[i+1:])
+		if j := strings.IndexAny(rest, " ./"); j >= 0 {
+			rest = rest[:j]
+		}
+		if strings.IndexFunc(rest, unicode.IsDigit) >= 0 {
+			s = s[:i] + " " + s[i+1:]
+		} else {
+			s = s[i+1:]
+		}
+	}
+	s = strings.ReplaceAll(s, "'", "")
+	words := strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	lst := make([]string, 0, len(words))
+	for _, w := range words {
+		if noise_words[w] || strings.IndexFunc(w, unicode.IsDigit) >= 0 {
+			continue
+		}
+		lst = append(lst, w)
+	}
+	if len(lst) > 1 && state_codes[lst[len(lst)-1]] {
+		lst = lst[:len(lst)-1]
+		if len(lst) > 2 {
+			lst = lst[:len(lst)-1]
+		}
+	}
+	return lst
+}
+
+// VendorMatch is a vendor that could be the one for a descriptor.
+type VendorMatch struct {
+	Vid     uuid.UUID
+	Vendor  string // FName of the vendor
+	Matched string // The name or alias of the vendor that matched
+	Score   int
+}
+
+// VendorMatcher finds vendors for descriptors.  Make one with
+// NewVendorMatcher for each list of vendors, since the names are
+// normalized once, when it is made.
+type VendorMatcher struct {
+	names []*vendor_name
+	exact map[string]*vendor_name // By name in lower case
+}
+
+type vendor_name struct {
+	v      *m1.Vendor
+	name   string
+	words  []string
+	joined string
+}
+
+// NewVendorMatcher makes a matcher for a list of vendors.  The FName,
+// DName and aliases of each vendor are used.
+func NewVendorMatcher(vendors []*m1.Vendor) *VendorMatcher {
+	vm := &VendorMatcher{names: make([]*vendor_name, 0, 4*len(vendors)),
+		exact: make(map[string]*vendor_name, 4*len(vendors))}
+	for _, v := range vendors {
+		names := append([]string{v.FName, v.DName}, v.Aliases...)
+		for _, n := range names {
+			if util.Blank(n) {
+				continue
+			}
+			vn := &vendor_name{v: v, name: strings.TrimSpace(n), words: descriptor_words(n)}
+			vn.joined = strings.Join(vn.words, " ")
+			vm.names = append(vm.names, vn)
+			key := strings.ToLower(vn.name)
+			if _, ok := vm.exact[key]; !ok {
+				vm.exact[key] = vn
+			}
+		}
+	}
+	return vm
+}
+
+// Best returns the id of the vendor for a descriptor.  A name or alias
+// that is the same, ignoring case, is used first.  Otherwise the best
+// match is used if it is certain and no other vendor is close.  A blank
+// descriptor gives a zero id and no error.
+func (vm *VendorMatcher) Best(desc string) (uuid.UUID, error) {
+	if util.Blank(desc) {
+		return uuid.Zero(), nil
+	}
+	if vn, ok := vm.exact[strings.ToLower(strings.TrimSpace(desc))]; ok {
+		return vn.v.Vid, nil
+	}
+	lst := vm.Suggest(desc, 2)
+	if len(lst) > 0 && lst[0].Score >= VendorScore_Certain {
+		if len(lst) == 1 || lst[0].Score-lst[1].Score >= VendorScore_Margin {
+			return lst[0].Vid, nil
+		}
+		return uuid.Zero(), fmt.Errorf("No vendor for %s. Could be %s or %s.", desc, lst[0].Vendor, lst[1].Vendor)
+	}
+	return uuid.Zero(), fmt.Errorf("No vendor for %s.", desc)
+}
+
+// Suggest returns up to max vendors that could be the one for a
+// descriptor, with a score of at least VendorScore_Suggest, best first.
+func (vm *VendorMatcher) Suggest(desc string, max int) []*VendorMatch {
+	words := descriptor_words(desc)
+	if len(words) == 0 {
+		return []*VendorMatch{}
+	}
+	joined := strings.Join(words, " ")
+	best := make(map[uuid.UUID]*VendorMatch, 10)
+	for _, vn := range vm.names {
+		score := 100
+		if vn.joined != joined {
+			score = name_score(words, joined, vn)
+		}
+		if score < VendorScore_Suggest {
+			continue
+		}
+		m, ok := best[vn.v.Vid]
+		if !ok || score > m.Score {
+			best[vn.v.Vid] = &VendorMatch{Vid: vn.v.Vid, Vendor: vn.v.FName, Matched: vn.name, Score: score}
+		}
+	}
+	lst := make([]*VendorMatch, 0, len(best))
+	for _, m := range best {
+		lst = append(lst, m)
+	}
+	sort.Slice(lst, func(i, j int) bool {
+		if lst[i].Score != lst[j].Score {
+			return lst[i].Score > lst[j].Score
+		}
+		return lst[i].Vendor < lst[j].Vendor
+	})
+	if max > 0 && len(lst) > max {
+		lst = lst[:max]
+	}
+	return lst
+}
+
+// name_score scores a normalized descriptor against a vendor name that
+// is not the same, from 0 to 95.  Two scores are worked out, and the
+// higher is used.  The first is on words: the share of the name's words
+// found in the descriptor counts most, and the share of the descriptor's
+// words that are in the name counts some.  Words match if they are the
+// same, if one is the start of the other (banks cut words short), or if
+// they are spelled almost the same.  A descriptor that is the start of
+// the name, or the other way, with or without the spaces, counts as 90%
+// alike.  The second score is
+// on spelling alone, for names that are run together ("BLUEBOTTLE").
+func name_score(words []string, joined string, vn *vendor_name) int {
+	if len(vn.words) == 0 {
+		return 0
+	}
+	a := strings.ReplaceAll(joined, " ", "")
+	b := strings.ReplaceAll(vn.joined, " ", "")
+	var sw float64
+	if strings.HasPrefix(vn.joined+" ", joined+" ") || strings.HasPrefix(joined+" ", vn.joined+" ") {
+		sw = 0.9
+	} else if len(a) >= 6 && len(b) >= 6 && (strings.HasPrefix(a, b) || strings.HasPrefix(b, a)) {
+		sw = 0.9
+	} else {
+		used := make([]bool, len(words))
+		n := 0
+		for _, nw := range vn.words {
+			for i, w := range words {
+				if !used[i] && same_word(w, nw) {
+					used[i] = true
+					n++
+					break
+				}
+			}
+		}
+		sw = 0.75*float64(n)/float64(len(vn.words)) + 0.25*float64(n)/float64(len(words))
+	}
+	maxlen := len(a)
+	if len(b) > maxlen {
+		maxlen = len(b)
+	}
+	ss := 1 - float64(util.EditDistance(a, b))/float64(maxlen)
+	if ss > sw {
+		sw = ss
+	}
+	return int(95*sw + 0.5)
+}
+
+// same_word returns true if two words are taken to be the same.
+func same_word(a, b string) bool {
+	if a == b {
+		return true
+	}
+	if len(a) >= 3 && len(b) >= 3 && (strings.HasPrefix(a, b) || strings.HasPrefix(b, a)) {
+		return true
+	}
+	if len(a) >= 5 && len(b) >= 5 {
+		maxd := 1
+		if len(a) >= 9 && len(b) >= 9 {
+			maxd = 2
+		}
+		return util.EditDistance(a, b) <= maxd
+	}
+	return false
+}
+
+// LearnVendorAlias adds the normalized form of a descriptor to the
+// aliases of a vendor, once it is known to be the vendor's.  Because
+// the alias is normalized, it matches the vendor's other stores and
+// transactions too, so one alias does the work of many.  The alias is
+// returned, or blank if the vendor already had it.
+func LearnVendorAlias(vid uuid.UUID, desc string) (string, error) {
+	v := m1.GetVendor(vid)
+	if v == nil {
+		return "", fmt.Errorf("No vendor (%s).", vid)
+	}
+	alias := NormalizeDescriptor(desc)
+	if alias == "" {
+		return "", fmt.Errorf("Nothing left of %q to use as an alias.", desc)
+	}
+	for _, n := range append([]string{v.FName, v.DName}, v.Aliases...) {
+		if NormalizeDescriptor(n) == alias {
+			return "", nil
+		}
+	}
+	vc := *v
+	vc.Aliases = append(util.CloneStringSlice(v.Aliases), alias)
+	if err := m1.AddVendor(&vc); err != nil {
+		return "", fmt.Errorf("Unable to add alias %s to vendor %s. Err=%v", alias, v.FName, err)
+	}
+	return alias, nil
+}
@@ -0,0 +1,202 @@
+// --------------------------------------------------------------------
+// balance.go -- Computes the balances of accounts, from their opening
+// balance and transactions.
+//
+// Created 2020-04-20 DLB
+// --------------------------------------------------------------------
+
+package m1data
+
+import (
+	"dbe/lib/util"
+	"dbe/lib/uuid"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Balances are not stored.  They are computed from the view whenever
+// they are asked for, so they are always right after an import, an
+// edit or a rollback.  An account starts with its opening balance at
+// the start of its opening date, and each transaction dated on or
+// after that day is added in date order.  Transactions dated before
+// it are taken to be in the opening balance already.
+//
+// There are two bases for the date of a transaction.  On the posted
+// basis, Date() is used, so everything the account knows about is
+// counted.  On the settled basis, only transactions that have settled
+// are counted, on their settle date, which is what the bank's own
+// balance shows.  The difference is what is pending.
+const (
+	Basis_Posted  = "posted"
+	Basis_Settled = "settled"
+)
+
+// ParseBasis checks the name of a basis, allowing the first letter.
+// Blank is the posted basis.
+func ParseBasis(s string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "p", Basis_Posted:
+		return Basis_Posted, nil
+	case "s", Basis_Settled:
+		return Basis_Settled, nil
+	}
+	return "", fmt.Errorf("Unknown basis (%q). Use %s or %s.", s, Basis_Posted, Basis_Settled)
+}
+
+// basis_date returns the date that a transaction counts on, or the zero
+// time if it does not count on the basis.
+func basis_date(t *Transaction, basis string) time.Time {
+	if basis == Basis_Settled {
+		return t.DateSettled
+	}
+	return t.Date()
+}
+
+// BalanceLine is one step of an account's running balance.  The first
+// line is always the opening balance, with no transaction.
+type BalanceLine struct {
+	Date    time.Time
+	T       *Transaction // nil for the opening balance
+	Amount  util.Money   // The change to the balance
+	Balance util.Money   // The balance after the change
+}
+
+// RunningBalance returns the running balance of an account, on a
+// basis, from its opening balance to its last transaction.
+// Transactions on the same day are in the order they were posted.
+func (v *View) RunningBalance(aid uuid.UUID, basis string) ([]*BalanceLine, error) {
+	a := v.accounts[aid]
+	if a == nil {
+		return nil, fmt.Errorf("No account (%s).", aid)
+	}
+	basis, err := ParseBasis(basis)
+	if err != nil {
+		return nil, err
+	}
+	tlst := v.byaccount.get(aid.String())
+	lst := make([]*BalanceLine, 0, len(tlst)+1)
+	lst = append(lst, &BalanceLine{Date: a.OpeningDate, Amount: a.OpeningBalance, Balance: a.OpeningBalance})
+	for _, t := range tlst {
+		d := basis_date(t, basis)
+		if d.IsZero() || t.Date().Before(a.OpeningDate) {
+			continue
+		}
+		lst = append(lst, &BalanceLine{Date: d, T: t, Amount: t.Amount})
+	}
+	lines := lst[1:]
+	sort.Slice(lines, func(i, j int) bool {
+		x, y := lines[i], lines[j]
+		if !x.Date.Equal(y.Date) {
+			return x.Date.Before(y.Date)
+		}
+		if !x.T.DatePosted.Equal(y.T.DatePosted) {
+			return x.T.DatePosted.Before(y.T.DatePosted)
+		}
+		return x.T.Tid.String() < y.T.Tid.String()
+	})
+	bal := a.OpeningBalance
+	for _, x := range lines {
+		bal += x.Amount
+		x.Balance = bal
+	}
+	return lst, nil
+}
+
+// Balance returns the balance of an account, on a basis, at the end of
+// a day.  If the day is the zero time, every transaction is counted.
+func (v *View) Balance(aid uuid.UUID, basis string, day time.Time) (util.Money, error) {
+	lst, err := v.RunningBalance(aid, basis)
+	if err != nil {
+		return 0, err
+	}
+	if day.IsZero() {
+		return lst[len(lst)-1].Balance, nil
+	}
+	end := time.Date(day.Year(), day.Month(), day.Day()+1, 0, 0, 0, 0, day.Location())
+	bal := lst[0].Balance
+	for _, x := range lst[1:] {
+		if !x.Date.Before(end) {
+			break
+		}
+		bal = x.Balance
+	}
+	return bal, nil
+}
+
+// MonthlyBalances sums a running balance by month.  There is a line for
+// each month that has transactions, dated the first of the month, with
+// the change for the month and the balance at its end.  The opening
+// line is kept as it is.
+func MonthlyBalances(lst []*BalanceLine) []*BalanceLine {
+	out := make([]*BalanceLine, 0, 24)
+	var cur *BalanceLine
+	for _, x := range lst {
+		if x.T == nil {
+			out = append(out, x)
+			continue
+		}
+		month := time.Date(x.Date.Year(), x.Date.Month(), 1, 0, 0, 0, 0, x.Date.Location())
+		if cur == nil || !cur.Date.Equal(month) {
+			cur = &BalanceLine{Date: month}
+			out = append(out, cur)
+		}
+		cur.Amount += x.Amount
+		cur.Balance = x.Balance
+	}
+	return out
+}
+
+// AccountBalance is the state of an account now.
+type AccountBalance struct {
+	A        *Account
+	Posted   util.Money // Balance on the posted basis
+	Settled  util.Money // Balance on the settled basis
+	NPending int        // Transactions that have not settled
+	Last     time.Time  // Date of the last transaction, or zero if none
+}
+
+// AccountBalances returns the balance of every account, sorted by the
+// accounts' full names.
+func (v *View) AccountBalances() []*AccountBalance {
+	lst := make([]*AccountBalance, 0, len(v.accounts))
+	for _, a := range v.accounts {
+		ab := &AccountBalance{A: a, Posted: a.OpeningBalance, Settled: a.OpeningBalance}
+		for _, t := range v.byaccount.get(a.Aid.String()) {
+			d := t.Date()
+			if d.IsZero() || d.Before(a.OpeningDate) {
+				continue
+			}
+			ab.Posted += t.Amount
+			if d.After(ab.Last) {
+				ab.Last = d
+			}
+			if t.DateSettled.IsZero() {
+				ab.NPending++
+			} else {
+				ab.Settled += t.Amount
+			}
+		}
+		lst = append(lst, ab)
+	}
+	sort.Slice(lst, func(i, j int) bool { return lst[i].A.FName < lst[j].A.FName })
+	return lst
+}
+
+// SetOpeningBalance sets the opening balance and date of an account.  It
+// is refused if the account is reconciled through the old or new date
+// (see check_locks).
+func SetOpeningBalance(aid uuid.UUID, amount util.Money, date time.Time) error {
+	dblock.Lock()
+	defer dblock.Unlock()
+	a := GetView().accounts[aid]
+	if a == nil {
+		return fmt.Errorf("No account (%s).", aid)
+	}
+	ac := *a
+	ac.Aliases = util.CloneStringSlice(a.Aliases)
+	ac.OpeningBalance = amount
+	ac.OpeningDate = date
+	return commit(&Change{Accounts: []*Account{&ac}})
+}
@@ -0,0 +1,290 @@
+// --------------------------------------------------------------------
+// batch.go -- Keeps imports in staged batches until they are committed
+// to the database or discarded.
+//
+// Created 2020-04-19 DLB
+// --------------------------------------------------------------------
+
+package m1data
+
+import (
+	"dbe/lib/util"
+	"dbe/lib/uuid"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Batches returns the import batches in the view, oldest first.
+func (v *View) Batches() []*ImportBatch {
+	lst := make([]*ImportBatch, 0, len(v.batches))
+	for _, b := range v.batches {
+		lst = append(lst, b)
+	}
+	sort.Slice(lst, func(i, j int) bool {
+		if !lst[i].Created.Equal(lst[j].Created) {
+			return lst[i].Created.Before(lst[j].Created)
+		}
+		return lst[i].Source < lst[j].Source
+	})
+	return lst
+}
+
+// Batch returns an import batch given its id, or nil.
+func (v *View) Batch(bid uuid.UUID) *ImportBatch {
+	return v.batches[bid]
+}
+
+// BatchByHash returns the staged or committed batch that was made from
+// a file with the given hash, or nil.  Rolled back batches are not
+// returned, so a file can be imported again after a rollback.
+func (v *View) BatchByHash(hash string) *ImportBatch {
+	if hash == "" {
+		return nil
+	}
+	for _, b := range v.batches {
+		if b.Hash == hash && b.Status != Batch_RolledBack {
+			return b
+		}
+	}
+	return nil
+}
+
+// GetBatches returns the import batches, oldest first.  The batches are
+// shared and must not be changed.
+func GetBatches() []*ImportBatch {
+	return GetView().Batches()
+}
+
+// GetBatch returns an import batch given its id, or nil.  The batch is
+// shared and must not be changed; use CopyBatch to make one that can be.
+func GetBatch(bid uuid.UUID) *ImportBatch {
+	return GetView().Batch(bid)
+}
+
+// CopyBatch returns a copy of a batch that can be changed and given to
+// AddBatch.  The rows and their transactions are copied too.
+func CopyBatch(b *ImportBatch) *ImportBatch {
+	return copy_batch(b)
+}
+
+func copy_batch(b *ImportBatch) *ImportBatch {
+	bc := *b
+	bc.Rows = make([]*ImportRow, len(b.Rows))
+	for i, r := range b.Rows {
+		rc := *r
+		if r.T != nil {
+			tc := *r.T
+			tc.Cats = append([]CatItem{}, r.T.Cats...)
+			rc.T = &tc
+		}
+		rc.Reasons = append([]string{}, r.Reasons...)
+		bc.Rows[i] = &rc
+	}
+	bc.NewAliases = make([]*BatchAlias, len(b.NewAliases))
+	for i, al := range b.NewAliases {
+		alc := *al
+		bc.NewAliases[i] = &alc
+	}
+	return &bc
+}
+
+// AddBatch stages an import batch, or replaces a staged batch with the
+// same id, as when its rows are fixed.  A new batch is given its id.
+// Committed batches cannot be changed.
+func AddBatch(b *ImportBatch) error {
+	dblock.Lock()
+	defer dblock.Unlock()
+	cur := GetView()
+	if b.Bid.IsZero() {
+		b.Bid = uuid.New()
+	}
+	if old := cur.batches[b.Bid]; old != nil && old.Status != Batch_Staged {
+		return fmt.Errorf("Import batch %s is already %s.", b.Bid, old.Status)
+	}
+	if b.Status == "" {
+		b.Status = Batch_Staged
+	}
+	if b.Status != Batch_Staged {
+		return fmt.Errorf("Import batch %s is %s, not %s.", b.Bid, b.Status, Batch_Staged)
+	}
+	if b.Created.IsZero() {
+		b.Created = time.Now()
+	}
+	for _, r := range b.Rows {
+		if r.T == nil {
+			return fmt.Errorf("Row %d has no transaction.", r.Row)
+		}
+		if !r.T.Aid.IsZero() && cur.accounts[r.T.Aid] == nil {
+			return fmt.Errorf("Row %d: No Account (%s).", r.Row, r.T.Aid)
+		}
+		if !r.T.Vid.IsZero() && cur.vendors[r.T.Vid] == nil {
+			return fmt.Errorf("Row %d: No Vendor (%s).", r.Row, r.T.Vid)
+		}
+	}
+	return commit(&Change{Batches: []*ImportBatch{copy_batch(b)}})
+}
+
+// CommitBatch adds the rows of a staged batch to the database, as a
+// single change.  Rows that are skipped are dropped, and rows that
+// might be duplicates go to the review queue.  Every other row is
+// added, with the batch's id in ImportId.  The account aliases that
+// the import found are added too.  If a row that is not skipped has no
+// account or a problem, nothing is done.  The batch is kept, without
+// its rows but with their counts and the aliases that were added, as a
+// record of the import.
+func CommitBatch(bid uuid.UUID) error {
+	dblock.Lock()
+	defer dblock.Unlock()
+	cur := GetView()
+	b := cur.batches[bid]
+	if b == nil {
+		return fmt.Errorf("No import batch (%s).", bid)
+	}
+	if b.Status != Batch_Staged {
+		return fmt.Errorf("Import batch %s is already %s.", bid, b.Status)
+	}
+	now := time.Now()
+	nskip := 0
+	c := &Change{Transactions: make([]*Transaction, 0, len(b.Rows))}
+	for _, r := range b.Rows {
+		if r.Skip {
+			nskip++
+			continue
+		}
+		if r.Status == Row_NoAccount || r.Status == Row_Problem {
+			return fmt.Errorf("Row %d has %s: %s Fix it or skip it.", r.Row,
+				strings.Replace(r.Status, "-", " ", -1), strings.Join(r.Reasons, " "))
+		}
+		tc, err := prepare_transaction(cur, r.T)
+		if err != nil {
+			return fmt.Errorf("Row %d: %v", r.Row, err)
+		}
+		tc.ImportId = bid
+		if r.Status == Row_Review {
+			c.Reviews = append(c.Reviews, &DupReview{Rid: uuid.New(), T: tc, DupOf: r.DupOf, Score: r.Score,
+				Reasons: append([]string{}, r.Reasons...), Source: b.Source, Created: now})
+			continue
+		}
+		c.Transactions = append(c.Transactions, tc)
+	}
+	added := make([]*BatchAlias, 0, len(b.NewAliases))
+	accounts := make(map[uuid.UUID]*Account, len(b.NewAliases))
+	for _, al := range b.NewAliases {
+		if a := cur.accounts[al.Aid]; a == nil || account_has_alias(cur, al.Alias) {
+			continue
+		}
+		ac := accounts[al.Aid]
+		if ac == nil {
+			acc := *cur.accounts[al.Aid]
+			acc.Aliases = util.CloneStringSlice(acc.Aliases)
+			ac = &acc
+			accounts[al.Aid] = ac
+			c.Accounts = append(c.Accounts, ac)
+		}
+		ac.Aliases = append(ac.Aliases, al.Alias)
+		alc := *al
+		added = append(added, &alc)
+	}
+	bc := *b
+	bc.Status = Batch_Committed
+	bc.Closed = now
+	bc.NRows = len(b.Rows)
+	bc.NAdded = len(c.Transactions)
+	bc.NReview = len(c.Reviews)
+	bc.NSkipped = nskip
+	bc.Rows = nil
+	bc.NewAliases = added
+	c.Batches = []*ImportBatch{&bc}
+	return commit(c)
+}
+
+// DiscardBatch drops a staged batch, and everything in it.
+func DiscardBatch(bid uuid.UUID) error {
+	dblock.Lock()
+	defer dblock.Unlock()
+	b := GetView().batches[bid]
+	if b == nil {
+		return fmt.Errorf("No import batch (%s).", bid)
+	}
+	if b.Status != Batch_Staged {
+		return fmt.Errorf("Import batch %s is already %s.", bid, b.Status)
+	}
+	return commit(&Change{DelBatches: []uuid.UUID{bid}})
+}
+
+// RollbackBatch undoes a committed import.  Every transaction that the
+// batch added is removed, even if it was changed since, along with any
+// of its rows still waiting in the review queue and the account aliases
+// it added, as a single change.
+// The batch is kept, marked as rolled back, and the number of
+// transactions removed is returned.
+func RollbackBatch(bid uuid.UUID) (int, error) {
+	dblock.Lock()
+	defer dblock.Unlock()
+	cur := GetView()
+	b := cur.batches[bid]
+	if b == nil {
+		return 0, fmt.Errorf("No import batch (%s).", bid)
+	}
+	if b.Status != Batch_Committed {
+		return 0, fmt.Errorf("Import batch %s is %s, not %s.", bid, b.Status, Batch_Committed)
+	}
+	c := &Change{DelTransactions: make([]uuid.UUID, 0, b.NAdded)}
+	cur.EachTransaction(func(t *Transaction) {
+		if t.ImportId == bid {
+			c.DelTransactions = append(c.DelTransactions, t.Tid)
+		}
+	})
+	for _, r := range cur.Reviews() {
+		if r.T.ImportId == bid {
+			c.DelReviews = append(c.DelReviews, r.Rid)
+		}
+	}
+	accounts := make(map[uuid.UUID]*Account, len(b.NewAliases))
+	for _, al := range b.NewAliases {
+		if cur.accounts[al.Aid] == nil {
+			continue
+		}
+		ac := accounts[al.Aid]
+		if ac == nil {
+			acc := *cur.accounts[al.Aid]
+			ac = &acc
+			accounts[al.Aid] = ac
+			c.Accounts = append(c.Accounts, ac)
+		}
+		aliases := make([]string, 0, len(ac.Aliases))
+		for _, x := range ac.Aliases {
+			if !strings.EqualFold(x, al.Alias) {
+				aliases = append(aliases, x)
+			}
+		}
+		ac.Aliases = aliases
+	}
+	bc := *b
+	bc.Status = Batch_RolledBack
+	bc.RolledBack = time.Now()
+	bc.NRemoved = len(c.DelTransactions)
+	c.Batches = []*ImportBatch{&bc}
+	if err := commit(c); err != nil {
+		return 0, err
+	}
+	return bc.NRemoved, nil
+}
+
+// account_has_alias returns true if any account already has a name or
+// alias, ignoring case.
+func account_has_alias(v *View, alias string) bool {
+	for _, a := range v.accounts {
+		if strings.EqualFold(a.FName, alias) {
+			return true
+		}
+		for _, x := range a.Aliases {
+			if strings.EqualFold(x, alias) {
+				return true
+			}
+		}
+	}
+	return false
+}
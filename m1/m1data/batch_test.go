@@ -0,0 +1,103 @@
+// --------------------------------------------------------------------
+// batch_test.go -- Test committing and rolling back import batches
+//
+// Created 2020-04-20 DLB
+// --------------------------------------------------------------------
+
+package m1data
+
+import (
+	"dbe/lib/util"
+	"testing"
+)
+
+// Test_RollbackBatchAliases commits a batch that adds two aliases to
+// the same account, and checks that the rollback takes both away.
+func Test_RollbackBatchAliases(t *testing.T) {
+	test_open(t)
+	aid := test_account(t, "Checking")
+	a := *GetView().Account(aid)
+	a.Aliases = []string{"BofA Check"}
+	if err := AddAccount(&a); err != nil {
+		t.Fatalf("AddAccount fails with Err=%v", err)
+	}
+	bid := test_batch(t, aid, "BOFA 1234", "BOFA 5678")
+	aliases := GetView().Account(aid).Aliases
+	if len(aliases) != 3 || !util.InStringSlice(aliases, "BOFA 1234") || !util.InStringSlice(aliases, "BOFA 5678") {
+		t.Fatalf("Aliases after commit = %v, Expected both new ones added", aliases)
+	}
+	if n := len(GetView().Batch(bid).NewAliases); n != 2 {
+		t.Fatalf("Committed batch keeps %d aliases, Expected 2", n)
+	}
+	if _, err := RollbackBatch(bid); err != nil {
+		t.Fatalf("RollbackBatch fails with Err=%v", err)
+	}
+	aliases = GetView().Account(aid).Aliases
+	if len(aliases) != 1 || aliases[0] != "BofA Check" {
+		t.Fatalf("Aliases after rollback = %v, Expected only the one from before the import", aliases)
+	}
+}
+
+// Test_CommitRollbackBatch takes a batch through commit and rollback,
+// and checks that the database ends up as it was before the import.
+func Test_CommitRollbackBatch(t *testing.T) {
+	test_open(t)
+	aid := test_account(t, "Checking")
+	keep := test_trans(t, aid, "2020-03-01", -1250)
+	b := &ImportBatch{Source: "march.csv", Kind: "csv", Rows: []*ImportRow{
+		{Row: 1, Status: Row_New, T: &Transaction{Aid: aid, Amount: -500, DatePosted: test_date("2020-03-05")}},
+		{Row: 2, Status: Row_NoVendor, T: &Transaction{Aid: aid, Amount: -600, DatePosted: test_date("2020-03-06")}},
+		{Row: 3, Status: Row_Review, DupOf: keep, Score: 80,
+			T: &Transaction{Aid: aid, Amount: -1250, DatePosted: test_date("2020-03-01")}},
+		{Row: 4, Status: Row_Duplicate, Skip: true, DupOf: keep,
+			T: &Transaction{Aid: aid, Amount: -1250, DatePosted: test_date("2020-03-01")}},
+	}}
+	if err := AddBatch(b); err != nil {
+		t.Fatalf("AddBatch fails with Err=%v", err)
+	}
+	if GetView().TransactionCount() != 1 {
+		t.Fatalf("Staging a batch changed the transactions")
+	}
+	if err := CommitBatch(b.Bid); err != nil {
+		t.Fatalf("CommitBatch fails with Err=%v", err)
+	}
+	v := GetView()
+	cb := v.Batch(b.Bid)
+	if cb.Status != Batch_Committed || cb.NAdded != 2 || cb.NReview != 1 || cb.NSkipped != 1 || cb.Rows != nil {
+		t.Fatalf("Committed batch = %+v, Expected 2 added, 1 to review and 1 skipped", cb)
+	}
+	if v.TransactionCount() != 3 || len(v.Reviews()) != 1 {
+		t.Fatalf("After commit %d transactions and %d reviews, Expected 3 and 1", v.TransactionCount(),
+			len(v.Reviews()))
+	}
+	n := 0
+	v.EachTransaction(func(tr *Transaction) {
+		if tr.ImportId == b.Bid {
+			n++
+		}
+	})
+	if n != 2 {
+		t.Fatalf("%d transactions have the batch's ImportId, Expected 2", n)
+	}
+	if err := CommitBatch(b.Bid); err == nil {
+		t.Fatalf("CommitBatch twice Expected an error")
+	}
+
+	// The rollback survives a restart, since it is journaled.
+	nremoved, err := RollbackBatch(b.Bid)
+	if err != nil || nremoved != 2 {
+		t.Fatalf("RollbackBatch = %d, Err=%v, Expected 2 removed", nremoved, err)
+	}
+	test_restart(t)
+	v = GetView()
+	if v.TransactionCount() != 1 || v.Transaction(keep) == nil || len(v.Reviews()) != 0 {
+		t.Fatalf("After rollback %d transactions and %d reviews, Expected only the one from before",
+			v.TransactionCount(), len(v.Reviews()))
+	}
+	if rb := v.Batch(b.Bid); rb.Status != Batch_RolledBack || rb.NRemoved != 2 {
+		t.Fatalf("Rolled back batch = %+v, Expected %s with 2 removed", rb, Batch_RolledBack)
+	}
+	if _, err := RollbackBatch(b.Bid); err == nil {
+		t.Fatalf("RollbackBatch twice Expected an error")
+	}
+}
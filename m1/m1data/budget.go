@@ -0,0 +1,382 @@
+// --------------------------------------------------------------------
+// budget.go -- Keeps the budgets of the categories, and compares them
+// with what was spent.
+//
+// Created 2020-04-20 DLB
+// --------------------------------------------------------------------
+
+package m1data
+
+import (
+	"dbe/lib/util"
+	"dbe/lib/uuid"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// What was spent in a category is taken from the splits (CatItems) of
+// the transactions, the same way as the category report: money going
+// out is spending, a refund takes it back, and transfers between our
+// own accounts are left out.
+//
+// To see overspending before the month is over, each budget line has a
+// pace: the part of the period's allowance that would be spent by now,
+// if it were spent evenly.  A category that has spent more than its
+// pace is ahead of its budget, and one that has spent more than the
+// whole allowance is over it.
+//
+// With a rollover, the allowance of a period is the budget's amount
+// plus what was carried from the period before, worked out from the
+// start of the budget.  A new budget for the category starts fresh.
+//
+// A budget covers the spending in the categories under its category
+// too.  A budget on one of those is then a limit inside the bigger one,
+// so it is not added to the totals again.
+
+// Status of a BudgetLine.
+const (
+	BudgetStatus_OK    = "ok"
+	BudgetStatus_Ahead = "ahead" // Spending faster than the pace
+	BudgetStatus_Over  = "over"  // Spent more than the allowance
+)
+
+// ParsePeriod checks the name of a budget period, allowing the first
+// letter, and "year" or "month".  Blank is monthly.
+func ParsePeriod(s string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "m", "month", Budget_Monthly:
+		return Budget_Monthly, nil
+	case "a", "y", "year", "yearly", Budget_Annual:
+		return Budget_Annual, nil
+	}
+	return "", fmt.Errorf("Unknown budget period (%q). Use %s or %s.", s, Budget_Monthly, Budget_Annual)
+}
+
+// ParseRollover checks a rollover option.  Blank, "none" and "no" are
+// no rollover.
+func ParseRollover(s string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "none", "no", "false":
+		return Rollover_None, nil
+	case "u", Rollover_Unspent:
+		return Rollover_Unspent, nil
+	case "a", "yes", "true", Rollover_All:
+		return Rollover_All, nil
+	}
+	return "", fmt.Errorf("Unknown rollover (%q). Use none, %s or %s.", s, Rollover_Unspent, Rollover_All)
+}
+
+// RolloverName returns a rollover option for display.
+func RolloverName(r string) string {
+	if r == Rollover_None {
+		return "none"
+	}
+	return r
+}
+
+// first_of_month returns the start of the month of a day.
+func first_of_month(d time.Time) time.Time {
+	return time.Date(d.Year(), d.Month(), 1, 0, 0, 0, 0, d.Location())
+}
+
+// Budgets returns every budget, by category name and then start.
+func (v *View) Budgets() []*Budget {
+	lst := make([]*Budget, 0, len(v.budgets))
+	for _, b := range v.budgets {
+		lst = append(lst, b)
+	}
+	v.sort_budgets(lst)
+	return lst
+}
+
+func (v *View) sort_budgets(lst []*Budget) {
+	sort.Slice(lst, func(i, j int) bool {
+		x, y := lst[i], lst[j]
+		if x.Cid != y.Cid {
+			return strings.ToLower(category_name(v, x.Cid)) < strings.ToLower(category_name(v, y.Cid))
+		}
+		return x.Start.Before(y.Start)
+	})
+}
+
+// Budget returns a budget given its id, or nil.
+func (v *View) Budget(id uuid.UUID) *Budget {
+	return v.budgets[id]
+}
+
+// CategoryBudgets returns the budgets of a category, oldest first.
+func (v *View) CategoryBudgets(cid uuid.UUID) []*Budget {
+	lst := make([]*Budget, 0, 2)
+	for _, b := range v.budgets {
+		if b.Cid == cid {
+			lst = append(lst, b)
+		}
+	}
+	sort.Slice(lst, func(i, j int) bool { return lst[i].Start.Before(lst[j].Start) })
+	return lst
+}
+
+// CategoryBudgetAt returns the budget of a category that starts in the
+// month of a day, or nil.
+func (v *View) CategoryBudgetAt(cid uuid.UUID, start time.Time) *Budget {
+	start = first_of_month(start)
+	for _, b := range v.budgets {
+		if b.Cid == cid && b.Start.Equal(start) {
+			return b
+		}
+	}
+	return nil
+}
+
+// BudgetFor returns the budget of a category in effect on a day, which
+// is the one that started last on or before it, or nil.
+func (v *View) BudgetFor(cid uuid.UUID, day time.Time) *Budget {
+	var found *Budget
+	for _, b := range v.budgets {
+		if b.Cid != cid || b.Start.After(day) {
+			continue
+		}
+		if found == nil || b.Start.After(found.Start) {
+			found = b
+		}
+	}
+	return found
+}
+
+// SetBudget adds a budget, or changes the one with the same id.  A new
+// budget is given its id.  The start is moved to the first of its
+// month, and a category can only have one budget starting in a month.
+func SetBudget(b *Budget) error {
+	dblock.Lock()
+	defer dblock.Unlock()
+	cur := GetView()
+	if cur.categories[b.Cid] == nil {
+		return fmt.Errorf("No category (%s) for the budget.", b.Cid)
+	}
+	period, err := ParsePeriod(b.Period)
+	if err != nil {
+		return err
+	}
+	rollover, err := ParseRollover(b.Rollover)
+	if err != nil {
+		return err
+	}
+	if b.Amount < 0 {
+		return fmt.Errorf("The amount of a budget cannot be negative (%s).", b.Amount)
+	}
+	if b.Start.IsZero() {
+		return fmt.Errorf("No start given for the budget.")
+	}
+	if b.BudId.IsZero() {
+		b.BudId = uuid.New()
+	}
+	b.Period, b.Rollover, b.Start = period, rollover, first_of_month(b.Start)
+	if o := cur.CategoryBudgetAt(b.Cid, b.Start); o != nil && o.BudId != b.BudId {
+		return fmt.Errorf("Category %s already has a budget starting %s.", category_name(cur, b.Cid),
+			b.Start.Format("2006-01"))
+	}
+	bc := *b
+	return commit(&Change{Budgets: []*Budget{&bc}})
+}
+
+// DeleteBudget drops a budget.  The budget before it, if any, is then
+// in effect until the next one starts.
+func DeleteBudget(id uuid.UUID) error {
+	dblock.Lock()
+	defer dblock.Unlock()
+	if GetView().budgets[id] == nil {
+		return fmt.Errorf("No budget (%s).", id)
+	}
+	return commit(&Change{DelBudgets: []uuid.UUID{id}})
+}
+
+// BudgetLine is one category of a budget report.
+type BudgetLine struct {
+	B         *Budget
+	Name      string     // Of the category
+	Depth     int        // Of the category in the tree, 1 for a top one
+	Inner     bool       // True if a category above this one has a budget too
+	From      time.Time  // Start of the period the report falls in
+	To        time.Time  // End of the period (exclusive)
+	Carry     util.Money // Carried from the periods before
+	Allowance util.Money // Amount plus Carry
+	Spent     util.Money // Spent in the period, through the report's day
+	Pace      util.Money // Part of the allowance that would be spent by now
+	Left      util.Money // Allowance less Spent
+	Status    string     // One of the BudgetStatus_ values
+}
+
+// BudgetReport is budget against actual spending for a month.
+type BudgetReport struct {
+	Month      time.Time // First of the month
+	AsOf       time.Time // Spending is counted through this day
+	Lines      []*BudgetLine
+	Budgeted   util.Money       // Total allowance of the monthly budgets
+	Spent      util.Money       // Total spent against them
+	Unbudgeted []*CategoryTotal // Categories with spending this month but no budget
+	Unspent    util.Money       // Total spent in them (the uncategorized too)
+}
+
+// BudgetReport compares the budgets in effect in a month with what was
+// spent through a day in that month.  If the day is zero or after the
+// month, the whole month is counted; if it is before the month, it is
+// taken to be the first.  Annual budgets are compared over the twelve
+// months that the month falls in.  The lines are in tree order (see
+// CategoryTree).
+func (v *View) BudgetReport(month, asof time.Time) (*BudgetReport, error) {
+	if month.IsZero() {
+		return nil, fmt.Errorf("No month given for the budget report.")
+	}
+	month = first_of_month(month)
+	next := month.AddDate(0, 1, 0)
+	if asof.IsZero() || !asof.Before(next) {
+		asof = next.AddDate(0, 0, -1)
+	}
+	if asof.Before(month) {
+		asof = month
+	}
+	asof = time.Date(asof.Year(), asof.Month(), asof.Day(), 0, 0, 0, 0, asof.Location())
+	r := &BudgetReport{Month: month, AsOf: asof}
+	spent := &spending{v: v, end: asof.AddDate(0, 0, 1), months: make(map[string]map[uuid.UUID]util.Money, 12)}
+	budgeted := make(map[uuid.UUID]bool, len(v.budgets))
+	for _, c := range v.CategoryTree() {
+		b := v.BudgetFor(c.Cid, month)
+		if b == nil {
+			continue
+		}
+		budgeted[c.Cid] = true
+		ln := budget_line(b, month, spent)
+		ln.Name, ln.Depth = c.Name, v.CategoryDepth(c.Cid)
+		ln.Inner = v.under_budget(c.Cid, budgeted, false)
+		r.Lines = append(r.Lines, ln)
+		if b.Period == Budget_Monthly && !ln.Inner {
+			r.Budgeted += ln.Allowance
+			r.Spent += ln.Spent
+		}
+	}
+	cr, err := v.CategoryReport(month, spent.end, uuid.Zero(), false)
+	if err != nil {
+		return nil, err
+	}
+	for _, ct := range cr.Lines {
+		if ct.Net() >= 0 || v.under_budget(ct.Cid, budgeted, true) {
+			continue
+		}
+		r.Unbudgeted = append(r.Unbudgeted, ct)
+		r.Unspent -= ct.Net()
+	}
+	return r, nil
+}
+
+// under_budget returns true if a category above another has a budget,
+// or the category itself does if self is true.
+func (v *View) under_budget(cid uuid.UUID, budgeted map[uuid.UUID]bool, self bool) bool {
+	if self && budgeted[cid] {
+		return true
+	}
+	for _, a := range v.CategoryAncestors(cid) {
+		if budgeted[a.Cid] {
+			return true
+		}
+	}
+	return false
+}
+
+// budget_line works out where a budget stands in the period that a
+// month falls in.
+func budget_line(b *Budget, month time.Time, spent *spending) *BudgetLine {
+	nmonths := 1
+	if b.Period == Budget_Annual {
+		nmonths = 12
+	}
+	ln := &BudgetLine{B: b}
+	from := b.Start
+	for {
+		to := from.AddDate(0, nmonths, 0)
+		ln.Allowance = b.Amount + ln.Carry
+		ln.Spent = spent.between(b.Cid, from, to)
+		ln.Left = ln.Allowance - ln.Spent
+		if month.Before(to) {
+			ln.From, ln.To = from, to
+			break
+		}
+		switch b.Rollover {
+		case Rollover_All:
+			ln.Carry = ln.Left
+		case Rollover_Unspent:
+			if ln.Left > 0 {
+				ln.Carry = ln.Left
+			} else {
+				ln.Carry = 0
+			}
+		}
+		from = to
+	}
+	days := int(ln.To.Sub(ln.From).Hours()/24 + 0.5)
+	gone := int(spent.end.Sub(ln.From).Hours()/24 + 0.5)
+	if gone > days {
+		gone = days
+	}
+	if ln.Allowance > 0 {
+		ln.Pace = ln.Allowance.MulFrac(gone, days)
+	}
+	switch {
+	case ln.Spent > ln.Allowance:
+		ln.Status = BudgetStatus_Over
+	case ln.Spent > ln.Pace:
+		ln.Status = BudgetStatus_Ahead
+	default:
+		ln.Status = BudgetStatus_OK
+	}
+	return ln
+}
+
+// spending totals what was spent in each category, a month at a time,
+// using the bymonth index.  Nothing on or after end is counted.
+type spending struct {
+	v      *View
+	end    time.Time
+	months map[string]map[uuid.UUID]util.Money
+}
+
+// between returns what was spent in a category, and the categories
+// under it, from the first of one month up to the first of another.
+func (s *spending) between(cid uuid.UUID, from, to time.Time) util.Money {
+	under := s.v.CategorySubtree(cid)
+	var total util.Money
+	for m := from; m.Before(to) && m.Before(s.end); m = m.AddDate(0, 1, 0) {
+		bycat := s.month(m)
+		for _, x := range under {
+			total += bycat[x]
+		}
+	}
+	return total
+}
+
+func (s *spending) month(m time.Time) map[uuid.UUID]util.Money {
+	key := m.Format("2006-01")
+	if bycat, ok := s.months[key]; ok {
+		return bycat
+	}
+	bycat := make(map[uuid.UUID]util.Money, 20)
+	for _, t := range s.v.bymonth.get(key) {
+		if !t.Date().Before(s.end) || s.v.IsTransfer(t) {
+			continue
+		}
+		for _, ci := range t.Cats {
+			bycat[ci.Cid] -= ci.Amount
+		}
+	}
+	s.months[key] = bycat
+	return bycat
+}
+
+// category_name returns the name of a category in a view, for messages.
+func category_name(v *View, cid uuid.UUID) string {
+	if cat := v.categories[cid]; cat != nil {
+		return cat.Name
+	}
+	return cid.String()
+}
@@ -0,0 +1,320 @@
+// --------------------------------------------------------------------
+// cattree.go -- Keeps the categories in a tree, and rolls totals up
+// it.
+//
+// Created 2020-04-20 DLB
+// --------------------------------------------------------------------
+
+package m1data
+
+import (
+	"dbe/lib/util"
+	"dbe/lib/uuid"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Each category can be under another, its parent, so that "Auto:Fuel"
+// and "Auto:Insurance" can roll up to "Auto".  Only the Parent field
+// makes the tree; the names are left alone, so the old colon-separated
+// names still work for lookups and imports.  BuildCategoryTree uses
+// those names to link up the categories the first time.
+//
+// Reports are made for the categories that the money is in, and then
+// rolled up with RollUp, which gives each category the subtotal of
+// itself and everything under it, down to any depth.
+
+// CategorySep separates the levels in the old category names.
+const CategorySep = ":"
+
+// CategoryChildren returns the categories directly under a category,
+// by name.  For the zero id, the top categories are returned.
+func (v *View) CategoryChildren(cid uuid.UUID) []*Category {
+	lst := make([]*Category, 0, 4)
+	for _, c := range v.categories {
+		if v.parent_of(c) == cid {
+			lst = append(lst, c)
+		}
+	}
+	sort.Slice(lst, func(i, j int) bool { return strings.ToLower(lst[i].Name) < strings.ToLower(lst[j].Name) })
+	return lst
+}
+
+// parent_of returns the parent of a category, or zero if it has none
+// or its parent is gone.
+func (v *View) parent_of(c *Category) uuid.UUID {
+	if c.Parent.IsZero() || v.categories[c.Parent] == nil {
+		return uuid.Zero()
+	}
+	return c.Parent
+}
+
+// CategoryAncestors returns the categories above a category, nearest
+// first.
+func (v *View) CategoryAncestors(cid uuid.UUID) []*Category {
+	lst := make([]*Category, 0, 4)
+	c := v.categories[cid]
+	for c != nil && len(lst) <= len(v.categories) {
+		p := v.parent_of(c)
+		if p.IsZero() {
+			break
+		}
+		c = v.categories[p]
+		lst = append(lst, c)
+	}
+	return lst
+}
+
+// CategoryDepth returns how far down the tree a category is, where a
+// top category is at depth 1.  Zero is returned for an unknown one.
+func (v *View) CategoryDepth(cid uuid.UUID) int {
+	if v.categories[cid] == nil {
+		return 0
+	}
+	return len(v.CategoryAncestors(cid)) + 1
+}
+
+// IsCategoryUnder returns true if a category is the other category, or
+// is anywhere under it.
+func (v *View) IsCategoryUnder(cid, top uuid.UUID) bool {
+	if cid == top {
+		return true
+	}
+	for _, a := range v.CategoryAncestors(cid) {
+		if a.Cid == top {
+			return true
+		}
+	}
+	return false
+}
+
+// CategorySubtree returns the id of a category and of every category
+// under it.
+func (v *View) CategorySubtree(cid uuid.UUID) []uuid.UUID {
+	lst := []uuid.UUID{cid}
+	for i := 0; i < len(lst) && len(lst) <= len(v.categories); i++ {
+		for _, c := range v.CategoryChildren(lst[i]) {
+			lst = append(lst, c.Cid)
+		}
+	}
+	return lst
+}
+
+// CategoryTree returns every category in tree order: each category is
+// followed by the ones under it, and categories at the same level are
+// by name.
+func (v *View) CategoryTree() []*Category {
+	lst := make([]*Category, 0, len(v.categories))
+	var walk func(cid uuid.UUID)
+	walk = func(cid uuid.UUID) {
+		for _, c := range v.CategoryChildren(cid) {
+			lst = append(lst, c)
+			walk(c.Cid)
+		}
+	}
+	walk(uuid.Zero())
+	return lst
+}
+
+// RollUp rolls the lines of a category report up the tree.  Each
+// category in the result has the totals of itself and every category
+// under it, and is followed by the categories under it, by name.
+// Categories with nothing in them are left out.  If depth is more than
+// zero, the categories deeper than that are folded into their ancestor
+// at that depth, so a depth of 1 gives only the top categories.  The
+// uncategorized line stays last.
+func (v *View) RollUp(lines []*CategoryTotal, depth int) []*CategoryTotal {
+	sums := make(map[uuid.UUID]*CategoryTotal, len(lines))
+	var nocat *CategoryTotal
+	for _, ln := range lines {
+		if ln.Cid.IsZero() || v.categories[ln.Cid] == nil {
+			if nocat == nil {
+				nocat = &CategoryTotal{}
+			}
+			add_totals(nocat, ln)
+			continue
+		}
+		path := append([]*Category{v.categories[ln.Cid]}, v.CategoryAncestors(ln.Cid)...)
+		for i, c := range path {
+			if depth > 0 && len(path)-i > depth {
+				continue
+			}
+			ct := sums[c.Cid]
+			if ct == nil {
+				ct = &CategoryTotal{Cid: c.Cid, Name: c.Name, Depth: len(path) - i}
+				sums[c.Cid] = ct
+			}
+			add_totals(ct, ln)
+			if i > 0 {
+				ct.Sub = true
+			}
+		}
+	}
+	out := make([]*CategoryTotal, 0, len(sums)+1)
+	for _, c := range v.CategoryTree() {
+		if ct := sums[c.Cid]; ct != nil {
+			out = append(out, ct)
+		}
+	}
+	if nocat != nil {
+		out = append(out, nocat)
+	}
+	return out
+}
+
+func add_totals(ct, ln *CategoryTotal) {
+	ct.Income += ln.Income
+	ct.Expense += ln.Expense
+	ct.N += ln.N
+}
+
+// check_category_parent returns an error if the parent of a category,
+// about to be added or changed, is unknown, or would put the category
+// under itself.
+func check_category_parent(v *View, c *Category) error {
+	if c.Parent.IsZero() {
+		return nil
+	}
+	if c.Parent == c.Cid {
+		return fmt.Errorf("Category %s cannot be its own parent.", c.Name)
+	}
+	p := v.categories[c.Parent]
+	if p == nil {
+		return fmt.Errorf("No category (%s) for the parent of %s.", c.Parent, c.Name)
+	}
+	if !c.Cid.IsZero() && v.IsCategoryUnder(p.Cid, c.Cid) {
+		return fmt.Errorf("Category %s is under %s, so it cannot be its parent.", p.Name, c.Name)
+	}
+	return nil
+}
+
+// SetCategoryParent puts a category under another, given their names.
+// A blank parent makes it a top category.
+func SetCategoryParent(name, parent string) error {
+	v := GetView()
+	c := v.CategoryByName(name)
+	if c == nil {
+		return fmt.Errorf("No category named %q.", name)
+	}
+	cc := *c
+	cc.Parent = uuid.Zero()
+	if !util.Blank(parent) {
+		p := v.CategoryByName(parent)
+		if p == nil {
+			return fmt.Errorf("No category named %q for the parent.", parent)
+		}
+		cc.Parent = p.Cid
+	}
+	return AddCategory(&cc)
+}
+
+// BuildCategoryTree links up the categories that have colon-separated
+// names, such as the ones from the old spreadsheet: each one is put
+// under the category named by the part before its last colon, which is
+// made if it does not exist.  A category whose parent is already set
+// is left alone.  It is done as a single change, and the number of
+// categories made and linked are returned.
+func BuildCategoryTree() (int, int, error) {
+	dblock.Lock()
+	defer dblock.Unlock()
+	cur := GetView()
+	byname := make(map[string]*Category, len(cur.categories))
+	for _, c := range cur.categories {
+		byname[c.Name] = c
+	}
+	byalias := make(map[string]*Category, len(cur.categories))
+	for _, c := range cur.categories {
+		for _, a := range c.Aliases {
+			byalias[a] = c
+		}
+	}
+	// Shallow names first, so that parents are made before the
+	// categories under them.
+	names := make([]string, 0, len(byname))
+	for n := range byname {
+		names = append(names, n)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		di, dj := strings.Count(names[i], CategorySep), strings.Count(names[j], CategorySep)
+		if di != dj {
+			return di < dj
+		}
+		return names[i] < names[j]
+	})
+	changed := make(map[uuid.UUID]*Category, len(names))
+	nmade, nlinked := 0, 0
+	var find func(name string) *Category
+	find = func(name string) *Category {
+		if c := byname[name]; c != nil {
+			return c
+		}
+		if c := byalias[name]; c != nil {
+			return c
+		}
+		c := &Category{Cid: uuid.New(), Name: name, Aliases: []string{name}}
+		if i := strings.LastIndex(name, CategorySep); i > 0 {
+			c.Parent = find(strings.TrimSpace(name[:i])).Cid
+		}
+		byname[name] = c
+		changed[c.Cid] = c
+		nmade++
+		return c
+	}
+	for _, n := range names {
+		c := byname[n]
+		i := strings.LastIndex(n, CategorySep)
+		if i <= 0 || !c.Parent.IsZero() {
+			continue
+		}
+		p := find(strings.TrimSpace(n[:i]))
+		if p.Cid == c.Cid {
+			continue
+		}
+		cc := *c
+		if x := changed[c.Cid]; x != nil {
+			cc = *x
+		}
+		cc.Parent = p.Cid
+		changed[cc.Cid] = &cc
+		nlinked++
+	}
+	if len(changed) == 0 {
+		return 0, 0, nil
+	}
+	c := &Change{Categories: make([]*Category, 0, len(changed))}
+	for _, cat := range changed {
+		c.Categories = append(c.Categories, cat)
+	}
+	if err := check_category_tree(cur, c.Categories); err != nil {
+		return 0, 0, err
+	}
+	if err := commit(c); err != nil {
+		return 0, 0, err
+	}
+	return nmade, nlinked, nil
+}
+
+// check_category_tree returns an error if changing some categories
+// would leave a cycle in the tree.
+func check_category_tree(v *View, lst []*Category) error {
+	parents := make(map[uuid.UUID]uuid.UUID, len(v.categories)+len(lst))
+	names := make(map[uuid.UUID]string, len(lst))
+	for _, c := range v.categories {
+		parents[c.Cid] = c.Parent
+	}
+	for _, c := range lst {
+		parents[c.Cid] = c.Parent
+		names[c.Cid] = c.Name
+	}
+	for _, c := range lst {
+		p := parents[c.Cid]
+		for n := 0; !p.IsZero(); n++ {
+			if p == c.Cid || n > len(parents) {
+				return fmt.Errorf("Category %s would be under itself.", names[c.Cid])
+			}
+			p = parents[p]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+// --------------------------------------------------------------------
+// cattree_test.go -- Test the tree of categories
+//
+// Created 2020-04-20 DLB
+// --------------------------------------------------------------------
+
+package m1data
+
+import (
+	"dbe/lib/uuid"
+	"strings"
+	"testing"
+)
+
+// test_category adds a category with the given name, and returns its id.
+func test_category(t *testing.T, name string) uuid.UUID {
+	t.Helper()
+	c := &Category{Name: name, Aliases: []string{name}}
+	if err := AddCategory(c); err != nil {
+		t.Fatalf("AddCategory fails with Err=%v", err)
+	}
+	return c.Cid
+}
+
+// Test_CategoryCycles checks that no change can put a category under
+// itself, directly or through others.
+func Test_CategoryCycles(t *testing.T) {
+	test_open(t)
+	a := test_category(t, "A")
+	b := test_category(t, "B")
+	test_category(t, "C")
+	if err := SetCategoryParent("A", "A"); err == nil || !strings.Contains(err.Error(), "own parent") {
+		t.Fatalf("SetCategoryParent of A under A gives Err=%v, Expected a refusal", err)
+	}
+	if err := SetCategoryParent("A", "Nothing"); err == nil || !strings.Contains(err.Error(), "No category") {
+		t.Fatalf("SetCategoryParent under an unknown category gives Err=%v, Expected a refusal", err)
+	}
+	cc := *GetView().Category(a)
+	cc.Parent = uuid.New()
+	if err := AddCategory(&cc); err == nil || !strings.Contains(err.Error(), "for the parent") {
+		t.Fatalf("AddCategory under an unknown id gives Err=%v, Expected a refusal", err)
+	}
+	if err := SetCategoryParent("A", "B"); err != nil {
+		t.Fatalf("SetCategoryParent fails with Err=%v", err)
+	}
+	if err := SetCategoryParent("B", "A"); err == nil || !strings.Contains(err.Error(), "cannot be its parent") {
+		t.Fatalf("SetCategoryParent of B under A gives Err=%v, Expected a refusal", err)
+	}
+	if err := SetCategoryParent("B", "C"); err != nil {
+		t.Fatalf("SetCategoryParent fails with Err=%v", err)
+	}
+	if err := SetCategoryParent("C", "A"); err == nil || !strings.Contains(err.Error(), "cannot be its parent") {
+		t.Fatalf("SetCategoryParent of C under A gives Err=%v, Expected a refusal", err)
+	}
+	v := GetView()
+	if d := v.CategoryDepth(a); d != 3 || !v.IsCategoryUnder(a, v.CategoryByName("C").Cid) {
+		t.Fatalf("Category A at depth %d, Expected 3 under C", d)
+	}
+	if !v.Category(v.CategoryByName("C").Cid).Parent.IsZero() || v.Category(b).Parent.IsZero() {
+		t.Fatalf("A refused change altered the tree")
+	}
+
+	// A change of several categories at once is checked as a whole.
+	c := *v.CategoryByName("C")
+	c.Parent = a
+	if err := check_category_tree(v, []*Category{&c}); err == nil {
+		t.Fatalf("check_category_tree with C under A Expected an error")
+	}
+	bc := *v.Category(b)
+	bc.Parent = uuid.Zero()
+	if err := check_category_tree(v, []*Category{&c, &bc}); err != nil {
+		t.Fatalf("check_category_tree with C under A and B at the top fails with Err=%v", err)
+	}
+}
+
+func Test_BuildCategoryTree(t *testing.T) {
+	test_open(t)
+	test_category(t, "Auto")
+	gas := test_category(t, "Auto:Gas")
+	ins := test_category(t, "Home:Insurance:Fire")
+	nmade, nlinked, err := BuildCategoryTree()
+	if err != nil || nmade != 2 || nlinked != 2 {
+		t.Fatalf("BuildCategoryTree = %d made, %d linked, Err=%v, Expected 2 and 2", nmade, nlinked, err)
+	}
+	v := GetView()
+	if v.Category(gas).Parent != v.CategoryByName("Auto").Cid || v.CategoryDepth(ins) != 3 {
+		t.Fatalf("BuildCategoryTree did not link the categories by name")
+	}
+	if nmade, nlinked, err := BuildCategoryTree(); err != nil || nmade != 0 || nlinked != 0 {
+		t.Fatalf("BuildCategoryTree again = %d made, %d linked, Err=%v, Expected no change", nmade, nlinked, err)
+	}
+}
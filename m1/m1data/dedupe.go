@@ -0,0 +1,256 @@
+// --------------------------------------------------------------------
+// dedupe.go -- Finds transactions that are probably already in the
+// database, and keeps the queue of possible duplicates for review.
+//
+// Created 2020-04-19 DLB
+// --------------------------------------------------------------------
+
+package m1data
+
+import (
+	"dbe/lib/uuid"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Bank statements overlap, so the same transaction is often imported
+// more than once, and it does not always look the same each time: the
+// posted date can move by a day or two, and the description can be
+// cut short or have a reference number added.  So instead of looking
+// for an exact copy, each transaction already in the account with the
+// same amount (found with the byamount index) is given a score from 0
+// to 100 for how alike the two are.  At DupScore_Certain or above, the
+// new transaction is taken to be a duplicate.  From DupScore_Likely up
+// to that, it is held in the review queue until someone decides.
+const (
+	DupScore_Certain = 90
+	DupScore_Likely  = 50
+)
+
+// DupDateWindow is the most number of days apart that two transactions
+// can be and still be duplicates.
+const DupDateWindow = 4
+
+// DupMatch is a transaction that could be a duplicate of another.
+type DupMatch struct {
+	T       *Transaction
+	Score   int
+	Reasons []string
+}
+
+// FindDuplicates returns the transactions in the view that could be
+// the same as t, with a score of at least DupScore_Likely, best first.
+// The transaction t does not need to be in the database.
+func (v *View) FindDuplicates(t *Transaction) []*DupMatch {
+	lst := make([]*DupMatch, 0, 2)
+	for _, o := range v.byamount.get(amount_key(t)) {
+		if o.Tid == t.Tid {
+			continue
+		}
+		m := dup_score(t, o)
+		if m != nil && m.Score >= DupScore_Likely {
+			lst = append(lst, m)
+		}
+	}
+	sort.Slice(lst, func(i, j int) bool {
+		if lst[i].Score != lst[j].Score {
+			return lst[i].Score > lst[j].Score
+		}
+		return lst[i].T.Date().Before(lst[j].T.Date())
+	})
+	return lst
+}
+
+// dup_score scores how alike two transactions with the same account
+// and amount are.  Nil is returned if they cannot be the same, because
+// the bank gave them different ids or check numbers, or their dates
+// are too far apart.
+func dup_score(t, o *Transaction) *DupMatch {
+	m := &DupMatch{T: o, Reasons: make([]string, 0, 4)}
+	if t.FitId != "" && o.FitId != "" {
+		if t.FitId != o.FitId {
+			return nil
+		}
+		m.Score = 100
+		m.Reasons = append(m.Reasons, "Same bank id.")
+		return m
+	}
+	days := int(t.Date().Sub(o.Date()).Hours() / 24)
+	if days < 0 {
+		days = -days
+	}
+	if days > DupDateWindow {
+		return nil
+	}
+	m.Score = 30
+	m.Reasons = append(m.Reasons, "Same amount.")
+	if t.CheckNum != "" && o.CheckNum != "" {
+		if t.CheckNum != o.CheckNum {
+			return nil
+		}
+		m.Score += 30
+		m.Reasons = append(m.Reasons, "Same check number.")
+	}
+	if days == 0 {
+		m.Reasons = append(m.Reasons, "Same date.")
+	} else {
+		m.Reasons = append(m.Reasons, fmt.Sprintf("Dates %d days apart.", days))
+	}
+	m.Score += 30 - 6*days
+	sim := similarity(t.Description, o.Description)
+	if sim > 0 {
+		m.Reasons = append(m.Reasons, fmt.Sprintf("Descriptions %d%% alike.", int(sim*100+0.5)))
+	}
+	m.Score += int(40*sim + 0.5)
+	if !t.Vid.IsZero() && t.Vid == o.Vid {
+		m.Score += 10
+		m.Reasons = append(m.Reasons, "Same vendor.")
+	}
+	if m.Score > 100 {
+		m.Score = 100
+	}
+	return m
+}
+
+// similarity returns how alike two descriptions are, from 0 to 1.  The
+// descriptions are broken into words, ignoring case and punctuation,
+// and the words they share are counted (the Dice coefficient).  If one
+// description is the start of the other, as when a bank cuts it short,
+// they are taken to be the same.
+func similarity(a, b string) float64 {
+	wa, wb := desc_words(a), desc_words(b)
+	if len(wa) == 0 || len(wb) == 0 {
+		return 0
+	}
+	ja, jb := strings.Join(wa, " "), strings.Join(wb, " ")
+	if ja == jb || strings.HasPrefix(ja, jb) || strings.HasPrefix(jb, ja) {
+		return 1
+	}
+	count := make(map[string]int, len(wa))
+	for _, w := range wa {
+		count[w]++
+	}
+	n := 0
+	for _, w := range wb {
+		if count[w] > 0 {
+			count[w]--
+			n++
+		}
+	}
+	return float64(2*n) / float64(len(wa)+len(wb))
+}
+
+// desc_words splits a description into lower case words.
+func desc_words(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Reviews returns the duplicate reviews in the view, oldest first.
+func (v *View) Reviews() []*DupReview {
+	lst := make([]*DupReview, 0, len(v.reviews))
+	for _, r := range v.reviews {
+		lst = append(lst, r)
+	}
+	sort.Slice(lst, func(i, j int) bool {
+		if !lst[i].Created.Equal(lst[j].Created) {
+			return lst[i].Created.Before(lst[j].Created)
+		}
+		return lst[i].T.Date().Before(lst[j].T.Date())
+	})
+	return lst
+}
+
+// Review returns a duplicate review given its id, or nil.
+func (v *View) Review(rid uuid.UUID) *DupReview {
+	return v.reviews[rid]
+}
+
+// GetReviews returns the duplicate reviews waiting for a decision,
+// oldest first.  The reviews are shared and must not be changed.
+func GetReviews() []*DupReview {
+	return GetView().Reviews()
+}
+
+// AddReviews puts transactions that might be duplicates into the review
+// queue.  Each review is given its id.
+func AddReviews(lst []*DupReview) error {
+	return AddTransactionsAndReviews(nil, lst)
+}
+
+// AddTransactionsAndReviews adds a list of transactions, as in
+// AddTransactions, and puts a list of possible duplicates into the
+// review queue, all as a single change.  This is what an import uses,
+// so that a file is either all in or not in at all.
+func AddTransactionsAndReviews(tlst []*Transaction, rlst []*DupReview) error {
+	dblock.Lock()
+	defer dblock.Unlock()
+	cur := GetView()
+	c := &Change{Transactions: make([]*Transaction, 0, len(tlst))}
+	for i, t := range tlst {
+		tc, err := prepare_transaction(cur, t)
+		if err != nil {
+			return fmt.Errorf("Transaction %d: %v", i+1, err)
+		}
+		t.Tid = tc.Tid
+		c.Transactions = append(c.Transactions, tc)
+	}
+	now := time.Now()
+	for i, r := range rlst {
+		if r.T == nil {
+			return fmt.Errorf("Review %d has no transaction.", i+1)
+		}
+		// The transaction is checked now, so that it can be added
+		// later without surprises.
+		tc, err := prepare_transaction(cur, r.T)
+		if err != nil {
+			return fmt.Errorf("Review %d: %v", i+1, err)
+		}
+		if r.Rid.IsZero() {
+			r.Rid = uuid.New()
+		}
+		if r.Created.IsZero() {
+			r.Created = now
+		}
+		rc := *r
+		rc.T = tc
+		rc.Reasons = append([]string{}, r.Reasons...)
+		c.Reviews = append(c.Reviews, &rc)
+	}
+	if len(c.Transactions) == 0 && len(c.Reviews) == 0 {
+		return nil
+	}
+	return commit(c)
+}
+
+// AcceptDuplicate decides that the transaction in a review is a
+// duplicate.  It is dropped, and the review is removed.
+func AcceptDuplicate(rid uuid.UUID) error {
+	dblock.Lock()
+	defer dblock.Unlock()
+	if GetView().Review(rid) == nil {
+		return fmt.Errorf("No duplicate review (%s).", rid)
+	}
+	return commit(&Change{DelReviews: []uuid.UUID{rid}})
+}
+
+// RejectDuplicate decides that the transaction in a review is not a
+// duplicate.  It is added to the database, and the review is removed.
+func RejectDuplicate(rid uuid.UUID) error {
+	dblock.Lock()
+	defer dblock.Unlock()
+	cur := GetView()
+	r := cur.Review(rid)
+	if r == nil {
+		return fmt.Errorf("No duplicate review (%s).", rid)
+	}
+	tc, err := prepare_transaction(cur, r.T)
+	if err != nil {
+		return err
+	}
+	return commit(&Change{Transactions: []*Transaction{tc}, DelReviews: []uuid.UUID{rid}})
+}
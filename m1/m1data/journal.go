@@ -0,0 +1,425 @@
+// --------------------------------------------------------------------
+// journal.go -- Append-only journal of changes made to the database
+// between snapshots.
+//
+// Created 2020-03-28 DLB
+// --------------------------------------------------------------------
+
+package m1data
+
+import (
+	"bytes"
+	"dbe/lib/log"
+	"dbe/lib/uuid"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// Change is a set of modifications to the database that are
+// journaled and applied as a unit.  The ids in the Del lists are
+// removed first, and then items in the other lists replace any
+// existing item with the same id.
+type Change struct {
+	Seq             uint64 // Sequence number, assigned when journaled
+	Schema          int    // SchemaVersion when journaled
+	Time            time.Time
+	Accounts        []*Account
+	Vendors         []*Vendor
+	Categories      []*Category
+	Transactions    []*Transaction
+	Reviews         []*DupReview
+	Batches         []*ImportBatch
+	Recons          []*Reconciliation
+	Budgets         []*Budget
+	DelAccounts     []uuid.UUID
+	DelVendors      []uuid.UUID
+	DelCategories   []uuid.UUID
+	DelTransactions []uuid.UUID
+	DelReviews      []uuid.UUID
+	DelBatches      []uuid.UUID
+	DelRecons       []uuid.UUID
+	DelBudgets      []uuid.UUID
+}
+
+var jnllock sync.Mutex
+var journalfile string = ""
+var jnl *os.File
+
+// Each record in the journal is an 8 byte prefix (length of the
+// data, followed by the crc32 of the data) and then the gob encoded
+// Change.  Every record is encoded with its own encoder, so that
+// records appended by different runs of the server can be read back
+// as a single file.
+const jnl_prefix_len = 8
+
+// jnl_header is the part of a Change that is the same under every
+// schema version.
+type jnl_header struct {
+	Seq    uint64
+	Schema int
+}
+
+// open_journal opens the journal for appending, creating it if
+// needed.
+func open_journal() error {
+	jnllock.Lock()
+	defer jnllock.Unlock()
+	if jnl != nil {
+		return nil
+	}
+	f, err := os.OpenFile(journalfile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0664)
+	if err != nil {
+		return fmt.Errorf("Unable to open journal file (%s). Err=%v", journalfile, err)
+	}
+	jnl = f
+	return nil
+}
+
+// journal_write appends a change to the journal and syncs it to the
+// disk before returning.
+func journal_write(c *Change) error {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	err := enc.Encode(c)
+	if err != nil {
+		return fmt.Errorf("Unable to encode change for journal. Err=%v", err)
+	}
+	rec := make_record(buf.Bytes())
+
+	jnllock.Lock()
+	defer jnllock.Unlock()
+	if jnl == nil {
+		return fmt.Errorf("Journal is not open.")
+	}
+	_, err = jnl.Write(rec)
+	if err != nil {
+		return fmt.Errorf("Unable to write to journal. Err=%v", err)
+	}
+	err = jnl.Sync()
+	if err != nil {
+		return fmt.Errorf("Unable to sync journal. Err=%v", err)
+	}
+	return nil
+}
+
+// journal_trim removes the changes up to lastseq from the journal.
+// Called after a snapshot has been written that contains them.
+// Changes can be committed while the snapshot is written, so any
+// change after lastseq is kept.
+func journal_trim(lastseq uint64) error {
+	jnllock.Lock()
+	defer jnllock.Unlock()
+	if jnl != nil {
+		jnl.Close()
+		jnl = nil
+	}
+	keep := make([]byte, 0, 1000)
+	if journal_has_data() {
+		b, err := ioutil.ReadFile(journalfile)
+		if err != nil {
+			return fmt.Errorf("Unable to read journal file. Err=%v", err)
+		}
+		recs, _ := split_journal(b)
+		for _, data := range recs {
+			var hdr jnl_header
+			err = gob.NewDecoder(bytes.NewReader(data)).Decode(&hdr)
+			if err == nil && hdr.Seq > lastseq {
+				keep = append(keep, make_record(data)...)
+			}
+		}
+	}
+	tmpfile := journalfile + ".tmp"
+	err := write_sync(tmpfile, keep)
+	if err == nil {
+		err = os.Rename(tmpfile, journalfile)
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to trim journal file (%s). Err=%v", journalfile, err)
+	}
+	f, err := os.OpenFile(journalfile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0664)
+	if err != nil {
+		return fmt.Errorf("Unable to open journal file (%s). Err=%v", journalfile, err)
+	}
+	jnl = f
+	return nil
+}
+
+// write_sync writes a file and syncs it to the disk.
+func write_sync(fn string, b []byte) error {
+	f, err := os.OpenFile(fn, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0664)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// make_record puts the prefix on the data for one journal record.
+func make_record(data []byte) []byte {
+	rec := make([]byte, jnl_prefix_len, jnl_prefix_len+len(data))
+	binary.LittleEndian.PutUint32(rec[0:4], uint32(len(data)))
+	binary.LittleEndian.PutUint32(rec[4:8], crc32.ChecksumIEEE(data))
+	return append(rec, data...)
+}
+
+// split_journal splits the contents of a journal file into the data of
+// each record.  A damaged record at the end of the file (from a crash
+// in the middle of a write) is logged and ignored, along with anything
+// after it.  The length of the good part of the file is also returned.
+func split_journal(b []byte) ([][]byte, int64) {
+	lst := make([][]byte, 0, 100)
+	goodlen := int64(0)
+	rdr := bytes.NewReader(b)
+	prefix := make([]byte, jnl_prefix_len)
+	for {
+		_, err := io.ReadFull(rdr, prefix)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Warnf("Partial record prefix at end of journal. Ignored.")
+			break
+		}
+		n := binary.LittleEndian.Uint32(prefix[0:4])
+		crc := binary.LittleEndian.Uint32(prefix[4:8])
+		if int64(n) > int64(rdr.Len()) {
+			log.Warnf("Partial record at end of journal. Ignored.")
+			break
+		}
+		data := make([]byte, n)
+		_, err = io.ReadFull(rdr, data)
+		if err != nil {
+			log.Warnf("Unable to read journal record. Err=%v. Rest of journal ignored.", err)
+			break
+		}
+		if crc32.ChecksumIEEE(data) != crc {
+			log.Warnf("Bad checksum on journal record %d. Rest of journal ignored.", len(lst)+1)
+			break
+		}
+		lst = append(lst, data)
+		goodlen += int64(jnl_prefix_len) + int64(n)
+	}
+	return lst, goodlen
+}
+
+// read_journal reads all the changes in a journal file.  The length
+// of the good part of the file is also returned.
+func read_journal(fn string) ([]*Change, int64, error) {
+	lst := make([]*Change, 0, 100)
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return lst, 0, fmt.Errorf("Unable to read journal file. Err=%v", err)
+	}
+	recs, goodlen := split_journal(b)
+	for i, data := range recs {
+		// The change is only decoded if it was written under a schema
+		// that can be replayed, since older changes may not decode into
+		// the current types.
+		var hdr jnl_header
+		err = gob.NewDecoder(bytes.NewReader(data)).Decode(&hdr)
+		if err != nil {
+			return lst, goodlen, fmt.Errorf("Unable to decode journal record %d. Err=%v", i+1, err)
+		}
+		c := &Change{Seq: hdr.Seq, Schema: hdr.Schema}
+		if journal_safe(schema_of(hdr.Schema)) {
+			err = gob.NewDecoder(bytes.NewReader(data)).Decode(c)
+			if err != nil {
+				return lst, goodlen, fmt.Errorf("Unable to decode journal record %d. Err=%v", i+1, err)
+			}
+		}
+		lst = append(lst, c)
+	}
+	return lst, goodlen, nil
+}
+
+// replay_journal applies the changes in the journal file that come
+// after lastseq to the given database.  It returns the number of changes
+// applied and the sequence number of the last change in the journal.
+// A damaged tail is cut off the file so that new changes are not
+// appended after it.
+func replay_journal(d *Database, lastseq uint64) (int, uint64, error) {
+	if !journal_has_data() {
+		return 0, lastseq, nil
+	}
+	lst, goodlen, err := read_journal(journalfile)
+	if err != nil {
+		return 0, lastseq, err
+	}
+	fi, err := os.Stat(journalfile)
+	if err == nil && fi.Size() > goodlen {
+		log.Warnf("Removing %d damaged bytes from end of journal.", fi.Size()-goodlen)
+		err = os.Truncate(journalfile, goodlen)
+		if err != nil {
+			return 0, lastseq, fmt.Errorf("Unable to truncate damaged journal. Err=%v", err)
+		}
+	}
+	for _, c := range lst {
+		if c.Seq != 0 && c.Seq <= lastseq {
+			continue
+		}
+		if !journal_safe(schema_of(c.Schema)) {
+			return 0, lastseq, set_journal_aside(schema_of(c.Schema))
+		}
+	}
+	n := 0
+	for _, c := range lst {
+		if c.Seq != 0 && c.Seq <= lastseq {
+			continue // Already in the snapshot.
+		}
+		if c.Seq > lastseq+1 && n == 0 {
+			log.Warnf("Changes %d to %d are missing from the snapshot and journal.", lastseq+1, c.Seq-1)
+		}
+		apply_change(d, c)
+		if c.Seq > lastseq {
+			lastseq = c.Seq
+		}
+		n++
+	}
+	return n, lastseq, nil
+}
+
+// set_journal_aside renames a journal that was written under an older
+// schema version that cannot be replayed, so that it is not lost, and
+// starts a new journal.
+func set_journal_aside(version int) error {
+	jnllock.Lock()
+	defer jnllock.Unlock()
+	if jnl != nil {
+		jnl.Close()
+		jnl = nil
+	}
+	fn := fmt.Sprintf("%s.schema%d", journalfile, version)
+	err := os.Rename(journalfile, fn)
+	if err != nil {
+		return fmt.Errorf("Unable to set aside journal from schema version %d. Err=%v", version, err)
+	}
+	log.Errorf("Journal was written under schema version %d and cannot be replayed.", version)
+	log.Errorf("It has been renamed to %s. Use an older server to recover its changes.", fn)
+	return nil
+}
+
+// apply_change puts the items of a change into the database.  The
+// change must already be validated.  It is used while a database is
+// being loaded; the live database is changed with View.apply.
+func apply_change(d *Database, c *Change) {
+	for _, id := range c.DelAccounts {
+		del_account(d.Accounts, d.accountnames, id)
+	}
+	for _, id := range c.DelVendors {
+		del_vendor(d.Vendors, d.vendornames, id)
+	}
+	for _, id := range c.DelCategories {
+		del_category(d.Categories, d.categorynames, id)
+	}
+	for _, tid := range c.DelTransactions {
+		delete(d.Transactions, tid)
+	}
+	for _, rid := range c.DelReviews {
+		delete(d.Reviews, rid)
+	}
+	for _, bid := range c.DelBatches {
+		delete(d.Batches, bid)
+	}
+	for _, id := range c.DelRecons {
+		delete(d.Recons, id)
+	}
+	for _, id := range c.DelBudgets {
+		delete(d.Budgets, id)
+	}
+	for _, a := range c.Accounts {
+		put_account(d.Accounts, d.accountnames, a)
+	}
+	for _, v := range c.Vendors {
+		put_vendor(d.Vendors, d.vendornames, v)
+	}
+	for _, cat := range c.Categories {
+		put_category(d.Categories, d.categorynames, cat)
+	}
+	for _, t := range c.Transactions {
+		d.Transactions[t.Tid] = t
+	}
+	for _, r := range c.Reviews {
+		d.Reviews[r.Rid] = r
+	}
+	for _, b := range c.Batches {
+		d.Batches[b.Bid] = b
+	}
+	for _, r := range c.Recons {
+		d.Recons[r.RecId] = r
+	}
+	for _, b := range c.Budgets {
+		d.Budgets[b.BudId] = b
+	}
+}
+
+// The put and del functions keep a map of items and its name index
+// in step.
+
+func put_account(m map[uuid.UUID]*Account, names map[string]uuid.UUID, a *Account) {
+	if old, ok := m[a.Aid]; ok {
+		delete(names, old.FName)
+	}
+	m[a.Aid] = a
+	names[a.FName] = a.Aid
+}
+
+func del_account(m map[uuid.UUID]*Account, names map[string]uuid.UUID, id uuid.UUID) {
+	if a, ok := m[id]; ok {
+		delete(names, a.FName)
+		delete(m, id)
+	}
+}
+
+func put_vendor(m map[uuid.UUID]*Vendor, names map[string]uuid.UUID, v *Vendor) {
+	if old, ok := m[v.Vid]; ok {
+		delete(names, old.FName)
+	}
+	m[v.Vid] = v
+	names[v.FName] = v.Vid
+}
+
+func del_vendor(m map[uuid.UUID]*Vendor, names map[string]uuid.UUID, id uuid.UUID) {
+	if v, ok := m[id]; ok {
+		delete(names, v.FName)
+		delete(m, id)
+	}
+}
+
+func put_category(m map[uuid.UUID]*Category, names map[string]uuid.UUID, cat *Category) {
+	if old, ok := m[cat.Cid]; ok {
+		delete(names, old.Name)
+	}
+	m[cat.Cid] = cat
+	names[cat.Name] = cat.Cid
+}
+
+func del_category(m map[uuid.UUID]*Category, names map[string]uuid.UUID, id uuid.UUID) {
+	if cat, ok := m[id]; ok {
+		delete(names, cat.Name)
+		delete(m, id)
+	}
+}
+
+// journal_has_data returns true if the journal file exists and
+// is not empty.
+func journal_has_data() bool {
+	fi, err := os.Stat(journalfile)
+	if err != nil {
+		return false
+	}
+	return fi.Size() > 0
+}
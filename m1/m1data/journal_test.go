@@ -0,0 +1,148 @@
+// --------------------------------------------------------------------
+// journal_test.go -- Test the journal, and replaying it after a crash
+//
+// Created 2020-04-20 DLB
+// --------------------------------------------------------------------
+
+package m1data
+
+import (
+	"dbe/lib/util"
+	"dbe/lib/uuid"
+	"os"
+	"testing"
+	"time"
+)
+
+// test_open starts a test on an empty database, kept by a gob store in
+// a folder of its own.
+func test_open(t *testing.T) {
+	t.Helper()
+	test_close()
+	if _, err := set_data_folder(t.TempDir()); err != nil {
+		t.Fatalf("set_data_folder fails with Err=%v", err)
+	}
+	publish(new_view(new_database(), 0))
+	if err := open_store(&gobstore{}); err != nil {
+		t.Fatalf("open_store fails with Err=%v", err)
+	}
+	t.Cleanup(test_close)
+}
+
+func test_close() {
+	if gStore != nil {
+		gStore.Close()
+		gStore = nil
+	}
+}
+
+// test_restart throws away the database in memory, as a crash would,
+// and loads it again from the store.
+func test_restart(t *testing.T) {
+	t.Helper()
+	test_close()
+	publish(new_view(new_database(), 0))
+	if err := open_store(&gobstore{}); err != nil {
+		t.Fatalf("open_store fails with Err=%v", err)
+	}
+}
+
+// test_account adds an account with the given name, and returns its id.
+func test_account(t *testing.T, name string) uuid.UUID {
+	t.Helper()
+	a := &Account{FName: name, Active: true}
+	if err := AddAccount(a); err != nil {
+		t.Fatalf("AddAccount(%s) fails with Err=%v", name, err)
+	}
+	return a.Aid
+}
+
+// test_trans adds a transaction, and returns its id.
+func test_trans(t *testing.T, aid uuid.UUID, date string, amount util.Money) uuid.UUID {
+	t.Helper()
+	d, _ := time.Parse("2006-01-02", date)
+	tr := &Transaction{Tid: uuid.New(), Aid: aid, Amount: amount, DatePosted: d, Description: "Test " + date}
+	if err := AddTransaction(tr); err != nil {
+		t.Fatalf("AddTransaction fails with Err=%v", err)
+	}
+	return tr.Tid
+}
+
+func Test_JournalReplay(t *testing.T) {
+	test_open(t)
+	aid := test_account(t, "Checking")
+	tid1 := test_trans(t, aid, "2020-03-01", -1250)
+	if err := SaveData(); err != nil {
+		t.Fatalf("SaveData fails with Err=%v", err)
+	}
+	tid2 := test_trans(t, aid, "2020-03-02", -2500)
+	tid3 := test_trans(t, aid, "2020-03-03", 10000)
+	seq := GetView().Seq()
+
+	// The server dies in the middle of writing the next change, leaving
+	// part of a record at the end of the journal.
+	test_close()
+	goodlen := journal_size(t)
+	f, err := os.OpenFile(journalfile, os.O_APPEND|os.O_WRONLY, 0664)
+	if err != nil {
+		t.Fatalf("Unable to open journal. Err=%v", err)
+	}
+	f.Write(make_record([]byte("a change that was never finished"))[:20])
+	f.Close()
+
+	test_restart(t)
+	v := GetView()
+	if v.Seq() != seq {
+		t.Fatalf("Seq after replay = %d, Expected %d", v.Seq(), seq)
+	}
+	if v.TransactionCount() != 3 {
+		t.Fatalf("TransactionCount after replay = %d, Expected 3", v.TransactionCount())
+	}
+	for _, tid := range []uuid.UUID{tid1, tid2, tid3} {
+		if v.Transaction(tid) == nil {
+			t.Fatalf("Transaction %s lost in the crash", tid)
+		}
+	}
+	if n := journal_size(t); n != goodlen {
+		t.Fatalf("Journal is %d bytes after replay, Expected the damaged tail cut to %d", n, goodlen)
+	}
+
+	// New changes go after the good part of the journal, and are
+	// replayed with the others.
+	tid4 := test_trans(t, aid, "2020-03-04", -500)
+	test_restart(t)
+	v = GetView()
+	if v.Seq() != seq+1 || v.TransactionCount() != 4 || v.Transaction(tid4) == nil {
+		t.Fatalf("After second restart seq = %d with %d transactions, Expected %d with 4", v.Seq(),
+			v.TransactionCount(), seq+1)
+	}
+}
+
+func Test_JournalTrim(t *testing.T) {
+	test_open(t)
+	aid := test_account(t, "Checking")
+	test_trans(t, aid, "2020-03-01", -1250)
+	if err := SaveData(); err != nil {
+		t.Fatalf("SaveData fails with Err=%v", err)
+	}
+	if n := journal_size(t); n != 0 {
+		t.Fatalf("Journal is %d bytes after save, Expected 0", n)
+	}
+	test_trans(t, aid, "2020-03-02", -2500)
+	lst, _, err := read_journal(journalfile)
+	if err != nil {
+		t.Fatalf("read_journal fails with Err=%v", err)
+	}
+	if len(lst) != 1 || lst[0].Seq != GetView().Seq() {
+		t.Fatalf("Journal has %d changes after save, Expected only the last one", len(lst))
+	}
+}
+
+func journal_size(t *testing.T) int64 {
+	t.Helper()
+	fi, err := os.Stat(journalfile)
+	if err != nil {
+		t.Fatalf("Unable to stat journal. Err=%v", err)
+	}
+	return fi.Size()
+}
@@ -7,11 +7,9 @@
 package m1data
 
 import (
-	"bytes"
 	"dbe/lib/log"
 	"dbe/lib/util"
 	"dbe/m1/config"
-	"encoding/gob"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -27,97 +25,117 @@ var datafile string = ""
 var backupfile string = ""
 var rootname string = "m1data"
 
-func init() {
-	log.Infof("Running Loader...")
+// OpenData opens the data store named by the 'data_store' config
+// parameter, in the folder given by 'data_folder', and loads the
+// database from it.  It is called once by main, after the config has
+// been read.  Until then the database is empty, and changes to it are
+// refused.
+func OpenData() error {
 	df, ok := config.GetParam("data_folder")
 	if !ok {
-		log.Fatalf("Configuration parameter 'data_folder' not provided.")
+		return fmt.Errorf("Configuration parameter 'data_folder' not provided.")
 	}
+	df, err := set_data_folder(df)
+	if err != nil {
+		return err
+	}
+	storename, _ := config.GetStringParam("data_store", "gob")
+	st, err := new_store(storename, df)
+	if err != nil {
+		return err
+	}
+	return open_store(st)
+}
+
+// set_data_folder sets the names of the files kept in the data folder,
+// and makes the backup folder if needed.  The folder is returned with
+// a trailing slash.
+func set_data_folder(df string) (string, error) {
 	if !strings.HasSuffix(df, "/") {
 		df += "/"
 	}
 	if !util.DirExists(df) {
-		log.Fatalf("Data folder (%q) doesn't exist.", df)
+		return df, fmt.Errorf("Data folder (%q) doesn't exist.", df)
 	}
 	datafile = df + rootname + ".dat"
 	backupfile = df + rootname + ".bck"
+	journalfile = df + rootname + ".jnl"
 	backupfolder = df + "backups/"
 	if !util.DirExists(backupfolder) {
 		err := os.Mkdir(backupfolder, 0775)
 		if err != nil {
-			log.Fatalf("Unable to make backup directory. Err=%v", err)
+			return df, fmt.Errorf("Unable to make backup directory. Err=%v", err)
 		}
 	}
-	log.Infof("Main data file: %s\n", datafile)
-	if util.FileExists(datafile) {
-		log.Infof("Attempting to Load Data.")
-		LoadData()
-	} else {
+	return df, nil
+}
+
+// open_store opens a store and makes it the one in use.  If the store
+// has data, the database is loaded from it.  A database that fails to
+// load is logged and left empty, so that a backup can still be loaded
+// from the console.
+func open_store(st Store) error {
+	err := st.Open()
+	if err != nil {
+		return fmt.Errorf("Unable to open %s data store. Err=%v", st.Name(), err)
+	}
+	gStore = st
+	log.Infof("Using the %s data store.", gStore.Name())
+	if !gStore.Exists() {
 		log.Infof("No Database Exiits!  Be sure to correct with backup or oldata.")
+		return nil
+	}
+	log.Infof("Attempting to Load Data.")
+	_, err = LoadData()
+	if err != nil {
+		log.Errorf("Unable to load the database. Err=%v", err)
 	}
+	return nil
 }
 
-// LoadData reads the snapshot on the disk into the current database.
-// It will try to load the primary file, and if that fails, it will
-// try the backup file.
-func LoadData() error {
-	dblock.Lock()
-	defer dblock.Unlock()
+// LoadData reads the database from the store into the current database.
+// Information about where the data actually came from is returned.
+func LoadData() (*SnapshotInfo, error) {
 	holddisk.Lock()
 	defer holddisk.Unlock()
-	if util.FileExists(datafile) {
-		t0 := time.Now()
-		d, err := read_file(datafile)
-		telp := time.Now().Sub(t0).Seconds() * 1000.0
-		if err == nil {
-			db = d
-			log.Infof("Database loaded from primary file. (%8.2f ms)", telp)
-			return nil
-		}
-		log.Errorf("Failed to load database from primary file. Err=%v", err)
-		log.Errorf("Primary file name: %s", datafile)
-	}
-	if !util.FileExists(backupfile) {
-		log.Errorf("No backup file found. Database empty!")
-		log.Errorf("Backup file name: %s", backupfile)
-		return fmt.Errorf("Unable to load database.")
+	dblock.Lock()
+	defer dblock.Unlock()
+	if err := store_ready(); err != nil {
+		return nil, err
 	}
-	t0 := time.Now()
-	d, err := read_file(backupfile)
-	telp := time.Now().Sub(t0).Seconds() * 1000.0
+	d, lastseq, info, err := gStore.Load()
 	if err != nil {
-		log.Errorf("Failed to load database from backup file. Err=%v", err)
-		log.Errorf("Backup file name: %s", backupfile)
-		return fmt.Errorf("Unalbe to load database.")
+		return info, err
 	}
-	db = d
-	log.Infof("Database loaded from backup file. (%f8.2 ms)", telp)
-	return nil
+	publish(new_view(d, lastseq))
+	return info, nil
 }
 
-// SaveData writes a snapshot of the current database to the disk. It also saves
-// the current snapshot to a different name.
+// SaveData writes a complete copy of the current database to the store.
+// For the gob store, this writes a new snapshot, keeps the previous one
+// as the backup file, and trims the journal.  The copy is taken from
+// the current view, so changes can still be made while it is written.
 func SaveData() error {
 	holddisk.Lock()
 	defer holddisk.Unlock()
-	if util.FileExists(datafile) {
-		err := os.Rename(datafile, backupfile)
-		if err != nil {
-			return fmt.Errorf("Unable to make way for new data. Err=%v", err)
-		}
+	if err := store_ready(); err != nil {
+		return err
 	}
-	dblock.Lock()
-	defer dblock.Unlock()
-	t0 := time.Now()
-	err := write_file(db, datafile)
-	telp := time.Now().Sub(t0).Seconds() * 1000.0
-	if err != nil {
-		log.Errorf("Unable to write to database file (%s). Err=%v", datafile, err)
-		return fmt.Errorf("Unable to save database.")
+	v := GetView()
+	return gStore.Save(v.database(), v.seq)
+}
+
+// replace_data makes a database the current one and saves it to the
+// store.  The replacement counts as a change, so it gets the next
+// sequence number, and no earlier change in the store can be replayed
+// over it.  The caller must hold both holddisk and dblock.
+func replace_data(d *Database) error {
+	if err := store_ready(); err != nil {
+		return err
 	}
-	log.Infof("Database saved to disk. (%8.2f ms)", telp)
-	log.Infof("Location: %s\n", datafile)
-	return nil
+	v := new_view(d, GetView().seq+1)
+	publish(v)
+	return gStore.Save(v.database(), v.seq)
 }
 
 // GetBackupFileList returns a list of saved backup files.
@@ -159,25 +177,74 @@ func DeleteBackup(fname string) error {
 	return nil
 }
 
-// LoadBackup loads a Backup file into the current database.
-func LoadBackup(fname string) error {
+// LoadBackup loads a Backup file into the current database.  The
+// loaded data is immediately saved to the store, so that the store
+// starts over from the backup.  Information about the
+// file loaded is returned.
+func LoadBackup(fname string) (*SnapshotInfo, error) {
 	fn := backupfolder + fname + ".dat"
 	if !util.FileExists(fn) {
-		return fmt.Errorf("File doesn't exist (%s).", fn)
+		return nil, fmt.Errorf("File doesn't exist (%s).", fn)
 	}
 	t0 := time.Now()
-	d, err := read_file(fn)
+	d, info, err := read_file(fn)
 	telp := time.Now().Sub(t0).Seconds() * 1000.0
 	if err != nil {
-		err = fmt.Errorf("Unable to load backup database file (%s). Err=%v", err)
+		err = fmt.Errorf("Unable to load backup database file (%s). Err=%v", fn, err)
 		log.Errorf("%v", err)
-		return err
+		return info, err
 	}
+	holddisk.Lock()
+	defer holddisk.Unlock()
 	dblock.Lock()
 	defer dblock.Unlock()
-	db = d
 	log.Infof("Backup file %s loaded into database. (%8.2f ms)", fname, telp)
-	return nil
+	log.Infof("Loaded: %s", info)
+	return info, replace_data(d)
+}
+
+// ReplaceDatabase replaces the entire current database with the one given,
+// which is then saved to the store.  It is used to bring in data from
+// outside, such as a copy kept in mysql.
+func ReplaceDatabase(d *Database) error {
+	holddisk.Lock()
+	defer holddisk.Unlock()
+	dblock.Lock()
+	defer dblock.Unlock()
+	log.Infof("Database replaced. %d transactions.", len(d.Transactions))
+	return replace_data(d)
+}
+
+// CheckBackup reads and verifies a backup file, upgrading it to the
+// current schema in memory if needed.  If rewrite is true and the file
+// is in an old format or old schema, it is written back in the current
+// format and schema.  The current database is not changed.
+func CheckBackup(fname string, rewrite bool) (*SnapshotInfo, error) {
+	fn := backupfolder + fname + ".dat"
+	if !util.FileExists(fn) {
+		return nil, fmt.Errorf("File doesn't exist (%s).", fn)
+	}
+	holddisk.Lock()
+	defer holddisk.Unlock()
+	d, info, err := read_file(fn)
+	if err != nil {
+		return info, err
+	}
+	if !rewrite || (info.UpgradedFrom == 0 && info.FormatVersion == snap_format_version) {
+		return info, nil
+	}
+	err = write_file(d, info.LastSeq, fn, "")
+	if err != nil {
+		return info, fmt.Errorf("Unable to rewrite backup. Err=%v", err)
+	}
+	log.Infof("Backup file %s rewritten at schema version %d.", fn, SchemaVersion)
+	return read_file_info(fn)
+}
+
+// read_file_info reads a snapshot file and returns only its information.
+func read_file_info(fn string) (*SnapshotInfo, error) {
+	_, info, err := read_file(fn)
+	return info, err
 }
 
 // SaveBackup writes the database to a backup file and returns
@@ -191,9 +258,10 @@ func SaveBackup(rootname string) (string, error) {
 		fname = "Backup_" + t0.Format("2006-01-02-15-04-05")
 	}
 	fn := backupfolder + fname + ".dat"
-	dblock.Lock()
-	defer dblock.Unlock()
-	err := write_file(db, fn)
+	holddisk.Lock()
+	defer holddisk.Unlock()
+	v := GetView()
+	err := write_file(v.database(), v.seq, fn, "")
 	telp := time.Now().Sub(t0).Seconds() * 1000.0
 	if err != nil {
 		log.Errorf("Unable to write backup file. Err=%v", err)
@@ -204,32 +272,3 @@ func SaveBackup(rootname string) (string, error) {
 	}
 	return fname, nil
 }
-
-func read_file(fn string) (*Database, error) {
-	var d Database
-	b, err := ioutil.ReadFile(fn)
-	if err != nil {
-		return &d, fmt.Errorf("Unalbe to read database file. Err=%v", err)
-	}
-	buf := bytes.NewBuffer(b)
-	dec := gob.NewDecoder(buf)
-	err = dec.Decode(&d)
-	if err != nil {
-		return &d, fmt.Errorf("Unable to decode database. Err=%v", err)
-	}
-	return &d, nil
-}
-
-func write_file(d *Database, fn string) error {
-	var buf bytes.Buffer
-	enc := gob.NewEncoder(&buf)
-	err := enc.Encode(d)
-	if err != nil {
-		return fmt.Errorf("Unable to encode the database. Err=%v", err)
-	}
-	err = ioutil.WriteFile(fn, buf.Bytes(), 0775)
-	if err != nil {
-		return fmt.Errorf("Unable to write data. Err=%v", err)
-	}
-	return nil
-}
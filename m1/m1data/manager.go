@@ -13,114 +13,235 @@ import (
 	"sync"
 )
 
+// dblock is held by writers, so that changes are made one at a time.
+// Readers don't need it (see view.go).
 var dblock sync.Mutex
-var db *Database
 
 func init() {
-	if db == nil {
-		db = &Database{}
-		db.Accounts = make(map[string]*Account, 10)
-		db.Vendors = make(map[string]*Vendor, 4000)
-		db.Categories = make(map[string]*Category, 1000)
-		db.Transactions = make(map[uuid.UUID]*Transaction, 30000)
+	if gView.Load() == nil {
+		publish(new_view(new_database(), 0))
 	}
 }
 
-// GetVendors returns all the vendors in the database.
+// new_database returns an empty database, ready for use.
+func new_database() *Database {
+	d := &Database{}
+	d.Accounts = make(map[uuid.UUID]*Account, 10)
+	d.Vendors = make(map[uuid.UUID]*Vendor, 4000)
+	d.Categories = make(map[uuid.UUID]*Category, 1000)
+	d.Transactions = make(map[uuid.UUID]*Transaction, 30000)
+	d.Reviews = make(map[uuid.UUID]*DupReview, 10)
+	d.Batches = make(map[uuid.UUID]*ImportBatch, 10)
+	d.Recons = make(map[uuid.UUID]*Reconciliation, 10)
+	d.Budgets = make(map[uuid.UUID]*Budget, 10)
+	fix_maps(d)
+	return d
+}
+
+// fix_maps makes sure that none of the maps in a database are nil, which
+// can happen when a database with empty maps is decoded, and rebuilds
+// the name indexes.
+func fix_maps(d *Database) {
+	if d.Accounts == nil {
+		d.Accounts = make(map[uuid.UUID]*Account, 10)
+	}
+	if d.Vendors == nil {
+		d.Vendors = make(map[uuid.UUID]*Vendor, 4000)
+	}
+	if d.Categories == nil {
+		d.Categories = make(map[uuid.UUID]*Category, 1000)
+	}
+	if d.Transactions == nil {
+		d.Transactions = make(map[uuid.UUID]*Transaction, 30000)
+	}
+	if d.Reviews == nil {
+		d.Reviews = make(map[uuid.UUID]*DupReview, 10)
+	}
+	if d.Batches == nil {
+		d.Batches = make(map[uuid.UUID]*ImportBatch, 10)
+	}
+	if d.Recons == nil {
+		d.Recons = make(map[uuid.UUID]*Reconciliation, 10)
+	}
+	if d.Budgets == nil {
+		d.Budgets = make(map[uuid.UUID]*Budget, 10)
+	}
+	d.accountnames = make(map[string]uuid.UUID, len(d.Accounts))
+	for id, a := range d.Accounts {
+		d.accountnames[a.FName] = id
+	}
+	d.vendornames = make(map[string]uuid.UUID, len(d.Vendors))
+	for id, v := range d.Vendors {
+		d.vendornames[v.FName] = id
+	}
+	d.categorynames = make(map[string]uuid.UUID, len(d.Categories))
+	for id, c := range d.Categories {
+		d.categorynames[c.Name] = id
+	}
+}
+
+// GetVendors returns a copy of all the vendors in the database.
 func GetVendors() []*Vendor {
-	dblock.Lock()
-	defer dblock.Unlock()
-	copylst := make([]*Vendor, 0, len(db.Vendors))
-	for _, v := range db.Vendors {
-		vc := *v
+	v := GetView()
+	copylst := make([]*Vendor, 0, len(v.vendors))
+	for _, vv := range v.vendors {
+		vc := *vv
 		copylst = append(copylst, &vc)
 	}
 	return copylst
 }
 
-// GetVendor returns a vendor given is full name.
-func GetVendor(name string) *Vendor {
-	dblock.Lock()
-	defer dblock.Unlock()
-	vv, ok := db.Vendors[name]
-	if !ok {
-		return nil
+// GetVendor returns a vendor given its id, or nil.  The vendor is
+// shared and must not be changed.
+func GetVendor(vid uuid.UUID) *Vendor {
+	return GetView().Vendor(vid)
+}
+
+// GetVendorByName returns a vendor given its full name, or nil.  The
+// vendor is shared and must not be changed.
+func GetVendorByName(name string) *Vendor {
+	return GetView().VendorByName(name)
+}
+
+// VendorName returns the full name of a vendor, or blank if the id
+// is zero or unknown.
+func VendorName(vid uuid.UUID) string {
+	v := GetView().Vendor(vid)
+	if v == nil {
+		return ""
 	}
-	return vv
+	return v.FName
 }
 
-// GetAccounts returns all the accounts in the database.
+// GetAccounts returns a copy of all the accounts in the database.
 func GetAccounts() []*Account {
-	dblock.Lock()
-	defer dblock.Unlock()
-	copylst := make([]*Account, 0, len(db.Accounts))
-	for _, v := range db.Accounts {
-		vc := *v
-		copylst = append(copylst, &vc)
+	v := GetView()
+	copylst := make([]*Account, 0, len(v.accounts))
+	for _, a := range v.accounts {
+		ac := *a
+		copylst = append(copylst, &ac)
 	}
 	return copylst
 }
 
-// GetCategories returns all the Categories in the database.
+// GetAccount returns an account given its id, or nil.  The account is
+// shared and must not be changed.
+func GetAccount(aid uuid.UUID) *Account {
+	return GetView().Account(aid)
+}
+
+// GetAccountByName returns an account given its full name, or nil.  The
+// account is shared and must not be changed.
+func GetAccountByName(name string) *Account {
+	return GetView().AccountByName(name)
+}
+
+// AccountName returns the full name of an account, or blank if the
+// id is unknown.
+func AccountName(aid uuid.UUID) string {
+	a := GetView().Account(aid)
+	if a == nil {
+		return ""
+	}
+	return a.FName
+}
+
+// GetCategories returns a copy of all the Categories in the database.
 func GetCategories() []*Category {
-	dblock.Lock()
-	defer dblock.Unlock()
-	copylst := make([]*Category, 0, len(db.Categories))
-	for _, v := range db.Categories {
-		vc := *v
-		copylst = append(copylst, &vc)
+	v := GetView()
+	copylst := make([]*Category, 0, len(v.categories))
+	for _, c := range v.categories {
+		cc := *c
+		copylst = append(copylst, &cc)
 	}
 	return copylst
 }
 
-// GetCategory returns the category given its name.
-func GetCategory(name string) *Category {
-	dblock.Lock()
-	defer dblock.Unlock()
-	cc, ok := db.Categories[name]
-	if !ok {
-		return nil
+// GetCategory returns a category given its id, or nil.  The category
+// is shared and must not be changed.
+func GetCategory(cid uuid.UUID) *Category {
+	return GetView().Category(cid)
+}
+
+// GetCategoryByName returns a category given its name, or nil.  The
+// category is shared and must not be changed.
+func GetCategoryByName(name string) *Category {
+	return GetView().CategoryByName(name)
+}
+
+// CategoryName returns the name of a category, or blank if the id
+// is zero or unknown.
+func CategoryName(cid uuid.UUID) string {
+	c := GetView().Category(cid)
+	if c == nil {
+		return ""
 	}
-	return cc
+	return c.Name
 }
 
-// GetTransactions returns all the transactions in the database.
+// GetTransactions returns a copy of all the transactions in the
+// database.  To read without copying, use GetView.
 func GetTransactions() []*Transaction {
-	dblock.Lock()
-	defer dblock.Unlock()
-	copylst := make([]*Transaction, 0, len(db.Transactions))
-	for _, v := range db.Transactions {
-		vc := *v
-		copylst = append(copylst, &vc)
-	}
+	v := GetView()
+	copylst := make([]*Transaction, 0, v.ntrans)
+	v.EachTransaction(func(t *Transaction) {
+		tc := *t
+		copylst = append(copylst, &tc)
+	})
 	return copylst
 }
 
 // AddVendor will either add a new vendor to the vendor list, or
-// replace an existing vendor with a updated version.
+// replace an existing vendor with a updated version.  A vendor with
+// a zero Vid is new, unless a vendor with the same name exists, in
+// which case that vendor is updated.
 func AddVendor(v *Vendor) error {
 	if util.Blank(v.FName) {
 		return fmt.Errorf("Vendor FName cannot be blank.")
 	}
 	dblock.Lock()
 	defer dblock.Unlock()
-	db.Vendors[v.FName] = v
-	return nil
+	cur := GetView()
+	vid, err := resolve_id(v.Vid, cur.vendornames, v.FName, "vendor")
+	if err != nil {
+		return err
+	}
+	v.Vid = vid
+	if !v.DefaultCid.IsZero() {
+		if _, ok := cur.categories[v.DefaultCid]; !ok {
+			return fmt.Errorf("No category (%s) for the default category of the vendor.", v.DefaultCid)
+		}
+	}
+	vc := *v
+	return commit(&Change{Vendors: []*Vendor{&vc}})
 }
 
 // AddCategory will add a category to the category list.  If the
-// category already exists, it will be updated.
+// category already exists, it will be updated.  A category with a
+// zero Cid is new, unless a category with the same name exists.
 func AddCategory(c *Category) error {
+	if util.Blank(c.Name) {
+		return fmt.Errorf("Category name cannot be blank.")
+	}
 	dblock.Lock()
 	defer dblock.Unlock()
-	for k, v := range db.Categories {
-		if k == c.Name {
+	cur := GetView()
+	cid, err := resolve_id(c.Cid, cur.categorynames, c.Name, "category")
+	if err != nil {
+		return err
+	}
+	c.Cid = cid
+	if err := check_category_parent(cur, c); err != nil {
+		return err
+	}
+	for k, v := range cur.categories {
+		if k == c.Cid {
 			continue
 		}
 		for _, n := range v.Aliases {
 			for _, n2 := range c.Aliases {
 				if n == n2 {
-					return fmt.Errorf("Dublicate Aliases (%s) found in existing cat (%s).", n, k)
+					return fmt.Errorf("Dublicate Aliases (%s) found in existing cat (%s).", n, v.Name)
 				}
 			}
 		}
@@ -132,38 +253,63 @@ func AddCategory(c *Category) error {
 	if !util.InStringSlice(c.Aliases, c.Name) {
 		c.Aliases = append(c.Aliases, c.Name)
 	}
-	db.Categories[c.Name] = c
-	return nil
+	cc := *c
+	return commit(&Change{Categories: []*Category{&cc}})
 }
 
 // AddAccount will add an account to the accout list, or it will
-// update an existing account
+// update an existing account.  An account with a zero Aid is new,
+// unless an account with the same name exists.
 func AddAccount(a *Account) error {
 	if util.Blank(a.FName) {
 		return fmt.Errorf("Account FName cannot be blank.")
 	}
 	dblock.Lock()
 	defer dblock.Unlock()
-	db.Accounts[a.FName] = a
-	return nil
+	aid, err := resolve_id(a.Aid, GetView().accountnames, a.FName, "account")
+	if err != nil {
+		return err
+	}
+	a.Aid = aid
+	ac := *a
+	return commit(&Change{Accounts: []*Account{&ac}})
 }
 
 // AddTransaction will either add a new transaction or update an
-// existing transaction.  For updating, the Vid must be provided,
-// or a new transaction is assumed.
+// existing transaction.  For updating, the Tid must be provided,
+// or a new transaction is assumed.  The category splits are checked
+// according to the split check mode (see validate.go).
 func AddTransaction(t *Transaction) error {
 	dblock.Lock()
 	defer dblock.Unlock()
+	tc, err := prepare_transaction(GetView(), t)
+	if err != nil {
+		return err
+	}
+	return commit(&Change{Transactions: []*Transaction{tc}})
+}
+
+// AddTransactions adds or updates a list of transactions as a single
+// change, so either all of them are added or none are.  Each one is
+// checked as in AddTransaction.  New transactions are given their Tid.
+func AddTransactions(lst []*Transaction) error {
+	return AddTransactionsAndReviews(lst, nil)
+}
+
+// prepare_transaction checks a transaction that is about to be added,
+// and returns a copy of it, ready for a change.  The caller must hold
+// dblock.
+func prepare_transaction(cur *View, t *Transaction) (*Transaction, error) {
 	// Make a copy...
 	tc := *t
-	_, ok := db.Accounts[tc.Account]
+	_, ok := cur.accounts[tc.Aid]
 	if !ok {
-		return fmt.Errorf("No Account (%s) for transaction.  Add Account first.", tc.Account)
+		return nil, fmt.Errorf("No Account (%s) for transaction.  Add Account first.", tc.Aid)
 	}
-	if !util.Blank(tc.Vendor) {
-		_, ok = db.Vendors[tc.Vendor]
+	if !tc.Vid.IsZero() {
+		_, ok = cur.vendors[tc.Vid]
 		if !ok {
-			return fmt.Errorf("No Vendor (%s) for transaction.  Add Vendor first.", tc.Vendor)
+			return nil, fmt.Errorf("No Vendor (%s) for transaction.  Add Vendor first.", tc.Vid)
 		}
 	}
 	if tc.Cats == nil {
@@ -172,6 +318,28 @@ func AddTransaction(t *Transaction) error {
 	if tc.Tid.IsZero() {
 		tc.Tid = uuid.New()
 	}
-	db.Transactions[tc.Tid] = &tc
-	return nil
+	err := check_splits(cur, &tc)
+	if err != nil {
+		return nil, err
+	}
+	return &tc, nil
+}
+
+// resolve_id finds the id to use for an item being added.  If the id
+// is zero, the item is new, unless the name is already in use, in which
+// case the id of the existing item is used.  If the id is given, the
+// name must not be in use by a different item.  The caller must hold
+// dblock.
+func resolve_id(id uuid.UUID, names map[string]uuid.UUID, name string, kind string) (uuid.UUID, error) {
+	other, used := names[name]
+	if id.IsZero() {
+		if used {
+			return other, nil
+		}
+		return uuid.New(), nil
+	}
+	if used && other != id {
+		return id, fmt.Errorf("The name %q is already used by another %s.", name, kind)
+	}
+	return id, nil
 }
@@ -0,0 +1,225 @@
+// --------------------------------------------------------------------
+// query.go -- Finds transactions with filters, sorting and paging.
+//
+// Created 2020-04-15 DLB
+// --------------------------------------------------------------------
+
+package m1data
+
+import (
+	"dbe/lib/util"
+	"dbe/lib/uuid"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Sort orders for a Query.
+const (
+	Sort_Date        = "date"
+	Sort_Amount      = "amount"
+	Sort_Description = "description"
+)
+
+// Query selects transactions.  Every filter that is set must match.  A
+// zero value for a field means that it is not used.
+type Query struct {
+	DateFrom time.Time // Inclusive
+	DateTo   time.Time // Exclusive
+	Aid      uuid.UUID
+	Vid      uuid.UUID
+	Cid      uuid.UUID // Matches if any split is for the category
+
+	// If UseAmount is true, only transactions with an amount from
+	// MinAmount to MaxAmount (in cents, inclusive) are found.
+	UseAmount bool
+	MinAmount util.Money
+	MaxAmount util.Money
+
+	Flag string // Exact match
+	Text string // Case-insensitive match on the text fields
+
+	SortBy     string // One of the Sort_ values.  Default is Sort_Date.
+	Descending bool
+	Offset     int // Number of found transactions to skip
+	Limit      int // Max number to return, or zero for all
+}
+
+// QueryTransactions runs a query on the current view.  The page of
+// transactions asked for is returned, along with the total number
+// found.  The transactions are shared and must not be changed.
+func QueryTransactions(q *Query) ([]*Transaction, int, error) {
+	return GetView().Query(q)
+}
+
+// Query runs a query on the view.  The page of transactions asked for
+// is returned, along with the total number found.
+func (v *View) Query(q *Query) ([]*Transaction, int, error) {
+	less, err := query_order(q)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !q.DateFrom.IsZero() && !q.DateTo.IsZero() && !q.DateFrom.Before(q.DateTo) {
+		return nil, 0, fmt.Errorf("Date range is empty (%s to %s).",
+			q.DateFrom.Format("2006-01-02"), q.DateTo.Format("2006-01-02"))
+	}
+	if q.UseAmount && q.MinAmount > q.MaxAmount {
+		return nil, 0, fmt.Errorf("Amount range is empty.")
+	}
+	if q.Offset < 0 || q.Limit < 0 {
+		return nil, 0, fmt.Errorf("Offset and limit cannot be negative.")
+	}
+	text := strings.ToLower(strings.TrimSpace(q.Text))
+	lst := make([]*Transaction, 0, 100)
+	for _, t := range v.candidates(q) {
+		if query_match(q, text, t) {
+			lst = append(lst, t)
+		}
+	}
+	sort.Slice(lst, func(i, j int) bool {
+		if q.Descending {
+			return less(lst[j], lst[i])
+		}
+		return less(lst[i], lst[j])
+	})
+	total := len(lst)
+	if q.Offset >= total {
+		return []*Transaction{}, total, nil
+	}
+	lst = lst[q.Offset:]
+	if q.Limit > 0 && q.Limit < len(lst) {
+		lst = lst[:q.Limit]
+	}
+	return lst, total, nil
+}
+
+// candidates uses the smallest index that applies to the query to find
+// the transactions that might match.
+func (v *View) candidates(q *Query) []*Transaction {
+	var best []*Transaction
+	found := false
+	use := func(lst []*Transaction) {
+		if !found || len(lst) < len(best) {
+			best = lst
+			found = true
+		}
+	}
+	if !q.Aid.IsZero() {
+		use(v.byaccount.get(q.Aid.String()))
+	}
+	if !q.Vid.IsZero() {
+		use(v.byvendor.get(q.Vid.String()))
+	}
+	if !q.DateFrom.IsZero() || !q.DateTo.IsZero() {
+		// Months are compared as "2006-01" strings.
+		from := ""
+		if !q.DateFrom.IsZero() {
+			from = q.DateFrom.Format("2006-01")
+		}
+		to := "9999-12"
+		if !q.DateTo.IsZero() {
+			to = q.DateTo.Format("2006-01")
+		}
+		n := 0
+		v.bymonth.each(func(k string, lst []*Transaction) {
+			if k >= from && k <= to {
+				n += len(lst)
+			}
+		})
+		if !found || n < len(best) {
+			months := make([]*Transaction, 0, n)
+			v.bymonth.each(func(k string, lst []*Transaction) {
+				if k >= from && k <= to {
+					months = append(months, lst...)
+				}
+			})
+			use(months)
+		}
+	}
+	if !found {
+		return v.Transactions()
+	}
+	return best
+}
+
+// query_match returns true if a transaction passes every filter in a
+// query.  The text must already be in lower case.
+func query_match(q *Query, text string, t *Transaction) bool {
+	d := t.Date()
+	if !q.DateFrom.IsZero() && d.Before(q.DateFrom) {
+		return false
+	}
+	if !q.DateTo.IsZero() && !d.Before(q.DateTo) {
+		return false
+	}
+	if !q.Aid.IsZero() && t.Aid != q.Aid {
+		return false
+	}
+	if !q.Vid.IsZero() && t.Vid != q.Vid {
+		return false
+	}
+	if !q.Cid.IsZero() {
+		ok := false
+		for _, ci := range t.Cats {
+			if ci.Cid == q.Cid {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	if q.UseAmount && (t.Amount < q.MinAmount || t.Amount > q.MaxAmount) {
+		return false
+	}
+	if q.Flag != "" && t.Flag != q.Flag {
+		return false
+	}
+	if text != "" {
+		ok := false
+		for _, s := range []string{t.Description, t.Notes, t.BankInfo, t.Location, t.CheckNum} {
+			if strings.Contains(strings.ToLower(s), text) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// query_order returns the less function for the sort order of a query.
+// Ties are broken by date and then id, so that paging is stable.
+func query_order(q *Query) (func(a, b *Transaction) bool, error) {
+	bydate := func(a, b *Transaction) bool {
+		da, db := a.Date(), b.Date()
+		if !da.Equal(db) {
+			return da.Before(db)
+		}
+		return a.Tid.String() < b.Tid.String()
+	}
+	switch strings.ToLower(q.SortBy) {
+	case "", Sort_Date:
+		return bydate, nil
+	case Sort_Amount:
+		return func(a, b *Transaction) bool {
+			if a.Amount != b.Amount {
+				return a.Amount < b.Amount
+			}
+			return bydate(a, b)
+		}, nil
+	case Sort_Description:
+		return func(a, b *Transaction) bool {
+			da, db := strings.ToLower(a.Description), strings.ToLower(b.Description)
+			if da != db {
+				return da < db
+			}
+			return bydate(a, b)
+		}, nil
+	}
+	return nil, fmt.Errorf("Unknown sort order (%q). Use date, amount or description.", q.SortBy)
+}
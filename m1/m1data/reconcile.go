@@ -0,0 +1,393 @@
+// --------------------------------------------------------------------
+// reconcile.go -- Reconciles accounts against bank statements, and
+// locks the periods that have been reconciled.
+//
+// Created 2020-04-20 DLB
+// --------------------------------------------------------------------
+
+package m1data
+
+import (
+	"dbe/lib/util"
+	"dbe/lib/uuid"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// A reconciliation is started for an account with the closing date and
+// ending balance of a statement.  Its starting balance is the opening
+// balance of the account plus every transaction already reconciled.
+// The transactions on the statement are then marked cleared, until the
+// starting balance plus the cleared transactions equals the ending
+// balance.  Only then can it be finished, which makes the cleared
+// transactions reconciled as a single change.
+//
+// An account is locked through the closing date of its last finished
+// reconciliation.  The check is made in commit, so it holds for every
+// way the database is changed: a reconciled transaction cannot be
+// deleted, or have its account, amount, dates or cleared state changed,
+// and no transaction can be added to (or moved into) the locked period.
+// The opening balance of the account cannot be changed either, if its
+// old or new date is in the locked period, since every reconciled
+// balance is counted from it.  Vendors, categories and notes can still
+// be changed.  Transactions in the period that did not clear stay open,
+// for the next statement.  To change a reconciled period, its
+// reconciliation is reopened first, which is only allowed for the last
+// one of the account.
+
+// Reconciliations returns the reconciliations of an account, oldest
+// first.
+func (v *View) Reconciliations(aid uuid.UUID) []*Reconciliation {
+	lst := make([]*Reconciliation, 0, 12)
+	for _, r := range v.recons {
+		if r.Aid == aid {
+			lst = append(lst, r)
+		}
+	}
+	sort.Slice(lst, func(i, j int) bool { return lst[i].End.Before(lst[j].End) })
+	return lst
+}
+
+// Reconciliation returns a reconciliation given its id, or nil.
+func (v *View) Reconciliation(id uuid.UUID) *Reconciliation {
+	return v.recons[id]
+}
+
+// OpenReconciliation returns the open reconciliation of an account, or
+// nil if there is none.
+func (v *View) OpenReconciliation(aid uuid.UUID) *Reconciliation {
+	for _, r := range v.recons {
+		if r.Aid == aid && r.Status == Recon_Open {
+			return r
+		}
+	}
+	return nil
+}
+
+// LockedThrough returns the closing date of the last finished
+// reconciliation of an account, or the zero time if there is none.
+func (v *View) LockedThrough(aid uuid.UUID) time.Time {
+	var end time.Time
+	for _, r := range v.recons {
+		if r.Aid == aid && r.Status == Recon_Finished && r.End.After(end) {
+			end = r.End
+		}
+	}
+	return end
+}
+
+// ReconcileState is where a reconciliation stands.
+type ReconcileState struct {
+	R          *Reconciliation
+	Items      []*Transaction // The transactions that can be on the statement, by date
+	NCleared   int
+	Cleared    util.Money // Total of the cleared items
+	ClearedBal util.Money // Starting balance plus the cleared items
+	Difference util.Money // Ending balance less the cleared balance, zero when done
+}
+
+// ReconcileState returns where a reconciliation stands.  For an open
+// one, the items are the transactions in the account that are not yet
+// reconciled, and are dated through the closing date or are cleared.
+// For a finished one, they are the transactions it reconciled.
+func (v *View) ReconcileState(id uuid.UUID) (*ReconcileState, error) {
+	r := v.recons[id]
+	if r == nil {
+		return nil, fmt.Errorf("No reconciliation (%s).", id)
+	}
+	a := v.accounts[r.Aid]
+	if a == nil {
+		return nil, fmt.Errorf("No account (%s) for the reconciliation.", r.Aid)
+	}
+	st := &ReconcileState{R: r}
+	for _, t := range v.byaccount.get(r.Aid.String()) {
+		if r.Status == Recon_Finished {
+			if t.RecId == id {
+				st.Items = append(st.Items, t)
+			}
+			continue
+		}
+		if t.Cleared == Cleared_Reconciled || t.Date().Before(a.OpeningDate) {
+			continue
+		}
+		if t.Cleared == Cleared_Yes || !t.Date().After(r.End) {
+			st.Items = append(st.Items, t)
+		}
+	}
+	sort.Slice(st.Items, func(i, j int) bool {
+		x, y := st.Items[i], st.Items[j]
+		if !x.Date().Equal(y.Date()) {
+			return x.Date().Before(y.Date())
+		}
+		return x.Tid.String() < y.Tid.String()
+	})
+	for _, t := range st.Items {
+		if t.Cleared != Cleared_No {
+			st.NCleared++
+			st.Cleared += t.Amount
+		}
+	}
+	st.ClearedBal = r.StartBal + st.Cleared
+	st.Difference = r.EndBal - st.ClearedBal
+	return st, nil
+}
+
+// StartReconciliation opens a reconciliation of an account against a
+// statement, given its month, closing date and ending balance.  If the
+// month is zero, the month of the closing date is used.  An account
+// can only have one open reconciliation, and the closing date must be
+// after the end of the last one.
+func StartReconciliation(aid uuid.UUID, month, end time.Time, endbal util.Money, user string) (*Reconciliation, error) {
+	if end.IsZero() {
+		return nil, fmt.Errorf("No closing date given for the statement.")
+	}
+	dblock.Lock()
+	defer dblock.Unlock()
+	cur := GetView()
+	a := cur.accounts[aid]
+	if a == nil {
+		return nil, fmt.Errorf("No account (%s).", aid)
+	}
+	if r := cur.OpenReconciliation(aid); r != nil {
+		return nil, fmt.Errorf("Account %s already has an open reconciliation (statement of %s). "+
+			"Finish it or discard it first.", a.FName, r.End.Format("2006-01-02"))
+	}
+	if through := cur.LockedThrough(aid); !through.IsZero() && !end.After(through) {
+		return nil, fmt.Errorf("Account %s is already reconciled through %s.", a.FName, through.Format("2006-01-02"))
+	}
+	if month.IsZero() {
+		month = time.Date(end.Year(), end.Month(), 1, 0, 0, 0, 0, end.Location())
+	}
+	r := &Reconciliation{RecId: uuid.New(), Aid: aid, Month: month, End: end, StartBal: a.OpeningBalance,
+		EndBal: endbal, Status: Recon_Open, User: user, Created: time.Now()}
+	for _, t := range cur.byaccount.get(aid.String()) {
+		if t.Cleared == Cleared_Reconciled {
+			r.StartBal += t.Amount
+		}
+	}
+	rc := *r
+	if err := commit(&Change{Recons: []*Reconciliation{&rc}}); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// SetCleared marks transactions as cleared, and others as not cleared,
+// as a single change.  Reconciled transactions cannot be changed.  The
+// number of transactions changed is returned.
+func SetCleared(clear, unclear []uuid.UUID) (int, error) {
+	dblock.Lock()
+	defer dblock.Unlock()
+	cur := GetView()
+	c := &Change{Transactions: make([]*Transaction, 0, len(clear)+len(unclear))}
+	set := func(lst []uuid.UUID, state string) error {
+		for _, tid := range lst {
+			t := cur.Transaction(tid)
+			if t == nil {
+				return fmt.Errorf("No transaction (%s).", tid)
+			}
+			if t.Cleared == Cleared_Reconciled {
+				return fmt.Errorf("Transaction %s is reconciled.", describe_transaction(t))
+			}
+			if t.Cleared == state {
+				continue
+			}
+			tc := *t
+			tc.Cleared = state
+			c.Transactions = append(c.Transactions, &tc)
+		}
+		return nil
+	}
+	if err := set(clear, Cleared_Yes); err != nil {
+		return 0, err
+	}
+	if err := set(unclear, Cleared_No); err != nil {
+		return 0, err
+	}
+	if len(c.Transactions) == 0 {
+		return 0, nil
+	}
+	if err := commit(c); err != nil {
+		return 0, err
+	}
+	return len(c.Transactions), nil
+}
+
+// FinishReconciliation finishes an open reconciliation, if the cleared
+// balance matches the statement.  The cleared transactions become
+// reconciled, and the account is locked through the closing date.
+func FinishReconciliation(id uuid.UUID) error {
+	dblock.Lock()
+	defer dblock.Unlock()
+	cur := GetView()
+	st, err := cur.ReconcileState(id)
+	if err != nil {
+		return err
+	}
+	if st.R.Status != Recon_Open {
+		return fmt.Errorf("The reconciliation is already %s.", st.R.Status)
+	}
+	if st.Difference != 0 {
+		return fmt.Errorf("The cleared balance (%s) is off from the statement (%s) by %s.",
+			st.ClearedBal, st.R.EndBal, st.Difference)
+	}
+	c := &Change{Transactions: make([]*Transaction, 0, st.NCleared)}
+	for _, t := range st.Items {
+		if t.Cleared != Cleared_Yes {
+			continue
+		}
+		tc := *t
+		tc.Cleared = Cleared_Reconciled
+		tc.RecId = id
+		c.Transactions = append(c.Transactions, &tc)
+	}
+	rc := *st.R
+	rc.Status = Recon_Finished
+	rc.Finished = time.Now()
+	rc.NCleared = len(c.Transactions)
+	c.Recons = []*Reconciliation{&rc}
+	return commit(c)
+}
+
+// ReopenReconciliation opens a finished reconciliation again, so that
+// its period can be changed.  Only the last one of an account can be
+// reopened, and only if the account has no other open one.  Its
+// transactions go back to cleared.  The number of them is returned.
+func ReopenReconciliation(id uuid.UUID) (int, error) {
+	dblock.Lock()
+	defer dblock.Unlock()
+	cur := GetView()
+	r := cur.recons[id]
+	if r == nil {
+		return 0, fmt.Errorf("No reconciliation (%s).", id)
+	}
+	if r.Status != Recon_Finished {
+		return 0, fmt.Errorf("The reconciliation is %s, not %s.", r.Status, Recon_Finished)
+	}
+	if !r.End.Equal(cur.LockedThrough(r.Aid)) {
+		return 0, fmt.Errorf("Only the last reconciliation of an account can be reopened.")
+	}
+	if cur.OpenReconciliation(r.Aid) != nil {
+		return 0, fmt.Errorf("The account has an open reconciliation. Finish it or discard it first.")
+	}
+	c := &Change{}
+	for _, t := range cur.byaccount.get(r.Aid.String()) {
+		if t.RecId != id {
+			continue
+		}
+		tc := *t
+		tc.Cleared = Cleared_Yes
+		tc.RecId = uuid.Zero()
+		c.Transactions = append(c.Transactions, &tc)
+	}
+	rc := *r
+	rc.Status = Recon_Open
+	rc.Finished = time.Time{}
+	rc.NCleared = 0
+	c.Recons = []*Reconciliation{&rc}
+	if err := commit(c); err != nil {
+		return 0, err
+	}
+	return len(c.Transactions), nil
+}
+
+// DiscardReconciliation drops an open reconciliation.  The transactions
+// that were cleared stay cleared.
+func DiscardReconciliation(id uuid.UUID) error {
+	dblock.Lock()
+	defer dblock.Unlock()
+	r := GetView().recons[id]
+	if r == nil {
+		return fmt.Errorf("No reconciliation (%s).", id)
+	}
+	if r.Status != Recon_Open {
+		return fmt.Errorf("The reconciliation is %s. Reopen it first.", r.Status)
+	}
+	return commit(&Change{DelRecons: []uuid.UUID{id}})
+}
+
+// check_locks returns an error if a change would alter a reconciled
+// period.  A change that carries a reconciliation may change the
+// transactions that it reconciles, which is how finishing and
+// reopening are done.
+func check_locks(v *View, c *Change) error {
+	inchange := make(map[uuid.UUID]bool, len(c.Recons))
+	for _, r := range c.Recons {
+		inchange[r.RecId] = true
+	}
+	const reopen = "Reopen its reconciliation first."
+	for _, tid := range c.DelTransactions {
+		old := v.Transaction(tid)
+		if old != nil && old.Cleared == Cleared_Reconciled && !inchange[old.RecId] {
+			return fmt.Errorf("Transaction %s is reconciled, and cannot be deleted. %s", describe_transaction(old), reopen)
+		}
+	}
+	for _, t := range c.Transactions {
+		old := v.Transaction(t.Tid)
+		if old != nil && old.Cleared == Cleared_Reconciled && !inchange[old.RecId] && locked_changed(old, t) {
+			return fmt.Errorf("Transaction %s is reconciled, and its account, amount, dates and cleared "+
+				"state cannot be changed. %s", describe_transaction(old), reopen)
+		}
+		if t.Cleared == Cleared_Reconciled {
+			if !inchange[t.RecId] && (old == nil || old.Cleared != Cleared_Reconciled || old.RecId != t.RecId) {
+				return fmt.Errorf("Transaction %s can only be reconciled by finishing a reconciliation.",
+					describe_transaction(t))
+			}
+			continue
+		}
+		through := v.LockedThrough(t.Aid)
+		if through.IsZero() || t.Date().After(through) {
+			continue
+		}
+		if old == nil || old.Aid != t.Aid || old.Date().After(through) {
+			return fmt.Errorf("Transaction %s is dated in the reconciled period of account %s (through %s). %s",
+				describe_transaction(t), account_name(v, t.Aid), through.Format("2006-01-02"), reopen)
+		}
+	}
+	for _, a := range c.Accounts {
+		old := v.accounts[a.Aid]
+		if old == nil || (old.OpeningBalance == a.OpeningBalance && old.OpeningDate.Equal(a.OpeningDate)) {
+			continue
+		}
+		through := v.LockedThrough(a.Aid)
+		if !through.IsZero() && (!old.OpeningDate.After(through) || !a.OpeningDate.After(through)) {
+			return fmt.Errorf("Account %s is reconciled through %s, and its opening balance cannot be changed. %s",
+				old.FName, through.Format("2006-01-02"), reopen)
+		}
+	}
+	if len(c.DelAccounts) > 0 {
+		deleted := make(map[uuid.UUID]bool, len(c.DelRecons))
+		for _, id := range c.DelRecons {
+			deleted[id] = true
+		}
+		for _, aid := range c.DelAccounts {
+			for _, r := range v.recons {
+				if r.Aid == aid && !deleted[r.RecId] {
+					return fmt.Errorf("Account %s has reconciliations, and cannot be deleted.", account_name(v, aid))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// locked_changed returns true if a change to a reconciled transaction
+// touches what reconciling it locked.
+func locked_changed(old, t *Transaction) bool {
+	return old.Aid != t.Aid || old.Amount != t.Amount || !old.DatePosted.Equal(t.DatePosted) ||
+		!old.DateSettled.Equal(t.DateSettled) || old.Cleared != t.Cleared || old.RecId != t.RecId
+}
+
+// account_name returns the name of an account in a view, for messages.
+func account_name(v *View, aid uuid.UUID) string {
+	if a := v.accounts[aid]; a != nil {
+		return a.FName
+	}
+	return aid.String()
+}
+
+// describe_transaction names a transaction for messages.
+func describe_transaction(t *Transaction) string {
+	return fmt.Sprintf("%s %q %s", t.Date().Format("2006-01-02"), t.Description, t.Amount)
+}
@@ -0,0 +1,148 @@
+// --------------------------------------------------------------------
+// reconcile_test.go -- Test reconciliations and the locks they make
+//
+// Created 2020-04-20 DLB
+// --------------------------------------------------------------------
+
+package m1data
+
+import (
+	"dbe/lib/util"
+	"dbe/lib/uuid"
+	"strings"
+	"testing"
+	"time"
+)
+
+// test_reconcile reconciles an account through the given closing date,
+// with the given transactions cleared, and returns the reconciliation.
+func test_reconcile(t *testing.T, aid uuid.UUID, end string, tids ...uuid.UUID) *Reconciliation {
+	t.Helper()
+	v := GetView()
+	endbal := v.Account(aid).OpeningBalance
+	for _, tr := range v.byaccount.get(aid.String()) {
+		if tr.Cleared == Cleared_Reconciled {
+			endbal += tr.Amount
+		}
+	}
+	for _, tid := range tids {
+		endbal += v.Transaction(tid).Amount
+	}
+	r, err := StartReconciliation(aid, time.Time{}, test_date(end), endbal, "test")
+	if err != nil {
+		t.Fatalf("StartReconciliation fails with Err=%v", err)
+	}
+	if _, err := SetCleared(tids, nil); err != nil {
+		t.Fatalf("SetCleared fails with Err=%v", err)
+	}
+	if err := FinishReconciliation(r.RecId); err != nil {
+		t.Fatalf("FinishReconciliation fails with Err=%v", err)
+	}
+	return GetView().Reconciliation(r.RecId)
+}
+
+// Test_LockedOpeningBalance checks that the opening balance cannot be
+// changed when its old or new date is in a reconciled period.
+func Test_LockedOpeningBalance(t *testing.T) {
+	test_open(t)
+	aid := test_account(t, "Checking")
+	if err := SetOpeningBalance(aid, 50000, test_date("2020-01-01")); err != nil {
+		t.Fatalf("SetOpeningBalance fails with Err=%v", err)
+	}
+	tid := test_trans(t, aid, "2020-03-05", -1250)
+	r := test_reconcile(t, aid, "2020-03-31", tid)
+	tests := []struct {
+		Name   string
+		Amount util.Money
+		Date   string
+	}{
+		{"amount changed", 60000, "2020-01-01"},
+		{"date moved within the lock", 50000, "2020-02-01"},
+		{"date on the closing date", 50000, "2020-03-31"},
+		{"date moved out of the lock", 50000, "2020-05-01"},
+	}
+	for _, x := range tests {
+		err := SetOpeningBalance(aid, x.Amount, test_date(x.Date))
+		if err == nil || !strings.Contains(err.Error(), "reconciled through 2020-03-31") {
+			t.Fatalf("SetOpeningBalance with %s gives Err=%v, Expected a refusal", x.Name, err)
+		}
+	}
+	if a := GetView().Account(aid); a.OpeningBalance != 50000 || !a.OpeningDate.Equal(test_date("2020-01-01")) {
+		t.Fatalf("Opening balance changed to %s on %s after the refusals", a.OpeningBalance, a.OpeningDate)
+	}
+	// Once reopened, the opening balance can be changed.
+	if _, err := ReopenReconciliation(r.RecId); err != nil {
+		t.Fatalf("ReopenReconciliation fails with Err=%v", err)
+	}
+	if err := SetOpeningBalance(aid, 60000, test_date("2020-01-01")); err != nil {
+		t.Fatalf("SetOpeningBalance after reopening fails with Err=%v", err)
+	}
+}
+
+// Test_ReconcileLocks checks that a finished reconciliation refuses
+// changes to its period, allows the ones it does not lock, and lets
+// them through again once it is reopened.
+func Test_ReconcileLocks(t *testing.T) {
+	test_open(t)
+	aid := test_account(t, "Checking")
+	tid := test_trans(t, aid, "2020-03-05", -1250)
+	open := test_trans(t, aid, "2020-03-06", -4000)
+	r := test_reconcile(t, aid, "2020-03-31", tid)
+	if tr := GetView().Transaction(tid); tr.Cleared != Cleared_Reconciled || tr.RecId != r.RecId {
+		t.Fatalf("Transaction is %q in %s, Expected reconciled", tr.Cleared, tr.RecId)
+	}
+	edit := func(f func(tc *Transaction)) error {
+		tc := *GetView().Transaction(tid)
+		f(&tc)
+		return AddTransaction(&tc)
+	}
+	del := func() error {
+		dblock.Lock()
+		defer dblock.Unlock()
+		return commit(&Change{DelTransactions: []uuid.UUID{tid}})
+	}
+	if err := del(); err == nil || !strings.Contains(err.Error(), "cannot be deleted") {
+		t.Fatalf("Deleting a reconciled transaction gives Err=%v, Expected a refusal", err)
+	}
+	if err := edit(func(tc *Transaction) { tc.Amount = -1300 }); err == nil ||
+		!strings.Contains(err.Error(), "is reconciled") {
+		t.Fatalf("Changing a reconciled amount gives Err=%v, Expected a refusal", err)
+	}
+	if _, err := SetCleared(nil, []uuid.UUID{tid}); err == nil {
+		t.Fatalf("Unclearing a reconciled transaction Expected an error")
+	}
+	tc := &Transaction{Tid: uuid.New(), Aid: aid, Amount: -500, DatePosted: test_date("2020-03-15")}
+	if err := AddTransaction(tc); err == nil || !strings.Contains(err.Error(), "reconciled period") {
+		t.Fatalf("Adding a transaction in the locked period gives Err=%v, Expected a refusal", err)
+	}
+	tc = &Transaction{Tid: uuid.New(), Aid: aid, Amount: -500, DatePosted: test_date("2020-04-02")}
+	if err := AddTransaction(tc); err != nil {
+		t.Fatalf("Adding a transaction after the locked period fails with Err=%v", err)
+	}
+	moved := *GetView().Transaction(tc.Tid)
+	moved.DatePosted = test_date("2020-03-20")
+	if err := AddTransaction(&moved); err == nil || !strings.Contains(err.Error(), "reconciled period") {
+		t.Fatalf("Moving a transaction into the locked period gives Err=%v, Expected a refusal", err)
+	}
+	// What the reconciliation does not lock can still be changed.
+	if err := edit(func(tc *Transaction) { tc.Notes = "Lunch" }); err != nil {
+		t.Fatalf("Changing the notes of a reconciled transaction fails with Err=%v", err)
+	}
+	if tr := GetView().Transaction(open); tr.Cleared == Cleared_Reconciled {
+		t.Fatalf("Uncleared transaction in the period was reconciled")
+	}
+	if _, err := SetCleared([]uuid.UUID{open}, nil); err != nil {
+		t.Fatalf("Clearing an open transaction in the period fails with Err=%v", err)
+	}
+
+	n, err := ReopenReconciliation(r.RecId)
+	if err != nil || n != 1 {
+		t.Fatalf("ReopenReconciliation = %d, Err=%v, Expected 1 transaction", n, err)
+	}
+	if err := edit(func(tc *Transaction) { tc.Amount = -1300 }); err != nil {
+		t.Fatalf("Changing the amount after reopening fails with Err=%v", err)
+	}
+	if err := del(); err != nil {
+		t.Fatalf("Deleting the transaction after reopening fails with Err=%v", err)
+	}
+}
@@ -0,0 +1,407 @@
+// --------------------------------------------------------------------
+// rename.go -- Renames, merges and deletes accounts, vendors and
+// categories, rewriting every reference to them.
+//
+// Created 2020-04-10 DLB
+// --------------------------------------------------------------------
+
+package m1data
+
+import (
+	"dbe/lib/util"
+	"dbe/lib/uuid"
+	"fmt"
+)
+
+// Transactions refer to accounts, vendors and categories by id, and
+// vendors refer to categories by id (DefaultCid), so a rename only
+// changes the item itself.  A merge or delete is done as one change
+// that holds the surviving item and a new copy of everything that
+// referred to the one removed.  The change is committed under dblock,
+// so either all the references move or none do.  Items are given by
+// name, since that is what people type.
+
+const (
+	kind_account  = "account"
+	kind_vendor   = "vendor"
+	kind_category = "category"
+)
+
+// RenameAccount gives an account a new name.  The new name must not be in use.
+func RenameAccount(from, to string) error {
+	return rename_item(kind_account, from, to)
+}
+
+// RenameVendor gives a vendor a new name.  The new name must not be in use.
+func RenameVendor(from, to string) error {
+	return rename_item(kind_vendor, from, to)
+}
+
+// RenameCategory gives a category a new name.  The new name must not be in
+// use.
+func RenameCategory(from, to string) error {
+	return rename_item(kind_category, from, to)
+}
+
+// MergeAccounts moves every reference of one account to another, and then
+// deletes the first.  Its name and aliases become aliases of the second.
+func MergeAccounts(from, into string) (int, error) {
+	return merge_item(kind_account, from, into)
+}
+
+// MergeVendors moves every reference of one vendor to another, and then
+// deletes the first.  Its name and aliases become aliases of the second.
+func MergeVendors(from, into string) (int, error) {
+	return merge_item(kind_vendor, from, into)
+}
+
+// MergeCategories moves every reference of one category to another, and then
+// deletes the first.  Its aliases become aliases of the second.
+func MergeCategories(from, into string) (int, error) {
+	return merge_item(kind_category, from, into)
+}
+
+// DeleteAccount deletes an account.  If the account is in use, a replacement
+// must be given, and all references are moved to it.
+func DeleteAccount(name, replacement string) (int, error) {
+	return delete_item(kind_account, name, replacement)
+}
+
+// DeleteVendor deletes a vendor.  If the vendor is in use, a replacement
+// must be given, and all references are moved to it.
+func DeleteVendor(name, replacement string) (int, error) {
+	return delete_item(kind_vendor, name, replacement)
+}
+
+// DeleteCategory deletes a category.  If the category is in use, a
+// replacement must be given, and all references are moved to it.
+func DeleteCategory(name, replacement string) (int, error) {
+	return delete_item(kind_category, name, replacement)
+}
+
+func rename_item(kind, from, to string) error {
+	if util.Blank(to) {
+		return fmt.Errorf("New %s name cannot be blank.", kind)
+	}
+	if from == to {
+		return fmt.Errorf("New name is the same as the old name.")
+	}
+	dblock.Lock()
+	defer dblock.Unlock()
+	cur := GetView()
+	id, ok := find_name(cur, kind, from)
+	if !ok {
+		return fmt.Errorf("No %s named %q.", kind, from)
+	}
+	if _, ok := find_name(cur, kind, to); ok {
+		return fmt.Errorf("A %s named %q already exists. Use merge instead.", kind, to)
+	}
+	c := &Change{}
+	switch kind {
+	case kind_account:
+		a := *cur.accounts[id]
+		a.FName = to
+		c.Accounts = append(c.Accounts, &a)
+	case kind_vendor:
+		v := *cur.vendors[id]
+		v.FName = to
+		c.Vendors = append(c.Vendors, &v)
+	case kind_category:
+		cat := *cur.categories[id]
+		for _, other := range cur.categories {
+			if other.Cid != id && util.InStringSlice(other.Aliases, to) {
+				return fmt.Errorf("The name %q is already an alias of category %q.", to, other.Name)
+			}
+		}
+		cat.Name = to
+		cat.Aliases = replace_string(cat.Aliases, from, to)
+		c.Categories = append(c.Categories, &cat)
+	}
+	return commit(c)
+}
+
+func merge_item(kind, from, into string) (int, error) {
+	if from == into {
+		return 0, fmt.Errorf("Cannot merge a %s into itself.", kind)
+	}
+	dblock.Lock()
+	defer dblock.Unlock()
+	cur := GetView()
+	fromid, ok := find_name(cur, kind, from)
+	if !ok {
+		return 0, fmt.Errorf("No %s named %q.", kind, from)
+	}
+	intoid, ok := find_name(cur, kind, into)
+	if !ok {
+		return 0, fmt.Errorf("No %s named %q.", kind, into)
+	}
+	if kind == kind_account {
+		if err := check_account_history(cur, fromid); err != nil {
+			return 0, err
+		}
+	}
+	c := &Change{}
+	switch kind {
+	case kind_account:
+		a := *cur.accounts[intoid]
+		a.Aliases = merge_strings(a.Aliases, append([]string{from}, cur.accounts[fromid].Aliases...))
+		c.Accounts = append(c.Accounts, &a)
+		c.DelAccounts = append(c.DelAccounts, fromid)
+	case kind_vendor:
+		v := *cur.vendors[intoid]
+		v.Aliases = merge_strings(v.Aliases, append([]string{from}, cur.vendors[fromid].Aliases...))
+		c.Vendors = append(c.Vendors, &v)
+		c.DelVendors = append(c.DelVendors, fromid)
+	case kind_category:
+		cat := *cur.categories[intoid]
+		cat.Aliases = merge_strings(cat.Aliases, cur.categories[fromid].Aliases)
+		c.Categories = append(c.Categories, &cat)
+		c.DelCategories = append(c.DelCategories, fromid)
+	}
+	n := rewrite_refs(cur, c, kind, fromid, intoid)
+	return n, commit(c)
+}
+
+func delete_item(kind, name, replacement string) (int, error) {
+	dblock.Lock()
+	defer dblock.Unlock()
+	cur := GetView()
+	id, ok := find_name(cur, kind, name)
+	if !ok {
+		return 0, fmt.Errorf("No %s named %q.", kind, name)
+	}
+	c := &Change{}
+	switch kind {
+	case kind_account:
+		c.DelAccounts = append(c.DelAccounts, id)
+	case kind_vendor:
+		c.DelVendors = append(c.DelVendors, id)
+	case kind_category:
+		c.DelCategories = append(c.DelCategories, id)
+	}
+	if util.Blank(replacement) {
+		n := count_refs(cur, kind, id)
+		if n > 0 {
+			return 0, fmt.Errorf("The %s %q has %d references. Give a replacement to delete it.", kind, name, n)
+		}
+		return 0, commit(c)
+	}
+	if replacement == name {
+		return 0, fmt.Errorf("The replacement cannot be the %s being deleted.", kind)
+	}
+	newid, ok := find_name(cur, kind, replacement)
+	if !ok {
+		return 0, fmt.Errorf("No %s named %q for the replacement.", kind, replacement)
+	}
+	if kind == kind_account {
+		if err := check_account_history(cur, id); err != nil {
+			return 0, err
+		}
+	}
+	n := rewrite_refs(cur, c, kind, id, newid)
+	return n, commit(c)
+}
+
+// find_name returns the id of the item of the given kind and name.
+func find_name(v *View, kind, name string) (uuid.UUID, bool) {
+	var id uuid.UUID
+	var ok bool
+	switch kind {
+	case kind_account:
+		id, ok = v.accountnames[name]
+	case kind_vendor:
+		id, ok = v.vendornames[name]
+	case kind_category:
+		id, ok = v.categorynames[name]
+	}
+	return id, ok
+}
+
+// count_refs returns the number of references to an item.  For an
+// account, its reconciliations count too, although rewrite_refs never
+// moves them (see check_account_history).
+func count_refs(v *View, kind string, id uuid.UUID) int {
+	n := rewrite_refs(v, &Change{}, kind, id, id)
+	if kind == kind_account {
+		n += len(v.Reconciliations(id))
+	}
+	return n
+}
+
+// check_account_history returns an error if an account has
+// reconciliations or an opening balance.  Both describe the statements
+// of that one account, and would be wrong for any other, so such an
+// account cannot be merged into another or replaced.
+func check_account_history(v *View, aid uuid.UUID) error {
+	a := v.accounts[aid]
+	if n := len(v.Reconciliations(aid)); n > 0 {
+		return fmt.Errorf("The account %q has %d reconciliations, which cannot be moved to another account. "+
+			"Reopen and discard them first.", a.FName, n)
+	}
+	if a.OpeningBalance != 0 || !a.OpeningDate.IsZero() {
+		return fmt.Errorf("The account %q has an opening balance, which cannot be moved to another account. "+
+			"Clear it first.", a.FName)
+	}
+	return nil
+}
+
+// rewrite_refs adds to the change a new copy of every transaction (and,
+// for categories, every vendor, budget and subcategory) that refers to
+// an item, with the reference changed to the new id.  The transactions
+// waiting in the duplicate reviews and in staged import batches are
+// changed the same way, as are the account aliases that committed
+// batches added.  The view itself is not touched.  The number of
+// references is returned.
+func rewrite_refs(v *View, c *Change, kind string, from, to uuid.UUID) int {
+	n := 0
+	v.EachTransaction(func(t *Transaction) {
+		if tc, nrefs := rewrite_transaction(t, kind, from, to); nrefs > 0 {
+			c.Transactions = append(c.Transactions, tc)
+			n += nrefs
+		}
+	})
+	for _, r := range v.reviews {
+		if tc, nrefs := rewrite_transaction(r.T, kind, from, to); nrefs > 0 {
+			rc := *r
+			rc.T = tc
+			c.Reviews = append(c.Reviews, &rc)
+			n += nrefs
+		}
+	}
+	for _, b := range v.batches {
+		var bc *ImportBatch
+		for i, r := range b.Rows {
+			tc, nrefs := rewrite_transaction(r.T, kind, from, to)
+			if nrefs == 0 {
+				continue
+			}
+			if bc == nil {
+				bc = copy_batch(b)
+			}
+			bc.Rows[i].T = tc
+			n += nrefs
+		}
+		// The aliases are not counted, since they only matter to a
+		// rollback, which skips an account that is gone.
+		for i, al := range b.NewAliases {
+			if kind != kind_account || al.Aid != from || from == to {
+				continue
+			}
+			if bc == nil {
+				bc = copy_batch(b)
+			}
+			bc.NewAliases[i].Aid = to
+		}
+		if bc != nil {
+			c.Batches = append(c.Batches, bc)
+		}
+	}
+	if kind == kind_category {
+		for _, vv := range v.vendors {
+			if vv.DefaultCid == from {
+				vc := *vv
+				vc.DefaultCid = to
+				c.Vendors = append(c.Vendors, &vc)
+				n++
+			}
+		}
+		for _, cat := range v.categories {
+			if cat.Parent != from || cat.Cid == from {
+				continue
+			}
+			n++
+			// The new category may already be in the change, as when
+			// it is merged into.
+			var cc *Category
+			for _, x := range c.Categories {
+				if x.Cid == cat.Cid {
+					cc = x
+				}
+			}
+			if cc == nil {
+				cx := *cat
+				cc = &cx
+				c.Categories = append(c.Categories, cc)
+			}
+			cc.Parent = to
+			// A category that the new one is under moves up instead, so
+			// that the tree has no cycle.
+			if to != from && v.IsCategoryUnder(to, cat.Cid) {
+				cc.Parent = v.categories[from].Parent
+			}
+		}
+		for _, b := range v.budgets {
+			if b.Cid != from {
+				continue
+			}
+			n++
+			// A budget that would clash with one the new category
+			// already has (starting the same month) is dropped.
+			if to != from && v.CategoryBudgetAt(to, b.Start) != nil {
+				c.DelBudgets = append(c.DelBudgets, b.BudId)
+				continue
+			}
+			bc := *b
+			bc.Cid = to
+			c.Budgets = append(c.Budgets, &bc)
+		}
+	}
+	return n
+}
+
+// rewrite_transaction returns a copy of a transaction with its
+// references to an item changed to the new id, and the number of
+// references changed.  If there are none, nil is returned.
+func rewrite_transaction(t *Transaction, kind string, from, to uuid.UUID) (*Transaction, int) {
+	n := 0
+	tc := *t
+	switch kind {
+	case kind_account:
+		if tc.Aid == from {
+			tc.Aid = to
+			n++
+		}
+	case kind_vendor:
+		if tc.Vid == from {
+			tc.Vid = to
+			n++
+		}
+	case kind_category:
+		tc.Cats = make([]CatItem, len(t.Cats))
+		copy(tc.Cats, t.Cats)
+		for i := range tc.Cats {
+			if tc.Cats[i].Cid == from {
+				tc.Cats[i].Cid = to
+				n++
+			}
+		}
+	}
+	if n == 0 {
+		return nil, 0
+	}
+	return &tc, n
+}
+
+// replace_string returns a copy of a list with one string replaced,
+// adding the new one if the old one is not found.
+func replace_string(lst []string, from, to string) []string {
+	out := make([]string, 0, len(lst)+1)
+	for _, s := range lst {
+		if s != from && s != to {
+			out = append(out, s)
+		}
+	}
+	return append(out, to)
+}
+
+// merge_strings returns a copy of a list with the strings in another
+// list added, without duplicates.
+func merge_strings(lst []string, more []string) []string {
+	out := make([]string, 0, len(lst)+len(more))
+	for _, s := range append(append([]string{}, lst...), more...) {
+		if !util.Blank(s) && !util.InStringSlice(out, s) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
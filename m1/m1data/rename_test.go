@@ -0,0 +1,136 @@
+// --------------------------------------------------------------------
+// rename_test.go -- Test merging and deleting accounts
+//
+// Created 2020-04-20 DLB
+// --------------------------------------------------------------------
+
+package m1data
+
+import (
+	"dbe/lib/util"
+	"dbe/lib/uuid"
+	"strings"
+	"testing"
+	"time"
+)
+
+func test_date(s string) time.Time {
+	d, _ := time.Parse("2006-01-02", s)
+	return d
+}
+
+// test_batch stages and commits an import batch with one transaction,
+// which adds the given aliases to the account.  The id of the batch is
+// returned.
+func test_batch(t *testing.T, aid uuid.UUID, aliases ...string) uuid.UUID {
+	t.Helper()
+	b := &ImportBatch{Source: "test.ofx", Kind: "ofx", Rows: []*ImportRow{
+		{Row: 1, Status: Row_New, T: &Transaction{Aid: aid, Amount: -700, DatePosted: test_date("2020-03-10"),
+			Description: "Imported"}}}}
+	for _, al := range aliases {
+		b.NewAliases = append(b.NewAliases, &BatchAlias{Aid: aid, Alias: al})
+	}
+	if err := AddBatch(b); err != nil {
+		t.Fatalf("AddBatch fails with Err=%v", err)
+	}
+	if err := CommitBatch(b.Bid); err != nil {
+		t.Fatalf("CommitBatch fails with Err=%v", err)
+	}
+	return b.Bid
+}
+
+func Test_MergeAccounts(t *testing.T) {
+	test_open(t)
+	from := test_account(t, "Old Checking")
+	into := test_account(t, "Checking")
+	tid := test_trans(t, from, "2020-03-01", -1250)
+	n, err := MergeAccounts("Old Checking", "Checking")
+	if err != nil || n != 1 {
+		t.Fatalf("MergeAccounts = %d, Err=%v, Expected 1 reference moved", n, err)
+	}
+	v := GetView()
+	if v.Account(from) != nil || v.Transaction(tid).Aid != into {
+		t.Fatalf("MergeAccounts did not move the transaction and delete the account")
+	}
+	if !util.InStringSlice(v.Account(into).Aliases, "Old Checking") {
+		t.Fatalf("MergeAccounts did not keep the old name as an alias")
+	}
+}
+
+func Test_MergeAccountRecons(t *testing.T) {
+	test_open(t)
+	from := test_account(t, "Old Checking")
+	test_account(t, "Checking")
+	if _, err := StartReconciliation(from, time.Time{}, test_date("2020-03-31"), 0, "test"); err != nil {
+		t.Fatalf("StartReconciliation fails with Err=%v", err)
+	}
+	_, err := MergeAccounts("Old Checking", "Checking")
+	if err == nil || !strings.Contains(err.Error(), "reconciliations") {
+		t.Fatalf("MergeAccounts with reconciliations gives Err=%v, Expected a refusal", err)
+	}
+	_, err = DeleteAccount("Old Checking", "Checking")
+	if err == nil || !strings.Contains(err.Error(), "reconciliations") {
+		t.Fatalf("DeleteAccount with a replacement gives Err=%v, Expected a refusal", err)
+	}
+	// With no transactions, the reconciliation is the only reference.
+	_, err = DeleteAccount("Old Checking", "")
+	if err == nil || !strings.Contains(err.Error(), "1 references") {
+		t.Fatalf("DeleteAccount gives Err=%v, Expected the reconciliation to count as a reference", err)
+	}
+	if GetView().Account(from) == nil || len(GetView().Reconciliations(from)) != 1 {
+		t.Fatalf("Account or reconciliation lost after the refusals")
+	}
+}
+
+func Test_MergeAccountOpeningBalance(t *testing.T) {
+	test_open(t)
+	from := test_account(t, "Old Checking")
+	test_account(t, "Checking")
+	if err := SetOpeningBalance(from, 50000, test_date("2020-01-01")); err != nil {
+		t.Fatalf("SetOpeningBalance fails with Err=%v", err)
+	}
+	_, err := MergeAccounts("Old Checking", "Checking")
+	if err == nil || !strings.Contains(err.Error(), "opening balance") {
+		t.Fatalf("MergeAccounts with an opening balance gives Err=%v, Expected a refusal", err)
+	}
+	_, err = DeleteAccount("Old Checking", "Checking")
+	if err == nil || !strings.Contains(err.Error(), "opening balance") {
+		t.Fatalf("DeleteAccount with a replacement gives Err=%v, Expected a refusal", err)
+	}
+	if a := GetView().Account(from); a == nil || a.OpeningBalance != 50000 {
+		t.Fatalf("Opening balance lost after the refusals")
+	}
+	// Once cleared, the account can be merged.
+	if err := SetOpeningBalance(from, 0, time.Time{}); err != nil {
+		t.Fatalf("SetOpeningBalance fails with Err=%v", err)
+	}
+	if _, err := MergeAccounts("Old Checking", "Checking"); err != nil {
+		t.Fatalf("MergeAccounts after clearing the opening balance fails with Err=%v", err)
+	}
+}
+
+// Test_MergeAccountBatchAlias checks that the aliases an import added
+// follow the account they were merged into, so that a rollback still
+// takes them away.
+func Test_MergeAccountBatchAlias(t *testing.T) {
+	test_open(t)
+	from := test_account(t, "Old Checking")
+	into := test_account(t, "Checking")
+	bid := test_batch(t, from, "BOFA 1234")
+	if _, err := MergeAccounts("Old Checking", "Checking"); err != nil {
+		t.Fatalf("MergeAccounts fails with Err=%v", err)
+	}
+	b := GetView().Batch(bid)
+	if len(b.NewAliases) != 1 || b.NewAliases[0].Aid != into {
+		t.Fatalf("Batch alias not moved to the account merged into")
+	}
+	if !util.InStringSlice(GetView().Account(into).Aliases, "BOFA 1234") {
+		t.Fatalf("Alias not merged into the account")
+	}
+	if _, err := RollbackBatch(bid); err != nil {
+		t.Fatalf("RollbackBatch fails with Err=%v", err)
+	}
+	if util.InStringSlice(GetView().Account(into).Aliases, "BOFA 1234") {
+		t.Fatalf("Rollback did not take the alias away after the merge")
+	}
+}
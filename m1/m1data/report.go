@@ -0,0 +1,137 @@
+// --------------------------------------------------------------------
+// report.go -- Totals income and spending by category.
+//
+// Created 2020-04-20 DLB
+// --------------------------------------------------------------------
+
+package m1data
+
+import (
+	"dbe/lib/util"
+	"dbe/lib/uuid"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The category report adds up the splits of the transactions in a date
+// range.  Money coming in is income, and money going out is spending,
+// taken split by split, so a refund in a category offsets what was
+// spent there.  A transaction with no categories, or whose splits do
+// not add up to its amount, puts what is left in uncategorized.
+//
+// Transfers between our own accounts are left out, unless asked for,
+// since otherwise a card payment is counted twice: once when the card
+// is used, and again when it is paid from checking.  What was left out
+// is totalled, so it can be shown.
+
+// CategoryTotal is the total of one category in a report.  The zero
+// Cid is for uncategorized money.
+type CategoryTotal struct {
+	Cid     uuid.UUID
+	Name    string
+	Income  util.Money // Money in, zero or more
+	Expense util.Money // Money out, zero or less
+	N       int        // Number of splits counted
+	Depth   int        // Level in the category tree, once rolled up (see RollUp)
+	Sub     bool       // True if the totals include categories under this one
+}
+
+// Net returns the income plus the expense of a category.
+func (ct *CategoryTotal) Net() util.Money {
+	return ct.Income + ct.Expense
+}
+
+// CategoryReport is the income and spending by category, over a range
+// of dates.
+type CategoryReport struct {
+	From       time.Time // Inclusive
+	To         time.Time // Exclusive
+	Aid        uuid.UUID // Only this account, if not zero
+	Lines      []*CategoryTotal
+	Income     util.Money
+	Expense    util.Money
+	NTrans     int        // Number of transactions counted
+	NTransfers int        // Number of transfer sides left out
+	Transfers  util.Money // The money that was moved by them, counted once
+}
+
+// Net returns the income plus the expense of the report.
+func (r *CategoryReport) Net() util.Money {
+	return r.Income + r.Expense
+}
+
+// CategoryReport totals the transactions dated from one day up to (but
+// not including) another, by category.  Either date can be zero for no
+// limit.  If aid is not zero, only that account is counted.  Transfers
+// are left out unless transfers is true.  The lines are by name, with
+// uncategorized last.
+func (v *View) CategoryReport(from, to time.Time, aid uuid.UUID, transfers bool) (*CategoryReport, error) {
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		return nil, fmt.Errorf("Date range is empty (%s to %s).", from.Format("2006-01-02"), to.Format("2006-01-02"))
+	}
+	if !aid.IsZero() && v.accounts[aid] == nil {
+		return nil, fmt.Errorf("No account (%s).", aid)
+	}
+	r := &CategoryReport{From: from, To: to, Aid: aid}
+	bycat := make(map[uuid.UUID]*CategoryTotal, len(v.categories)+1)
+	add := func(cid uuid.UUID, amt util.Money) {
+		ct := bycat[cid]
+		if ct == nil {
+			ct = &CategoryTotal{Cid: cid}
+			if cat := v.categories[cid]; cat != nil {
+				ct.Name = cat.Name
+			}
+			bycat[cid] = ct
+		}
+		ct.N++
+		if amt >= 0 {
+			ct.Income += amt
+			r.Income += amt
+		} else {
+			ct.Expense += amt
+			r.Expense += amt
+		}
+	}
+	v.EachTransaction(func(t *Transaction) {
+		if !aid.IsZero() && t.Aid != aid {
+			return
+		}
+		d := t.Date()
+		if (!from.IsZero() && d.Before(from)) || (!to.IsZero() && !d.Before(to)) {
+			return
+		}
+		if !transfers && v.IsTransfer(t) {
+			r.NTransfers++
+			if t.Amount < 0 {
+				r.Transfers -= t.Amount
+			}
+			return
+		}
+		r.NTrans++
+		left := t.Amount
+		for _, ci := range t.Cats {
+			cid := ci.Cid
+			if v.categories[cid] == nil {
+				cid = uuid.Zero()
+			}
+			add(cid, ci.Amount)
+			left -= ci.Amount
+		}
+		if left != 0 || len(t.Cats) == 0 {
+			add(uuid.Zero(), left)
+		}
+	})
+	for _, ct := range bycat {
+		r.Lines = append(r.Lines, ct)
+	}
+	sort.Slice(r.Lines, func(i, j int) bool {
+		x, y := r.Lines[i], r.Lines[j]
+		if x.Cid.IsZero() != y.Cid.IsZero() {
+			return y.Cid.IsZero()
+		}
+		return strings.ToLower(x.Name) < strings.ToLower(y.Name)
+	})
+	return r, nil
+}
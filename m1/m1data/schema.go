@@ -0,0 +1,117 @@
+// --------------------------------------------------------------------
+// schema.go -- Versions of the data model, and the migrations that
+// upgrade old snapshots to the current model.
+//
+// Created 2020-04-01 DLB
+// --------------------------------------------------------------------
+
+package m1data
+
+import (
+	"bytes"
+	"dbe/lib/log"
+	"encoding/gob"
+	"fmt"
+)
+
+// SchemaVersion is the version of the data model (the Database type and
+// everything in it) used by this code.  It must be incremented whenever
+// the model changes in a way that gob cannot decode on its own (a field
+// is renamed, changes type, or changes meaning), and a migration from the
+// previous version must be added to the migrations table.  Snapshots
+// written before versions were recorded are version 1.
+//
+//	1 -- Accounts, vendors and categories keyed by name.
+//	2 -- Everything keyed by uuid, with references by id.
+const SchemaVersion = 2
+
+// migration upgrades a snapshot payload from one schema version to the
+// next.  The upgrade func is given the gob encoded Database written under version
+// from and must return it encoded under version from+1.  Migrations are
+// applied one after another, so a very old snapshot is upgraded through
+// every version in between.
+type migration struct {
+	from        int
+	description string
+	upgrade     func(payload []byte) ([]byte, error)
+
+	// journalsafe is true if changes journaled under the old version can
+	// be replayed as-is under the new version.  This is normally only
+	// the case when the new version just adds fields.
+	journalsafe bool
+}
+
+// migrations is the registry of all migrations, one for each schema
+// version before the current one.  It is a table, rather than being
+// filled in by init functions, so that it is complete before any
+// snapshot can be read.
+var migrations = []*migration{
+	{from: 1, description: "Give accounts, vendors and categories ids.", upgrade: upgrade_v1},
+}
+
+// find_migration returns the migration from a given version, or nil.
+func find_migration(from int) *migration {
+	for _, m := range migrations {
+		if m.from == from {
+			return m
+		}
+	}
+	return nil
+}
+
+// migrate upgrades a payload from the given schema version to the
+// current one.
+func migrate(payload []byte, version int) ([]byte, error) {
+	if version > SchemaVersion {
+		return nil, fmt.Errorf("Snapshot is schema version %d, which is newer than this server (%d).",
+			version, SchemaVersion)
+	}
+	var err error
+	for v := version; v < SchemaVersion; v++ {
+		m := find_migration(v)
+		if m == nil {
+			return nil, fmt.Errorf("No migration registered from schema version %d.", v)
+		}
+		log.Infof("Applying migration from schema version %d: %s", v, m.description)
+		payload, err = m.upgrade(payload)
+		if err != nil {
+			return nil, fmt.Errorf("Migration from schema version %d failed. Err=%v", v, err)
+		}
+	}
+	return payload, nil
+}
+
+// journal_safe returns true if changes journaled under the given
+// schema version can be replayed under the current version.
+func journal_safe(version int) bool {
+	for v := version; v < SchemaVersion; v++ {
+		m := find_migration(v)
+		if m == nil || !m.journalsafe {
+			return false
+		}
+	}
+	return version <= SchemaVersion
+}
+
+// schema_of returns the schema version recorded in a header or change,
+// where zero means it was written before versions were recorded.
+func schema_of(v int) int {
+	if v == 0 {
+		return 1
+	}
+	return v
+}
+
+// decode_payload and encode_payload are helpers for migrations that
+// can decode the old payload into their own copy of the old types.
+func decode_payload(payload []byte, e interface{}) error {
+	dec := gob.NewDecoder(bytes.NewReader(payload))
+	return dec.Decode(e)
+}
+
+func encode_payload(e interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	err := enc.Encode(e)
+	return buf.Bytes(), err
+}
@@ -0,0 +1,116 @@
+// --------------------------------------------------------------------
+// schema_test.go -- Test upgrading old snapshots to the current schema
+//
+// Created 2020-04-20 DLB
+// --------------------------------------------------------------------
+
+package m1data
+
+import (
+	"dbe/lib/uuid"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// A database at schema version 1.  The second transaction names a
+// vendor and a category that are not in the lists.
+func test_v1_database() *v1Database {
+	d := &v1Database{Accounts: map[string]*v1Account{}, Vendors: map[string]*v1Vendor{},
+		Categories: map[string]*v1Category{}, Transactions: map[uuid.UUID]*v1Transaction{}}
+	d.Accounts["Checking"] = &v1Account{FName: "Checking", Active: true, Aliases: []string{"1234"}}
+	d.Categories["Food"] = &v1Category{Name: "Food", Aliases: []string{"Food"}}
+	d.Vendors["Safeway"] = &v1Vendor{FName: "Safeway", DefaultCat: "Food"}
+	date := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+	t1 := &v1Transaction{Tid: uuid.New(), Amount: -1250, Account: "Checking", Vendor: "Safeway",
+		Cats: []v1CatItem{{Amount: -1250, Category: "Food"}}, DatePosted: date}
+	t2 := &v1Transaction{Tid: uuid.New(), Amount: -4000, Account: "Checking", Vendor: "Shell",
+		Cats: []v1CatItem{{Amount: -4000, Category: "Gas"}}, DatePosted: date}
+	d.Transactions[t1.Tid] = t1
+	d.Transactions[t2.Tid] = t2
+	return d
+}
+
+func Test_UpgradeV1(t *testing.T) {
+	old := test_v1_database()
+	payload, err := encode_payload(old)
+	if err != nil {
+		t.Fatalf("encode_payload fails with Err=%v", err)
+	}
+	// Version 1 snapshots were written without a header.
+	fn := t.TempDir() + "/v1.dat"
+	if err := ioutil.WriteFile(fn, payload, 0664); err != nil {
+		t.Fatalf("Unable to write snapshot. Err=%v", err)
+	}
+	d, info, err := read_file(fn)
+	if err != nil {
+		t.Fatalf("read_file fails with Err=%v", err)
+	}
+	if info.UpgradedFrom != 1 || info.SchemaVersion != SchemaVersion || info.Verified {
+		t.Fatalf("read_file info = %+v, Expected unverified and upgraded from 1", info)
+	}
+	if len(d.Accounts) != 1 || len(d.Vendors) != 2 || len(d.Categories) != 2 || len(d.Transactions) != 2 {
+		t.Fatalf("Upgrade gives %d accounts, %d vendors, %d categories and %d transactions, "+
+			"Expected 1, 2, 2 and 2", len(d.Accounts), len(d.Vendors), len(d.Categories), len(d.Transactions))
+	}
+	for id, a := range d.Accounts {
+		if a.Aid != id || id.IsZero() {
+			t.Fatalf("Account %s has Aid %s", id, a.Aid)
+		}
+	}
+	acc := d.Accounts[d.accountnames["Checking"]]
+	food := d.categorynames["Food"]
+	if acc == nil || food.IsZero() || d.categorynames["Gas"].IsZero() || d.vendornames["Shell"].IsZero() {
+		t.Fatalf("Upgrade lost a name, or did not make the missing vendor and category")
+	}
+	if d.Vendors[d.vendornames["Safeway"]].DefaultCid != food {
+		t.Fatalf("Vendor default category not changed to an id")
+	}
+	for tid, tr := range old.Transactions {
+		tn := d.Transactions[tid]
+		if tn == nil {
+			t.Fatalf("Transaction %s lost in the upgrade", tid)
+		}
+		if tn.Aid != acc.Aid || tn.Vid != d.vendornames[tr.Vendor] || len(tn.Cats) != 1 ||
+			tn.Cats[0].Cid != d.categorynames[tr.Cats[0].Category] || tn.Amount.Cents() != tr.Amount {
+			t.Fatalf("Transaction %s upgraded to %+v, from %+v", tid, tn, tr)
+		}
+	}
+}
+
+func Test_MigrateNewer(t *testing.T) {
+	if _, err := migrate([]byte{}, SchemaVersion+1); err == nil {
+		t.Fatalf("migrate from a newer schema Expected an error")
+	}
+	if journal_safe(SchemaVersion + 1) {
+		t.Fatalf("journal_safe of a newer schema = true, Expected false")
+	}
+	if journal_safe(1) {
+		t.Fatalf("journal_safe(1) = true, Expected false since ids were added")
+	}
+}
+
+// Test_LoadV1 checks that a version 1 snapshot is saved again at the
+// current schema when it is loaded, with the old one kept as the backup.
+func Test_LoadV1(t *testing.T) {
+	test_open(t)
+	payload, err := encode_payload(test_v1_database())
+	if err != nil {
+		t.Fatalf("encode_payload fails with Err=%v", err)
+	}
+	if err := ioutil.WriteFile(datafile, payload, 0664); err != nil {
+		t.Fatalf("Unable to write snapshot. Err=%v", err)
+	}
+	test_restart(t)
+	if GetView().TransactionCount() != 2 || GetAccountByName("Checking") == nil {
+		t.Fatalf("Version 1 snapshot not loaded")
+	}
+	_, info, err := read_file(datafile)
+	if err != nil || info.UpgradedFrom != 0 || !info.Verified {
+		t.Fatalf("Snapshot after load = %+v, Err=%v, Expected verified at schema %d", info, err, SchemaVersion)
+	}
+	_, info, err = read_file(backupfile)
+	if err != nil || info.UpgradedFrom != 1 {
+		t.Fatalf("Backup after load = %+v, Err=%v, Expected the version 1 snapshot", info, err)
+	}
+}
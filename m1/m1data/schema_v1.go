@@ -0,0 +1,173 @@
+// --------------------------------------------------------------------
+// schema_v1.go -- The data model at schema version 1, and the
+// migration to version 2.
+//
+// Created 2020-04-12 DLB
+// --------------------------------------------------------------------
+
+package m1data
+
+import (
+	"dbe/lib/log"
+	"dbe/lib/util"
+	"dbe/lib/uuid"
+	"fmt"
+	"time"
+)
+
+// These types are frozen copies of the data model at schema version 1,
+// where accounts, vendors and categories were keyed by name, and
+// transactions referred to them by name.  They must not be changed.
+
+type v1Database struct {
+	Accounts     map[string]*v1Account
+	Vendors      map[string]*v1Vendor
+	Categories   map[string]*v1Category
+	Transactions map[uuid.UUID]*v1Transaction
+}
+
+type v1Transaction struct {
+	Tid         uuid.UUID
+	Amount      int
+	Account     string
+	Vendor      string
+	Cats        []v1CatItem
+	Description string
+	DatePosted  time.Time
+	DateSettled time.Time
+	Month       time.Time
+	BankInfo    string
+	Location    string
+	CheckNum    string
+	Flag        string
+	Receipts    []string
+	Notes       string
+}
+
+type v1CatItem struct {
+	Amount   int
+	Category string
+	Notes    string
+}
+
+type v1Vendor struct {
+	FName          string
+	DName          string
+	Aliases        []string
+	PrimaryProduct string
+	BusinessType   string
+	DefaultCat     string
+	Notes          string
+}
+
+type v1Category struct {
+	Name    string
+	Aliases []string
+	Notes   string
+}
+
+type v1Account struct {
+	ShortName string
+	DName     string
+	FName     string
+	Notes     string
+	Active    bool
+	Aliases   []string
+}
+
+// upgrade_v1 gives every account, vendor and category a new id, and
+// changes all references by name into references by id.  A reference
+// to a name that doesn't exist gets a new item with that name, so that
+// no information is lost.
+func upgrade_v1(payload []byte) ([]byte, error) {
+	var old v1Database
+	err := decode_payload(payload, &old)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decode version 1 database. Err=%v", err)
+	}
+	d := upgrade_v1_database(&old)
+	return encode_payload(d)
+}
+
+func upgrade_v1_database(old *v1Database) *Database {
+	d := new_database()
+	for _, a := range old.Accounts {
+		d.Accounts[uuid.New()] = &Account{ShortName: a.ShortName, DName: a.DName, FName: a.FName,
+			Notes: a.Notes, Active: a.Active, Aliases: a.Aliases}
+	}
+	for _, c := range old.Categories {
+		d.Categories[uuid.New()] = &Category{Name: c.Name, Aliases: c.Aliases, Notes: c.Notes}
+	}
+	for _, v := range old.Vendors {
+		d.Vendors[uuid.New()] = &Vendor{FName: v.FName, DName: v.DName, Aliases: v.Aliases,
+			PrimaryProduct: v.PrimaryProduct, BusinessType: v.BusinessType, Notes: v.Notes}
+	}
+	set_ids_v1(d)
+	fix_maps(d)
+
+	ncreated := 0
+	catid := func(name string) uuid.UUID {
+		if name == "" {
+			return uuid.Zero()
+		}
+		id, ok := d.categorynames[name]
+		if !ok {
+			id = uuid.New()
+			d.Categories[id] = &Category{Cid: id, Name: name, Aliases: []string{name},
+				Notes: "Created when upgrading from schema version 1."}
+			d.categorynames[name] = id
+			ncreated++
+		}
+		return id
+	}
+	for _, v := range old.Vendors {
+		d.Vendors[d.vendornames[v.FName]].DefaultCid = catid(v.DefaultCat)
+	}
+	for _, t := range old.Transactions {
+		tn := &Transaction{Tid: t.Tid, Amount: util.Money(t.Amount), Description: t.Description,
+			DatePosted: t.DatePosted, DateSettled: t.DateSettled, Month: t.Month, BankInfo: t.BankInfo,
+			Location: t.Location, CheckNum: t.CheckNum, Flag: t.Flag, Receipts: t.Receipts,
+			Notes: t.Notes, Cats: make([]CatItem, 0, len(t.Cats))}
+		aid, ok := d.accountnames[t.Account]
+		if !ok {
+			aid = uuid.New()
+			d.Accounts[aid] = &Account{Aid: aid, FName: t.Account, Aliases: []string{},
+				Notes: "Created when upgrading from schema version 1."}
+			d.accountnames[t.Account] = aid
+			ncreated++
+		}
+		tn.Aid = aid
+		if t.Vendor != "" {
+			vid, ok := d.vendornames[t.Vendor]
+			if !ok {
+				vid = uuid.New()
+				d.Vendors[vid] = &Vendor{Vid: vid, FName: t.Vendor, Aliases: []string{},
+					Notes: "Created when upgrading from schema version 1."}
+				d.vendornames[t.Vendor] = vid
+				ncreated++
+			}
+			tn.Vid = vid
+		}
+		for _, ci := range t.Cats {
+			tn.Cats = append(tn.Cats, CatItem{Amount: util.Money(ci.Amount), Cid: catid(ci.Category), Notes: ci.Notes})
+		}
+		d.Transactions[tn.Tid] = tn
+	}
+	if ncreated > 0 {
+		log.Warnf("%d missing accounts, vendors or categories were created during the upgrade.", ncreated)
+	}
+	return d
+}
+
+// set_ids_v1 copies the map keys into the items.
+func set_ids_v1(d *Database) {
+	for id, a := range d.Accounts {
+		a.Aid = id
+	}
+	for id, v := range d.Vendors {
+		v.Vid = id
+	}
+	for id, c := range d.Categories {
+		c.Cid = id
+	}
+}
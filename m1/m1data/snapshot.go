@@ -0,0 +1,216 @@
+// --------------------------------------------------------------------
+// snapshot.go -- Reads and writes snapshot files of the database.
+//
+// Created 2020-03-29 DLB
+// --------------------------------------------------------------------
+
+package m1data
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"dbe/lib/log"
+	"dbe/lib/util"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// A snapshot file starts with the magic string, followed by the length
+// of the header (4 bytes), the gob encoded header, and then the gob
+// encoded database (the payload).  Files written before the header
+// was introduced are just the payload, and are still readable, but
+// cannot be verified.
+const snap_magic = "M1SNAP\r\n"
+const snap_format_version = 1
+
+// SnapshotInfo describes a snapshot file.  Everything except
+// FileName and Verified is stored in the header of the file.
+type SnapshotInfo struct {
+	FileName      string    // Full path of the file
+	Verified      bool      // True if the checksum and counts were checked
+	FormatVersion int       // Zero for files without a header
+	SchemaVersion int       // Version of the data model when written
+	UpgradedFrom  int       // If not zero, the schema version before migration
+	Saved         time.Time // When the snapshot was written
+	LastSeq       uint64    // Last journal sequence number in the snapshot
+	NAccounts     int
+	NVendors      int
+	NCategories   int
+	NTransactions int
+	PayloadSize   int64
+	Sha256        string // Hex of the sha256 of the payload
+}
+
+// String gives a short description of the snapshot, for reports.
+func (s *SnapshotInfo) String() string {
+	upgraded := ""
+	if s.UpgradedFrom != 0 {
+		upgraded = fmt.Sprintf(", upgraded from schema %d", s.UpgradedFrom)
+	}
+	if s.FormatVersion == 0 {
+		return fmt.Sprintf("%s (old format, no checksum%s)", s.FileName, upgraded)
+	}
+	return fmt.Sprintf("%s (saved %s, %d transactions, checksum ok%s)", s.FileName,
+		s.Saved.Format("2006-01-02 15:04:05"), s.NTransactions, upgraded)
+}
+
+// read_file reads and verifies a snapshot file.
+func read_file(fn string) (*Database, *SnapshotInfo, error) {
+	info := &SnapshotInfo{FileName: fn}
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, info, fmt.Errorf("Unalbe to read database file. Err=%v", err)
+	}
+	payload := b
+	if bytes.HasPrefix(b, []byte(snap_magic)) {
+		b = b[len(snap_magic):]
+		if len(b) < 4 {
+			return nil, info, fmt.Errorf("Snapshot file truncated in header.")
+		}
+		n := binary.LittleEndian.Uint32(b[0:4])
+		b = b[4:]
+		if int64(n) > int64(len(b)) {
+			return nil, info, fmt.Errorf("Snapshot file truncated in header.")
+		}
+		dec := gob.NewDecoder(bytes.NewReader(b[:n]))
+		err = dec.Decode(info)
+		if err != nil {
+			return nil, info, fmt.Errorf("Unable to decode snapshot header. Err=%v", err)
+		}
+		info.FileName = fn
+		payload = b[n:]
+		if int64(len(payload)) != info.PayloadSize {
+			return nil, info, fmt.Errorf("Snapshot payload is %d bytes, header says %d.",
+				len(payload), info.PayloadSize)
+		}
+		sum := sha256.Sum256(payload)
+		if hex.EncodeToString(sum[:]) != info.Sha256 {
+			return nil, info, fmt.Errorf("Snapshot checksum does not match.")
+		}
+	} else {
+		log.Warnf("Snapshot %s is in the old format, and cannot be verified.", fn)
+	}
+	version := schema_of(info.SchemaVersion)
+	info.SchemaVersion = version
+	if version != SchemaVersion {
+		payload, err = migrate(payload, version)
+		if err != nil {
+			return nil, info, err
+		}
+		info.UpgradedFrom = version
+		info.SchemaVersion = SchemaVersion
+		log.Infof("Snapshot %s upgraded from schema version %d to %d.", fn, version, SchemaVersion)
+	}
+	var d Database
+	err = decode_payload(payload, &d)
+	if err != nil {
+		return nil, info, fmt.Errorf("Unable to decode database. Err=%v", err)
+	}
+	fix_maps(&d)
+	if info.FormatVersion > 0 && info.UpgradedFrom == 0 {
+		if len(d.Accounts) != info.NAccounts || len(d.Vendors) != info.NVendors ||
+			len(d.Categories) != info.NCategories || len(d.Transactions) != info.NTransactions {
+			return nil, info, fmt.Errorf("Record counts in snapshot do not match header.")
+		}
+		info.Verified = true
+	} else {
+		// An upgrade can add items, so the counts can only be checked
+		// at the original version.  The checksum has been checked.
+		info.Verified = info.FormatVersion > 0
+		info.NAccounts = len(d.Accounts)
+		info.NVendors = len(d.Vendors)
+		info.NCategories = len(d.Categories)
+		info.NTransactions = len(d.Transactions)
+	}
+	return &d, info, nil
+}
+
+// write_file writes a snapshot of the database to a temporary file,
+// syncs it to the disk, and then renames it into place.  If keep is
+// not blank and a file already exists with the target name, the old
+// file is kept under the name given by keep.
+func write_file(d *Database, lastseq uint64, fn string, keep string) error {
+	payload, err := encode_payload(d)
+	if err != nil {
+		return fmt.Errorf("Unable to encode the database. Err=%v", err)
+	}
+	sum := sha256.Sum256(payload)
+	info := &SnapshotInfo{FormatVersion: snap_format_version, SchemaVersion: SchemaVersion,
+		Saved: time.Now(), LastSeq: lastseq,
+		NAccounts: len(d.Accounts), NVendors: len(d.Vendors), NCategories: len(d.Categories),
+		NTransactions: len(d.Transactions), PayloadSize: int64(len(payload)),
+		Sha256: hex.EncodeToString(sum[:])}
+	var hbuf bytes.Buffer
+	enc := gob.NewEncoder(&hbuf)
+	err = enc.Encode(info)
+	if err != nil {
+		return fmt.Errorf("Unable to encode the snapshot header. Err=%v", err)
+	}
+	hlen := make([]byte, 4)
+	binary.LittleEndian.PutUint32(hlen, uint32(hbuf.Len()))
+
+	tmpfn := fn + ".tmp"
+	f, err := os.OpenFile(tmpfn, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0664)
+	if err != nil {
+		return fmt.Errorf("Unable to create temp file. Err=%v", err)
+	}
+	for _, part := range [][]byte{[]byte(snap_magic), hlen, hbuf.Bytes(), payload} {
+		_, err = f.Write(part)
+		if err != nil {
+			f.Close()
+			os.Remove(tmpfn)
+			return fmt.Errorf("Unable to write data. Err=%v", err)
+		}
+	}
+	err = f.Sync()
+	if err != nil {
+		f.Close()
+		os.Remove(tmpfn)
+		return fmt.Errorf("Unable to sync data. Err=%v", err)
+	}
+	err = f.Close()
+	if err != nil {
+		os.Remove(tmpfn)
+		return fmt.Errorf("Unable to close temp file. Err=%v", err)
+	}
+	if keep != "" && util.FileExists(fn) {
+		// A hard link keeps the old data in place until the new
+		// file is renamed over it.  If links don't work, fall back
+		// to a rename, which leaves a short time with no primary file.
+		os.Remove(keep)
+		err = os.Link(fn, keep)
+		if err != nil {
+			err = os.Rename(fn, keep)
+			if err != nil {
+				os.Remove(tmpfn)
+				return fmt.Errorf("Unable to make way for new data. Err=%v", err)
+			}
+		}
+	}
+	err = os.Rename(tmpfn, fn)
+	if err != nil {
+		os.Remove(tmpfn)
+		return fmt.Errorf("Unable to rename temp file into place. Err=%v", err)
+	}
+	sync_dir(filepath.Dir(fn))
+	return nil
+}
+
+// sync_dir syncs a directory so that renames in it are on the disk.
+func sync_dir(dir string) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	err = f.Sync()
+	if err != nil {
+		log.Warnf("Unable to sync directory %s. Err=%v", dir, err)
+	}
+}
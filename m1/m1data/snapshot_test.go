@@ -0,0 +1,99 @@
+// --------------------------------------------------------------------
+// snapshot_test.go -- Test reading and verifying snapshot files
+//
+// Created 2020-04-20 DLB
+// --------------------------------------------------------------------
+
+package m1data
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// test_snapshot writes a small database to a snapshot file, and
+// returns the name of the file.
+func test_snapshot(t *testing.T) string {
+	t.Helper()
+	test_open(t)
+	aid := test_account(t, "Checking")
+	test_trans(t, aid, "2020-03-01", -1250)
+	test_trans(t, aid, "2020-03-02", 4000)
+	v := GetView()
+	fn := t.TempDir() + "/snap.dat"
+	if err := write_file(v.database(), v.Seq(), fn, ""); err != nil {
+		t.Fatalf("write_file fails with Err=%v", err)
+	}
+	return fn
+}
+
+func Test_SnapshotVerified(t *testing.T) {
+	fn := test_snapshot(t)
+	d, info, err := read_file(fn)
+	if err != nil {
+		t.Fatalf("read_file fails with Err=%v", err)
+	}
+	if !info.Verified || info.SchemaVersion != SchemaVersion || info.LastSeq != GetView().Seq() {
+		t.Fatalf("read_file info = %+v, Expected verified at schema %d", info, SchemaVersion)
+	}
+	if len(d.Accounts) != 1 || len(d.Transactions) != 2 {
+		t.Fatalf("read_file gives %d accounts and %d transactions, Expected 1 and 2", len(d.Accounts),
+			len(d.Transactions))
+	}
+}
+
+func Test_SnapshotRejected(t *testing.T) {
+	fn := test_snapshot(t)
+	good, err := ioutil.ReadFile(fn)
+	if err != nil {
+		t.Fatalf("Unable to read snapshot. Err=%v", err)
+	}
+	tests := []struct {
+		Name   string
+		Damage func(b []byte) []byte
+		Err    string
+	}{
+		{"flipped byte", func(b []byte) []byte { b[len(b)-10] ^= 0xff; return b }, "checksum"},
+		{"truncated payload", func(b []byte) []byte { return b[:len(b)-10] }, "header says"},
+		{"truncated header", func(b []byte) []byte { return b[:len(snap_magic)+6] }, "truncated"},
+		{"no header length", func(b []byte) []byte { return b[:len(snap_magic)+2] }, "truncated"},
+	}
+	for _, x := range tests {
+		b := make([]byte, len(good))
+		copy(b, good)
+		if err := ioutil.WriteFile(fn, x.Damage(b), 0664); err != nil {
+			t.Fatalf("Unable to write snapshot. Err=%v", err)
+		}
+		_, _, err := read_file(fn)
+		if err == nil || !strings.Contains(err.Error(), x.Err) {
+			t.Fatalf("read_file with %s gives Err=%v, Expected %q", x.Name, err, x.Err)
+		}
+	}
+}
+
+// Test_SnapshotBackup checks that the store falls back to the backup
+// file when the primary file is damaged.
+func Test_SnapshotBackup(t *testing.T) {
+	test_open(t)
+	aid := test_account(t, "Checking")
+	tid := test_trans(t, aid, "2020-03-01", -1250)
+	if err := SaveData(); err != nil {
+		t.Fatalf("SaveData fails with Err=%v", err)
+	}
+	if err := SaveData(); err != nil {
+		t.Fatalf("SaveData fails with Err=%v", err)
+	}
+	b, err := ioutil.ReadFile(datafile)
+	if err != nil {
+		t.Fatalf("Unable to read snapshot. Err=%v", err)
+	}
+	b[len(b)-10] ^= 0xff
+	if err := ioutil.WriteFile(datafile, b, 0664); err != nil {
+		t.Fatalf("Unable to write snapshot. Err=%v", err)
+	}
+	test_restart(t)
+	if GetView().Transaction(tid) == nil {
+		t.Fatalf("Transaction not loaded from the backup file")
+	}
+}
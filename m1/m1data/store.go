@@ -0,0 +1,107 @@
+// --------------------------------------------------------------------
+// store.go -- Interface to the place where the database is kept
+// between runs of the server.
+//
+// Created 2020-04-04 DLB
+// --------------------------------------------------------------------
+
+package m1data
+
+import (
+	"dbe/lib/log"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Store keeps the database durable.  The in-memory View is always
+// the working copy that the getters read from.  Every change to it is
+// first handed to the store (as a Change holding accounts, vendors,
+// categories and transactions), and a full copy is handed over when
+// the data is saved or a backup is loaded.  The store is selected with
+// the 'data_store' config parameter.
+type Store interface {
+	// Name gives a short name for the store, for reports.
+	Name() string
+
+	// Exists returns true if the store has data that can be loaded.
+	Exists() bool
+
+	// Open prepares the store for use.  It is called once at startup.
+	Open() error
+
+	// Load reads the entire database from the store.  It returns the
+	// database, the sequence number of the last change it contains, and
+	// information about where the data came from.
+	Load() (*Database, uint64, *SnapshotInfo, error)
+
+	// Commit makes a single change durable.  It is called with dblock
+	// held, before the change is applied to the in-memory database.  If
+	// an error is returned, the change is not applied.
+	Commit(c *Change) error
+
+	// Save replaces everything in the store with the given database,
+	// which contains all changes up to lastseq.  Save is not called with
+	// dblock held, so changes after lastseq can be committed while it
+	// runs, and they must not be lost.
+	Save(d *Database, lastseq uint64) error
+
+	// Close releases the store.
+	Close() error
+}
+
+var gStore Store
+
+// new_store makes the store with the given name.
+func new_store(name, folder string) (Store, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "gob", "file", "files":
+		return &gobstore{}, nil
+	case "sqlite", "sqlite3":
+		return &sqlitestore{filename: folder + rootname + ".sqlite"}, nil
+	}
+	return nil, fmt.Errorf("Unknown data store (%q). Use 'gob' or 'sqlite'.", name)
+}
+
+// GetStoreName returns the name of the store in use.
+func GetStoreName() string {
+	if gStore == nil {
+		return ""
+	}
+	return gStore.Name()
+}
+
+// store_ready returns an error if no store has been opened (see
+// OpenData).
+func store_ready() error {
+	if gStore == nil {
+		return fmt.Errorf("No data store is open.")
+	}
+	return nil
+}
+
+// commit hands a change to the store and then publishes a new view
+// with the change applied.  If the store cannot take the change, the
+// database is not changed.  The items in the change become part of
+// the view, so the caller must not keep them.  A change that would
+// alter a reconciled period is refused (see reconcile.go).  The caller
+// must hold dblock.
+func commit(c *Change) error {
+	if err := store_ready(); err != nil {
+		return err
+	}
+	v := GetView()
+	if err := check_locks(v, c); err != nil {
+		return err
+	}
+	c.Seq = v.seq + 1
+	c.Schema = SchemaVersion
+	c.Time = time.Now()
+	err := gStore.Commit(c)
+	if err != nil {
+		log.Errorf("Change not made. %v", err)
+		return fmt.Errorf("Unable to save change. Err=%v", err)
+	}
+	publish(v.apply(c))
+	return nil
+}
@@ -0,0 +1,132 @@
+// --------------------------------------------------------------------
+// store_gob.go -- Store that keeps the database in a gob snapshot file
+// plus a journal of the changes made since the snapshot.
+//
+// Created 2020-04-04 DLB
+// --------------------------------------------------------------------
+
+package m1data
+
+import (
+	"dbe/lib/log"
+	"dbe/lib/util"
+	"fmt"
+	"time"
+)
+
+type gobstore struct{}
+
+func (s *gobstore) Name() string {
+	return "gob"
+}
+
+func (s *gobstore) Exists() bool {
+	return util.FileExists(datafile) || journal_has_data()
+}
+
+func (s *gobstore) Open() error {
+	log.Infof("Main data file: %s", datafile)
+	return open_journal()
+}
+
+// Load reads the snapshot on the disk and then replays the journal of
+// changes made since that snapshot.  It will try the primary file, and
+// if that fails, it will try the backup file.
+func (s *gobstore) Load() (*Database, uint64, *SnapshotInfo, error) {
+	var d *Database
+	var info *SnapshotInfo
+	var err error
+	if util.FileExists(datafile) {
+		t0 := time.Now()
+		d, info, err = read_file(datafile)
+		telp := time.Now().Sub(t0).Seconds() * 1000.0
+		if err == nil {
+			log.Infof("Database loaded from primary file. (%8.2f ms)", telp)
+		} else {
+			d = nil
+			log.Errorf("Failed to load database from primary file. Err=%v", err)
+			log.Errorf("Primary file name: %s", datafile)
+		}
+	}
+	if d == nil && util.FileExists(backupfile) {
+		t0 := time.Now()
+		d, info, err = read_file(backupfile)
+		telp := time.Now().Sub(t0).Seconds() * 1000.0
+		if err != nil {
+			log.Errorf("Failed to load database from backup file. Err=%v", err)
+			log.Errorf("Backup file name: %s", backupfile)
+			return nil, 0, nil, fmt.Errorf("Unalbe to load database.")
+		}
+		log.Infof("Database loaded from backup file. (%8.2f ms)", telp)
+	}
+	if d == nil {
+		if !journal_has_data() {
+			log.Errorf("No backup file found. Database empty!")
+			log.Errorf("Backup file name: %s", backupfile)
+			return nil, 0, nil, fmt.Errorf("Unable to load database.")
+		}
+		log.Warnf("No snapshot found. Rebuilding database from the journal only.")
+		d = new_database()
+		info = &SnapshotInfo{FileName: journalfile}
+	}
+	log.Infof("Loaded: %s", info)
+	n, lastseq, err := replay_journal(d, info.LastSeq)
+	if err != nil {
+		log.Errorf("Failed to replay journal. Err=%v", err)
+		log.Errorf("Journal file name: %s", journalfile)
+		return nil, 0, info, fmt.Errorf("Unable to replay journal.")
+	}
+	if n > 0 {
+		log.Infof("%d changes replayed from journal.", n)
+	}
+	err = open_journal()
+	if err != nil {
+		return nil, 0, info, err
+	}
+	if info.UpgradedFrom != 0 {
+		// Upgrades can assign new ids, so the upgraded data must be saved
+		// before any change refers to them.  The old snapshot becomes the
+		// backup file.
+		err = s.Save(d, lastseq)
+		if err != nil {
+			return nil, 0, info, fmt.Errorf("Unable to save upgraded database. Err=%v", err)
+		}
+	}
+	return d, lastseq, info, nil
+}
+
+func (s *gobstore) Commit(c *Change) error {
+	return journal_write(c)
+}
+
+// Save writes a snapshot of the database. The previous snapshot is kept
+// as the backup file.  Once the snapshot is written, the changes it
+// holds are removed from the journal.
+func (s *gobstore) Save(d *Database, lastseq uint64) error {
+	t0 := time.Now()
+	err := write_file(d, lastseq, datafile, backupfile)
+	telp := time.Now().Sub(t0).Seconds() * 1000.0
+	if err != nil {
+		log.Errorf("Unable to write to database file (%s). Err=%v", datafile, err)
+		return fmt.Errorf("Unable to save database.")
+	}
+	log.Infof("Database saved to disk. (%8.2f ms)", telp)
+	log.Infof("Location: %s\n", datafile)
+	err = journal_trim(lastseq)
+	if err != nil {
+		log.Errorf("%v", err)
+		return fmt.Errorf("Database saved, but journal not trimmed. Err=%v", err)
+	}
+	return nil
+}
+
+func (s *gobstore) Close() error {
+	jnllock.Lock()
+	defer jnllock.Unlock()
+	if jnl == nil {
+		return nil
+	}
+	err := jnl.Close()
+	jnl = nil
+	return err
+}
@@ -0,0 +1,597 @@
+// --------------------------------------------------------------------
+// store_sqlite.go -- Store that keeps the database in an embedded
+// SQLite file.
+//
+// Created 2020-04-05 DLB
+// --------------------------------------------------------------------
+
+package m1data
+
+import (
+	"database/sql"
+	"dbe/lib/log"
+	"dbe/lib/util"
+	"dbe/lib/uuid"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Each table holds one kind of item.  The full item is kept as json
+// in the Data column, and the columns that are useful for queries are
+// copied out next to it.  The Meta table holds the schema version and
+// the sequence number of the last change committed.  The item tables
+// follow the schema version, and are made again when the data is
+// upgraded.
+var sqlite_meta_table string = `create table if not exists Meta(
+		Key text primary key,
+		Value text)`
+
+var sqlite_item_tables []string = []string{"Accounts", "Vendors", "Categories", "Transactions", "Reviews", "Batches", "Recons", "Budgets"}
+
+var sqlite_tables []string = []string{
+	`create table if not exists Accounts(
+		Aid text primary key,
+		Name text,
+		Data text)`,
+	`create table if not exists Vendors(
+		Vid text primary key,
+		Name text,
+		Data text)`,
+	`create table if not exists Categories(
+		Cid text primary key,
+		Name text,
+		Data text)`,
+	`create table if not exists Transactions(
+		Tid text primary key,
+		Aid text,
+		Vid text,
+		Date text,
+		Amount integer,
+		Data text)`,
+	`create table if not exists Reviews(
+		Rid text primary key,
+		Data text)`,
+	`create table if not exists Batches(
+		Bid text primary key,
+		Data text)`,
+	`create table if not exists Recons(
+		RecId text primary key,
+		Data text)`,
+	`create table if not exists Budgets(
+		BudId text primary key,
+		Data text)`,
+	`create index if not exists AccountName on Accounts(Name)`,
+	`create index if not exists VendorName on Vendors(Name)`,
+	`create index if not exists CategoryName on Categories(Name)`,
+	`create index if not exists TransAccount on Transactions(Aid)`,
+	`create index if not exists TransVendor on Transactions(Vid)`,
+	`create index if not exists TransDate on Transactions(Date)`,
+}
+
+type sqlitestore struct {
+	filename string
+	sdb      *sql.DB
+	lock     sync.Mutex // Orders Commit and Save
+	lastseq  uint64     // Sequence number of the last change in the file
+}
+
+func (s *sqlitestore) Name() string {
+	return "sqlite"
+}
+
+func (s *sqlitestore) Exists() bool {
+	if !util.FileExists(s.filename) || s.sdb == nil {
+		return false
+	}
+	v, err := s.get_meta(s.sdb, "Schema")
+	return err == nil && v != ""
+}
+
+func (s *sqlitestore) Open() error {
+	var err error
+	log.Infof("SQLite data file: %s", s.filename)
+	s.sdb, err = sql.Open("sqlite3", "file:"+s.filename+"?_journal_mode=WAL&_synchronous=FULL&_foreign_keys=off")
+	if err != nil {
+		return fmt.Errorf("Unable to open sqlite file. Err=%v", err)
+	}
+	// SQLite allows only one writer, and we serialize writes with dblock
+	// anyway.  One connection avoids 'database is locked' errors.
+	s.sdb.SetMaxOpenConns(1)
+	_, err = s.sdb.Exec(sqlite_meta_table)
+	if err != nil {
+		return fmt.Errorf("Unable to create sqlite tables. Err=%v", err)
+	}
+	sv, err := s.get_meta(s.sdb, "Schema")
+	if err != nil {
+		return err
+	}
+	if sv != "" && sv != fmt.Sprintf("%d", SchemaVersion) {
+		// The tables are from an older schema, and are made again
+		// when the data is upgraded in Load.
+		return nil
+	}
+	for _, stmt := range sqlite_tables {
+		_, err = s.sdb.Exec(stmt)
+		if err != nil {
+			return fmt.Errorf("Unable to create sqlite tables. Err=%v", err)
+		}
+	}
+	return nil
+}
+
+// Load reads every table into a new database.
+func (s *sqlitestore) Load() (*Database, uint64, *SnapshotInfo, error) {
+	t0 := time.Now()
+	info := &SnapshotInfo{FileName: s.filename}
+	sv, err := s.get_meta(s.sdb, "Schema")
+	if err != nil {
+		return nil, 0, info, err
+	}
+	version, _ := strconv.Atoi(sv)
+	info.SchemaVersion = version
+	if version > SchemaVersion {
+		return nil, 0, info, fmt.Errorf("SQLite data is schema version %d, which is newer than this server (%d).",
+			version, SchemaVersion)
+	}
+	sseq, err := s.get_meta(s.sdb, "LastSeq")
+	if err != nil {
+		return nil, 0, info, err
+	}
+	info.LastSeq, _ = strconv.ParseUint(sseq, 10, 64)
+	ssaved, _ := s.get_meta(s.sdb, "Saved")
+	info.Saved, _ = time.Parse(time.RFC3339, ssaved)
+
+	var check string
+	err = s.sdb.QueryRow("pragma integrity_check").Scan(&check)
+	if err != nil || check != "ok" {
+		return nil, 0, info, fmt.Errorf("SQLite integrity check failed (%s). Err=%v", check, err)
+	}
+	info.Verified = true
+
+	var d *Database
+	if version < SchemaVersion {
+		d, err = s.load_old(version)
+	} else {
+		d, err = s.load_current()
+	}
+	if err != nil {
+		return nil, 0, info, err
+	}
+	if version < SchemaVersion {
+		info.UpgradedFrom = version
+		info.SchemaVersion = SchemaVersion
+		err = s.Save(d, info.LastSeq)
+		if err != nil {
+			return nil, 0, info, fmt.Errorf("Unable to save upgraded data. Err=%v", err)
+		}
+		log.Infof("SQLite data upgraded from schema version %d to %d.", version, SchemaVersion)
+	}
+	info.NAccounts = len(d.Accounts)
+	info.NVendors = len(d.Vendors)
+	info.NCategories = len(d.Categories)
+	info.NTransactions = len(d.Transactions)
+	telp := time.Now().Sub(t0).Seconds() * 1000.0
+	log.Infof("Database loaded from sqlite. (%8.2f ms)", telp)
+	s.lastseq = info.LastSeq
+	return d, info.LastSeq, info, nil
+}
+
+// load_current reads every table at the current schema.
+func (s *sqlitestore) load_current() (*Database, error) {
+	d := new_database()
+	err := s.load_table("Accounts", func(data []byte) error {
+		var a Account
+		err := json.Unmarshal(data, &a)
+		d.Accounts[a.Aid] = &a
+		return err
+	})
+	if err == nil {
+		err = s.load_table("Vendors", func(data []byte) error {
+			var v Vendor
+			err := json.Unmarshal(data, &v)
+			d.Vendors[v.Vid] = &v
+			return err
+		})
+	}
+	if err == nil {
+		err = s.load_table("Categories", func(data []byte) error {
+			var c Category
+			err := json.Unmarshal(data, &c)
+			d.Categories[c.Cid] = &c
+			return err
+		})
+	}
+	if err == nil {
+		err = s.load_table("Transactions", func(data []byte) error {
+			var t Transaction
+			err := json.Unmarshal(data, &t)
+			d.Transactions[t.Tid] = &t
+			return err
+		})
+	}
+	if err == nil {
+		err = s.load_table("Reviews", func(data []byte) error {
+			var r DupReview
+			err := json.Unmarshal(data, &r)
+			d.Reviews[r.Rid] = &r
+			return err
+		})
+	}
+	if err == nil {
+		err = s.load_table("Batches", func(data []byte) error {
+			var b ImportBatch
+			err := json.Unmarshal(data, &b)
+			d.Batches[b.Bid] = &b
+			return err
+		})
+	}
+	if err == nil {
+		err = s.load_table("Recons", func(data []byte) error {
+			var r Reconciliation
+			err := json.Unmarshal(data, &r)
+			d.Recons[r.RecId] = &r
+			return err
+		})
+	}
+	if err == nil {
+		err = s.load_table("Budgets", func(data []byte) error {
+			var b Budget
+			err := json.Unmarshal(data, &b)
+			d.Budgets[b.BudId] = &b
+			return err
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	fix_maps(d)
+	return d, nil
+}
+
+// load_old reads the tables written under an older schema, and
+// upgrades the data to the current schema.  The old rows are read into
+// the frozen types of that version, and then run through the same
+// migrations as a snapshot file.
+func (s *sqlitestore) load_old(version int) (*Database, error) {
+	var old interface{}
+	var err error
+	switch version {
+	case 1:
+		d1 := &v1Database{Accounts: make(map[string]*v1Account), Vendors: make(map[string]*v1Vendor),
+			Categories: make(map[string]*v1Category), Transactions: make(map[uuid.UUID]*v1Transaction)}
+		err = s.load_table("Accounts", func(data []byte) error {
+			var a v1Account
+			err := json.Unmarshal(data, &a)
+			d1.Accounts[a.FName] = &a
+			return err
+		})
+		if err == nil {
+			err = s.load_table("Vendors", func(data []byte) error {
+				var v v1Vendor
+				err := json.Unmarshal(data, &v)
+				d1.Vendors[v.FName] = &v
+				return err
+			})
+		}
+		if err == nil {
+			err = s.load_table("Categories", func(data []byte) error {
+				var c v1Category
+				err := json.Unmarshal(data, &c)
+				d1.Categories[c.Name] = &c
+				return err
+			})
+		}
+		if err == nil {
+			err = s.load_table("Transactions", func(data []byte) error {
+				var t v1Transaction
+				err := json.Unmarshal(data, &t)
+				d1.Transactions[t.Tid] = &t
+				return err
+			})
+		}
+		old = d1
+	default:
+		return nil, fmt.Errorf("No way to read sqlite data at schema version %d.", version)
+	}
+	if err != nil {
+		return nil, err
+	}
+	payload, err := encode_payload(old)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to encode old data. Err=%v", err)
+	}
+	payload, err = migrate(payload, version)
+	if err != nil {
+		return nil, err
+	}
+	var d Database
+	err = decode_payload(payload, &d)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decode upgraded data. Err=%v", err)
+	}
+	fix_maps(&d)
+	return &d, nil
+}
+
+func (s *sqlitestore) load_table(table string, f func(data []byte) error) error {
+	rows, err := s.sdb.Query("select Data from " + table)
+	if err != nil {
+		return fmt.Errorf("Unable to read %s from sqlite. Err=%v", table, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var data []byte
+		err = rows.Scan(&data)
+		if err != nil {
+			return fmt.Errorf("Unable to scan row in %s. Err=%v", table, err)
+		}
+		err = f(data)
+		if err != nil {
+			return fmt.Errorf("Unable to decode row in %s. Err=%v", table, err)
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return fmt.Errorf("Error iterating rows in %s. Err=%v", table, err)
+	}
+	return nil
+}
+
+// Commit writes all the items of a change in one sql transaction.
+func (s *sqlitestore) Commit(c *Change) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	tx, err := s.sdb.Begin()
+	if err != nil {
+		return fmt.Errorf("Unable to begin sqlite transaction. Err=%v", err)
+	}
+	err = s.put_change(tx, c)
+	if err == nil {
+		err = s.set_meta(tx, "LastSeq", fmt.Sprintf("%d", c.Seq))
+	}
+	if err != nil {
+		attempt_rollback(tx, "Commit of change failed.")
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("Sqlite commit failed. Err=%v", err)
+	}
+	s.lastseq = c.Seq
+	return nil
+}
+
+// Save empties every table and writes the whole database into them,
+// in one sql transaction.  Every change is already in the file when
+// it is committed, so if the file has changes after lastseq, it is
+// newer than the database given and is left alone.
+func (s *sqlitestore) Save(d *Database, lastseq uint64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if lastseq < s.lastseq {
+		log.Infof("SQLite already has changes up to %d. Nothing to save.", s.lastseq)
+		return nil
+	}
+	t0 := time.Now()
+	tx, err := s.sdb.Begin()
+	if err != nil {
+		return fmt.Errorf("Unable to begin sqlite transaction. Err=%v", err)
+	}
+	// The tables are made again, in case they are from an older schema.
+	for _, table := range sqlite_item_tables {
+		_, err = tx.Exec("drop table if exists " + table)
+		if err != nil {
+			attempt_rollback(tx, "Unable to drop table.")
+			return fmt.Errorf("Unable to drop %s. Err=%v", table, err)
+		}
+	}
+	for _, stmt := range sqlite_tables {
+		_, err = tx.Exec(stmt)
+		if err != nil {
+			attempt_rollback(tx, "Unable to create table.")
+			return fmt.Errorf("Unable to create sqlite tables. Err=%v", err)
+		}
+	}
+	c := &Change{}
+	for _, a := range d.Accounts {
+		c.Accounts = append(c.Accounts, a)
+	}
+	for _, v := range d.Vendors {
+		c.Vendors = append(c.Vendors, v)
+	}
+	for _, cat := range d.Categories {
+		c.Categories = append(c.Categories, cat)
+	}
+	for _, t := range d.Transactions {
+		c.Transactions = append(c.Transactions, t)
+	}
+	for _, r := range d.Reviews {
+		c.Reviews = append(c.Reviews, r)
+	}
+	for _, b := range d.Batches {
+		c.Batches = append(c.Batches, b)
+	}
+	for _, r := range d.Recons {
+		c.Recons = append(c.Recons, r)
+	}
+	for _, b := range d.Budgets {
+		c.Budgets = append(c.Budgets, b)
+	}
+	err = s.put_change(tx, c)
+	if err == nil {
+		err = s.set_meta(tx, "Schema", fmt.Sprintf("%d", SchemaVersion))
+	}
+	if err == nil {
+		err = s.set_meta(tx, "LastSeq", fmt.Sprintf("%d", lastseq))
+	}
+	if err == nil {
+		err = s.set_meta(tx, "Saved", time.Now().Format(time.RFC3339))
+	}
+	if err != nil {
+		attempt_rollback(tx, "Save of database failed.")
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("Sqlite commit failed. Err=%v", err)
+	}
+	s.lastseq = lastseq
+	telp := time.Now().Sub(t0).Seconds() * 1000.0
+	log.Infof("Database saved to sqlite. (%8.2f ms)", telp)
+	return nil
+}
+
+func (s *sqlitestore) Close() error {
+	if s.sdb == nil {
+		return nil
+	}
+	err := s.sdb.Close()
+	s.sdb = nil
+	return err
+}
+
+func (s *sqlitestore) put_change(tx *sql.Tx, c *Change) error {
+	if schema, _ := s.get_meta(tx, "Schema"); schema == "" {
+		err := s.set_meta(tx, "Schema", fmt.Sprintf("%d", SchemaVersion))
+		if err != nil {
+			return err
+		}
+	}
+	dels := []struct {
+		table, key string
+		ids        []uuid.UUID
+	}{{"Accounts", "Aid", c.DelAccounts}, {"Vendors", "Vid", c.DelVendors},
+		{"Categories", "Cid", c.DelCategories}, {"Transactions", "Tid", c.DelTransactions},
+		{"Reviews", "Rid", c.DelReviews}, {"Batches", "Bid", c.DelBatches},
+		{"Recons", "RecId", c.DelRecons}, {"Budgets", "BudId", c.DelBudgets}}
+	for _, del := range dels {
+		for _, id := range del.ids {
+			_, err := tx.Exec("delete from "+del.table+" where "+del.key+"=?", id.String())
+			if err != nil {
+				return fmt.Errorf("Unable to delete %s from %s. Err=%v", id, del.table, err)
+			}
+		}
+	}
+	for _, a := range c.Accounts {
+		data, err := json.Marshal(a)
+		if err == nil {
+			_, err = tx.Exec("insert or replace into Accounts(Aid, Name, Data) values(?, ?, ?)",
+				a.Aid.String(), a.FName, data)
+		}
+		if err != nil {
+			return fmt.Errorf("Unable to write account %q. Err=%v", a.FName, err)
+		}
+	}
+	for _, v := range c.Vendors {
+		data, err := json.Marshal(v)
+		if err == nil {
+			_, err = tx.Exec("insert or replace into Vendors(Vid, Name, Data) values(?, ?, ?)",
+				v.Vid.String(), v.FName, data)
+		}
+		if err != nil {
+			return fmt.Errorf("Unable to write vendor %q. Err=%v", v.FName, err)
+		}
+	}
+	for _, cat := range c.Categories {
+		data, err := json.Marshal(cat)
+		if err == nil {
+			_, err = tx.Exec("insert or replace into Categories(Cid, Name, Data) values(?, ?, ?)",
+				cat.Cid.String(), cat.Name, data)
+		}
+		if err != nil {
+			return fmt.Errorf("Unable to write category %q. Err=%v", cat.Name, err)
+		}
+	}
+	if len(c.Transactions) > 0 {
+		stmt, err := tx.Prepare("insert or replace into Transactions(Tid, Aid, Vid, Date, Amount, Data)" +
+			" values(?, ?, ?, ?, ?, ?)")
+		if err != nil {
+			return fmt.Errorf("Unable to prepare transaction insert. Err=%v", err)
+		}
+		defer stmt.Close()
+		for _, t := range c.Transactions {
+			data, err := json.Marshal(t)
+			if err == nil {
+				_, err = stmt.Exec(t.Tid.String(), t.Aid.String(), t.Vid.String(), t.Date().Format("2006-01-02"),
+					t.Amount.Cents(), data)
+			}
+			if err != nil {
+				return fmt.Errorf("Unable to write transaction %s. Err=%v", t.Tid, err)
+			}
+		}
+	}
+	for _, r := range c.Reviews {
+		data, err := json.Marshal(r)
+		if err == nil {
+			_, err = tx.Exec("insert or replace into Reviews(Rid, Data) values(?, ?)", r.Rid.String(), data)
+		}
+		if err != nil {
+			return fmt.Errorf("Unable to write duplicate review %s. Err=%v", r.Rid, err)
+		}
+	}
+	for _, b := range c.Batches {
+		data, err := json.Marshal(b)
+		if err == nil {
+			_, err = tx.Exec("insert or replace into Batches(Bid, Data) values(?, ?)", b.Bid.String(), data)
+		}
+		if err != nil {
+			return fmt.Errorf("Unable to write import batch %s. Err=%v", b.Bid, err)
+		}
+	}
+	for _, r := range c.Recons {
+		data, err := json.Marshal(r)
+		if err == nil {
+			_, err = tx.Exec("insert or replace into Recons(RecId, Data) values(?, ?)", r.RecId.String(), data)
+		}
+		if err != nil {
+			return fmt.Errorf("Unable to write reconciliation %s. Err=%v", r.RecId, err)
+		}
+	}
+	for _, b := range c.Budgets {
+		data, err := json.Marshal(b)
+		if err == nil {
+			_, err = tx.Exec("insert or replace into Budgets(BudId, Data) values(?, ?)", b.BudId.String(), data)
+		}
+		if err != nil {
+			return fmt.Errorf("Unable to write budget %s. Err=%v", b.BudId, err)
+		}
+	}
+	return nil
+}
+
+// sqlquerier is either a *sql.DB or a *sql.Tx.
+type sqlquerier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func (s *sqlitestore) get_meta(q sqlquerier, key string) (string, error) {
+	var v string
+	err := q.QueryRow("select Value from Meta where Key=?", key).Scan(&v)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("Unable to read %s from Meta. Err=%v", key, err)
+	}
+	return v, nil
+}
+
+func (s *sqlitestore) set_meta(tx *sql.Tx, key, value string) error {
+	_, err := tx.Exec("insert or replace into Meta(Key, Value) values(?, ?)", key, value)
+	if err != nil {
+		return fmt.Errorf("Unable to write %s to Meta. Err=%v", key, err)
+	}
+	return nil
+}
+
+func attempt_rollback(tx *sql.Tx, reason string) {
+	err := tx.Rollback()
+	if err != nil {
+		log.Errorf("Unable to rollback. Err=%v. Reason for Rollback: %s", err, reason)
+	}
+}
@@ -0,0 +1,282 @@
+// --------------------------------------------------------------------
+// transfer.go -- Links the two sides of a transfer between our own
+// accounts, and finds the pairs that are likely transfers.
+//
+// Created 2020-04-20 DLB
+// --------------------------------------------------------------------
+
+package m1data
+
+import (
+	"dbe/lib/uuid"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Money moved between two of our own accounts, such as a card payment
+// from checking, shows up twice: once going out of one account and once
+// coming into the other.  Neither side is income or spending, so the
+// two are linked as a transfer (each has the Tid of the other in Xfer),
+// and the reports leave them out.  A link is only trusted if it goes
+// both ways, so a side that is deleted, as when an import is rolled
+// back, simply leaves the other side unlinked.
+//
+// FindTransfers looks for likely pairs among the transactions not yet
+// linked: opposite amounts in two different accounts, dated no more than
+// TransferDateWindow days apart.  Each pair is given a score, and the
+// best pairs are taken first, so that each transaction is in only one.
+
+// TransferDateWindow is the most number of days apart that the two
+// sides of a transfer can be and still be found by FindTransfers.
+// Payments to a card can take several days to post.
+const TransferDateWindow = 5
+
+// transfer_words are the words in a description that hint at a
+// transfer.
+var transfer_words = []string{"transfer", "xfer", "payment", "pmt", "autopay", "epay", "thank"}
+
+// TransferMatch is a likely transfer found by FindTransfers.
+type TransferMatch struct {
+	From    *Transaction // The side where the money went out
+	To      *Transaction // The side where it came in
+	Days    int          // Days between the two
+	Score   int
+	Reasons []string
+}
+
+// TransferOf returns the other side of a transfer, or nil if the
+// transaction is not linked to one.
+func (v *View) TransferOf(t *Transaction) *Transaction {
+	if t.Xfer.IsZero() {
+		return nil
+	}
+	o := v.Transaction(t.Xfer)
+	if o == nil || o.Xfer != t.Tid {
+		return nil
+	}
+	return o
+}
+
+// IsTransfer returns true if a transaction is one side of a transfer.
+func (v *View) IsTransfer(t *Transaction) bool {
+	return v.TransferOf(t) != nil
+}
+
+// FindTransfers returns the likely transfers between transactions that
+// are not yet linked, best first, with each transaction in at most one
+// of them.  The sides must be no more than days apart; if days is zero
+// or less, TransferDateWindow is used.
+func (v *View) FindTransfers(days int) []*TransferMatch {
+	if days <= 0 {
+		days = TransferDateWindow
+	}
+	all := make([]*TransferMatch, 0, 100)
+	v.EachTransaction(func(t *Transaction) {
+		if t.Amount >= 0 || v.IsTransfer(t) {
+			return
+		}
+		for aid := range v.accounts {
+			if aid == t.Aid {
+				continue
+			}
+			key := aid.String() + ":" + strconv.Itoa(-t.Amount.Cents())
+			for _, o := range v.byamount.get(key) {
+				if v.IsTransfer(o) {
+					continue
+				}
+				if m := v.transfer_score(t, o, days); m != nil {
+					all = append(all, m)
+				}
+			}
+		}
+	})
+	sort.Slice(all, func(i, j int) bool {
+		x, y := all[i], all[j]
+		if x.Score != y.Score {
+			return x.Score > y.Score
+		}
+		if !x.From.Date().Equal(y.From.Date()) {
+			return x.From.Date().Before(y.From.Date())
+		}
+		if x.From.Tid != y.From.Tid {
+			return x.From.Tid.String() < y.From.Tid.String()
+		}
+		return x.To.Tid.String() < y.To.Tid.String()
+	})
+	used := make(map[uuid.UUID]bool, 2*len(all))
+	lst := make([]*TransferMatch, 0, len(all))
+	for _, m := range all {
+		if used[m.From.Tid] || used[m.To.Tid] {
+			continue
+		}
+		used[m.From.Tid] = true
+		used[m.To.Tid] = true
+		lst = append(lst, m)
+	}
+	sort.SliceStable(lst, func(i, j int) bool { return lst[i].From.Date().Before(lst[j].From.Date()) })
+	return lst
+}
+
+// transfer_score scores how likely it is that money going out in one
+// transaction came into another.  Nil is returned if their dates are
+// too far apart.
+func (v *View) transfer_score(from, to *Transaction, window int) *TransferMatch {
+	days := int(from.Date().Sub(to.Date()).Hours() / 24)
+	if days < 0 {
+		days = -days
+	}
+	if days > window {
+		return nil
+	}
+	m := &TransferMatch{From: from, To: to, Days: days, Reasons: make([]string, 0, 4)}
+	m.Score = 50
+	m.Reasons = append(m.Reasons, "Opposite amounts.")
+	if days == 0 {
+		m.Reasons = append(m.Reasons, "Same date.")
+	} else {
+		m.Reasons = append(m.Reasons, fmt.Sprintf("Dates %d days apart.", days))
+	}
+	m.Score += 20 - 4*days
+	if names_account(from, v.accounts[to.Aid]) || names_account(to, v.accounts[from.Aid]) {
+		m.Score += 30
+		m.Reasons = append(m.Reasons, "Names the other account.")
+	} else if has_transfer_word(from.Description) || has_transfer_word(to.Description) {
+		m.Score += 15
+		m.Reasons = append(m.Reasons, "Description reads like a transfer.")
+	}
+	if m.Score > 100 {
+		m.Score = 100
+	}
+	return m
+}
+
+// names_account returns true if a transaction's description, or a
+// transfer split from a QIF file, names an account.
+func names_account(t *Transaction, a *Account) bool {
+	if a == nil {
+		return false
+	}
+	names := append([]string{a.ShortName, a.DName, a.FName}, a.Aliases...)
+	desc := " " + strings.Join(desc_words(t.Description), " ") + " "
+	for _, ci := range t.Cats {
+		if strings.HasPrefix(ci.Notes, "Transfer: ") {
+			desc += strings.Join(desc_words(ci.Notes), " ") + " "
+		}
+	}
+	for _, n := range names {
+		w := strings.Join(desc_words(n), " ")
+		if len(w) >= 3 && strings.Contains(desc, " "+w+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+// has_transfer_word returns true if a description has one of the
+// transfer_words.
+func has_transfer_word(s string) bool {
+	for _, w := range desc_words(s) {
+		for _, x := range transfer_words {
+			if w == x {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// LinkTransfer links two transactions as the sides of a transfer.  They
+// must be in different accounts, with opposite amounts, and neither can
+// already be linked to another.
+func LinkTransfer(tid1, tid2 uuid.UUID) error {
+	dblock.Lock()
+	defer dblock.Unlock()
+	cur := GetView()
+	c, err := link_transfer(cur, tid1, tid2)
+	if err != nil {
+		return err
+	}
+	return commit(c)
+}
+
+// LinkTransfers links the pairs found by FindTransfers, as a single
+// change.  The number linked is returned.
+func LinkTransfers(lst []*TransferMatch) (int, error) {
+	dblock.Lock()
+	defer dblock.Unlock()
+	cur := GetView()
+	c := &Change{Transactions: make([]*Transaction, 0, 2*len(lst))}
+	seen := make(map[uuid.UUID]bool, 2*len(lst))
+	for _, m := range lst {
+		if seen[m.From.Tid] || seen[m.To.Tid] {
+			return 0, fmt.Errorf("Transaction %s is in more than one transfer.", describe_transaction(m.From))
+		}
+		seen[m.From.Tid] = true
+		seen[m.To.Tid] = true
+		cx, err := link_transfer(cur, m.From.Tid, m.To.Tid)
+		if err != nil {
+			return 0, err
+		}
+		c.Transactions = append(c.Transactions, cx.Transactions...)
+	}
+	if len(c.Transactions) == 0 {
+		return 0, nil
+	}
+	if err := commit(c); err != nil {
+		return 0, err
+	}
+	return len(lst), nil
+}
+
+// link_transfer returns the change that links two transactions.
+func link_transfer(cur *View, tid1, tid2 uuid.UUID) (*Change, error) {
+	t1, t2 := cur.Transaction(tid1), cur.Transaction(tid2)
+	if t1 == nil {
+		return nil, fmt.Errorf("No transaction (%s).", tid1)
+	}
+	if t2 == nil {
+		return nil, fmt.Errorf("No transaction (%s).", tid2)
+	}
+	if t1.Aid == t2.Aid {
+		return nil, fmt.Errorf("Both sides of a transfer are in account %s.", account_name(cur, t1.Aid))
+	}
+	if t1.Amount != -t2.Amount || t1.Amount == 0 {
+		return nil, fmt.Errorf("The amounts of a transfer must be opposite (%s and %s).", t1.Amount, t2.Amount)
+	}
+	for _, t := range []*Transaction{t1, t2} {
+		if o := cur.TransferOf(t); o != nil && o.Tid != tid1 && o.Tid != tid2 {
+			return nil, fmt.Errorf("Transaction %s is already a transfer with %s. Unlink it first.",
+				describe_transaction(t), describe_transaction(o))
+		}
+	}
+	tc1, tc2 := *t1, *t2
+	tc1.Xfer = tid2
+	tc2.Xfer = tid1
+	return &Change{Transactions: []*Transaction{&tc1, &tc2}}, nil
+}
+
+// UnlinkTransfer undoes a transfer, given either side.  Both sides are
+// kept, as they were, but are no longer linked.
+func UnlinkTransfer(tid uuid.UUID) error {
+	dblock.Lock()
+	defer dblock.Unlock()
+	cur := GetView()
+	t := cur.Transaction(tid)
+	if t == nil {
+		return fmt.Errorf("No transaction (%s).", tid)
+	}
+	if t.Xfer.IsZero() {
+		return fmt.Errorf("Transaction %s is not a transfer.", describe_transaction(t))
+	}
+	tc := *t
+	tc.Xfer = uuid.Zero()
+	c := &Change{Transactions: []*Transaction{&tc}}
+	if o := cur.TransferOf(t); o != nil {
+		oc := *o
+		oc.Xfer = uuid.Zero()
+		c.Transactions = append(c.Transactions, &oc)
+	}
+	return commit(c)
+}
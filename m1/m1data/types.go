@@ -7,24 +7,37 @@
 package m1data
 
 import (
+	"dbe/lib/util"
 	"dbe/lib/uuid"
 	"time"
 )
 
-// Database is the entire database for the m1 project
+// Database is the entire database for the m1 project.  Everything is
+// keyed by its id.  Names can be changed at any time, so they are only
+// used to look items up, through the name indexes.
 type Database struct {
-	Accounts     map[string]*Account
-	Vendors      map[string]*Vendor
-	Categories   map[string]*Category
+	Accounts     map[uuid.UUID]*Account
+	Vendors      map[uuid.UUID]*Vendor
+	Categories   map[uuid.UUID]*Category
 	Transactions map[uuid.UUID]*Transaction
+	Reviews      map[uuid.UUID]*DupReview   // Possible duplicates, waiting to be reviewed
+	Batches      map[uuid.UUID]*ImportBatch // Imports, staged or committed
+	Recons       map[uuid.UUID]*Reconciliation
+	Budgets      map[uuid.UUID]*Budget
+
+	// Name indexes.  These are not saved, but are rebuilt by fix_maps
+	// and kept up to date by apply_change.
+	accountnames  map[string]uuid.UUID // By FName
+	vendornames   map[string]uuid.UUID // By FName
+	categorynames map[string]uuid.UUID // By Name
 }
 
 // Transaction is the basic data item for m1
 type Transaction struct {
 	Tid         uuid.UUID // To uniquely id this transaction
-	Amount      int       // In cents
-	Account     string    // Points to FName in Accounts map
-	Vendor      string    // Points to FName in Vendors map
+	Amount      util.Money
+	Aid         uuid.UUID // Points to Accounts map
+	Vid         uuid.UUID // Points to Vendors map, or zero if unknown
 	Cats        []CatItem // Can be empty but not nil
 	Description string
 	DatePosted  time.Time
@@ -33,47 +46,213 @@ type Transaction struct {
 	BankInfo    string
 	Location    string
 	CheckNum    string
+	FitId       string // Id given by the bank in a download (OFX FITID), or blank
 	Flag        string
 	Receipts    []string // Urls to receipt files (images, pdfs, etc.)
 	Notes       string
+	ImportId    uuid.UUID // The ImportBatch that added this transaction, or zero
+	Cleared     string    // One of the Cleared_ values
+	RecId       uuid.UUID // The Reconciliation that reconciled this transaction, or zero
+	Xfer        uuid.UUID // The other side of a transfer between accounts, or zero
+}
+
+// Cleared state of a Transaction.  A transaction is cleared when it is
+// checked off against a statement, and reconciled when the statement
+// is finished (see reconcile.go).  A reconciled transaction is locked.
+const (
+	Cleared_No         = ""
+	Cleared_Yes        = "cleared"
+	Cleared_Reconciled = "reconciled"
+)
+
+// Reconciliation is the checking of one account against one statement
+// from the bank.  While it is open, the transactions on the statement
+// are marked cleared, until the cleared balance matches the ending
+// balance of the statement.  Then it is finished, its cleared
+// transactions become reconciled, and the account is locked through
+// the end of the statement.
+type Reconciliation struct {
+	RecId    uuid.UUID
+	Aid      uuid.UUID
+	Month    time.Time  // The statement month
+	End      time.Time  // Closing date of the statement
+	StartBal util.Money // Reconciled balance before this statement
+	EndBal   util.Money // Ending balance on the statement
+	Status   string     // One of the Recon_ values
+	User     string     // Who started it
+	Created  time.Time
+	Finished time.Time
+	NCleared int // Transactions reconciled, kept when finished
+}
+
+// Status of a Reconciliation.
+const (
+	Recon_Open     = "open"
+	Recon_Finished = "finished"
+)
+
+// Budget is the planned spending for a category, for each month or
+// each year, from a starting month.  A category can have several
+// budgets, each in effect from its start until the next one starts, so
+// a budget can be changed without losing the history of the old one.
+type Budget struct {
+	BudId    uuid.UUID
+	Cid      uuid.UUID
+	Period   string     // One of the Budget_ periods
+	Amount   util.Money // Planned spending for each period
+	Rollover string     // One of the Rollover_ values
+	Start    time.Time  // First day of the first period, always the first of a month
+	Notes    string
 }
 
+// Period of a Budget.  A monthly budget is for each calendar month.  An
+// annual budget is for each twelve months from its start, so it can
+// follow a school or fiscal year.
+const (
+	Budget_Monthly = "monthly"
+	Budget_Annual  = "annual"
+)
+
+// What is carried from one period of a Budget to the next.  With
+// Rollover_Unspent, money left over is added to the next period, but
+// overspending is forgiven.  With Rollover_All, overspending is taken
+// out of the next period as well.
+const (
+	Rollover_None    = ""
+	Rollover_Unspent = "unspent"
+	Rollover_All     = "all"
+)
+
 // CatItem is use to categorize transactions.  Note that
 // a transaction should have at least one CatItem and all the
 // CatItems in a transaction should add to the ammount in
 // the transaction.
 type CatItem struct {
-	Amount   int
-	Category string // Points to name in Category map
-	Notes    string
+	Amount util.Money
+	Cid    uuid.UUID // Points to Categories map
+	Notes  string
 }
 
+// DupReview holds an incoming transaction that looks like it is
+// already in the database, until someone decides.  The transaction is
+// not in the database while it is being reviewed.  If it is a duplicate,
+// it is dropped; if not, it is added.
+type DupReview struct {
+	Rid     uuid.UUID
+	T       *Transaction // The incoming transaction
+	DupOf   uuid.UUID    // The transaction in the database that it most likely duplicates
+	Score   int          // How alike they are, from 0 to 100 (see dedupe.go)
+	Reasons []string
+	Source  string // Where the transaction came from, such as the file name
+	Created time.Time
+}
+
+// ImportBatch holds the transactions from one import (a file, or the
+// old data) while they are looked over.  Each row of the source is
+// staged with its status, and can be fixed or skipped.  Then the batch
+// is committed, which adds its rows to the database as a single change,
+// or discarded.  Each transaction that is added keeps the id of its
+// batch in ImportId.  Once committed, the rows are no longer kept, but
+// the batch is, as the record of the import, and so that all of its
+// transactions can be removed by rolling it back.
+type ImportBatch struct {
+	Bid        uuid.UUID
+	Source     string // File name, or where the rows came from
+	Kind       string // The kind of import, such as ofx, qif, csv or olddata
+	Hash       string // SHA-256 of the file, in hex, or blank
+	Profile    string // The CSV profile used, if any
+	User       string // Who did the import
+	Status     string // One of the Batch_ values
+	Created    time.Time
+	Closed     time.Time // When the batch was committed
+	RolledBack time.Time // When the batch was rolled back
+	NRows      int       // Counts, kept when the batch is committed
+	NAdded     int
+	NReview    int
+	NSkipped   int
+	NRemoved   int // Transactions removed by the rollback
+	Rows       []*ImportRow
+	NewAliases []*BatchAlias // Account aliases to add on commit, or that were added
+}
+
+// BatchAlias is an account id from an import file that is to be added
+// to the aliases of an account.  It waits in the batch, so that the
+// account is only changed if the batch is committed.  A committed batch
+// keeps the ones that were added, so that a rollback can take them
+// away again.
+type BatchAlias struct {
+	Aid   uuid.UUID
+	Alias string
+}
+
+// Status of an ImportBatch.  A discarded batch is deleted.
+const (
+	Batch_Staged     = "staged"
+	Batch_Committed  = "committed"
+	Batch_RolledBack = "rolled-back"
+)
+
+// ImportRow is one transaction in a staged import.
+type ImportRow struct {
+	Row     int          // Line or item number in the source, from 1
+	T       *Transaction // As it will be added.  Aid is zero if no account was found.
+	Status  string       // One of the Row_ values
+	Reasons []string     // Why the row has its status
+	Skip    bool         // True if the row is not to be added
+	DupOf   uuid.UUID    // For Row_Duplicate and Row_Review, the transaction it is like
+	Score   int          // How alike they are (see dedupe.go)
+	Account string       // The account named in the source
+	Vendor  string       // The payee named in the source
+}
+
+// Status of an ImportRow.  Rows that are new, or have no vendor or a
+// missing category, are added when the batch is committed.  Duplicates
+// are skipped unless someone says otherwise, and possible duplicates go
+// to the review queue.  A batch cannot be committed while a row that is
+// not skipped has no account or a problem.
+const (
+	Row_New        = "new"
+	Row_Duplicate  = "duplicate"
+	Row_Review     = "review"
+	Row_NoVendor   = "no-vendor"
+	Row_NoCategory = "no-category"
+	Row_NoAccount  = "no-account"
+	Row_Problem    = "problem"
+)
+
 // Vendor describes the primary party for a transaction.
 type Vendor struct {
+	Vid            uuid.UUID
 	FName          string
 	DName          string
 	Aliases        []string
 	PrimaryProduct string
 	BusinessType   string
-	DefaultCat     string // blank, or points to name in Category map
+	DefaultCid     uuid.UUID // zero, or points to Categories map
 	Notes          string
 }
 
-// Category is used to organize transactions.
+// Category is used to organize transactions.  Categories form a tree
+// through Parent, so that totals can roll up (see cattree.go).
 type Category struct {
+	Cid     uuid.UUID
 	Name    string
 	Aliases []string // Must contain the Name.
 	Notes   string
+	Parent  uuid.UUID // The category this one is under, or zero for a top one
 }
 
 // Account is the basic bucket where money flows in or out.
 type Account struct {
-	ShortName string
-	DName     string
-	FName     string
-	Notes     string
-	Active    bool
-	Aliases   []string
+	Aid            uuid.UUID
+	ShortName      string
+	DName          string
+	FName          string
+	Notes          string
+	Active         bool
+	Aliases        []string
+	OpeningBalance util.Money // What was in the account at the start of OpeningDate
+	OpeningDate    time.Time  // Transactions before this are in the opening balance
 }
 
 // Year returns the year (as a 4 digit int) in which the
@@ -0,0 +1,119 @@
+// --------------------------------------------------------------------
+// validate.go -- Checks that the category splits of a transaction
+// balance.
+//
+// Created 2020-04-08 DLB
+// --------------------------------------------------------------------
+
+package m1data
+
+import (
+	"dbe/lib/log"
+	"dbe/lib/util"
+	"dbe/lib/uuid"
+	"dbe/m1/config"
+	"fmt"
+	"strings"
+)
+
+// The split check mode is set with the 'split_check' config parameter.
+// In strict mode, AddTransaction refuses a transaction whose splits
+// do not balance.  In warn mode (the default), the transaction is
+// added and a warning is logged.
+const (
+	SplitCheck_Warn   = "warn"
+	SplitCheck_Strict = "strict"
+)
+
+var gSplitCheck string = SplitCheck_Warn
+
+func init() {
+	mode, _ := config.GetStringParam("split_check", SplitCheck_Warn)
+	mode = strings.ToLower(strings.TrimSpace(mode))
+	if mode != SplitCheck_Warn && mode != SplitCheck_Strict {
+		log.Warnf("Unknown split_check mode (%q). Using %q.", mode, SplitCheck_Warn)
+		mode = SplitCheck_Warn
+	}
+	gSplitCheck = mode
+}
+
+// GetSplitCheckMode returns the mode used to check splits in AddTransaction.
+func GetSplitCheckMode() string {
+	return gSplitCheck
+}
+
+// SplitProblem describes a transaction whose splits do not balance.
+type SplitProblem struct {
+	Tid      uuid.UUID
+	Problems []string
+}
+
+// CheckSplits returns every transaction in the database that violates
+// the split rules.
+func CheckSplits() []*SplitProblem {
+	return GetView().CheckSplits()
+}
+
+// CheckSplits returns every transaction in the view that violates the
+// split rules.  Callers that look the transactions up should use the
+// same view, so that none of them can have been deleted since.
+func (v *View) CheckSplits() []*SplitProblem {
+	lst := make([]*SplitProblem, 0, 100)
+	v.EachTransaction(func(t *Transaction) {
+		probs := split_problems(v, t)
+		if len(probs) > 0 {
+			lst = append(lst, &SplitProblem{Tid: t.Tid, Problems: probs})
+		}
+	})
+	return lst
+}
+
+// CheckTransactionSplits returns the split problems of a transaction
+// that is not yet in the database, so that callers can find out what
+// AddTransaction would say about it.
+func CheckTransactionSplits(t *Transaction) []string {
+	return split_problems(GetView(), t)
+}
+
+// split_problems checks the category splits of a transaction.  The
+// splits must add up to the amount of the transaction, every split
+// must name a category in the database, and no split can be zero.
+// A transaction for zero dollars can have no splits.
+func split_problems(v *View, t *Transaction) []string {
+	probs := make([]string, 0)
+	var sum util.Money
+	for i, ci := range t.Cats {
+		sum += ci.Amount
+		if ci.Amount == 0 {
+			probs = append(probs, fmt.Sprintf("Split %d has a zero amount.", i+1))
+		}
+		if ci.Cid.IsZero() {
+			probs = append(probs, fmt.Sprintf("Split %d has no category.", i+1))
+		} else if _, ok := v.categories[ci.Cid]; !ok {
+			probs = append(probs, fmt.Sprintf("Split %d has an unknown category (%s).", i+1, ci.Cid))
+		}
+	}
+	if len(t.Cats) == 0 && t.Amount != 0 {
+		probs = append(probs, "No splits.")
+	} else if sum != t.Amount {
+		probs = append(probs, fmt.Sprintf("Splits add to %s, not %s.",
+			sum, t.Amount))
+	}
+	return probs
+}
+
+// check_splits applies the split check mode to a transaction that is
+// about to be added.  The caller must hold dblock.
+func check_splits(v *View, t *Transaction) error {
+	probs := split_problems(v, t)
+	if len(probs) == 0 {
+		return nil
+	}
+	msg := strings.Join(probs, " ")
+	if gSplitCheck == SplitCheck_Strict {
+		return fmt.Errorf("Splits do not balance. %s", msg)
+	}
+	log.Warnf("Transaction %s (%s, %s) splits do not balance. %s", t.Tid,
+		t.Date().Format("2006-01-02"), t.Description, msg)
+	return nil
+}
@@ -0,0 +1,509 @@
+// --------------------------------------------------------------------
+// view.go -- Read-only versions of the database, so that readers
+// never wait on writers.
+//
+// Created 2020-04-14 DLB
+// --------------------------------------------------------------------
+
+package m1data
+
+import (
+	"dbe/lib/uuid"
+	"hash/fnv"
+	"strconv"
+	"sync/atomic"
+)
+
+// The live database is kept as a View.  A view is never changed once
+// it is published.  A writer (holding dblock) makes a new view that
+// shares everything with the old one except the parts its change
+// touches, and then publishes it with a single atomic store.  Readers
+// just load the current view, so they take no locks, and they see a
+// consistent database for as long as they hold on to it -- which is
+// what reports and saving need.
+//
+// Accounts, vendors and categories are few, so their maps are copied
+// whole when one of them changes.  Transactions are split into shards
+// by the first two characters of their id, and only the shards that a
+// change touches are copied.  The transaction indexes (used by Query)
+// work the same way: each maps a key to a short list, and is split into
+// shards by a hash of the key, and only the shards and lists that a
+// change touches are copied.  The duplicate
+// reviews, import batches, reconciliations and budgets are few, and
+// are copied whole like the accounts.
+
+const tshard_count = 256
+
+// View is one version of the database.  The items reached through a
+// view are shared with other views and with other readers, so they
+// must not be modified.  Use the Add functions to make changes.
+type View struct {
+	seq           uint64 // Sequence number of the last change in this view
+	accounts      map[uuid.UUID]*Account
+	vendors       map[uuid.UUID]*Vendor
+	categories    map[uuid.UUID]*Category
+	accountnames  map[string]uuid.UUID
+	vendornames   map[string]uuid.UUID
+	categorynames map[string]uuid.UUID
+	tshards       [tshard_count]map[uuid.UUID]*Transaction
+	ntrans        int
+	byaccount     tindex // Keyed by Aid
+	byvendor      tindex // Keyed by Vid
+	bymonth       tindex // Keyed by month of Date(), as "2006-01"
+	byamount      tindex // Keyed by Aid and Amount, for finding duplicates
+	reviews       map[uuid.UUID]*DupReview
+	batches       map[uuid.UUID]*ImportBatch
+	recons        map[uuid.UUID]*Reconciliation
+	budgets       map[uuid.UUID]*Budget
+}
+
+var gView atomic.Value // Holds the current *View
+
+// GetView returns the current version of the database.  It never
+// blocks, and the view returned never changes.
+func GetView() *View {
+	return gView.Load().(*View)
+}
+
+// publish makes a view the current one.  The caller must hold dblock.
+func publish(v *View) {
+	gView.Store(v)
+}
+
+// new_view makes a view that holds everything in a database.  The
+// items are shared, so the database must not be changed afterwards.
+func new_view(d *Database, seq uint64) *View {
+	fix_maps(d)
+	v := &View{seq: seq, accounts: d.Accounts, vendors: d.Vendors, categories: d.Categories,
+		accountnames: d.accountnames, vendornames: d.vendornames, categorynames: d.categorynames,
+		reviews: d.Reviews, batches: d.Batches, recons: d.Recons,
+		budgets: d.Budgets}
+	for i := range v.tshards {
+		v.tshards[i] = make(map[uuid.UUID]*Transaction, len(d.Transactions)/tshard_count+1)
+	}
+	v.byaccount = new_tindex(len(d.Accounts))
+	v.byvendor = new_tindex(len(d.Vendors))
+	v.bymonth = new_tindex(500)
+	v.byamount = new_tindex(len(d.Transactions))
+	for tid, t := range d.Transactions {
+		v.tshards[tshard(tid)][tid] = t
+		v.byaccount.put(account_key(t), t)
+		v.byvendor.put(vendor_key(t), t)
+		v.bymonth.put(month_key(t), t)
+		v.byamount.put(amount_key(t), t)
+	}
+	v.ntrans = len(d.Transactions)
+	return v
+}
+
+// database returns the view as a Database, for the stores.  The items
+// are shared with the view, and must not be changed.
+func (v *View) database() *Database {
+	d := &Database{accountnames: v.accountnames, vendornames: v.vendornames, categorynames: v.categorynames}
+	d.Accounts = v.accounts
+	d.Vendors = v.vendors
+	d.Categories = v.categories
+	d.Reviews = v.reviews
+	d.Batches = v.batches
+	d.Recons = v.recons
+	d.Budgets = v.budgets
+	d.Transactions = make(map[uuid.UUID]*Transaction, v.ntrans)
+	for _, shard := range v.tshards {
+		for tid, t := range shard {
+			d.Transactions[tid] = t
+		}
+	}
+	return d
+}
+
+// apply returns a new view with a change made to it.  The old view is
+// not touched.
+func (v *View) apply(c *Change) *View {
+	nv := *v
+	nv.seq = c.Seq
+	if len(c.Accounts) > 0 || len(c.DelAccounts) > 0 {
+		nv.accounts = make(map[uuid.UUID]*Account, len(v.accounts)+len(c.Accounts))
+		for id, a := range v.accounts {
+			nv.accounts[id] = a
+		}
+		nv.accountnames = copy_names(v.accountnames)
+	}
+	if len(c.Vendors) > 0 || len(c.DelVendors) > 0 {
+		nv.vendors = make(map[uuid.UUID]*Vendor, len(v.vendors)+len(c.Vendors))
+		for id, vv := range v.vendors {
+			nv.vendors[id] = vv
+		}
+		nv.vendornames = copy_names(v.vendornames)
+	}
+	if len(c.Categories) > 0 || len(c.DelCategories) > 0 {
+		nv.categories = make(map[uuid.UUID]*Category, len(v.categories)+len(c.Categories))
+		for id, cat := range v.categories {
+			nv.categories[id] = cat
+		}
+		nv.categorynames = copy_names(v.categorynames)
+	}
+	if len(c.Reviews) > 0 || len(c.DelReviews) > 0 {
+		nv.reviews = make(map[uuid.UUID]*DupReview, len(v.reviews)+len(c.Reviews))
+		for id, r := range v.reviews {
+			nv.reviews[id] = r
+		}
+		for _, id := range c.DelReviews {
+			delete(nv.reviews, id)
+		}
+		for _, r := range c.Reviews {
+			nv.reviews[r.Rid] = r
+		}
+	}
+	if len(c.Batches) > 0 || len(c.DelBatches) > 0 {
+		nv.batches = make(map[uuid.UUID]*ImportBatch, len(v.batches)+len(c.Batches))
+		for id, b := range v.batches {
+			nv.batches[id] = b
+		}
+		for _, id := range c.DelBatches {
+			delete(nv.batches, id)
+		}
+		for _, b := range c.Batches {
+			nv.batches[b.Bid] = b
+		}
+	}
+	if len(c.Recons) > 0 || len(c.DelRecons) > 0 {
+		nv.recons = make(map[uuid.UUID]*Reconciliation, len(v.recons)+len(c.Recons))
+		for id, r := range v.recons {
+			nv.recons[id] = r
+		}
+		for _, id := range c.DelRecons {
+			delete(nv.recons, id)
+		}
+		for _, r := range c.Recons {
+			nv.recons[r.RecId] = r
+		}
+	}
+	if len(c.Budgets) > 0 || len(c.DelBudgets) > 0 {
+		nv.budgets = make(map[uuid.UUID]*Budget, len(v.budgets)+len(c.Budgets))
+		for id, b := range v.budgets {
+			nv.budgets[id] = b
+		}
+		for _, id := range c.DelBudgets {
+			delete(nv.budgets, id)
+		}
+		for _, b := range c.Budgets {
+			nv.budgets[b.BudId] = b
+		}
+	}
+	for _, id := range c.DelAccounts {
+		del_account(nv.accounts, nv.accountnames, id)
+	}
+	for _, id := range c.DelVendors {
+		del_vendor(nv.vendors, nv.vendornames, id)
+	}
+	for _, id := range c.DelCategories {
+		del_category(nv.categories, nv.categorynames, id)
+	}
+	for _, a := range c.Accounts {
+		put_account(nv.accounts, nv.accountnames, a)
+	}
+	for _, vv := range c.Vendors {
+		put_vendor(nv.vendors, nv.vendornames, vv)
+	}
+	for _, cat := range c.Categories {
+		put_category(nv.categories, nv.categorynames, cat)
+	}
+	if len(c.Transactions) > 0 || len(c.DelTransactions) > 0 {
+		v.apply_transactions(&nv, c)
+	}
+	return &nv
+}
+
+// apply_transactions makes the transaction part of a change to a new
+// view, and updates its indexes.
+func (v *View) apply_transactions(nv *View, c *Change) {
+	copied := make(map[int]bool, len(c.Transactions)+len(c.DelTransactions))
+	shard := func(tid uuid.UUID) map[uuid.UUID]*Transaction {
+		i := tshard(tid)
+		if !copied[i] {
+			m := make(map[uuid.UUID]*Transaction, len(v.tshards[i])+1)
+			for k, t := range v.tshards[i] {
+				m[k] = t
+			}
+			nv.tshards[i] = m
+			copied[i] = true
+		}
+		return nv.tshards[i]
+	}
+	byaccount := edit_index(v.byaccount)
+	byvendor := edit_index(v.byvendor)
+	bymonth := edit_index(v.bymonth)
+	byamount := edit_index(v.byamount)
+	unindex := func(t *Transaction) {
+		byaccount.remove(account_key(t), t.Tid)
+		byvendor.remove(vendor_key(t), t.Tid)
+		bymonth.remove(month_key(t), t.Tid)
+		byamount.remove(amount_key(t), t.Tid)
+	}
+	for _, tid := range c.DelTransactions {
+		m := shard(tid)
+		if old, ok := m[tid]; ok {
+			unindex(old)
+			delete(m, tid)
+			nv.ntrans--
+		}
+	}
+	for _, t := range c.Transactions {
+		m := shard(t.Tid)
+		if old, ok := m[t.Tid]; ok {
+			unindex(old)
+		} else {
+			nv.ntrans++
+		}
+		m[t.Tid] = t
+		byaccount.add(account_key(t), t)
+		byvendor.add(vendor_key(t), t)
+		bymonth.add(month_key(t), t)
+		byamount.add(amount_key(t), t)
+	}
+	nv.byaccount = byaccount.idx
+	nv.byvendor = byvendor.idx
+	nv.bymonth = bymonth.idx
+	nv.byamount = byamount.idx
+}
+
+// tshard returns the shard that holds a transaction.
+func tshard(tid uuid.UUID) int {
+	s := tid.String()
+	if len(s) < 2 {
+		return 0
+	}
+	n, err := strconv.ParseUint(s[0:2], 16, 8)
+	if err != nil {
+		return 0
+	}
+	return int(n)
+}
+
+// tindex is an index of transactions, split into shards by a hash of
+// the key.  The lists are in no order.
+type tindex [tshard_count]map[string][]*Transaction
+
+func new_tindex(size int) tindex {
+	var x tindex
+	for i := range x {
+		x[i] = make(map[string][]*Transaction, size/tshard_count+1)
+	}
+	return x
+}
+
+// kshard returns the shard of an index that holds a key.
+func kshard(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % tshard_count)
+}
+
+// get returns the list of transactions for a key.
+func (x *tindex) get(key string) []*Transaction {
+	return x[kshard(key)][key]
+}
+
+// each calls f for every key in the index and its list, in no order.
+func (x *tindex) each(f func(key string, lst []*Transaction)) {
+	for _, m := range x {
+		for k, lst := range m {
+			f(k, lst)
+		}
+	}
+}
+
+// put adds a transaction to the index in place.  It is only used on a
+// view that is not yet published.
+func (x *tindex) put(key string, t *Transaction) {
+	m := x[kshard(key)]
+	m[key] = append(m[key], t)
+}
+
+func account_key(t *Transaction) string {
+	return t.Aid.String()
+}
+
+func vendor_key(t *Transaction) string {
+	return t.Vid.String()
+}
+
+func month_key(t *Transaction) string {
+	return t.Date().Format("2006-01")
+}
+
+func amount_key(t *Transaction) string {
+	return t.Aid.String() + ":" + strconv.Itoa(t.Amount.Cents())
+}
+
+// index_edit makes a changed copy of an index.  Each shard and each
+// list is copied the first time it is changed, so the original index is
+// not touched, and a change costs only as much as the keys it touches.
+type index_edit struct {
+	idx    tindex
+	shards map[int]bool
+	copied map[string]bool
+}
+
+func edit_index(idx tindex) *index_edit {
+	return &index_edit{idx: idx, shards: make(map[int]bool, 10), copied: make(map[string]bool, 10)}
+}
+
+func (e *index_edit) shard(key string) map[string][]*Transaction {
+	i := kshard(key)
+	if !e.shards[i] {
+		m := make(map[string][]*Transaction, len(e.idx[i])+1)
+		for k, lst := range e.idx[i] {
+			m[k] = lst
+		}
+		e.idx[i] = m
+		e.shards[i] = true
+	}
+	return e.idx[i]
+}
+
+func (e *index_edit) list(key string) []*Transaction {
+	m := e.shard(key)
+	if !e.copied[key] {
+		old := m[key]
+		lst := make([]*Transaction, len(old), len(old)+1)
+		copy(lst, old)
+		m[key] = lst
+		e.copied[key] = true
+	}
+	return m[key]
+}
+
+func (e *index_edit) add(key string, t *Transaction) {
+	e.shard(key)[key] = append(e.list(key), t)
+}
+
+func (e *index_edit) remove(key string, tid uuid.UUID) {
+	lst := e.list(key)
+	for i, t := range lst {
+		if t.Tid == tid {
+			lst[i] = lst[len(lst)-1]
+			lst = lst[:len(lst)-1]
+			break
+		}
+	}
+	if len(lst) == 0 {
+		delete(e.shard(key), key)
+		return
+	}
+	e.shard(key)[key] = lst
+}
+
+func copy_names(m map[string]uuid.UUID) map[string]uuid.UUID {
+	out := make(map[string]uuid.UUID, len(m)+1)
+	for k, id := range m {
+		out[k] = id
+	}
+	return out
+}
+
+// Seq returns the sequence number of the last change in the view.
+func (v *View) Seq() uint64 {
+	return v.seq
+}
+
+// Accounts returns all the accounts in the view.
+func (v *View) Accounts() []*Account {
+	lst := make([]*Account, 0, len(v.accounts))
+	for _, a := range v.accounts {
+		lst = append(lst, a)
+	}
+	return lst
+}
+
+// Account returns an account given its id, or nil.
+func (v *View) Account(aid uuid.UUID) *Account {
+	return v.accounts[aid]
+}
+
+// AccountByName returns an account given its full name, or nil.
+func (v *View) AccountByName(name string) *Account {
+	id, ok := v.accountnames[name]
+	if !ok {
+		return nil
+	}
+	return v.accounts[id]
+}
+
+// Vendors returns all the vendors in the view.
+func (v *View) Vendors() []*Vendor {
+	lst := make([]*Vendor, 0, len(v.vendors))
+	for _, vv := range v.vendors {
+		lst = append(lst, vv)
+	}
+	return lst
+}
+
+// Vendor returns a vendor given its id, or nil.
+func (v *View) Vendor(vid uuid.UUID) *Vendor {
+	return v.vendors[vid]
+}
+
+// VendorByName returns a vendor given its full name, or nil.
+func (v *View) VendorByName(name string) *Vendor {
+	id, ok := v.vendornames[name]
+	if !ok {
+		return nil
+	}
+	return v.vendors[id]
+}
+
+// Categories returns all the categories in the view.
+func (v *View) Categories() []*Category {
+	lst := make([]*Category, 0, len(v.categories))
+	for _, c := range v.categories {
+		lst = append(lst, c)
+	}
+	return lst
+}
+
+// Category returns a category given its id, or nil.
+func (v *View) Category(cid uuid.UUID) *Category {
+	return v.categories[cid]
+}
+
+// CategoryByName returns a category given its name, or nil.
+func (v *View) CategoryByName(name string) *Category {
+	id, ok := v.categorynames[name]
+	if !ok {
+		return nil
+	}
+	return v.categories[id]
+}
+
+// Transaction returns a transaction given its id, or nil.
+func (v *View) Transaction(tid uuid.UUID) *Transaction {
+	return v.tshards[tshard(tid)][tid]
+}
+
+// TransactionCount returns the number of transactions in the view.
+func (v *View) TransactionCount() int {
+	return v.ntrans
+}
+
+// Transactions returns all the transactions in the view.  Only the
+// list is made; the transactions themselves are shared.
+func (v *View) Transactions() []*Transaction {
+	lst := make([]*Transaction, 0, v.ntrans)
+	for _, shard := range v.tshards {
+		for _, t := range shard {
+			lst = append(lst, t)
+		}
+	}
+	return lst
+}
+
+// EachTransaction calls f for every transaction in the view, in no
+// particular order.
+func (v *View) EachTransaction(f func(t *Transaction)) {
+	for _, shard := range v.tshards {
+		for _, t := range shard {
+			f(t)
+		}
+	}
+}
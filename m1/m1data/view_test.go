@@ -0,0 +1,111 @@
+// --------------------------------------------------------------------
+// view_test.go -- Test the copy-on-write views and their indexes
+//
+// Created 2020-04-20 DLB
+// --------------------------------------------------------------------
+
+package m1data
+
+import (
+	"dbe/lib/util"
+	"dbe/lib/uuid"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// index_tids lists the ids under each key of an index, so that two
+// indexes can be compared.
+func index_tids(x *tindex) map[string]string {
+	out := make(map[string]string, 100)
+	x.each(func(k string, lst []*Transaction) {
+		ids := make([]string, 0, len(lst))
+		for _, t := range lst {
+			ids = append(ids, t.Tid.String())
+		}
+		sort.Strings(ids)
+		out[k] = strings.Join(ids, ",")
+	})
+	return out
+}
+
+// check_indexes compares the indexes of a view with ones built from
+// scratch.
+func check_indexes(t *testing.T, v *View) {
+	t.Helper()
+	w := new_view(v.database(), v.Seq())
+	for _, x := range []struct {
+		Name string
+		A, B *tindex
+	}{{"byaccount", &v.byaccount, &w.byaccount}, {"byvendor", &v.byvendor, &w.byvendor},
+		{"bymonth", &v.bymonth, &w.bymonth}, {"byamount", &v.byamount, &w.byamount}} {
+		if !reflect.DeepEqual(index_tids(x.A), index_tids(x.B)) {
+			t.Fatalf("Index %s does not match one built from scratch", x.Name)
+		}
+	}
+}
+
+func Test_ViewIndexes(t *testing.T) {
+	test_open(t)
+	a1 := test_account(t, "Checking")
+	a2 := test_account(t, "Visa")
+	tids := make([]uuid.UUID, 0, 40)
+	for i := 0; i < 40; i++ {
+		tids = append(tids, test_trans(t, a1, "2020-03-01", util.Money(-100*(i%5))))
+	}
+	old := GetView()
+	before := index_tids(&old.byamount)
+
+	// Move some to another account, amount and month, and delete others.
+	for i, tid := range tids[:10] {
+		tc := *GetView().Transaction(tid)
+		tc.Aid = a2
+		tc.Amount = util.Money(-7 * i)
+		tc.DatePosted = test_date("2020-04-15")
+		if err := AddTransaction(&tc); err != nil {
+			t.Fatalf("AddTransaction fails with Err=%v", err)
+		}
+	}
+	c := &Change{DelTransactions: tids[10:15]}
+	dblock.Lock()
+	err := commit(c)
+	dblock.Unlock()
+	if err != nil {
+		t.Fatalf("commit fails with Err=%v", err)
+	}
+	v := GetView()
+	if v.TransactionCount() != 35 || len(v.byaccount.get(a2.String())) != 10 {
+		t.Fatalf("View has %d transactions, %d in Visa, Expected 35 and 10", v.TransactionCount(),
+			len(v.byaccount.get(a2.String())))
+	}
+	check_indexes(t, v)
+	if !reflect.DeepEqual(index_tids(&old.byamount), before) {
+		t.Fatalf("Changes altered the index of an older view")
+	}
+}
+
+// Test_ViewIndexShards checks that a change to one transaction copies
+// only the shards of the indexes that it touches.
+func Test_ViewIndexShards(t *testing.T) {
+	test_open(t)
+	aid := test_account(t, "Checking")
+	for i := 0; i < 300; i++ {
+		test_trans(t, aid, "2020-03-01", util.Money(-i))
+	}
+	old := GetView()
+	tid := test_trans(t, aid, "2020-03-02", -99999)
+	v := GetView()
+	tr := v.Transaction(tid)
+	touched := kshard(amount_key(tr))
+	for i := range v.byamount {
+		same := reflect.ValueOf(v.byamount[i]).Pointer() == reflect.ValueOf(old.byamount[i]).Pointer()
+		if i == touched && same {
+			t.Fatalf("Shard %d of byamount was changed in place", i)
+		}
+		if i != touched && !same {
+			t.Fatalf("Shard %d of byamount was copied, but the change does not touch it", i)
+		}
+	}
+	check_indexes(t, v)
+}
@@ -11,6 +11,8 @@ import (
 	"dbe/lib/util"
 	"dbe/m1/config"
 	"dbe/m1/console"
+	"dbe/m1/m1data"
+	"dbe/m1/m1sql"
 	"dbe/m1/pages"
 	"dbe/m1/sessions"
 	"fmt"
@@ -41,6 +43,10 @@ func main() {
 		log.Fatalf("log_folder not provided in config.txt")
 	}
 	log.SetLogFolder(logfolder)
+	err = m1data.OpenData()
+	if err != nil {
+		log.Fatalf("Unable to open the data. Err=%v", err)
+	}
 
 	gHostAddr, ok = config.GetParam("hostaddr")
 	if !ok {
@@ -48,19 +54,21 @@ func main() {
 		gHostAddr = ":8081"
 		config.SetParam("hostaddr", gHostAddr)
 	}
-	// sql_pw, ok := config.GetParam("sql_pw")
-	// if !ok {
-	// 	err = fmt.Errorf("Mysql password not found in config file.")
-	// 	log.Errorf("%v", err)
-	// 	fmt.Fprintf(os.Stderr, "%v\n", err)
-	// 	return
-	// }
-	// err = m1sql.OpenDatabase(sql_pw)
-	// if err != nil {
-	// 	log.Errorf("%v", err)
-	// 	fmt.Fprintf(os.Stderr, "%v\n", err)
-	// 	return
-	// }
+	// MySQL is optional.  It is only used to copy the data in and out, so
+	// the server runs without it if no password is configured.
+	sql_pw, ok := config.GetParam("sql_pw")
+	if ok && !util.Blank(sql_pw) {
+		sql_host, _ := config.GetStringParam("sql_host", "")
+		err = m1sql.OpenDatabase(sql_pw, sql_host)
+		if err != nil {
+			log.Errorf("MySQL not available. %v", err)
+			fmt.Fprintf(os.Stderr, "MySQL not available. %v\n", err)
+		} else {
+			log.Infof("Connected to MySQL database.")
+		}
+	} else {
+		log.Infof("Mysql password not found in config file. MySQL not used.")
+	}
 
 	console.RegistorCmd("version", "", "Gives the version of this server.", handle_version)
 
@@ -17,7 +17,9 @@ create table Accounts(
   DName varchar(120),
   FName varchar(120),
   Notes varchar(1200),
-  Active int
+  Active int,
+  OpeningBalance int,          /* In cents, at the start of OpeningDate */
+  OpeningDate date
 );
 
 insert into Accounts(Aid, Active, ShortName, DName, FName, Notes) values(1, 0, "ML",    "ML Checking",   "Merrill Lynch Checking",     "");
@@ -52,7 +54,8 @@ insert into AccountAlias(Aid, Alias, Notes) values(5, "Visa 9836",  "");
 create table Categories(
   Cid char(32),
   Name varchar(120),
-  Notes varchar(1200)
+  Notes varchar(1200),
+  Parent char(32)
 );
 
 create table CatAlias(
@@ -83,17 +86,57 @@ create table Transactions(
   Description varchar(240),
   DatePosted date,
   DateSettled date,
-  Account char(32),            /* Required */
-  Vendor char(32),             /* 0  = unknown */
-  MonthNum int,                /* Statement Month, 1-12 */
+  Month date,                  /* Statement Month-Year */
+  Aid int,                     /* Required */
+  Vid char(32),                /* 0  = unknown */
   BankInfo varchar(240),
   Location varchar(240),
-  CheckNum varchar(32)
+  CheckNum varchar(32),
+  FitId varchar(255),          /* Id from the bank download, for dedupe */
+  Flag varchar(32),
+  Notes varchar(1200),
+  ImportId char(32),           /* Import batch that added it, or 0 */
+  Cleared varchar(32),         /* Blank, cleared or reconciled */
+  RecId char(32),              /* Reconciliation that reconciled it, or 0 */
+  Xfer char(32)                /* Other side of a transfer between accounts, or 0 */
 );
 
+create index TransTid on Transactions(Tid);
+
 create table CatList(
   Tid char(32),
   Cid char(32), 
-  Amount int
+  Amount int,
+  Notes varchar(1200)
 );
 
+create index CatListTid on CatList(Tid);
+
+create table Receipts(
+  Tid char(32),
+  Url varchar(512)
+);
+
+create table Recons(
+  RecId char(32),
+  Aid int,
+  Month date,                  /* Statement Month-Year */
+  EndDate date,                /* Closing date of the statement */
+  StartBal int,                /* In cents */
+  EndBal int,                  /* In cents */
+  Status varchar(32),          /* open or finished */
+  User varchar(120),
+  Created datetime,
+  Finished datetime,
+  NCleared int
+);
+
+create table Budgets(
+  BudId char(32),
+  Cid char(32),
+  Period varchar(32),          /* monthly or annual */
+  Amount int,                  /* In cents, for each period */
+  Rollover varchar(32),        /* Blank, unspent or all */
+  Start date,                  /* First day of the first period */
+  Notes varchar(1200)
+);
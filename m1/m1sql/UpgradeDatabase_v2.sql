@@ -0,0 +1,72 @@
+/* --------------------------------------------------------------------
+// UpgradeDatabase_v2.sql -- Upgrades a database made with the first
+// CreateDatabase.sql to the tables that copy-sql uses.
+//
+// Created 2020-04-20 DLB
+// --------------------------------------------------------------------
+
+The first Transactions table named its account and vendor by char(32)
+and kept only the month number, which cannot be turned into the Aid,
+Vid and Month that copy-sql uses.  Those columns are dropped, so run
+"copy-sql to" after this script to fill the tables again.
+*/
+
+Use M1Data;
+
+alter table Accounts
+  add column OpeningBalance int,     /* In cents, at the start of OpeningDate */
+  add column OpeningDate date;
+
+alter table Categories
+  add column Parent char(32);
+
+alter table Transactions
+  drop column Account,
+  drop column Vendor,
+  drop column MonthNum,
+  add column Month date after DateSettled,       /* Statement Month-Year */
+  add column Aid int after Month,                /* Required */
+  add column Vid char(32) after Aid,             /* 0  = unknown */
+  add column FitId varchar(255),                 /* Id from the bank download, for dedupe */
+  add column Flag varchar(32),
+  add column Notes varchar(1200),
+  add column ImportId char(32),                  /* Import batch that added it, or 0 */
+  add column Cleared varchar(32),                /* Blank, cleared or reconciled */
+  add column RecId char(32),                     /* Reconciliation that reconciled it, or 0 */
+  add column Xfer char(32);                      /* Other side of a transfer between accounts, or 0 */
+
+create index TransTid on Transactions(Tid);
+
+alter table CatList
+  add column Notes varchar(1200);
+
+create index CatListTid on CatList(Tid);
+
+create table Receipts(
+  Tid char(32),
+  Url varchar(512)
+);
+
+create table Recons(
+  RecId char(32),
+  Aid int,
+  Month date,                  /* Statement Month-Year */
+  EndDate date,                /* Closing date of the statement */
+  StartBal int,                /* In cents */
+  EndBal int,                  /* In cents */
+  Status varchar(32),          /* open or finished */
+  User varchar(120),
+  Created datetime,
+  Finished datetime,
+  NCleared int
+);
+
+create table Budgets(
+  BudId char(32),
+  Cid char(32),
+  Period varchar(32),          /* monthly or annual */
+  Amount int,                  /* In cents, for each period */
+  Rollover varchar(32),        /* Blank, unspent or all */
+  Start date,                  /* First day of the first period */
+  Notes varchar(1200)
+);
@@ -7,11 +7,13 @@
 package m1sql
 
 import (
+	"database/sql"
 	"dbe/lib/log"
 	"fmt"
 	"sort"
 	"strings"
 	"sync"
+	"time"
 )
 
 type Alias struct {
@@ -27,6 +29,9 @@ type Account struct {
 	Notes     string
 	Active    bool
 	Aliases   []Alias
+
+	OpeningBalance int       // In cents, at the start of OpeningDate
+	OpeningDate    time.Time // Zero if none
 }
 
 type AccountAlias struct {
@@ -41,22 +46,27 @@ var gAccountAliasCasheLock sync.Mutex
 var gAccountCache []*Account
 var gAccountAliases []*AccountAlias
 
-func GetAccounts() []*Account {
+// GetAccounts returns all the accounts in the database, with their
+// aliases.  The list is cached until an alias is changed.
+func GetAccounts() ([]*Account, error) {
 	gAccountCasheLock.Lock()
 	defer gAccountCasheLock.Unlock()
 	if gAccountCache != nil {
-		return gAccountCache
+		return gAccountCache, nil
 	}
 	lstmap := make(map[int]*Account, 10)
-	rows, err := m_db.Query("Select Aid, ShortName, DName, FName, Notes, Active from Accounts")
+	rows, err := m_db.Query("Select Aid, ShortName, DName, FName, Notes, Active, OpeningBalance, OpeningDate from Accounts")
 	if err != nil {
 		log.Errorf("Err getting Accounts. Returning empty slice. Err=%v", err)
-		return []*Account{}
+		return []*Account{}, fmt.Errorf("Err getting accounts. Err=%v", err)
 	}
+	defer rows.Close()
 	for rows.Next() {
 		var sname, dname, fname, notes string
 		var aid, active int
-		err = rows.Scan(&aid, &sname, &dname, &fname, &notes, &active)
+		var opening sql.NullInt64
+		var opendate sql.NullTime
+		err = rows.Scan(&aid, &sname, &dname, &fname, &notes, &active, &opening, &opendate)
 		if err != nil {
 			log.Errorf("Err during row scan in getAccounts. Shipping account. Err=%v.", err)
 			continue
@@ -67,9 +77,18 @@ func GetAccounts() []*Account {
 		}
 		atmp := make([]Alias, 0, 10)
 		lstmap[aid] = &Account{Aid: aid, DName: dname, ShortName: sname,
-			FName: fname, Notes: notes, Active: bactive, Aliases: atmp}
+			FName: fname, Notes: notes, Active: bactive, Aliases: atmp,
+			OpeningBalance: int(opening.Int64), OpeningDate: opendate.Time}
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Errorf("Database failure after iterating rows on Accounts table. Err=%v", err)
+		return []*Account{}, fmt.Errorf("Error during row interation on Accounts Table. Err=%v", err)
+	}
+	aliases, err := GetAccountAliases()
+	if err != nil {
+		return []*Account{}, err
 	}
-	aliases := GetAccountAliases()
 	for _, a := range aliases {
 		acc, ok := lstmap[a.Aid]
 		if !ok {
@@ -84,21 +103,23 @@ func GetAccounts() []*Account {
 	}
 	sort.Slice(lst, func(i, j int) bool { return lst[j].Aid > lst[i].Aid })
 	gAccountCache = lst
-	return gAccountCache
+	return gAccountCache, nil
 }
 
-func GetAccountAliases() []*AccountAlias {
+// GetAccountAliases returns all the account aliases in the database.
+func GetAccountAliases() ([]*AccountAlias, error) {
 	gAccountAliasCasheLock.Lock()
 	defer gAccountAliasCasheLock.Unlock()
 	if gAccountAliases != nil {
-		return gAccountAliases
+		return gAccountAliases, nil
 	}
 	lst := make([]*AccountAlias, 0, 10)
 	rows, err := m_db.Query("Select Aid, Alias, Notes from AccountAlias")
 	if err != nil {
 		log.Errorf("Err getting AccountAlias. Returning empty slice. Err=%v", err)
-		return lst
+		return lst, fmt.Errorf("Err getting account aliases. Err=%v", err)
 	}
+	defer rows.Close()
 	for rows.Next() {
 		var aid int
 		var alias, notes string
@@ -109,13 +130,21 @@ func GetAccountAliases() []*AccountAlias {
 		}
 		lst = append(lst, &AccountAlias{Aid: aid, Alias: alias, Notes: notes})
 	}
+	err = rows.Err()
+	if err != nil {
+		log.Errorf("Database failure after iterating rows on AccountAlias table. Err=%v", err)
+		return lst, fmt.Errorf("Error during row interation on AccountAlias Table. Err=%v", err)
+	}
 	gAccountAliases = lst
-	return gAccountAliases
+	return gAccountAliases, nil
 }
 
 func AddAccountAlias(Aid int, Name string, Notes string) error {
 	var err error
-	acclst := GetAccounts()
+	acclst, err := GetAccounts()
+	if err != nil {
+		return err
+	}
 	bFound := false
 	for _, a := range acclst {
 		if a.Aid == Aid {
@@ -126,7 +155,10 @@ func AddAccountAlias(Aid int, Name string, Notes string) error {
 	if !bFound {
 		return fmt.Errorf("Invalid account ID.")
 	}
-	aliaslst := GetAccountAliases()
+	aliaslst, err := GetAccountAliases()
+	if err != nil {
+		return err
+	}
 	for _, a := range aliaslst {
 		if a.Aid == Aid && strings.ToLower(strings.TrimSpace(a.Alias)) == strings.ToLower(strings.TrimSpace(Name)) {
 			return fmt.Errorf("Alias already exists.")
@@ -175,3 +207,75 @@ func DeleteAccountAlias(Aid int, Name string) (int, error) {
 	gAccountAliases = nil
 	return n, err
 }
+
+// UpdateAccount will either change an account to match the input or make a
+// new account if one doesn't already exist.  An Aid of zero means a new
+// account, and the next available Aid is assigned.  The aliases are replaced.
+func UpdateAccount(a *Account) error {
+	tx, err := m_db.Begin()
+	if err != nil {
+		log.Errorf("Unable to begin transaction for UpdateAccount. Err=%v", err)
+		return err
+	}
+	if a.Aid != 0 {
+		_, err = tx.Exec("Delete from Accounts where Aid=?", a.Aid)
+		if err == nil {
+			_, err = tx.Exec("Delete from AccountAlias where Aid=?", a.Aid)
+		}
+		if err != nil {
+			attempt_rollback(tx, "Unable to remove old account.")
+			return fmt.Errorf("Unable to remove old account. Err=%v", err)
+		}
+	}
+	err = insert_account(tx, a)
+	if err != nil {
+		attempt_rollback(tx, "Unable to insert account.")
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Errorf("Commit failed on Update Accounts. Err=%v", err)
+		return fmt.Errorf("Commit failed on Update Accounts. Err=%v", err)
+	}
+	clear_account_cache()
+	return nil
+}
+
+// insert_account adds a new account and its aliases.  If the Aid is zero,
+// the next available Aid is assigned.
+func insert_account(tx *sql.Tx, a *Account) error {
+	if a.Aid == 0 {
+		var maxaid sql.NullInt64
+		err := tx.QueryRow("Select max(Aid) from Accounts").Scan(&maxaid)
+		if err != nil {
+			return fmt.Errorf("Unable to find next account id. Err=%v", err)
+		}
+		a.Aid = int(maxaid.Int64) + 1
+	}
+	active := 0
+	if a.Active {
+		active = 1
+	}
+	_, err := tx.Exec("Insert into Accounts(Aid, ShortName, DName, FName, Notes, Active, OpeningBalance, OpeningDate) "+
+		"values(?, ?, ?, ?, ?, ?, ?, ?)", a.Aid, a.ShortName, a.DName, a.FName, a.Notes, active,
+		a.OpeningBalance, null_date(a.OpeningDate))
+	if err != nil {
+		return fmt.Errorf("Unable to insert into Accounts. Err=%v", err)
+	}
+	for _, alias := range a.Aliases {
+		_, err = tx.Exec("Insert into AccountAlias(Aid, Alias, Notes) values(?, ?, ?)", a.Aid, alias.Name, alias.Notes)
+		if err != nil {
+			return fmt.Errorf("Failed to insert into AccountAlias. Err=%v", err)
+		}
+	}
+	return nil
+}
+
+func clear_account_cache() {
+	gAccountAliasCasheLock.Lock()
+	defer gAccountAliasCasheLock.Unlock()
+	gAccountCasheLock.Lock()
+	defer gAccountCasheLock.Unlock()
+	gAccountCache = nil
+	gAccountAliases = nil
+}
@@ -0,0 +1,106 @@
+// --------------------------------------------------------------------
+// budgets.go -- Manage the budgets table
+//
+// Created 2020-04-20 DLB
+// --------------------------------------------------------------------
+
+package m1sql
+
+import (
+	"database/sql"
+	"dbe/lib/log"
+	"dbe/lib/uuid"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Budget is the planned spending for a category, from a starting month.
+type Budget struct {
+	BudId    uuid.UUID
+	Cid      uuid.UUID
+	Period   string // monthly or annual
+	Amount   int    // In cents, for each period
+	Rollover string // Blank, unspent or all
+	Start    time.Time
+	Notes    string
+}
+
+const budget_columns = "BudId, Cid, Period, Amount, Rollover, Start, Notes"
+
+// GetAllBudgets returns all the budgets in the database, sorted by
+// category and start.
+func GetAllBudgets() ([]*Budget, error) {
+	rows, err := m_db.Query("Select " + budget_columns + " from Budgets")
+	if err != nil {
+		log.Errorf("Err getting Budgets. Returning empty slice. Err=%v", err)
+		return []*Budget{}, fmt.Errorf("Err getting budgets. Err=%v", err)
+	}
+	defer rows.Close()
+	lst := make([]*Budget, 0, 100)
+	for rows.Next() {
+		b, err := scan_budget(rows)
+		if err != nil {
+			log.Errorf("Bad row in Budgets: %v.", err)
+			return []*Budget{}, fmt.Errorf("Bad row in budgets: %v", err)
+		}
+		lst = append(lst, b)
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Errorf("Database failure after iterating rows on Budgets table. Err=%v", err)
+		return []*Budget{}, fmt.Errorf("Error during row interation on Budgets Table. Err=%v", err)
+	}
+	sort.Slice(lst, func(i, j int) bool {
+		if lst[i].Cid != lst[j].Cid {
+			return lst[i].Cid.String() < lst[j].Cid.String()
+		}
+		return lst[i].Start.Before(lst[j].Start)
+	})
+	return lst, nil
+}
+
+// insert_budget adds a budget inside the given transaction.
+func insert_budget(tx *sql.Tx, b *Budget) error {
+	if b.BudId.IsZero() {
+		b.BudId = uuid.New()
+	}
+	res, err := tx.Exec("Insert into Budgets("+budget_columns+") values(?, ?, ?, ?, ?, ?, ?)",
+		b.BudId.String(), b.Cid.String(), b.Period, b.Amount, b.Rollover, null_date(b.Start), b.Notes)
+	if err != nil {
+		return fmt.Errorf("Unable to insert into Budgets. Err=%v", err)
+	}
+	rowCnt, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("Unable to get Rows Affected. Err=%v", err)
+	}
+	if rowCnt != 1 {
+		return fmt.Errorf("Wrong rowcount (%d), after Insert.", rowCnt)
+	}
+	return nil
+}
+
+func scan_budget(rows *sql.Rows) (*Budget, error) {
+	var b Budget
+	var sbudid, scid, period, rollover, notes sql.NullString
+	var amount sql.NullInt64
+	var start sql.NullTime
+	err := rows.Scan(&sbudid, &scid, &period, &amount, &rollover, &start, &notes)
+	if err != nil {
+		return &b, fmt.Errorf("Err during row scan in GetAllBudgets. Err=%v.", err)
+	}
+	b.BudId, err = uuid.FromString(sbudid.String)
+	if err != nil {
+		return &b, fmt.Errorf("Invalid uuid (%q) found for budget. Err=%v", sbudid.String, err)
+	}
+	b.Cid, err = uuid.FromString(scid.String)
+	if err != nil {
+		return &b, fmt.Errorf("Invalid category uuid (%q) found for budget %s. Err=%v", scid.String, b.BudId, err)
+	}
+	b.Period = period.String
+	b.Amount = int(amount.Int64)
+	b.Rollover = rollover.String
+	b.Start = start.Time
+	b.Notes = notes.String
+	return &b, nil
+}
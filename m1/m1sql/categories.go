@@ -0,0 +1,212 @@
+// --------------------------------------------------------------------
+// categories.go -- Manage the categories and category alias tables
+//
+// Created 2020-04-06 DLB
+// --------------------------------------------------------------------
+
+package m1sql
+
+import (
+	"database/sql"
+	"dbe/lib/log"
+	"dbe/lib/util"
+	"dbe/lib/uuid"
+	"fmt"
+	"sort"
+)
+
+type Category struct {
+	Cid     uuid.UUID
+	Name    string
+	Notes   string
+	Parent  uuid.UUID // Zero for a top level category
+	Aliases []string
+}
+
+// GetAllCategories returns all categories in the database, with their aliases.
+func GetAllCategories() ([]*Category, error) {
+	lstmap := make(map[uuid.UUID]*Category, 1000)
+	scmd := "Select Categories.Cid, Name, Categories.Notes, Parent, Alias from Categories "
+	scmd += "Left Join CatAlias on Categories.Cid=CatAlias.Cid"
+	rows, err := m_db.Query(scmd)
+	if err != nil {
+		log.Errorf("Err getting Categories. Returning empty slice. Err=%v", err)
+		return []*Category{}, fmt.Errorf("Err getting categories. Err=%v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		craw, err := scan_category(rows)
+		if err != nil {
+			log.Errorf("Bad row in Categories: %v.", err)
+			return []*Category{}, fmt.Errorf("Bad row in categories: %v", err)
+		}
+		if craw.Cid.IsZero() {
+			log.Errorf("Category with zero uuid found. Skipping.")
+			continue
+		}
+		c, ok := lstmap[craw.Cid]
+		if !ok {
+			lstmap[craw.Cid] = craw
+		} else {
+			c.Aliases = append(c.Aliases, craw.Aliases...)
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Errorf("Database failure after iterating rows on Categories table. Err=%v", err)
+		return []*Category{}, fmt.Errorf("Error during row interation on Categories Table. Err=%v", err)
+	}
+	lst := make([]*Category, 0, len(lstmap))
+	for _, c := range lstmap {
+		lst = append(lst, c)
+	}
+	sort.Slice(lst, func(i, j int) bool { return lst[j].Name > lst[i].Name })
+	return lst, nil
+}
+
+// UpdateCategory will either change a category to match the input or make a
+// new category if one doesn't already exist.  The aliases are replaced.
+func UpdateCategory(c *Category) error {
+	tx, err := m_db.Begin()
+	if err != nil {
+		log.Errorf("Unable to begin transaction for UpdateCategory. Err=%v", err)
+		return err
+	}
+	err = update_category(tx, c)
+	if err != nil {
+		attempt_rollback(tx, "Err from update_category")
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Errorf("Commit failed on Update Categories. Err=%v", err)
+		return fmt.Errorf("Commit failed on Update Categories. Err=%v", err)
+	}
+	return nil
+}
+
+// DeleteCategory removes a category and its aliases.  The number of
+// categories deleted (zero or one) is returned.
+func DeleteCategory(cid uuid.UUID) (int, error) {
+	tx, err := m_db.Begin()
+	if err != nil {
+		log.Errorf("Unable to begin transaction for DeleteCategory. Err=%v", err)
+		return 0, err
+	}
+	_, err = tx.Exec("Delete from CatAlias where Cid=?", cid.String())
+	if err != nil {
+		attempt_rollback(tx, "Delete from CatAlias failed.")
+		return 0, fmt.Errorf("Unable to delete from CatAlias. Err=%v", err)
+	}
+	res, err := tx.Exec("Delete from Categories where Cid=?", cid.String())
+	if err != nil {
+		attempt_rollback(tx, "Delete from Categories failed.")
+		return 0, fmt.Errorf("Unable to delete from Categories. Err=%v", err)
+	}
+	nn, _ := res.RowsAffected()
+	if nn != 0 && nn != 1 {
+		attempt_rollback(tx, "Wrong rowcount after delete from Categories.")
+		return 0, fmt.Errorf("Wrong number of rows affected (%d) when deleting a category.", nn)
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Errorf("Commit failed on Delete Category. Err=%v", err)
+		return 0, fmt.Errorf("Commit failed on Delete Category. Err=%v", err)
+	}
+	return int(nn), nil
+}
+
+// update_category does the work of UpdateCategory inside the given transaction.
+func update_category(tx *sql.Tx, c *Category) error {
+	if !c.Cid.IsZero() {
+		var n int
+		err := tx.QueryRow("Select count(*) from Categories where Cid=?", c.Cid.String()).Scan(&n)
+		if err != nil {
+			return fmt.Errorf("Unable to look up category. Err=%v", err)
+		}
+		if n > 0 {
+			res, err := tx.Exec("Update Categories set Name=?, Notes=?, Parent=? Where Cid=?", c.Name, c.Notes,
+				c.Parent.String(), c.Cid.String())
+			if err != nil {
+				return fmt.Errorf("Unable to update Categories table. Err=%v", err)
+			}
+			rowCnt, err := res.RowsAffected()
+			if err != nil {
+				return fmt.Errorf("Unable to retrieve rows affected after Update Categories. Err=%v", err)
+			}
+			if rowCnt > 1 {
+				return fmt.Errorf("Unable to update categories. Rows affected more than one (was %d).", rowCnt)
+			}
+			_, err = tx.Exec("Delete from CatAlias where Cid=?", c.Cid.String())
+			if err != nil {
+				return fmt.Errorf("Unable to delete category aliases. Err=%v", err)
+			}
+			return add_category_aliases(tx, c.Cid, c.Aliases)
+		}
+	}
+	if c.Cid.IsZero() {
+		c.Cid = uuid.New()
+	}
+	res, err := tx.Exec("Insert into Categories(Cid, Name, Notes, Parent) values(?, ?, ?, ?)", c.Cid.String(), c.Name,
+		c.Notes, c.Parent.String())
+	if err != nil {
+		return fmt.Errorf("Unable to insert into Categories. Err=%v", err)
+	}
+	rowCnt, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("Unable to get Rows Affected. Err=%v", err)
+	}
+	if rowCnt != 1 {
+		return fmt.Errorf("Wrong rowcount (%d), after Insert.", rowCnt)
+	}
+	return add_category_aliases(tx, c.Cid, c.Aliases)
+}
+
+func add_category_aliases(tx *sql.Tx, cid uuid.UUID, aliases []string) error {
+	if len(aliases) <= 0 {
+		return nil
+	}
+	stmt, err := tx.Prepare("Insert into CatAlias(Cid, Alias, Location, Notes) values(?, ?, '', '')")
+	if err != nil {
+		return fmt.Errorf("Unable to Prepare for insert into CatAlias. Err=%v", err)
+	}
+	defer stmt.Close()
+	for _, a := range aliases {
+		_, err = stmt.Exec(cid.String(), a)
+		if err != nil {
+			return fmt.Errorf("Failed to insert into CatAlias. Err=%v", err)
+		}
+	}
+	return nil
+}
+
+func scan_category(rows *sql.Rows) (*Category, error) {
+	var c Category
+	var scid_null, name_null, notes_null, parent_null, alias_null sql.NullString
+	err := rows.Scan(&scid_null, &name_null, &notes_null, &parent_null, &alias_null)
+	if err != nil {
+		return &c, fmt.Errorf("Err during row scan in GetAllCategories. Err=%v.", err)
+	}
+	if !scid_null.Valid {
+		return &c, fmt.Errorf("NULL uuid found for category.")
+	}
+	c.Cid, err = uuid.FromString(scid_null.String)
+	if err != nil {
+		return &c, fmt.Errorf("Invalid uuid (%q) found for category. Err=%v", scid_null.String, err)
+	}
+	if name_null.Valid {
+		c.Name = name_null.String
+	}
+	if notes_null.Valid {
+		c.Notes = notes_null.String
+	}
+	c.Parent, err = uuid.FromString0(parent_null.String)
+	if err != nil {
+		return &c, fmt.Errorf("Invalid parent uuid (%q) found for category %s. Err=%v", parent_null.String, c.Cid, err)
+	}
+	c.Aliases = make([]string, 0, 5)
+	if alias_null.Valid && !util.Blank(alias_null.String) {
+		c.Aliases = append(c.Aliases, alias_null.String)
+	}
+	return &c, nil
+}
@@ -0,0 +1,128 @@
+// --------------------------------------------------------------------
+// data.go -- Reads and replaces the entire M1Data database at once.
+//
+// Created 2020-04-06 DLB
+// --------------------------------------------------------------------
+
+package m1sql
+
+import (
+	"dbe/lib/log"
+	"fmt"
+	"time"
+)
+
+// AllData holds the entire contents of the M1Data database.
+type AllData struct {
+	Accounts     []*Account
+	Vendors      []*Vendor
+	Categories   []*Category
+	Transactions []*Transaction
+	Recons       []*Recon
+	Budgets      []*Budget
+}
+
+var all_tables []string = []string{"Accounts", "AccountAlias", "Vendors", "VendorAlias",
+	"Categories", "CatAlias", "Transactions", "CatList", "Receipts", "Recons", "Budgets"}
+
+// GetAllData reads every table in the database.
+func GetAllData() (*AllData, error) {
+	var err error
+	d := &AllData{}
+	clear_account_cache()
+	d.Accounts, err = GetAccounts()
+	if err != nil {
+		return nil, err
+	}
+	d.Vendors, err = GetAllVendors()
+	if err != nil {
+		return nil, err
+	}
+	d.Categories, err = GetAllCategories()
+	if err != nil {
+		return nil, err
+	}
+	d.Transactions, err = GetAllTransactions()
+	if err != nil {
+		return nil, err
+	}
+	d.Recons, err = GetAllRecons()
+	if err != nil {
+		return nil, err
+	}
+	d.Budgets, err = GetAllBudgets()
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// ReplaceAllData deletes everything in the database and writes the given
+// data in its place, all in one sql transaction.  Items with zero ids
+// are assigned new ones.
+func ReplaceAllData(d *AllData) error {
+	t0 := time.Now()
+	tx, err := m_db.Begin()
+	if err != nil {
+		log.Errorf("Unable to begin transaction for ReplaceAllData. Err=%v", err)
+		return err
+	}
+	for _, table := range all_tables {
+		_, err = tx.Exec("Delete from " + table)
+		if err != nil {
+			attempt_rollback(tx, "Unable to empty table.")
+			return fmt.Errorf("Unable to empty table %s. Err=%v", table, err)
+		}
+	}
+	for _, a := range d.Accounts {
+		err = insert_account(tx, a)
+		if err != nil {
+			attempt_rollback(tx, "Unable to insert account.")
+			return fmt.Errorf("Account %q: %v", a.FName, err)
+		}
+	}
+	for _, v := range d.Vendors {
+		err = insert_vendor(tx, v)
+		if err != nil {
+			attempt_rollback(tx, "Unable to insert vendor.")
+			return fmt.Errorf("Vendor %q: %v", v.FName, err)
+		}
+	}
+	for _, c := range d.Categories {
+		err = update_category(tx, c)
+		if err != nil {
+			attempt_rollback(tx, "Unable to insert category.")
+			return fmt.Errorf("Category %q: %v", c.Name, err)
+		}
+	}
+	for _, t := range d.Transactions {
+		err = update_transaction(tx, t)
+		if err != nil {
+			attempt_rollback(tx, "Unable to insert transaction.")
+			return fmt.Errorf("Transaction %s: %v", t.Tid, err)
+		}
+	}
+	for _, r := range d.Recons {
+		err = insert_recon(tx, r)
+		if err != nil {
+			attempt_rollback(tx, "Unable to insert reconciliation.")
+			return fmt.Errorf("Reconciliation %s: %v", r.RecId, err)
+		}
+	}
+	for _, b := range d.Budgets {
+		err = insert_budget(tx, b)
+		if err != nil {
+			attempt_rollback(tx, "Unable to insert budget.")
+			return fmt.Errorf("Budget %s: %v", b.BudId, err)
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Errorf("Commit failed on ReplaceAllData. Err=%v", err)
+		return fmt.Errorf("Commit failed on ReplaceAllData. Err=%v", err)
+	}
+	clear_account_cache()
+	telp := time.Now().Sub(t0).Seconds() * 1000.0
+	log.Infof("Sql database replaced. %d transactions written. (%8.2f ms)", len(d.Transactions), telp)
+	return nil
+}
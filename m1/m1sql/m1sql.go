@@ -14,13 +14,30 @@ import (
 
 var m_db *sql.DB
 
-func OpenDatabase(pw string) error {
+// OpenDatabase connects to the M1Data database on the given host.  A blank
+// host means the local server.
+func OpenDatabase(pw string, host string) error {
 	var err error
-	connection := fmt.Sprintf("root:%s@/M1Data", pw)
+	if host != "" {
+		host = "tcp(" + host + ")"
+	}
+	// parseTime is needed to scan the date columns in Transactions.
+	connection := fmt.Sprintf("root:%s@%s/M1Data?parseTime=true", pw, host)
 	m_db, err = sql.Open("mysql", connection)
 	if err != nil {
 		err := fmt.Errorf("Unable to open database. Err=%v\n", err)
 		return err
 	}
+	err = m_db.Ping()
+	if err != nil {
+		m_db.Close()
+		m_db = nil
+		return fmt.Errorf("Unable to connect to database. Err=%v", err)
+	}
 	return nil
 }
+
+// IsOpen returns true if the database has been opened.
+func IsOpen() bool {
+	return m_db != nil
+}
@@ -0,0 +1,114 @@
+// --------------------------------------------------------------------
+// recons.go -- Manage the reconciliations table
+//
+// Created 2020-04-20 DLB
+// --------------------------------------------------------------------
+
+package m1sql
+
+import (
+	"database/sql"
+	"dbe/lib/log"
+	"dbe/lib/uuid"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Recon is the checking of one account against one statement.  The
+// transactions it reconciled point back to it with their RecId.
+type Recon struct {
+	RecId    uuid.UUID
+	Aid      int       // Account, required
+	Month    time.Time // Statement Month-Year
+	End      time.Time // Closing date of the statement
+	StartBal int       // In cents
+	EndBal   int       // In cents
+	Status   string    // open or finished
+	User     string
+	Created  time.Time
+	Finished time.Time
+	NCleared int
+}
+
+const recon_columns = "RecId, Aid, Month, EndDate, StartBal, EndBal, Status, User, Created, Finished, NCleared"
+
+// GetAllRecons returns all the reconciliations in the database, sorted
+// by account and statement date.
+func GetAllRecons() ([]*Recon, error) {
+	rows, err := m_db.Query("Select " + recon_columns + " from Recons")
+	if err != nil {
+		log.Errorf("Err getting Recons. Returning empty slice. Err=%v", err)
+		return []*Recon{}, fmt.Errorf("Err getting reconciliations. Err=%v", err)
+	}
+	defer rows.Close()
+	lst := make([]*Recon, 0, 100)
+	for rows.Next() {
+		r, err := scan_recon(rows)
+		if err != nil {
+			log.Errorf("Bad row in Recons: %v.", err)
+			return []*Recon{}, fmt.Errorf("Bad row in reconciliations: %v", err)
+		}
+		lst = append(lst, r)
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Errorf("Database failure after iterating rows on Recons table. Err=%v", err)
+		return []*Recon{}, fmt.Errorf("Error during row interation on Recons Table. Err=%v", err)
+	}
+	sort.Slice(lst, func(i, j int) bool {
+		if lst[i].Aid != lst[j].Aid {
+			return lst[i].Aid < lst[j].Aid
+		}
+		return lst[i].End.Before(lst[j].End)
+	})
+	return lst, nil
+}
+
+// insert_recon adds a reconciliation inside the given transaction.
+func insert_recon(tx *sql.Tx, r *Recon) error {
+	if r.RecId.IsZero() {
+		r.RecId = uuid.New()
+	}
+	res, err := tx.Exec("Insert into Recons("+recon_columns+") values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		r.RecId.String(), r.Aid, null_date(r.Month), null_date(r.End), r.StartBal, r.EndBal, r.Status, r.User,
+		null_date(r.Created), null_date(r.Finished), r.NCleared)
+	if err != nil {
+		return fmt.Errorf("Unable to insert into Recons. Err=%v", err)
+	}
+	rowCnt, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("Unable to get Rows Affected. Err=%v", err)
+	}
+	if rowCnt != 1 {
+		return fmt.Errorf("Wrong rowcount (%d), after Insert.", rowCnt)
+	}
+	return nil
+}
+
+func scan_recon(rows *sql.Rows) (*Recon, error) {
+	var r Recon
+	var srecid, status, user sql.NullString
+	var month, end, created, finished sql.NullTime
+	var aid, startbal, endbal, ncleared sql.NullInt64
+	err := rows.Scan(&srecid, &aid, &month, &end, &startbal, &endbal, &status, &user, &created, &finished,
+		&ncleared)
+	if err != nil {
+		return &r, fmt.Errorf("Err during row scan in GetAllRecons. Err=%v.", err)
+	}
+	r.RecId, err = uuid.FromString(srecid.String)
+	if err != nil {
+		return &r, fmt.Errorf("Invalid uuid (%q) found for reconciliation. Err=%v", srecid.String, err)
+	}
+	r.Aid = int(aid.Int64)
+	r.Month = month.Time
+	r.End = end.Time
+	r.StartBal = int(startbal.Int64)
+	r.EndBal = int(endbal.Int64)
+	r.Status = status.String
+	r.User = user.String
+	r.Created = created.Time
+	r.Finished = finished.Time
+	r.NCleared = int(ncleared.Int64)
+	return &r, nil
+}
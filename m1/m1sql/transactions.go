@@ -0,0 +1,347 @@
+// --------------------------------------------------------------------
+// transactions.go -- Manage the transactions, catlist and receipts tables
+//
+// Created 2020-04-06 DLB
+// --------------------------------------------------------------------
+
+package m1sql
+
+import (
+	"database/sql"
+	"dbe/lib/log"
+	"dbe/lib/uuid"
+	"fmt"
+	"sort"
+	"time"
+)
+
+type Transaction struct {
+	Tid         uuid.UUID
+	Amount      int // In cents
+	Description string
+	DatePosted  time.Time
+	DateSettled time.Time
+	Month       time.Time // Statement Month-Year
+	Aid         int       // Account, required
+	Vid         uuid.UUID // Vendor, zero if unknown
+	BankInfo    string
+	Location    string
+	CheckNum    string
+	FitId       string // Id given by the bank in a download
+	Flag        string
+	Notes       string
+	ImportId    uuid.UUID     // The import batch that added it, zero if none
+	Cleared     string        // Blank, cleared or reconciled
+	RecId       uuid.UUID     // The reconciliation that reconciled it, zero if none
+	Xfer        uuid.UUID     // The other side of a transfer between accounts, zero if none
+	Cats        []CatListItem // From the CatList table
+	Receipts    []string      // From the Receipts table
+}
+
+// CatListItem is one split of a transaction into a category.
+type CatListItem struct {
+	Cid    uuid.UUID
+	Amount int
+	Notes  string
+}
+
+const trans_columns = "Tid, Amount, Description, DatePosted, DateSettled, Month, Aid, Vid, " +
+	"BankInfo, Location, CheckNum, FitId, Flag, Notes, ImportId, Cleared, RecId, Xfer"
+
+// GetAllTransactions returns all transactions in the database, with their
+// category splits and receipts.  The list is sorted by date.
+func GetAllTransactions() ([]*Transaction, error) {
+	tmap := make(map[uuid.UUID]*Transaction, 30000)
+	rows, err := m_db.Query("Select " + trans_columns + " from Transactions")
+	if err != nil {
+		log.Errorf("Err getting Transactions. Returning empty slice. Err=%v", err)
+		return []*Transaction{}, fmt.Errorf("Err getting transactions. Err=%v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		t, err := scan_transaction(rows)
+		if err != nil {
+			log.Errorf("Bad row in Transactions: %v.", err)
+			return []*Transaction{}, fmt.Errorf("Bad row in transactions: %v", err)
+		}
+		if t.Tid.IsZero() {
+			log.Errorf("Transaction with zero uuid found. Skipping.")
+			continue
+		}
+		tmap[t.Tid] = t
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Errorf("Database failure after iterating rows on Transactions table. Err=%v", err)
+		return []*Transaction{}, fmt.Errorf("Error during row interation on Transactions Table. Err=%v", err)
+	}
+	err = load_catlist(m_db, tmap)
+	if err != nil {
+		return []*Transaction{}, err
+	}
+	err = load_receipts(m_db, tmap)
+	if err != nil {
+		return []*Transaction{}, err
+	}
+	lst := make([]*Transaction, 0, len(tmap))
+	for _, t := range tmap {
+		lst = append(lst, t)
+	}
+	sort.Slice(lst, func(i, j int) bool { return lst[i].DatePosted.Before(lst[j].DatePosted) })
+	return lst, nil
+}
+
+// UpdateTransaction will either change a transaction to match the input or
+// make a new transaction if one doesn't already exist.  The CatList rows and
+// receipts of the transaction are replaced, all in one sql transaction.
+func UpdateTransaction(t *Transaction) error {
+	tx, err := m_db.Begin()
+	if err != nil {
+		log.Errorf("Unable to begin transaction for UpdateTransaction. Err=%v", err)
+		return err
+	}
+	err = update_transaction(tx, t)
+	if err != nil {
+		attempt_rollback(tx, "Err from update_transaction")
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Errorf("Commit failed on Update Transactions. Err=%v", err)
+		return fmt.Errorf("Commit failed on Update Transactions. Err=%v", err)
+	}
+	return nil
+}
+
+// DeleteTransaction removes a transaction along with its CatList rows and
+// receipts.  The number of transactions deleted (zero or one) is returned.
+func DeleteTransaction(tid uuid.UUID) (int, error) {
+	tx, err := m_db.Begin()
+	if err != nil {
+		log.Errorf("Unable to begin transaction for DeleteTransaction. Err=%v", err)
+		return 0, err
+	}
+	err = delete_transaction_items(tx, tid)
+	if err != nil {
+		attempt_rollback(tx, "Unable to delete transaction items.")
+		return 0, err
+	}
+	res, err := tx.Exec("Delete from Transactions where Tid=?", tid.String())
+	if err != nil {
+		attempt_rollback(tx, "Delete from Transactions failed.")
+		return 0, fmt.Errorf("Unable to delete from Transactions. Err=%v", err)
+	}
+	nn, _ := res.RowsAffected()
+	if nn != 0 && nn != 1 {
+		attempt_rollback(tx, "Wrong rowcount after delete from Transactions.")
+		return 0, fmt.Errorf("Wrong number of rows affected (%d) when deleting a transaction.", nn)
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Errorf("Commit failed on Delete Transaction. Err=%v", err)
+		return 0, fmt.Errorf("Commit failed on Delete Transaction. Err=%v", err)
+	}
+	return int(nn), nil
+}
+
+// update_transaction does the work of UpdateTransaction inside the given
+// transaction.
+func update_transaction(tx *sql.Tx, t *Transaction) error {
+	if t.Tid.IsZero() {
+		t.Tid = uuid.New()
+	} else {
+		err := delete_transaction_items(tx, t.Tid)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("Delete from Transactions where Tid=?", t.Tid.String())
+		if err != nil {
+			return fmt.Errorf("Unable to delete old transaction. Err=%v", err)
+		}
+	}
+	res, err := tx.Exec("Insert into Transactions("+trans_columns+")"+
+		" values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		t.Tid.String(), t.Amount, t.Description, null_date(t.DatePosted), null_date(t.DateSettled),
+		null_date(t.Month), t.Aid, t.Vid.String(), t.BankInfo, t.Location, t.CheckNum, t.FitId, t.Flag, t.Notes,
+		t.ImportId.String(), t.Cleared, t.RecId.String(), t.Xfer.String())
+	if err != nil {
+		return fmt.Errorf("Unable to insert into Transactions. Err=%v", err)
+	}
+	rowCnt, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("Unable to get Rows Affected. Err=%v", err)
+	}
+	if rowCnt != 1 {
+		return fmt.Errorf("Wrong rowcount (%d), after Insert.", rowCnt)
+	}
+	if len(t.Cats) > 0 {
+		stmt, err := tx.Prepare("Insert into CatList(Tid, Cid, Amount, Notes) values(?, ?, ?, ?)")
+		if err != nil {
+			return fmt.Errorf("Unable to Prepare for insert into CatList. Err=%v", err)
+		}
+		defer stmt.Close()
+		for _, c := range t.Cats {
+			_, err = stmt.Exec(t.Tid.String(), c.Cid.String(), c.Amount, c.Notes)
+			if err != nil {
+				return fmt.Errorf("Failed to insert into CatList. Err=%v", err)
+			}
+		}
+	}
+	if len(t.Receipts) > 0 {
+		stmt, err := tx.Prepare("Insert into Receipts(Tid, Url) values(?, ?)")
+		if err != nil {
+			return fmt.Errorf("Unable to Prepare for insert into Receipts. Err=%v", err)
+		}
+		defer stmt.Close()
+		for _, r := range t.Receipts {
+			_, err = stmt.Exec(t.Tid.String(), r)
+			if err != nil {
+				return fmt.Errorf("Failed to insert into Receipts. Err=%v", err)
+			}
+		}
+	}
+	return nil
+}
+
+func delete_transaction_items(tx *sql.Tx, tid uuid.UUID) error {
+	_, err := tx.Exec("Delete from CatList where Tid=?", tid.String())
+	if err != nil {
+		return fmt.Errorf("Unable to delete from CatList. Err=%v", err)
+	}
+	_, err = tx.Exec("Delete from Receipts where Tid=?", tid.String())
+	if err != nil {
+		return fmt.Errorf("Unable to delete from Receipts. Err=%v", err)
+	}
+	return nil
+}
+
+// querier is either a *sql.DB or a *sql.Tx.
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func load_catlist(q querier, tmap map[uuid.UUID]*Transaction) error {
+	rows, err := q.Query("Select Tid, Cid, Amount, Notes from CatList")
+	if err != nil {
+		return fmt.Errorf("Err getting CatList. Err=%v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var stid, scid, notes sql.NullString
+		var amount sql.NullInt64
+		err = rows.Scan(&stid, &scid, &amount, &notes)
+		if err != nil {
+			return fmt.Errorf("Err during row scan in CatList. Err=%v", err)
+		}
+		tid := uuid.ForceStr(stid.String)
+		cid, err := uuid.FromString0(scid.String)
+		if err != nil {
+			return fmt.Errorf("Invalid category uuid (%q) in CatList. Err=%v", scid.String, err)
+		}
+		t, ok := tmap[tid]
+		if !ok {
+			log.Errorf("CatList row for unknown transaction (%s). Skipping.", tid)
+			continue
+		}
+		t.Cats = append(t.Cats, CatListItem{Cid: cid, Amount: int(amount.Int64), Notes: notes.String})
+	}
+	err = rows.Err()
+	if err != nil {
+		return fmt.Errorf("Database failure after iterating rows on CatList table. Err=%v", err)
+	}
+	return nil
+}
+
+func load_receipts(q querier, tmap map[uuid.UUID]*Transaction) error {
+	rows, err := q.Query("Select Tid, Url from Receipts")
+	if err != nil {
+		return fmt.Errorf("Err getting Receipts. Err=%v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var stid, url sql.NullString
+		err = rows.Scan(&stid, &url)
+		if err != nil {
+			return fmt.Errorf("Err during row scan in Receipts. Err=%v", err)
+		}
+		tid := uuid.ForceStr(stid.String)
+		t, ok := tmap[tid]
+		if !ok {
+			log.Errorf("Receipt for unknown transaction (%s). Skipping.", tid)
+			continue
+		}
+		t.Receipts = append(t.Receipts, url.String)
+	}
+	err = rows.Err()
+	if err != nil {
+		return fmt.Errorf("Database failure after iterating rows on Receipts table. Err=%v", err)
+	}
+	return nil
+}
+
+func scan_transaction(rows *sql.Rows) (*Transaction, error) {
+	var t Transaction
+	var stid, description, bankinfo, location, checknum, fitid, flag, notes, svid, simport, cleared, srecid, sxfer sql.NullString
+	var posted, settled, month sql.NullTime
+	var amount, aid sql.NullInt64
+	err := rows.Scan(&stid, &amount, &description, &posted, &settled, &month, &aid, &svid,
+		&bankinfo, &location, &checknum, &fitid, &flag, &notes, &simport, &cleared, &srecid, &sxfer)
+	if err != nil {
+		return &t, fmt.Errorf("Err during row scan in GetAllTransactions. Err=%v.", err)
+	}
+	if !stid.Valid {
+		return &t, fmt.Errorf("NULL uuid found for transaction.")
+	}
+	t.Tid, err = uuid.FromString(stid.String)
+	if err != nil {
+		return &t, fmt.Errorf("Invalid uuid (%q) found for transaction. Err=%v", stid.String, err)
+	}
+	t.Amount = int(amount.Int64)
+	t.Aid = int(aid.Int64)
+	t.Vid, err = uuid.FromString0(svid.String)
+	if err != nil {
+		return &t, fmt.Errorf("Invalid vendor uuid (%q) found for transaction %s. Err=%v", svid.String, t.Tid, err)
+	}
+	t.ImportId, err = uuid.FromString0(simport.String)
+	if err != nil {
+		return &t, fmt.Errorf("Invalid import uuid (%q) found for transaction %s. Err=%v", simport.String, t.Tid, err)
+	}
+	t.RecId, err = uuid.FromString0(srecid.String)
+	if err != nil {
+		return &t, fmt.Errorf("Invalid reconciliation uuid (%q) found for transaction %s. Err=%v",
+			srecid.String, t.Tid, err)
+	}
+	t.Xfer, err = uuid.FromString0(sxfer.String)
+	if err != nil {
+		return &t, fmt.Errorf("Invalid transfer uuid (%q) found for transaction %s. Err=%v",
+			sxfer.String, t.Tid, err)
+	}
+	t.Cleared = cleared.String
+	t.Description = description.String
+	t.BankInfo = bankinfo.String
+	t.Location = location.String
+	t.CheckNum = checknum.String
+	t.FitId = fitid.String
+	t.Flag = flag.String
+	t.Notes = notes.String
+	if posted.Valid {
+		t.DatePosted = posted.Time
+	}
+	if settled.Valid {
+		t.DateSettled = settled.Time
+	}
+	if month.Valid {
+		t.Month = month.Time
+	}
+	t.Cats = make([]CatListItem, 0, 1)
+	t.Receipts = make([]string, 0)
+	return &t, nil
+}
+
+// null_date gives NULL for a zero date, so that it is not stored as 0001-01-01.
+func null_date(d time.Time) sql.NullTime {
+	if d.IsZero() {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: d, Valid: true}
+}
@@ -206,6 +206,21 @@ func add_vendor_aliases(tx *sql.Tx, vid uuid.UUID, aliases []string) error {
 	return nil
 }
 
+// insert_vendor adds a new vendor and its aliases.  A Vid is assigned if
+// one is not provided.
+func insert_vendor(tx *sql.Tx, v *Vendor) error {
+	if v.Vid.IsZero() {
+		v.Vid = uuid.New()
+	}
+	_, err := tx.Exec("Insert into Vendors(Vid, DName, FName, DefaultCat, BusinessType, PrimaryProduct, Notes)"+
+		" values(?, ?, ?, ?, ?, ?, ?)",
+		v.Vid.String(), v.DName, v.FName, v.DefaultCat, v.BusinessType, v.PrimaryProduct, v.Notes)
+	if err != nil {
+		return fmt.Errorf("Unable to insert into Vendors. Err=%v", err)
+	}
+	return add_vendor_aliases(tx, v.Vid, v.Aliases)
+}
+
 func get_vendor_by_uuid(tx *sql.Tx, vid uuid.UUID) (*Vendor, error) {
 	var v Vendor
 
@@ -215,13 +230,14 @@ func get_vendor_by_uuid(tx *sql.Tx, vid uuid.UUID) (*Vendor, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 	ncnt := 0
 	for rows.Next() {
 		vraw, err := scan_vendor(rows)
 		if err != nil {
 			return nil, fmt.Errorf("Scan failure in get_vendor_by_uuid. Err=%v.", err)
 		}
-		if !vraw.Vid.IsZero() {
+		if vraw.Vid.IsZero() {
 			return nil, fmt.Errorf("Vendor with zero uuid returned from select statement!")
 		}
 		if vraw.Vid != vid {
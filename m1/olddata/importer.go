@@ -0,0 +1,95 @@
+// --------------------------------------------------------------------
+// importer.go -- Lets the transaction files of the old data be
+// imported like any other file (see m1/importer).
+//
+// Created 2020-04-19 DLB
+// --------------------------------------------------------------------
+
+package olddata
+
+import (
+	"bytes"
+	"dbe/lib/util"
+	"dbe/m1/importer"
+	"encoding/csv"
+	"fmt"
+	"strings"
+)
+
+// old_importer reads the transaction files from the old spreadsheets.
+// Each row names its account, vendor and category, which are matched
+// to the database when the file is imported.  What the old row said is
+// kept in the transaction's notes.
+type old_importer struct{}
+
+func init() {
+	importer.RegisterImporter(old_importer{})
+}
+
+func (old_importer) Name() string {
+	return "olddata"
+}
+
+func (old_importer) Description() string {
+	return "Transactions from the old spreadsheets, saved as CSV."
+}
+
+// Detect knows an old file by its header, which must have the
+// account, settle date and amount columns.
+func (old_importer) Detect(fn string, head []byte) int {
+	if is_transaction_header(head) {
+		return importer.Detect_Certain
+	}
+	return importer.Detect_No
+}
+
+func (old_importer) Parse(fn string, data []byte, opt *importer.Options) ([]*importer.Parsed, error) {
+	c := util.NewContext(util.Context_Internal)
+	tlst, err := ReadTransactions(c, bytes.NewReader(data), make([]*OldTransaction, 0, 1000))
+	if err != nil {
+		return nil, err
+	}
+	lst := make([]*importer.Parsed, 0, 5)
+	byname := make(map[string]*importer.Parsed, 5)
+	for _, t := range tlst {
+		key := strings.ToLower(strings.TrimSpace(t.Account))
+		p, ok := byname[key]
+		if !ok {
+			p = &importer.Parsed{Account: t.Account, Records: make([]*importer.Record, 0, len(tlst))}
+			byname[key] = p
+			lst = append(lst, p)
+		}
+		r := &importer.Record{Payee: t.Vendor, Description: t.Description, Memo: t.BankInfo,
+			Location: t.Location, CheckNum: t.CheckNum, Flag: t.Flag, DatePosted: t.DatePosted,
+			DateSettled: t.DateSettled, Month: t.Month, Amount: t.Amount, Category: t.Category}
+		r.Notes = fmt.Sprintf("Old Account: %s\nOld Vendor: %s\nOld Cat: %s.\n", t.Account, t.Vendor, t.Category)
+		if !util.Blank(t.Flag) {
+			r.Notes += fmt.Sprintf("Flags: %s\n", t.Flag)
+		}
+		if !util.Blank(t.CheckNum) {
+			r.Notes += fmt.Sprintf("CheckNum: %s\n", t.CheckNum)
+		}
+		if !util.Blank(t.Receipt) {
+			r.Notes += fmt.Sprintf("Receipt: %s\n", t.Receipt)
+		}
+		p.Records = append(p.Records, r)
+	}
+	return lst, nil
+}
+
+// is_transaction_header returns true if the first line of some data is
+// the header of an old transaction file.
+func is_transaction_header(data []byte) bool {
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		data = data[:i]
+	}
+	rdr := csv.NewReader(bytes.NewReader(data))
+	rdr.LazyQuotes = true
+	rdr.TrimLeadingSpace = true
+	r, err := rdr.Read()
+	if err != nil {
+		return false
+	}
+	m, err := map_header(r)
+	return err == nil && m.iAccount >= 0
+}
@@ -13,7 +13,6 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"strconv"
 	"strings"
 )
 
@@ -42,7 +41,12 @@ func LoadTransactions(c *util.Context, filename string, records []*OldTransactio
 		return records, err
 	}
 	defer fi.Close()
+	return ReadTransactions(c, fi, records)
+}
 
+// ReadTransactions reads the transactions in the csv data of one old
+// file, and appends them to records.
+func ReadTransactions(c *util.Context, fi io.Reader, records []*OldTransaction) ([]*OldTransaction, error) {
 	rdr := csv.NewReader(fi)
 	rdr.LazyQuotes = true
 	rdr.TrimLeadingSpace = true
@@ -136,17 +140,16 @@ func convert_record(c *util.Context, m headermap, r []string, ilinenum int) (rou
 		rd.Receipt = r[m.iReceipt]
 	}
 	if m.iAmount > 0 {
-		t := remove_commas(r[m.iAmount])
-		t = strings.TrimSpace(t)
+		t := strings.TrimSpace(r[m.iAmount])
 		if len(t) <= 0 {
 			c.Printf("Blank value found for amount in line %d. Using zero.\n", ilinenum)
 			rd.Amount = 0
 		} else {
-			f, err := strconv.ParseFloat(t, 64)
+			amt, err := util.ParseMoney(t)
 			if err != nil {
 				return nil, fmt.Errorf("Unable to parse the amount in line %d. (%q)", ilinenum, r[m.iAmount])
 			}
-			rd.Amount = int(f * 100.0)
+			rd.Amount = amt
 		}
 	}
 	return rd, nil
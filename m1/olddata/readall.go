@@ -9,27 +9,60 @@ package olddata
 import (
 	"dbe/lib/log"
 	"dbe/lib/util"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
 )
 
-var inputfiles []string = []string{
-	"CarolsVisa.csv",
-	"BofAChecking.csv",
-	"DalsVisa.csv",
-	"FRBChecking.csv",
-	"MLChecking.csv",
+// TransactionFiles returns the old transaction files in the old data
+// folder, sorted by name.  Any csv file there with the header of a
+// transaction file is one, so a new card only needs its file.
+func TransactionFiles() ([]string, error) {
+	if olddata_folder == "" {
+		return nil, fmt.Errorf("No old data folder. Set olddata_folder in the config file.")
+	}
+	infos, err := ioutil.ReadDir(olddata_folder)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read the old data folder (%s). Err=%v", olddata_folder, err)
+	}
+	lst := make([]string, 0, 10)
+	for _, fi := range infos {
+		if fi.IsDir() || !has_csv_extension(fi.Name()) {
+			continue
+		}
+		fn := olddata_folder + fi.Name()
+		f, err := os.Open(fn)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to open %s. Err=%v", fn, err)
+		}
+		head := make([]byte, 2048)
+		n, _ := f.Read(head)
+		f.Close()
+		if is_transaction_header(head[:n]) {
+			lst = append(lst, fn)
+		}
+	}
+	sort.Strings(lst)
+	return lst, nil
 }
 
 func ReadAll(c *util.Context) (records []*OldTransaction, err error) {
+	files, err := TransactionFiles()
+	if err != nil {
+		return nil, err
+	}
 	t := make([]*OldTransaction, 0, 20000)
 	n := 0
-	for _, fn := range inputfiles {
-		t, err = LoadTransactions(c, olddata_folder+fn, t)
+	for _, fn := range files {
+		t, err = LoadTransactions(c, fn, t)
 		if err != nil {
-			log.Errorf("Unable to read oldata file %s. Err=%v", olddata_folder+fn, err)
-			c.Printf("Error in file %s.\nErr=%v\nOperation hatled.\n", fn, err)
+			log.Errorf("Unable to read oldata file %s. Err=%v", fn, err)
+			c.Printf("Error in file %s.\nErr=%v\nOperation hatled.\n", filepath.Base(fn), err)
 			break
 		}
-		c.Printf("Read %s. Records = %d\n", fn, len(t)-n)
+		c.Printf("Read %s. Records = %d\n", filepath.Base(fn), len(t)-n)
 		n = len(t)
 	}
 	return t, nil
@@ -7,11 +7,12 @@
 package olddata
 
 import (
+	"dbe/lib/util"
 	"time"
 )
 
 type OldTransaction struct {
-	Amount      int // In cents
+	Amount      util.Money
 	Description string
 	DatePosted  time.Time
 	DateSettled time.Time
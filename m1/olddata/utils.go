@@ -8,6 +8,8 @@ package olddata
 
 import (
 	"dbe/lib/util"
+	"path/filepath"
+	"strings"
 )
 
 // findmax returns the maxium number in the argument list.
@@ -42,3 +44,8 @@ func all_fields_blank(ss []string) bool {
 	}
 	return true
 }
+
+// has_csv_extension returns true if a file name ends in .csv.
+func has_csv_extension(fn string) bool {
+	return strings.ToLower(filepath.Ext(fn)) == ".csv"
+}
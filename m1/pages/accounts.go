@@ -1,5 +1,6 @@
 // --------------------------------------------------------------------
-// main.go -- Starting Page for m1
+// accounts.go -- Page with the balances of the accounts, and the
+// balance history of one of them.
 //
 // Created 2020-03-15 DLB
 // --------------------------------------------------------------------
@@ -9,20 +10,46 @@ package pages
 import (
 	"dbe/lib/log"
 	"dbe/lib/util"
-	"dbe/m1/users"
-	"encoding/json"
+	"dbe/lib/uuid"
+	m1 "dbe/m1/m1data"
+	"fmt"
 	"github.com/gin-gonic/gin"
+	"html"
+	"strings"
+	"time"
 )
 
-type AccountsDefaults struct {
-	User string
-	Year string
+// AccountRow is one account with its balances, ready for the page.  The
+// strings are already escaped for html.
+type AccountRow struct {
+	Aid      string
+	Name     string
+	Opening  string
+	Opened   string
+	Posted   string
+	Settled  string
+	Pending  int
+	Last     string
+	Selected bool
+}
+
+// BalanceRow is one line of an account's balance history, ready for the
+// page.  The strings are already escaped for html.
+type BalanceRow struct {
+	Date        string
+	Description string
+	Amount      string
+	Balance     string
+	Opening     bool
 }
 
 type AccountsData struct {
 	*HeaderData
-	Defaults  *AccountsDefaults
-	UsersJson string
+	Accounts []*AccountRow
+	Account  *AccountRow // The account being shown, or nil
+	Basis    string
+	ByMonth  bool
+	History  []*BalanceRow
 }
 
 func init() {
@@ -31,52 +58,110 @@ func init() {
 }
 
 func handle_accounts(c *gin.Context) {
-	handle_accounts_with_error(c, "")
+	aid, _ := uuid.FromString(c.Query("Aid"))
+	handle_accounts_with_message(c, aid, c.Query("Basis"), c.Query("By") == "month", "", "")
 }
 
-func handle_accounts_with_error(c *gin.Context, errmsg string) {
+func handle_accounts_with_message(c *gin.Context, aid uuid.UUID, basis string, bymonth bool, msg, errmsg string) {
 	data := &AccountsData{}
 	data.HeaderData = GetHeaderData(c)
 	data.PageTitle = "Accounts"
-	data.Instructions = ""
+	data.Instructions = "The posted balance counts every transaction. The settled balance counts " +
+		"only the ones that have settled, and should match the bank."
 	data.StyleSheets = []string{"accounts"}
-	data.OnLoadFuncJS = "startUp"
+	data.Message = msg
 	data.ErrorMessage = errmsg
 
-	ulst := users.GetUsers()
-	u_bytes, err := json.MarshalIndent(ulst, "", "  ")
+	basis, err := m1.ParseBasis(basis)
 	if err != nil {
-		SendErrorPagef(c, "Unabel to convert user list to json. <br>Err=%v", err)
-		return
+		data.ErrorMessage = err.Error()
+		basis = m1.Basis_Posted
 	}
-	data.UsersJson = string(u_bytes)
-
-	//var err error
-	// data.SelectionBoxData, err = GetSelectionBoxData()
-	// if err != nil {
-	// 	SendErrorPage(c, err)
-	// 	return
-	// }
+	data.Basis = basis
+	data.ByMonth = bymonth
 
-	var sd *AccountsDefaults
-	ses := GetSession(c)
-	t, ok := ses.Data["AccountsDefaults"]
-	if !ok {
-		sd = &AccountsDefaults{}
-	} else {
-		sd, ok = t.(*AccountsDefaults)
-		if !ok {
-			log.Errorf("Unable to type convert AccountsDefaults in handle_accounts_with_error.")
-			sd = &AccountsDefaults{}
+	v := m1.GetView()
+	for _, ab := range v.AccountBalances() {
+		a := ab.A
+		row := &AccountRow{Aid: a.Aid.String(), Name: html.EscapeString(a.FName),
+			Opening: a.OpeningBalance.String(), Opened: format_day(a.OpeningDate), Posted: ab.Posted.String(),
+			Settled: ab.Settled.String(), Pending: ab.NPending, Last: format_day(ab.Last), Selected: a.Aid == aid}
+		data.Accounts = append(data.Accounts, row)
+		if row.Selected {
+			data.Account = row
 		}
 	}
-	if util.Blank(sd.User) {
-		sd.User = data.HeaderData.Designer
+	if data.Account == nil {
+		SendPage(c, data, "header", "menubar", "accounts", "footer")
+		return
+	}
+	lst, err := v.RunningBalance(aid, basis)
+	if err != nil {
+		data.ErrorMessage = err.Error()
+		SendPage(c, data, "header", "menubar", "accounts", "footer")
+		return
+	}
+	if bymonth {
+		lst = m1.MonthlyBalances(lst)
+	}
+	// Newest first, since that is what is looked at most.
+	for i := len(lst) - 1; i >= 0; i-- {
+		x := lst[i]
+		row := &BalanceRow{Date: format_day(x.Date), Amount: x.Amount.String(), Balance: x.Balance.String()}
+		switch {
+		case x.T == nil:
+			row.Description, row.Opening = "Opening Balance", true
+		case bymonth:
+			row.Date = x.Date.Format("2006-01")
+		default:
+			row.Description = html.EscapeString(x.T.Description)
+		}
+		data.History = append(data.History, row)
 	}
-	data.Defaults = sd
 	SendPage(c, data, "header", "menubar", "accounts", "footer")
 }
 
+// handle_accounts_post sets the opening balance of an account.
 func handle_accounts_post(c *gin.Context) {
-	handle_accounts_with_error(c, "Page not fully implemented yet.")
+	aid, err := uuid.FromString(c.PostForm("Aid"))
+	basis := c.PostForm("Basis")
+	bymonth := c.PostForm("By") == "month"
+	if err != nil {
+		handle_accounts_with_message(c, aid, basis, bymonth, "", fmt.Sprintf("Bad id for the account (%q).", c.PostForm("Aid")))
+		return
+	}
+	a := m1.GetAccount(aid)
+	if a == nil {
+		handle_accounts_with_message(c, aid, basis, bymonth, "", "The account is gone.")
+		return
+	}
+	amt, err := util.ParseMoney(c.PostForm("Opening"))
+	if err != nil {
+		handle_accounts_with_message(c, aid, basis, bymonth, "", fmt.Sprintf("Bad amount (%q).", c.PostForm("Opening")))
+		return
+	}
+	var date time.Time
+	if s := strings.TrimSpace(c.PostForm("Opened")); s != "" {
+		date, err = util.ParseGenericTime(s)
+		if err != nil {
+			handle_accounts_with_message(c, aid, basis, bymonth, "", fmt.Sprintf("Bad date (%q).", s))
+			return
+		}
+	}
+	err = m1.SetOpeningBalance(aid, amt, date)
+	if err != nil {
+		handle_accounts_with_message(c, aid, basis, bymonth, "", err.Error())
+		return
+	}
+	log.Infof("Opening balance of %s set to %s on %s by %s.", a.FName, amt, format_day(date),
+		GetHeaderData(c).Designer)
+	handle_accounts_with_message(c, aid, basis, bymonth, "The opening balance was set.", "")
+}
+
+// format_day returns a date for the page, or blank for the zero time.
+func format_day(d time.Time) string {
+	if d.IsZero() {
+		return ""
+	}
+	return d.Format("2006-01-02")
 }
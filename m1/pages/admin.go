@@ -62,7 +62,7 @@ func handle_command(c *gin.Context) {
 	cmd := string(cmd_bytes)
 	log.Infof("Admin command from %s received: %s", data.Designer, cmd)
 
-	sout := console.ExecuteCommand(cmd)
+	sout := console.ExecuteCommandAs(data.Designer, cmd)
 	sendCmdResponse(c, sout)
 }
 
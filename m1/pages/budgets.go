@@ -0,0 +1,229 @@
+// --------------------------------------------------------------------
+// budgets.go -- Page to set the budgets of the categories, and to see
+// how this month's spending compares with them.
+//
+// Created 2020-04-20 DLB
+// --------------------------------------------------------------------
+
+package pages
+
+import (
+	"dbe/lib/log"
+	"dbe/lib/util"
+	"dbe/lib/uuid"
+	m1 "dbe/m1/m1data"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"html"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BudgetReportRow is one category of the budget report, ready for the
+// page.  The strings are already escaped for html.
+type BudgetReportRow struct {
+	Name      string
+	Period    string
+	Carry     string
+	Allowance string
+	Pace      string
+	Spent     string
+	Left      string
+	Status    string
+	Used      int // Percent of the allowance spent, for the bar
+	PaceAt    int // Percent of the allowance at the pace
+	Indent    int // Pixels, for the depth in the category tree
+}
+
+// BudgetRow is one budget, ready for the page.  The strings are already
+// escaped for html.
+type BudgetRow struct {
+	BudId    string
+	Category string
+	Amount   string
+	Period   string
+	Start    string
+	Rollover string
+	Notes    string
+}
+
+// UnbudgetedRow is spending in a category with no budget.
+type UnbudgetedRow struct {
+	Name  string
+	Spent string
+}
+
+type BudgetsData struct {
+	*HeaderData
+	Month      string // yyyy-mm
+	PrevMonth  string
+	NextMonth  string
+	AsOf       string
+	Lines      []*BudgetReportRow
+	Budgeted   string
+	Spent      string
+	Unbudgeted []*UnbudgetedRow
+	Unspent    string
+	Budgets    []*BudgetRow
+	Categories []string
+}
+
+func init() {
+	RegisterPage("/Budgets", Invoke_GET, authorizer, handle_budgets)
+	RegisterPage("/SubmitBudgets", Invoke_POST, authorizer, handle_budgets_post)
+}
+
+func handle_budgets(c *gin.Context) {
+	handle_budgets_with_message(c, c.Query("Month"), "", "")
+}
+
+func handle_budgets_with_message(c *gin.Context, smonth, msg, errmsg string) {
+	data := &BudgetsData{}
+	data.HeaderData = GetHeaderData(c)
+	data.PageTitle = "Budgets"
+	data.Instructions = "Spending this month against the budgets. A category is ahead when it is " +
+		"spending faster than its pace (the part of the allowance that would be spent by now), " +
+		"and over when it has spent more than its allowance."
+	data.StyleSheets = []string{"budgets"}
+	data.Message = msg
+	data.ErrorMessage = errmsg
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	month := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, time.UTC)
+	if !util.Blank(smonth) {
+		m, err := time.Parse("2006-01", strings.TrimSpace(smonth))
+		if err != nil {
+			data.ErrorMessage = fmt.Sprintf("Bad month (%q). Use yyyy-mm.", smonth)
+		} else {
+			month = m
+		}
+	}
+	data.Month = month.Format("2006-01")
+	data.PrevMonth = month.AddDate(0, -1, 0).Format("2006-01")
+	data.NextMonth = month.AddDate(0, 1, 0).Format("2006-01")
+
+	v := m1.GetView()
+	for _, b := range v.Budgets() {
+		data.Budgets = append(data.Budgets, &BudgetRow{BudId: b.BudId.String(),
+			Category: html.EscapeString(category_fname(v, b.Cid)), Amount: b.Amount.String(), Period: b.Period,
+			Start: b.Start.Format("2006-01"), Rollover: m1.RolloverName(b.Rollover),
+			Notes: html.EscapeString(b.Notes)})
+	}
+	for _, cat := range v.Categories() {
+		data.Categories = append(data.Categories, html.EscapeString(cat.Name))
+	}
+	sort.Strings(data.Categories)
+
+	r, err := v.BudgetReport(month, today)
+	if err != nil {
+		data.ErrorMessage = err.Error()
+		SendPage(c, data, "header", "menubar", "budgets", "footer")
+		return
+	}
+	data.AsOf = r.AsOf.Format("2006-01-02")
+	for _, ln := range r.Lines {
+		row := &BudgetReportRow{Name: html.EscapeString(ln.Name), Period: ln.From.Format("2006-01"),
+			Carry: ln.Carry.String(), Allowance: ln.Allowance.String(), Pace: ln.Pace.String(),
+			Spent: ln.Spent.String(), Left: ln.Left.String(), Status: ln.Status,
+			Used: percent_of(ln.Spent, ln.Allowance), PaceAt: percent_of(ln.Pace, ln.Allowance),
+			Indent: tree_indent(ln.Depth)}
+		if ln.B.Period == m1.Budget_Annual {
+			row.Period += " to " + ln.To.AddDate(0, 0, -1).Format("2006-01")
+		}
+		data.Lines = append(data.Lines, row)
+	}
+	data.Budgeted = r.Budgeted.String()
+	data.Spent = r.Spent.String()
+	for _, ct := range r.Unbudgeted {
+		name := html.EscapeString(ct.Name)
+		if ct.Cid.IsZero() {
+			name = "(uncategorized)"
+		}
+		data.Unbudgeted = append(data.Unbudgeted, &UnbudgetedRow{Name: name, Spent: (-ct.Net()).String()})
+	}
+	data.Unspent = r.Unspent.String()
+	SendPage(c, data, "header", "menubar", "budgets", "footer")
+}
+
+// percent_of returns part as a percent of whole, from 0 to 100, for
+// the bars on the page.
+func percent_of(part, whole util.Money) int {
+	if whole <= 0 {
+		if part > 0 {
+			return 100
+		}
+		return 0
+	}
+	p := int(int64(part) * 100 / int64(whole))
+	if p < 0 {
+		return 0
+	}
+	if p > 100 {
+		return 100
+	}
+	return p
+}
+
+// handle_budgets_post sets or deletes a budget.
+func handle_budgets_post(c *gin.Context) {
+	smonth := c.PostForm("Month")
+	user := GetHeaderData(c).Designer
+	v := m1.GetView()
+	switch c.PostForm("Action") {
+	case "set":
+		cat := v.CategoryByName(c.PostForm("Category"))
+		if cat == nil {
+			handle_budgets_with_message(c, smonth, "", fmt.Sprintf("No category named %q.", c.PostForm("Category")))
+			return
+		}
+		start, err := time.Parse("2006-01", strings.TrimSpace(c.PostForm("Start")))
+		if err != nil {
+			handle_budgets_with_message(c, smonth, "", fmt.Sprintf("Bad start (%q). Use yyyy-mm.", c.PostForm("Start")))
+			return
+		}
+		amt, err := util.ParseMoney(c.PostForm("Amount"))
+		if err != nil {
+			handle_budgets_with_message(c, smonth, "", fmt.Sprintf("Bad amount (%q).", c.PostForm("Amount")))
+			return
+		}
+		b := &m1.Budget{Cid: cat.Cid, Start: start}
+		if o := v.CategoryBudgetAt(cat.Cid, start); o != nil {
+			bc := *o
+			b = &bc
+		}
+		b.Amount, b.Period, b.Rollover, b.Notes = amt, c.PostForm("Period"), c.PostForm("Rollover"), c.PostForm("Notes")
+		err = m1.SetBudget(b)
+		if err != nil {
+			handle_budgets_with_message(c, smonth, "", err.Error())
+			return
+		}
+		log.Infof("Budget of %s from %s set to %s %s by %s.", cat.Name, b.Start.Format("2006-01"), b.Amount,
+			b.Period, user)
+		handle_budgets_with_message(c, smonth, fmt.Sprintf("The budget of %s was set.", html.EscapeString(cat.Name)), "")
+	case "delete":
+		id, err := uuid.FromString(c.PostForm("BudId"))
+		if err != nil {
+			handle_budgets_with_message(c, smonth, "", fmt.Sprintf("Bad id for the budget (%q).", c.PostForm("BudId")))
+			return
+		}
+		err = m1.DeleteBudget(id)
+		if err != nil {
+			handle_budgets_with_message(c, smonth, "", err.Error())
+			return
+		}
+		log.Infof("Budget %s deleted by %s.", id, user)
+		handle_budgets_with_message(c, smonth, "The budget was deleted.", "")
+	default:
+		handle_budgets_with_message(c, smonth, "", fmt.Sprintf("Unknown action (%q).", c.PostForm("Action")))
+	}
+}
+
+// category_fname returns the name of a category, or blank.
+func category_fname(v *m1.View, cid uuid.UUID) string {
+	if cat := v.Category(cid); cat != nil {
+		return cat.Name
+	}
+	return ""
+}
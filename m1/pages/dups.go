@@ -0,0 +1,100 @@
+// --------------------------------------------------------------------
+// dups.go -- Page to review possible duplicate transactions.
+//
+// Created 2020-04-19 DLB
+// --------------------------------------------------------------------
+
+package pages
+
+import (
+	"dbe/lib/log"
+	"dbe/lib/uuid"
+	m1 "dbe/m1/m1data"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"html"
+	"strings"
+)
+
+// DupRow is one transaction in the review queue, ready for the page.
+// The strings are already escaped for html.
+type DupRow struct {
+	Rid         string
+	Date        string
+	Account     string
+	Description string
+	Amount      string
+	Score       int
+	LikeDate    string
+	LikeDesc    string
+	Reasons     string
+	Source      string
+}
+
+type DupsData struct {
+	*HeaderData
+	Rows []*DupRow
+}
+
+func init() {
+	RegisterPage("/Dups", Invoke_GET, authorizer, handle_dups)
+	RegisterPage("/SubmitDups", Invoke_POST, authorizer, handle_dups_post)
+}
+
+func handle_dups(c *gin.Context) {
+	handle_dups_with_message(c, "", "")
+}
+
+func handle_dups_with_message(c *gin.Context, msg, errmsg string) {
+	data := &DupsData{}
+	data.HeaderData = GetHeaderData(c)
+	data.PageTitle = "Possible Duplicates"
+	data.Instructions = "These transactions look like ones already in the database. " +
+		"Drop the ones that are duplicates, and add the ones that are not."
+	data.StyleSheets = []string{"dups"}
+	data.Message = msg
+	data.ErrorMessage = errmsg
+
+	v := m1.GetView()
+	for _, r := range v.Reviews() {
+		t := r.T
+		row := &DupRow{Rid: r.Rid.String(), Date: t.Date().Format("2006-01-02"),
+			Account: html.EscapeString(m1.AccountName(t.Aid)), Description: html.EscapeString(t.Description),
+			Amount: t.Amount.String(), Score: r.Score, Reasons: html.EscapeString(strings.Join(r.Reasons, " ")),
+			Source: html.EscapeString(r.Source)}
+		if o := v.Transaction(r.DupOf); o != nil {
+			row.LikeDate = o.Date().Format("2006-01-02")
+			row.LikeDesc = html.EscapeString(o.Description)
+		}
+		data.Rows = append(data.Rows, row)
+	}
+	SendPage(c, data, "header", "menubar", "dups", "footer")
+}
+
+func handle_dups_post(c *gin.Context) {
+	rid, err := uuid.FromString(c.PostForm("Rid"))
+	if err != nil {
+		handle_dups_with_message(c, "", fmt.Sprintf("Bad id for the transaction (%q).", c.PostForm("Rid")))
+		return
+	}
+	user := GetHeaderData(c).Designer
+	switch c.PostForm("Action") {
+	case "drop":
+		err = m1.AcceptDuplicate(rid)
+		if err == nil {
+			log.Infof("Possible duplicate %s dropped by %s.", rid, user)
+			handle_dups_with_message(c, "The duplicate was dropped.", "")
+			return
+		}
+	case "add":
+		err = m1.RejectDuplicate(rid)
+		if err == nil {
+			log.Infof("Possible duplicate %s added by %s.", rid, user)
+			handle_dups_with_message(c, "The transaction was added.", "")
+			return
+		}
+	default:
+		err = fmt.Errorf("Unknown action (%q).", c.PostForm("Action"))
+	}
+	handle_dups_with_message(c, "", err.Error())
+}
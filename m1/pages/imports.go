@@ -0,0 +1,270 @@
+// --------------------------------------------------------------------
+// imports.go -- Pages to look over, fix, commit and discard staged
+// imports.
+//
+// Created 2020-04-19 DLB
+// --------------------------------------------------------------------
+
+package pages
+
+import (
+	"dbe/lib/log"
+	"dbe/lib/util"
+	"dbe/lib/uuid"
+	"dbe/m1/importer"
+	m1 "dbe/m1/m1data"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"html"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ImportBatchRow is one staged batch, ready for the page.  The strings
+// are already escaped for html.
+type ImportBatchRow struct {
+	Bid      string
+	Created  string
+	Source   string
+	Kind     string
+	Rows     int
+	ToAdd    int
+	Skipped  int
+	NeedWork int
+}
+
+// ImportRow is one row of a staged batch, ready for the page.  The
+// strings are already escaped for html.
+type ImportRow struct {
+	Row         int
+	Status      string
+	Skip        bool
+	NeedsWork   bool
+	Date        string
+	Account     string
+	Vendor      string
+	Cat         string
+	Description string
+	Amount      string
+	Reasons     string
+	Source      string // Account and vendor names from the file
+}
+
+type ImportsData struct {
+	*HeaderData
+	Batches    []*ImportBatchRow
+	Batch      *ImportBatchRow // The batch being shown, or nil
+	Status     string          // Only rows with this status are shown
+	Statuses   []string
+	Rows       []*ImportRow
+	Accounts   []string
+	Vendors    []string
+	Categories []string
+}
+
+func init() {
+	RegisterPage("/Imports", Invoke_GET, authorizer, handle_imports)
+	RegisterPage("/SubmitImportRow", Invoke_POST, authorizer, handle_import_row_post)
+	RegisterPage("/SubmitImport", Invoke_POST, authorizer, handle_import_post)
+}
+
+func handle_imports(c *gin.Context) {
+	bid, _ := uuid.FromString(c.Query("Bid"))
+	handle_imports_with_message(c, bid, c.Query("Status"), "", "")
+}
+
+func handle_imports_with_message(c *gin.Context, bid uuid.UUID, status, msg, errmsg string) {
+	data := &ImportsData{}
+	data.HeaderData = GetHeaderData(c)
+	data.PageTitle = "Staged Imports"
+	data.Instructions = "Imports are staged here before they go into the database. " +
+		"Fix or skip the rows that need work, then commit the batch, or discard it."
+	data.StyleSheets = []string{"imports"}
+	data.Message = msg
+	data.ErrorMessage = errmsg
+
+	v := m1.GetView()
+	for _, b := range v.Batches() {
+		if b.Status == m1.Batch_Staged {
+			data.Batches = append(data.Batches, make_import_batch_row(b))
+		}
+	}
+	b := v.Batch(bid)
+	if b == nil || b.Status != m1.Batch_Staged {
+		SendPage(c, data, "header", "menubar", "imports", "footer")
+		return
+	}
+	data.Batch = make_import_batch_row(b)
+	data.Status = html.EscapeString(status)
+	data.Statuses = []string{m1.Row_NoAccount, m1.Row_Problem, m1.Row_NoVendor, m1.Row_NoCategory,
+		m1.Row_Review, m1.Row_Duplicate, m1.Row_New}
+	for _, r := range b.Rows {
+		if status != "" && r.Status != status {
+			continue
+		}
+		t := r.T
+		row := &ImportRow{Row: r.Row, Status: r.Status, Skip: r.Skip,
+			NeedsWork: !r.Skip && (r.Status == m1.Row_NoAccount || r.Status == m1.Row_Problem),
+			Date:      t.Date().Format("2006-01-02"), Description: html.EscapeString(t.Description),
+			Amount: t.Amount.String(), Reasons: html.EscapeString(strings.Join(r.Reasons, " "))}
+		if a := v.Account(t.Aid); a != nil {
+			row.Account = html.EscapeString(a.FName)
+		}
+		if vv := v.Vendor(t.Vid); vv != nil {
+			row.Vendor = html.EscapeString(vv.FName)
+		}
+		if len(t.Cats) == 1 {
+			if cat := v.Category(t.Cats[0].Cid); cat != nil {
+				row.Cat = html.EscapeString(cat.Name)
+			}
+		} else if len(t.Cats) > 1 {
+			row.Cat = fmt.Sprintf("(%d splits)", len(t.Cats))
+		}
+		if row.Account == "" || row.Vendor == "" {
+			row.Source = html.EscapeString(strings.TrimSpace(r.Account + " " + r.Vendor))
+		}
+		data.Rows = append(data.Rows, row)
+	}
+	for _, a := range v.Accounts() {
+		data.Accounts = append(data.Accounts, html.EscapeString(a.FName))
+	}
+	for _, vv := range v.Vendors() {
+		data.Vendors = append(data.Vendors, html.EscapeString(vv.FName))
+	}
+	for _, cat := range v.Categories() {
+		data.Categories = append(data.Categories, html.EscapeString(cat.Name))
+	}
+	sort.Strings(data.Accounts)
+	sort.Strings(data.Vendors)
+	sort.Strings(data.Categories)
+	SendPage(c, data, "header", "menubar", "imports", "footer")
+}
+
+func make_import_batch_row(b *m1.ImportBatch) *ImportBatchRow {
+	br := &ImportBatchRow{Bid: b.Bid.String(), Created: b.Created.Format("2006-01-02 15:04"),
+		Source: html.EscapeString(b.Source), Kind: html.EscapeString(b.Kind), Rows: len(b.Rows)}
+	for _, r := range b.Rows {
+		switch {
+		case r.Skip:
+			br.Skipped++
+		case r.Status == m1.Row_NoAccount || r.Status == m1.Row_Problem:
+			br.NeedWork++
+		default:
+			br.ToAdd++
+		}
+	}
+	return br
+}
+
+func handle_import_row_post(c *gin.Context) {
+	status := c.PostForm("Status")
+	bid, err := uuid.FromString(c.PostForm("Bid"))
+	if err != nil {
+		handle_imports_with_message(c, bid, status, "", fmt.Sprintf("Bad id for the batch (%q).", c.PostForm("Bid")))
+		return
+	}
+	nrow, err := strconv.Atoi(c.PostForm("Row"))
+	if err != nil {
+		handle_imports_with_message(c, bid, status, "", fmt.Sprintf("Bad row (%q).", c.PostForm("Row")))
+		return
+	}
+	v := m1.GetView()
+	b := v.Batch(bid)
+	if b == nil {
+		handle_imports_with_message(c, bid, status, "", "The batch is gone.")
+		return
+	}
+	var r *m1.ImportRow
+	for _, rr := range b.Rows {
+		if rr.Row == nrow {
+			r = rr
+		}
+	}
+	if r == nil {
+		handle_imports_with_message(c, bid, status, "", fmt.Sprintf("No row %d in the batch.", nrow))
+		return
+	}
+	e := &importer.RowEdit{}
+	switch c.PostForm("Action") {
+	case "skip":
+		e.SetSkip, e.Skip = true, true
+	case "keep":
+		e.SetSkip, e.Skip = true, false
+	case "save":
+		if s := c.PostForm("Account"); !util.Blank(s) {
+			a := v.AccountByName(s)
+			if a == nil {
+				handle_imports_with_message(c, bid, status, "", fmt.Sprintf("No account named %q.", s))
+				return
+			}
+			e.Aid = a.Aid
+		}
+		if s := c.PostForm("Vendor"); !util.Blank(s) {
+			vv := v.VendorByName(s)
+			if vv == nil {
+				handle_imports_with_message(c, bid, status, "", fmt.Sprintf("No vendor named %q.", s))
+				return
+			}
+			e.Vid = vv.Vid
+		}
+		if s := c.PostForm("Cat"); !util.Blank(s) {
+			cat := v.CategoryByName(s)
+			if cat == nil {
+				handle_imports_with_message(c, bid, status, "", fmt.Sprintf("No category named %q.", s))
+				return
+			}
+			// Splits are left alone unless a different category is given.
+			if len(r.T.Cats) != 1 || r.T.Cats[0].Cid != cat.Cid {
+				e.Cid = cat.Cid
+			}
+		}
+	default:
+		handle_imports_with_message(c, bid, status, "", fmt.Sprintf("Unknown action (%q).", c.PostForm("Action")))
+		return
+	}
+	err = importer.EditBatch(bid, []int{nrow}, e)
+	if err != nil {
+		handle_imports_with_message(c, bid, status, "", err.Error())
+		return
+	}
+	log.Infof("Row %d of import batch %s changed by %s.", nrow, bid, GetHeaderData(c).Designer)
+	handle_imports_with_message(c, bid, status, fmt.Sprintf("Row %d was changed.", nrow), "")
+}
+
+func handle_import_post(c *gin.Context) {
+	bid, err := uuid.FromString(c.PostForm("Bid"))
+	if err != nil {
+		handle_imports_with_message(c, bid, "", "", fmt.Sprintf("Bad id for the batch (%q).", c.PostForm("Bid")))
+		return
+	}
+	user := GetHeaderData(c).Designer
+	switch c.PostForm("Action") {
+	case "commit":
+		var n int
+		n, err = importer.RecheckBatch(bid)
+		if err == nil && n > 0 {
+			handle_imports_with_message(c, bid, "", fmt.Sprintf("The database changed since the batch was staged, "+
+				"and %d rows changed status.  Look it over, and commit it again.", n), "")
+			return
+		}
+		if err == nil {
+			err = m1.CommitBatch(bid)
+		}
+		if err == nil {
+			log.Infof("Import batch %s committed by %s.", bid, user)
+			handle_imports_with_message(c, uuid.Zero(), "", "The batch was committed.", "")
+			return
+		}
+	case "discard":
+		err = m1.DiscardBatch(bid)
+		if err == nil {
+			log.Infof("Import batch %s discarded by %s.", bid, user)
+			handle_imports_with_message(c, uuid.Zero(), "", "The batch was discarded.", "")
+			return
+		}
+	default:
+		err = fmt.Errorf("Unknown action (%q).", c.PostForm("Action"))
+	}
+	handle_imports_with_message(c, bid, "", "", err.Error())
+}
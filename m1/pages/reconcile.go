@@ -0,0 +1,221 @@
+// --------------------------------------------------------------------
+// reconcile.go -- Page to reconcile an account against a statement.
+//
+// Created 2020-04-20 DLB
+// --------------------------------------------------------------------
+
+package pages
+
+import (
+	"dbe/lib/log"
+	"dbe/lib/util"
+	"dbe/lib/uuid"
+	m1 "dbe/m1/m1data"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"html"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ReconcileAccount is an account that can be reconciled, ready for the
+// page.  The strings are already escaped for html.
+type ReconcileAccount struct {
+	Aid      string
+	Name     string
+	Locked   string // The date the account is reconciled through
+	Open     string // The closing date of the open reconciliation, if any
+	Selected bool
+}
+
+// ReconcileItem is a transaction that can be on the statement, ready for
+// the page.  The strings are already escaped for html.
+type ReconcileItem struct {
+	Tid         string
+	Date        string
+	Description string
+	Amount      string
+	Cleared     bool
+}
+
+// ReconcileHistory is a reconciliation of the account, ready for the
+// page.
+type ReconcileHistory struct {
+	RecId    string
+	Month    string
+	End      string
+	StartBal string
+	EndBal   string
+	Status   string
+	NCleared int
+	User     string
+	Last     bool // True if it is the last finished one, which can be reopened
+}
+
+type ReconcileData struct {
+	*HeaderData
+	Accounts   []*ReconcileAccount
+	Account    *ReconcileAccount // The account being shown, or nil
+	RecId      string            // The open reconciliation, or blank
+	Month      string
+	End        string
+	StartBal   string
+	Cleared    string
+	NCleared   int
+	ClearedBal string
+	EndBal     string
+	Difference string
+	Balances   bool
+	Items      []*ReconcileItem
+	History    []*ReconcileHistory
+}
+
+func init() {
+	RegisterPage("/Reconcile", Invoke_GET, authorizer, handle_reconcile)
+	RegisterPage("/SubmitReconcile", Invoke_POST, authorizer, handle_reconcile_post)
+}
+
+func handle_reconcile(c *gin.Context) {
+	aid, _ := uuid.FromString(c.Query("Aid"))
+	handle_reconcile_with_message(c, aid, "", "")
+}
+
+func handle_reconcile_with_message(c *gin.Context, aid uuid.UUID, msg, errmsg string) {
+	data := &ReconcileData{}
+	data.HeaderData = GetHeaderData(c)
+	data.PageTitle = "Reconcile"
+	data.Instructions = "Start with the closing date and ending balance of the statement. " +
+		"Check off the transactions on the statement, and save, until the difference is zero. " +
+		"Then finish, which locks the account through the closing date."
+	data.StyleSheets = []string{"reconcile"}
+	data.Message = msg
+	data.ErrorMessage = errmsg
+
+	v := m1.GetView()
+	for _, a := range v.Accounts() {
+		ra := &ReconcileAccount{Aid: a.Aid.String(), Name: html.EscapeString(a.FName),
+			Locked: format_day(v.LockedThrough(a.Aid)), Selected: a.Aid == aid}
+		if r := v.OpenReconciliation(a.Aid); r != nil {
+			ra.Open = format_day(r.End)
+		}
+		data.Accounts = append(data.Accounts, ra)
+		if ra.Selected {
+			data.Account = ra
+		}
+	}
+	sort.Slice(data.Accounts, func(i, j int) bool {
+		return strings.ToLower(data.Accounts[i].Name) < strings.ToLower(data.Accounts[j].Name)
+	})
+	if data.Account == nil {
+		SendPage(c, data, "header", "menubar", "reconcile", "footer")
+		return
+	}
+	lst := v.Reconciliations(aid)
+	for i := len(lst) - 1; i >= 0; i-- {
+		r := lst[i]
+		data.History = append(data.History, &ReconcileHistory{RecId: r.RecId.String(), Month: r.Month.Format("2006-01"),
+			End: format_day(r.End), StartBal: r.StartBal.String(), EndBal: r.EndBal.String(), Status: r.Status,
+			NCleared: r.NCleared, User: html.EscapeString(r.User),
+			Last: r.Status == m1.Recon_Finished && r.End.Equal(v.LockedThrough(aid))})
+	}
+	r := v.OpenReconciliation(aid)
+	if r == nil {
+		SendPage(c, data, "header", "menubar", "reconcile", "footer")
+		return
+	}
+	st, err := v.ReconcileState(r.RecId)
+	if err != nil {
+		data.ErrorMessage = err.Error()
+		SendPage(c, data, "header", "menubar", "reconcile", "footer")
+		return
+	}
+	data.RecId = r.RecId.String()
+	data.Month = r.Month.Format("2006-01")
+	data.End = format_day(r.End)
+	data.StartBal = r.StartBal.String()
+	data.Cleared = st.Cleared.String()
+	data.NCleared = st.NCleared
+	data.ClearedBal = st.ClearedBal.String()
+	data.EndBal = r.EndBal.String()
+	data.Difference = st.Difference.String()
+	data.Balances = st.Difference == 0
+	for _, t := range st.Items {
+		data.Items = append(data.Items, &ReconcileItem{Tid: t.Tid.String(), Date: format_day(t.Date()),
+			Description: html.EscapeString(t.Description), Amount: t.Amount.String(),
+			Cleared: t.Cleared != m1.Cleared_No})
+	}
+	SendPage(c, data, "header", "menubar", "reconcile", "footer")
+}
+
+func handle_reconcile_post(c *gin.Context) {
+	aid, err := uuid.FromString(c.PostForm("Aid"))
+	if err != nil {
+		handle_reconcile_with_message(c, aid, "", fmt.Sprintf("Bad id for the account (%q).", c.PostForm("Aid")))
+		return
+	}
+	a := m1.GetAccount(aid)
+	if a == nil {
+		handle_reconcile_with_message(c, aid, "", "The account is gone.")
+		return
+	}
+	user := GetHeaderData(c).Designer
+	recid, _ := uuid.FromString(c.PostForm("RecId"))
+	msg := ""
+	switch c.PostForm("Action") {
+	case "start":
+		var end time.Time
+		var bal util.Money
+		end, err = util.ParseGenericTime(c.PostForm("End"))
+		if err != nil {
+			err = fmt.Errorf("Bad closing date (%q).", c.PostForm("End"))
+			break
+		}
+		bal, err = util.ParseMoney(c.PostForm("EndBal"))
+		if err != nil {
+			err = fmt.Errorf("Bad ending balance (%q).", c.PostForm("EndBal"))
+			break
+		}
+		_, err = m1.StartReconciliation(aid, time.Time{}, end, bal, user)
+		msg = "The reconciliation was started."
+	case "save", "finish":
+		var st *m1.ReconcileState
+		st, err = m1.GetView().ReconcileState(recid)
+		if err != nil {
+			break
+		}
+		checked := make(map[string]bool, len(st.Items))
+		for _, s := range c.PostFormArray("Cleared") {
+			checked[s] = true
+		}
+		clear := make([]uuid.UUID, 0, len(st.Items))
+		unclear := make([]uuid.UUID, 0, len(st.Items))
+		for _, t := range st.Items {
+			if checked[t.Tid.String()] {
+				clear = append(clear, t.Tid)
+			} else {
+				unclear = append(unclear, t.Tid)
+			}
+		}
+		_, err = m1.SetCleared(clear, unclear)
+		msg = "The cleared transactions were saved."
+		if err == nil && c.PostForm("Action") == "finish" {
+			err = m1.FinishReconciliation(recid)
+			msg = "The reconciliation was finished."
+		}
+	case "discard":
+		err = m1.DiscardReconciliation(recid)
+		msg = "The reconciliation was discarded."
+	case "reopen":
+		_, err = m1.ReopenReconciliation(recid)
+		msg = "The reconciliation was reopened."
+	default:
+		err = fmt.Errorf("Unknown action (%q).", c.PostForm("Action"))
+	}
+	if err != nil {
+		handle_reconcile_with_message(c, aid, "", err.Error())
+		return
+	}
+	log.Infof("Reconcile of %s: %s by %s.", a.FName, c.PostForm("Action"), user)
+	handle_reconcile_with_message(c, aid, msg, "")
+}
@@ -0,0 +1,192 @@
+// --------------------------------------------------------------------
+// reports.go -- Page with income and spending by category, and the
+// likely transfers that are not yet linked.
+//
+// Created 2020-04-20 DLB
+// --------------------------------------------------------------------
+
+package pages
+
+import (
+	"dbe/lib/log"
+	"dbe/lib/util"
+	"dbe/lib/uuid"
+	m1 "dbe/m1/m1data"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"html"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReportLine is one category of the report, ready for the page.  The
+// strings are already escaped for html.
+type ReportLine struct {
+	Name    string
+	Income  string
+	Expense string
+	Net     string
+	N       int
+	Total   bool
+	Sub     bool // True for a subtotal of the categories under it
+	Indent  int  // Pixels, for the depth in the category tree
+}
+
+// TransferRow is one likely transfer, ready for the page.  The strings
+// are already escaped for html.
+type TransferRow struct {
+	Date        string
+	From        string
+	To          string
+	Description string
+	Amount      string
+	Score       int
+	Reasons     string
+}
+
+type ReportsData struct {
+	*HeaderData
+	From       string
+	To         string
+	Account    string
+	Accounts   []string
+	Transfers  bool // True if transfers are counted
+	Depth      int  // Levels of the category tree shown, or zero for all
+	Depths     []int
+	Lines      []*ReportLine
+	NTransfers int
+	XferAmount string
+	Likely     []*TransferRow
+}
+
+func init() {
+	RegisterPage("/Reports", Invoke_GET, authorizer, handle_reports)
+	RegisterPage("/SubmitReports", Invoke_POST, authorizer, handle_reports_post)
+}
+
+func handle_reports(c *gin.Context) {
+	depth, _ := strconv.Atoi(c.Query("Depth"))
+	handle_reports_with_message(c, c.Query("From"), c.Query("To"), c.Query("Account"),
+		c.Query("Transfers") == "on", depth, "", "")
+}
+
+func handle_reports_with_message(c *gin.Context, sfrom, sto, sacc string, transfers bool, depth int, msg, errmsg string) {
+	data := &ReportsData{}
+	data.HeaderData = GetHeaderData(c)
+	data.PageTitle = "Reports"
+	data.Instructions = "Income and spending by category. Transfers between our own accounts " +
+		"are left out, so that card payments are not counted twice."
+	data.StyleSheets = []string{"reports"}
+	data.Message = msg
+	data.ErrorMessage = errmsg
+	data.From = html.EscapeString(sfrom)
+	data.To = html.EscapeString(sto)
+	data.Account = html.EscapeString(sacc)
+	data.Transfers = transfers
+	data.Depth = depth
+	data.Depths = []int{1, 2, 3}
+
+	v := m1.GetView()
+	for _, a := range v.Accounts() {
+		data.Accounts = append(data.Accounts, html.EscapeString(a.FName))
+	}
+	r, err := report_for_page(v, sfrom, sto, sacc, transfers)
+	if err != nil {
+		data.ErrorMessage = err.Error()
+		SendPage(c, data, "header", "menubar", "reports", "footer")
+		return
+	}
+	for _, ct := range v.RollUp(r.Lines, depth) {
+		name := html.EscapeString(ct.Name)
+		if ct.Cid.IsZero() {
+			name = "(uncategorized)"
+		}
+		data.Lines = append(data.Lines, &ReportLine{Name: name, Income: ct.Income.String(),
+			Expense: ct.Expense.String(), Net: ct.Net().String(), N: ct.N, Sub: ct.Sub, Indent: tree_indent(ct.Depth)})
+	}
+	data.Lines = append(data.Lines, &ReportLine{Name: "Total", Income: r.Income.String(),
+		Expense: r.Expense.String(), Net: r.Net().String(), N: r.NTrans, Total: true, Indent: tree_indent(1)})
+	data.NTransfers = r.NTransfers
+	data.XferAmount = r.Transfers.String()
+	for _, m := range v.FindTransfers(0) {
+		data.Likely = append(data.Likely, &TransferRow{Date: format_day(m.From.Date()),
+			From: html.EscapeString(account_fname(v, m.From.Aid)), To: html.EscapeString(account_fname(v, m.To.Aid)),
+			Description: html.EscapeString(m.From.Description), Amount: (-m.From.Amount).String(),
+			Score: m.Score, Reasons: html.EscapeString(strings.Join(m.Reasons, " "))})
+	}
+	SendPage(c, data, "header", "menubar", "reports", "footer")
+}
+
+// report_for_page runs the category report for the filters on the page.
+// The to date is included.
+func report_for_page(v *m1.View, sfrom, sto, sacc string, transfers bool) (*m1.CategoryReport, error) {
+	var from, to time.Time
+	var err error
+	if !util.Blank(sfrom) {
+		from, err = util.ParseGenericTime(sfrom)
+		if err != nil {
+			return nil, fmt.Errorf("Bad from date (%q).", sfrom)
+		}
+	}
+	if !util.Blank(sto) {
+		to, err = util.ParseGenericTime(sto)
+		if err != nil {
+			return nil, fmt.Errorf("Bad to date (%q).", sto)
+		}
+		to = to.AddDate(0, 0, 1)
+	}
+	aid := uuid.Zero()
+	if !util.Blank(sacc) {
+		a := v.AccountByName(sacc)
+		if a == nil {
+			return nil, fmt.Errorf("No account named %q.", sacc)
+		}
+		aid = a.Aid
+	}
+	return v.CategoryReport(from, to, aid, transfers)
+}
+
+// handle_reports_post links the likely transfers shown on the page.  So
+// that only what was looked at is linked, the number shown must still
+// be what is found.
+func handle_reports_post(c *gin.Context) {
+	sfrom, sto, sacc := c.PostForm("From"), c.PostForm("To"), c.PostForm("Account")
+	transfers := c.PostForm("Transfers") == "on"
+	depth, _ := strconv.Atoi(c.PostForm("Depth"))
+	if c.PostForm("Action") != "link" {
+		handle_reports_with_message(c, sfrom, sto, sacc, transfers, depth, "",
+			fmt.Sprintf("Unknown action (%q).", c.PostForm("Action")))
+		return
+	}
+	lst := m1.GetView().FindTransfers(0)
+	if n, err := strconv.Atoi(c.PostForm("N")); err != nil || n != len(lst) {
+		handle_reports_with_message(c, sfrom, sto, sacc, transfers, depth, "",
+			"The likely transfers changed. Look them over, and link them again.")
+		return
+	}
+	n, err := m1.LinkTransfers(lst)
+	if err != nil {
+		handle_reports_with_message(c, sfrom, sto, sacc, transfers, depth, "", err.Error())
+		return
+	}
+	log.Infof("%d transfers linked by %s.", n, GetHeaderData(c).Designer)
+	handle_reports_with_message(c, sfrom, sto, sacc, transfers, depth, fmt.Sprintf("%d transfers were linked.", n), "")
+}
+
+// account_fname returns the full name of an account, or blank.
+func account_fname(v *m1.View, aid uuid.UUID) string {
+	if a := v.Account(aid); a != nil {
+		return a.FName
+	}
+	return ""
+}
+
+// tree_indent returns how far to indent a category on a page, given its
+// depth in the category tree.
+func tree_indent(depth int) int {
+	if depth <= 1 {
+		return 4
+	}
+	return 4 + 16*(depth-1)
+}
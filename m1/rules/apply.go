@@ -0,0 +1,267 @@
+// --------------------------------------------------------------------
+// apply.go -- Matches rules to transactions and fills them in.
+//
+// Created 2020-04-19 DLB
+// --------------------------------------------------------------------
+
+package rules
+
+import (
+	"dbe/lib/util"
+	m1 "dbe/m1/m1data"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// VendorDefault is the rule name given in a Result when the categories
+// came from the vendor's default category.
+const VendorDefault = "(vendor default)"
+
+// Result is what the rules would do to a transaction.
+type Result struct {
+	T       *m1.Transaction // A changed copy of the transaction
+	Rule    string          // Name of the rule used
+	Changes []string        // What was changed, for reports
+}
+
+// Apply runs the rules on a transaction.  The first rule that matches
+// is used.  Unless the rule says to replace them, only the parts of the
+// transaction that are blank are filled in: a vendor is set if there is
+// none, and categories are set if none of the splits has one.  If the
+// transaction still has no categories after that, and its vendor has a
+// default category, the default is used.  Nil is returned if nothing
+// would change.  The transaction given is not changed.
+func (rs *RuleSet) Apply(v *m1.View, t *m1.Transaction) *Result {
+	tc := *t
+	res := &Result{T: &tc, Changes: make([]string, 0, 4)}
+	for _, r := range rs.Rules {
+		if r.Matches(t) {
+			res.Rule = r.Name
+			r.apply(v, res)
+			break
+		}
+	}
+	if needs_cats(&tc) && !tc.Vid.IsZero() && tc.Amount != 0 {
+		vv := v.Vendor(tc.Vid)
+		if vv != nil && !vv.DefaultCid.IsZero() {
+			tc.Cats = []m1.CatItem{m1.CatItem{Cid: vv.DefaultCid, Amount: tc.Amount}}
+			res.Changes = append(res.Changes, "Cats: "+cats_text(v, tc.Cats)+" "+VendorDefault)
+			if res.Rule == "" {
+				res.Rule = VendorDefault
+			}
+		}
+	}
+	if len(res.Changes) == 0 {
+		return nil
+	}
+	return res
+}
+
+// Matches returns true if the rule matches a transaction.
+func (r *Rule) Matches(t *m1.Transaction) bool {
+	if !r.vid.IsZero() && t.Vid != r.vid {
+		return false
+	}
+	if !r.aid.IsZero() && t.Aid != r.aid {
+		return false
+	}
+	if r.Desc != nil && !r.Desc.MatchString(t.Description) {
+		return false
+	}
+	if r.UseAmount && (t.Amount < r.MinAmount || t.Amount > r.MaxAmount) {
+		return false
+	}
+	if r.MinDay > 0 {
+		day := t.Date().Day()
+		if day < r.MinDay || day > r.MaxDay {
+			return false
+		}
+	}
+	return true
+}
+
+// apply makes the changes of a rule to the transaction in a result.
+func (r *Rule) apply(v *m1.View, res *Result) {
+	t := res.T
+	if !r.setvid.IsZero() && t.Vid != r.setvid && (t.Vid.IsZero() || r.Replace) {
+		t.Vid = r.setvid
+		res.Changes = append(res.Changes, "Vendor: "+r.SetVendor)
+	}
+	if len(r.Splits) > 0 && t.Amount != 0 && (needs_cats(t) || r.Replace) {
+		cats := r.make_splits(t.Amount)
+		if cats != nil && !same_cats(t.Cats, cats) {
+			t.Cats = cats
+			res.Changes = append(res.Changes, "Cats: "+cats_text(v, cats))
+		}
+	}
+	if r.Flag != "" && t.Flag != r.Flag && (t.Flag == "" || r.Replace) {
+		t.Flag = r.Flag
+		res.Changes = append(res.Changes, "Flag: "+r.Flag)
+	}
+	if r.Note != "" && !strings.Contains(t.Notes, r.Note) {
+		if t.Notes != "" && !strings.HasSuffix(t.Notes, "\n") {
+			t.Notes += "\n"
+		}
+		t.Notes += r.Note + "\n"
+		res.Changes = append(res.Changes, "Note: "+r.Note)
+	}
+}
+
+// make_splits divides an amount among the categories of a rule.  Fixed
+// amounts take the sign of the amount, and percents are of the whole
+// amount.  Nil is returned if nothing would be left for the rest.
+func (r *Rule) make_splits(amount util.Money) []m1.CatItem {
+	cats := make([]m1.CatItem, len(r.Splits))
+	irest := -1
+	for i, sp := range r.Splits {
+		cats[i].Cid = sp.cid
+		if sp.Rest {
+			irest = i
+		}
+	}
+	if irest < 0 {
+		// All percents, adding to 100.
+		weights := make([]int, len(r.Splits))
+		for i, sp := range r.Splits {
+			weights[i] = sp.Percent
+		}
+		for i, amt := range amount.Allocate(weights) {
+			cats[i].Amount = amt
+		}
+	} else {
+		var used util.Money
+		for i, sp := range r.Splits {
+			switch {
+			case sp.Rest:
+				continue
+			case sp.Percent > 0:
+				cats[i].Amount = amount.MulFrac(sp.Percent, 10000)
+			case amount < 0:
+				cats[i].Amount = -sp.Amount
+			default:
+				cats[i].Amount = sp.Amount
+			}
+			used += cats[i].Amount
+		}
+		left := amount - used
+		if left == 0 || (left < 0) != (amount < 0) {
+			return nil
+		}
+		cats[irest].Amount = left
+	}
+	// A small amount can leave a percent with nothing.
+	lst := make([]m1.CatItem, 0, len(cats))
+	for _, ci := range cats {
+		if ci.Amount != 0 {
+			lst = append(lst, ci)
+		}
+	}
+	return lst
+}
+
+// needs_cats returns true if none of the splits of a transaction has a
+// category.
+func needs_cats(t *m1.Transaction) bool {
+	for _, ci := range t.Cats {
+		if !ci.Cid.IsZero() {
+			return false
+		}
+	}
+	return true
+}
+
+func same_cats(a, b []m1.CatItem) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Cid != b[i].Cid || a[i].Amount != b[i].Amount {
+			return false
+		}
+	}
+	return true
+}
+
+// cats_text describes splits, such as "Groceries -30.00, Household -12.85".
+func cats_text(v *m1.View, cats []m1.CatItem) string {
+	lst := make([]string, 0, len(cats))
+	for _, ci := range cats {
+		name := ""
+		if c := v.Category(ci.Cid); c != nil {
+			name = c.Name
+		}
+		lst = append(lst, fmt.Sprintf("%s %s", name, ci.Amount))
+	}
+	return strings.Join(lst, ", ")
+}
+
+// MatchText describes what a rule matches, for reports.
+func (r *Rule) MatchText() string {
+	lst := make([]string, 0, 5)
+	if r.Vendor != "" {
+		lst = append(lst, "vendor="+r.Vendor)
+	}
+	if r.Account != "" {
+		lst = append(lst, "account="+r.Account)
+	}
+	if r.DescText != "" {
+		lst = append(lst, "desc="+r.DescText)
+	}
+	if r.UseAmount {
+		lst = append(lst, "amount="+range_text(r.MinAmount, r.MaxAmount))
+	}
+	if r.MinDay > 0 {
+		lst = append(lst, fmt.Sprintf("day=%d..%d", r.MinDay, r.MaxDay))
+	}
+	if len(lst) == 0 {
+		return "(everything)"
+	}
+	return strings.Join(lst, " ")
+}
+
+// ActionText describes what a rule does, for reports.
+func (r *Rule) ActionText() string {
+	lst := make([]string, 0, 5)
+	if r.SetVendor != "" {
+		lst = append(lst, "vendor="+r.SetVendor)
+	}
+	if len(r.Splits) == 1 && r.Splits[0].Rest {
+		lst = append(lst, "cat="+r.Splits[0].Category)
+	} else if len(r.Splits) > 0 {
+		cats := make([]string, 0, len(r.Splits))
+		for _, sp := range r.Splits {
+			switch {
+			case sp.Rest:
+				cats = append(cats, sp.Category+" rest")
+			case sp.Percent > 0:
+				cats = append(cats, fmt.Sprintf("%s %s%%", sp.Category, util.Money(sp.Percent)))
+			default:
+				cats = append(cats, fmt.Sprintf("%s %s", sp.Category, sp.Amount))
+			}
+		}
+		lst = append(lst, "cats="+strings.Join(cats, ", "))
+	}
+	if r.Flag != "" {
+		lst = append(lst, "flag="+r.Flag)
+	}
+	if r.Note != "" {
+		lst = append(lst, "note="+r.Note)
+	}
+	if r.Replace {
+		lst = append(lst, "(replace)")
+	}
+	return strings.Join(lst, " ")
+}
+
+func range_text(min, max util.Money) string {
+	s := ""
+	if min != util.Money(math.MinInt64) {
+		s += min.Format(util.MoneyFormat{NoCommas: true})
+	}
+	s += ".."
+	if max != util.Money(math.MaxInt64) {
+		s += max.Format(util.MoneyFormat{NoCommas: true})
+	}
+	return s
+}
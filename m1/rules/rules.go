@@ -0,0 +1,397 @@
+// --------------------------------------------------------------------
+// rules.go -- Rules that fill in the vendor, categories, flag and
+// notes of transactions.  This file reads the rules file.
+//
+// Created 2020-04-19 DLB
+// --------------------------------------------------------------------
+
+package rules
+
+import (
+	"dbe/lib/util"
+	"dbe/lib/uuid"
+	"dbe/m1/config"
+	m1 "dbe/m1/m1data"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The rules are kept in a text file next to config.txt, named by the
+// 'rules_file' config parameter (rules.txt by default).  Each rule
+// starts with its name in brackets, and is followed by key=value lines,
+// in the same style as the import profiles:
+//
+//   // Everything from Costco is mostly food.
+//   [costco]
+//   vendor=Costco
+//   cats=Groceries 70%, Household rest
+//
+// The rules are tried in the order they are in the file, and the first
+// one that matches a transaction is used.  See rules_example.txt for
+// all the keys.
+
+const default_rules_file = "rules.txt"
+
+// Rule matches transactions and says what to fill in on them.  The
+// match fields that are blank (or zero) match everything.
+type Rule struct {
+	Name string
+	Line int // Line in the file where the rule starts
+
+	// Match
+	Vendor    string
+	Account   string
+	DescText  string         // The regular expression, as given
+	Desc      *regexp.Regexp // Matched against the description, ignoring case
+	UseAmount bool
+	MinAmount util.Money
+	MaxAmount util.Money
+	MinDay    int // Day of the month, 1 to 31.  Zero for any day.
+	MaxDay    int
+
+	// Actions
+	SetVendor string
+	Splits    []*Split
+	Flag      string
+	Note      string
+	Replace   bool // If true, replaces what is already there
+
+	vid, aid, setvid uuid.UUID // Filled in by Compile
+}
+
+// Split is one category of a rule's splits.  Exactly one of Percent,
+// Amount and Rest is given.
+type Split struct {
+	Category string
+	Percent  int        // In hundredths of a percent, of the whole amount
+	Amount   util.Money // Without a sign; it takes the sign of the transaction
+	Rest     bool       // Gets whatever is left
+
+	cid uuid.UUID
+}
+
+// RuleSet is all the rules in a file, in order.
+type RuleSet struct {
+	FileName string
+	Rules    []*Rule
+}
+
+// RulesFile returns the name of the file that holds the rules.
+func RulesFile() string {
+	fn, _ := config.GetStringParam("rules_file", default_rules_file)
+	return fn
+}
+
+// LoadRules reads the rules file and checks its names against the
+// database.  If there is no rules file, the set is empty.
+func LoadRules(v *m1.View) (*RuleSet, error) {
+	fn := RulesFile()
+	data, err := ioutil.ReadFile(fn)
+	if os.IsNotExist(err) {
+		return &RuleSet{FileName: fn}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read rules (%s). Err=%v", fn, err)
+	}
+	rs, err := ParseRules(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fn, err)
+	}
+	rs.FileName = fn
+	err = rs.Compile(v)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fn, err)
+	}
+	return rs, nil
+}
+
+// ParseRules reads rules from the text of a rules file.  The names in
+// the rules are not checked until Compile.
+func ParseRules(text string) (*RuleSet, error) {
+	rs := &RuleSet{Rules: make([]*Rule, 0, 20)}
+	names := make(map[string]bool, 20)
+	var r *Rule
+	lines := strings.Split(text, "\n")
+	for i, ln := range lines {
+		ilinenum := i + 1
+		ln = strings.TrimSpace(ln)
+		if util.Blank(ln) || strings.HasPrefix(ln, "//") {
+			continue
+		}
+		if strings.HasPrefix(ln, "[") && strings.HasSuffix(ln, "]") {
+			if r != nil {
+				if err := check_rule(r); err != nil {
+					return nil, err
+				}
+			}
+			name := strings.TrimSpace(ln[1 : len(ln)-1])
+			if name == "" {
+				return nil, fmt.Errorf("Blank rule name on line %d.", ilinenum)
+			}
+			if names[strings.ToLower(name)] {
+				return nil, fmt.Errorf("Rule %q is given twice (line %d).", name, ilinenum)
+			}
+			names[strings.ToLower(name)] = true
+			r = &Rule{Name: name, Line: ilinenum}
+			rs.Rules = append(rs.Rules, r)
+			continue
+		}
+		if r == nil {
+			return nil, fmt.Errorf("Line %d is not in a rule. Start a rule with [name].", ilinenum)
+		}
+		i := strings.Index(ln, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("Bad syntax on line %d. No equal char found.", ilinenum)
+		}
+		key := strings.ToLower(strings.TrimSpace(ln[:i]))
+		val := strings.TrimSpace(ln[i+1:])
+		if err := set_rule_value(r, key, val); err != nil {
+			return nil, fmt.Errorf("Line %d: %v", ilinenum, err)
+		}
+	}
+	if r != nil {
+		if err := check_rule(r); err != nil {
+			return nil, err
+		}
+	}
+	return rs, nil
+}
+
+// set_rule_value sets one key of a rule.
+func set_rule_value(r *Rule, key, val string) error {
+	var err error
+	switch key {
+	case "vendor", "ven":
+		r.Vendor = val
+	case "account", "acc":
+		r.Account = val
+	case "desc", "description":
+		r.Desc, err = regexp.Compile("(?i)" + val)
+		if err != nil {
+			return fmt.Errorf("Bad regular expression for desc (%q). Err=%v", val, err)
+		}
+		r.DescText = val
+	case "amount", "amt":
+		r.UseAmount = true
+		r.MinAmount, r.MaxAmount, err = parse_amount_range(val)
+		if err != nil {
+			return err
+		}
+	case "day":
+		r.MinDay, r.MaxDay, err = parse_day_range(val)
+		if err != nil {
+			return err
+		}
+	case "set_vendor":
+		r.SetVendor = val
+	case "cat", "category":
+		r.Splits = []*Split{&Split{Category: val, Rest: true}}
+	case "cats", "splits":
+		r.Splits, err = parse_splits(val)
+		if err != nil {
+			return err
+		}
+	case "flag":
+		r.Flag = val
+	case "note", "notes":
+		r.Note = val
+	case "replace":
+		r.Replace, err = util.StrToBool(val, false)
+		if err != nil {
+			return fmt.Errorf("Bad value for replace (%q).", val)
+		}
+	default:
+		return fmt.Errorf("Unknown key (%q).", key)
+	}
+	return nil
+}
+
+// parse_amount_range reads an amount range, such as "-50..-10", "..0"
+// or "-12.50".  The range includes both ends.
+func parse_amount_range(s string) (util.Money, util.Money, error) {
+	smin, smax := s, s
+	if i := strings.Index(s, ".."); i >= 0 {
+		smin, smax = s[:i], s[i+2:]
+	}
+	min, max := util.Money(math.MinInt64), util.Money(math.MaxInt64)
+	var err error
+	if !util.Blank(smin) {
+		if min, err = util.ParseMoney(smin); err != nil {
+			return 0, 0, fmt.Errorf("Bad amount range (%q).", s)
+		}
+	}
+	if !util.Blank(smax) {
+		if max, err = util.ParseMoney(smax); err != nil {
+			return 0, 0, fmt.Errorf("Bad amount range (%q).", s)
+		}
+	}
+	if min > max {
+		return 0, 0, fmt.Errorf("Amount range is empty (%q).", s)
+	}
+	return min, max, nil
+}
+
+// parse_day_range reads a range of days in the month, such as "1..5"
+// or "15".
+func parse_day_range(s string) (int, int, error) {
+	smin, smax := s, s
+	if i := strings.Index(s, ".."); i >= 0 {
+		smin, smax = s[:i], s[i+2:]
+	}
+	min, err1 := strconv.Atoi(strings.TrimSpace(smin))
+	max, err2 := strconv.Atoi(strings.TrimSpace(smax))
+	if err1 != nil || err2 != nil || min < 1 || max > 31 || min > max {
+		return 0, 0, fmt.Errorf("Bad day range (%q). Use a day, or days such as 1..5.", s)
+	}
+	return min, max, nil
+}
+
+// parse_splits reads a list of categories and their shares, such as
+// "Groceries 70%, Household rest" or "Cable 45.00, Internet rest".  A
+// category without a share gets the rest.  An amount must have a
+// decimal point or a dollar sign, so that a category name that ends in
+// a number is not taken for one.
+func parse_splits(s string) ([]*Split, error) {
+	lst := make([]*Split, 0, 3)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		sp := &Split{Category: part, Rest: true}
+		if i := strings.LastIndex(part, " "); i > 0 {
+			share := strings.TrimSpace(part[i+1:])
+			name := strings.TrimSpace(part[:i])
+			switch {
+			case strings.ToLower(share) == "rest":
+				sp.Category = name
+			case strings.HasSuffix(share, "%"):
+				pct, err := util.ParseMoney(strings.TrimSuffix(share, "%"))
+				if err != nil || pct <= 0 || pct > 10000 {
+					return nil, fmt.Errorf("Bad percent (%q) for %s.", share, name)
+				}
+				sp = &Split{Category: name, Percent: pct.Cents()}
+			case strings.ContainsAny(share, ".$"):
+				if amt, err := util.ParseMoney(share); err == nil {
+					if amt <= 0 {
+						return nil, fmt.Errorf("Bad amount (%q) for %s. Give it without a sign.", share, name)
+					}
+					sp = &Split{Category: name, Amount: amt}
+				}
+			}
+		}
+		lst = append(lst, sp)
+	}
+	if len(lst) == 0 {
+		return nil, fmt.Errorf("No categories given.")
+	}
+	return lst, nil
+}
+
+// check_rule makes sure that a rule can be used.
+func check_rule(r *Rule) error {
+	if r.SetVendor == "" && len(r.Splits) == 0 && r.Flag == "" && r.Note == "" {
+		return fmt.Errorf("Rule %q does nothing. Give set_vendor, cat, cats, flag or note.", r.Name)
+	}
+	nrest, pct := 0, 0
+	for _, sp := range r.Splits {
+		if sp.Rest {
+			nrest++
+		}
+		pct += sp.Percent
+	}
+	if nrest > 1 {
+		return fmt.Errorf("Rule %q has more than one category for the rest.", r.Name)
+	}
+	if len(r.Splits) > 0 && nrest == 0 {
+		for _, sp := range r.Splits {
+			if sp.Percent == 0 {
+				return fmt.Errorf("Rule %q has fixed amounts, so one category must get the rest.", r.Name)
+			}
+		}
+		if pct != 10000 {
+			return fmt.Errorf("Rule %q has percents that add to %s%%, not 100%%.", r.Name, util.Money(pct))
+		}
+	}
+	if nrest == 1 && pct >= 10000 {
+		return fmt.Errorf("Rule %q has percents that leave nothing for the rest.", r.Name)
+	}
+	return nil
+}
+
+// Compile looks up the vendors, accounts and categories named in the
+// rules.  An unknown name is an error, so that a typo does not quietly
+// turn a rule off.
+func (rs *RuleSet) Compile(v *m1.View) error {
+	vendors := v.Vendors()
+	accounts := v.Accounts()
+	cats := v.Categories()
+	for _, r := range rs.Rules {
+		var err error
+		if r.Vendor != "" {
+			if r.vid, err = find_vendor(vendors, r.Vendor); err != nil {
+				return fmt.Errorf("Rule %q: %v", r.Name, err)
+			}
+		}
+		if r.SetVendor != "" {
+			if r.setvid, err = find_vendor(vendors, r.SetVendor); err != nil {
+				return fmt.Errorf("Rule %q: %v", r.Name, err)
+			}
+		}
+		if r.Account != "" {
+			if r.aid, err = find_account(accounts, r.Account); err != nil {
+				return fmt.Errorf("Rule %q: %v", r.Name, err)
+			}
+		}
+		for _, sp := range r.Splits {
+			if sp.cid, err = find_category(cats, sp.Category); err != nil {
+				return fmt.Errorf("Rule %q: %v", r.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// The find functions match a name against the names and aliases of
+// the items, ignoring case.
+
+func find_vendor(lst []*m1.Vendor, name string) (uuid.UUID, error) {
+	for _, v := range lst {
+		if strings.EqualFold(v.FName, name) || strings.EqualFold(v.DName, name) || has_alias(v.Aliases, name) {
+			return v.Vid, nil
+		}
+	}
+	return uuid.Zero(), fmt.Errorf("No vendor named %q.", name)
+}
+
+func find_account(lst []*m1.Account, name string) (uuid.UUID, error) {
+	for _, a := range lst {
+		if strings.EqualFold(a.FName, name) || strings.EqualFold(a.ShortName, name) || has_alias(a.Aliases, name) {
+			return a.Aid, nil
+		}
+	}
+	return uuid.Zero(), fmt.Errorf("No account named %q.", name)
+}
+
+func find_category(lst []*m1.Category, name string) (uuid.UUID, error) {
+	for _, c := range lst {
+		if strings.EqualFold(c.Name, name) || has_alias(c.Aliases, name) {
+			return c.Cid, nil
+		}
+	}
+	return uuid.Zero(), fmt.Errorf("No category named %q.", name)
+}
+
+func has_alias(aliases []string, name string) bool {
+	for _, a := range aliases {
+		if strings.EqualFold(a, name) {
+			return true
+		}
+	}
+	return false
+}